@@ -6,10 +6,53 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/vzahanych/vm-api/core"
 )
 
+// findOrRegisterImage returns the ID of the named base image, registering the file first when
+// the catalog doesn't have it yet
+func findOrRegisterImage(name, path string) (string, error) {
+	resp, err := http.Get("http://localhost:8080/images")
+	if err != nil {
+		return "", fmt.Errorf("error sending request to list images: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var listResponse core.ListImagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&listResponse); err != nil {
+		return "", fmt.Errorf("error decoding list images response: %v", err)
+	}
+	for _, image := range listResponse.Images {
+		if image.Name == name {
+			return image.ID, nil
+		}
+	}
+
+	body, err := json.Marshal(core.ImageRegistrationRequest{Name: name, Path: path})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling image registration request: %v", err)
+	}
+	registerResp, err := http.Post("http://localhost:8080/images", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return "", fmt.Errorf("error sending request to register image: %v", err)
+	}
+	defer registerResp.Body.Close()
+
+	if registerResp.StatusCode != http.StatusAccepted {
+		var errResponse core.ErrorResponse
+		_ = json.NewDecoder(registerResp.Body).Decode(&errResponse)
+		return "", fmt.Errorf("failed to register image: %s", errResponse.Error.Message)
+	}
+
+	var image core.ImageResponse
+	if err := waitOperation(registerResp, &image); err != nil {
+		return "", fmt.Errorf("failed to register image: %v", err)
+	}
+	return image.ID, nil
+}
+
 func createVM(vmRequest core.VMCreationRequest) (*core.VMCreationResponse, error) {
 	url := "http://localhost:8080/vms" // The endpoint URL for VM creation
 	body, err := json.Marshal(vmRequest)
@@ -23,20 +66,59 @@ func createVM(vmRequest core.VMCreationRequest) (*core.VMCreationResponse, error
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusCreated {
+	if resp.StatusCode != http.StatusAccepted {
 		var errResponse core.ErrorResponse
 		_ = json.NewDecoder(resp.Body).Decode(&errResponse)
 		return nil, fmt.Errorf("failed to create VM: %s", errResponse.Error.Message)
 	}
 
+	// The VM is created in the background, wait for the operation to finish
 	var createVMResponse core.VMCreationResponse
-	if err := json.NewDecoder(resp.Body).Decode(&createVMResponse); err != nil {
-		return nil, fmt.Errorf("error decoding create VM response: %v", err)
+	if err := waitOperation(resp, &createVMResponse); err != nil {
+		return nil, fmt.Errorf("failed to create VM: %v", err)
 	}
 
 	return &createVMResponse, nil
 }
 
+// waitOperation polls the operation returned by an accepted request until it finishes
+// and decodes its result into the given value
+func waitOperation(resp *http.Response, result any) error {
+	var op core.Operation
+	if err := json.NewDecoder(resp.Body).Decode(&op); err != nil {
+		return fmt.Errorf("error decoding operation: %v", err)
+	}
+
+	url := "http://localhost:8080" + resp.Header.Get("Location")
+	for op.Status == core.OperationRunning {
+		time.Sleep(time.Second)
+
+		opResp, err := http.Get(url)
+		if err != nil {
+			return fmt.Errorf("error sending request to get operation: %v", err)
+		}
+		err = json.NewDecoder(opResp.Body).Decode(&op)
+		opResp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("error decoding operation: %v", err)
+		}
+	}
+
+	if op.Status != core.OperationSucceeded {
+		if op.Error != nil {
+			return fmt.Errorf("operation %s: %s", op.Status, op.Error.Message)
+		}
+		return fmt.Errorf("operation %s", op.Status)
+	}
+
+	// The result is a generic JSON value, round trip it into the expected type
+	data, err := json.Marshal(op.Result)
+	if err != nil {
+		return fmt.Errorf("error encoding operation result: %v", err)
+	}
+	return json.Unmarshal(data, result)
+}
+
 func getVMStatus(vmID string) (*core.GetVMStatusResponse, error) {
 	url := fmt.Sprintf("http://localhost:8080/vms/%s/status", vmID)
 
@@ -74,27 +156,33 @@ func deleteVM(vmID string) (*core.VMDeletionResponse, error) {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if resp.StatusCode != http.StatusAccepted {
 		var errResponse core.ErrorResponse
 		_ = json.NewDecoder(resp.Body).Decode(&errResponse)
 		return nil, fmt.Errorf("failed to delete VM: %s", errResponse.Error.Message)
 	}
 
 	var deletionResponse core.VMDeletionResponse
-	if err := json.NewDecoder(resp.Body).Decode(&deletionResponse); err != nil {
-		return nil, fmt.Errorf("error decoding delete VM response: %v", err)
+	if err := waitOperation(resp, &deletionResponse); err != nil {
+		return nil, fmt.Errorf("failed to delete VM: %v", err)
 	}
 
 	return &deletionResponse, nil
 }
 
 func main() {
+	// VMs are created from a base image of the catalog
+	imageID, err := findOrRegisterImage("ubuntu24.04", "/var/lib/libvirt/images/ubuntu24.04-2.qcow2")
+	if err != nil {
+		log.Fatalf("Error getting the base image: %v", err)
+	}
+
 	// Example VM creation request
 	vmRequest := core.VMCreationRequest{
 		VCPUs:    2,
 		Memory:   4096,
 		DiskSize: 20,
-		BaseImage: "/var/lib/libvirt/images/ubuntu24.04-2.qcow2",
+		Image:    imageID,
 		CPUPinning: &core.CPUPinning{
 			Cores: []int{0, 1},
 		},
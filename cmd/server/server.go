@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -47,6 +48,29 @@ var serverCmd = &cobra.Command{
 		handlerOptions.Level = level
 		logger = slog.New(slog.NewJSONHandler(os.Stdout, handlerOptions)) // Use standard library's slog
 
+		// Open the VM inventory, it has to survive API restarts
+		store, err := core.NewStore(config.Store)
+		if err != nil {
+			log.Fatalf("Error opening the store: %v", err)
+		}
+		defer store.Close()
+
+		// MAC addresses are reserved in the store so they stay unique across restarts
+		macs, err := core.NewMACAllocator(config.MAC, store)
+		if err != nil {
+			log.Fatalf("Error configuring the MAC allocator: %v", err)
+		}
+
+		// Base images are registered from the configured directories only
+		images, err := core.NewImageCatalog(config.Images, store)
+		if err != nil {
+			log.Fatalf("Error configuring the image catalog: %v", err)
+		}
+
+		// One libvirt connection is shared by every request and closed on shutdown
+		connections := core.NewConnectionManager(config.Libvirt, logger)
+		defer connections.Close()
+
 		r := gin.Default()
 
 		// Enable CORS for all origins
@@ -55,9 +79,68 @@ var serverCmd = &cobra.Command{
 		// Custom logger middleware to use slog
 		r.Use(core.CustomGinLogger(logger))
 
-		r.POST("/vms", core.CreateVMHandler)       // Create VM
-		r.DELETE("/vms/:id", core.DeleteVMHandler) // Delete VM
-		r.GET("/vms/:id/status", core.GetVMStatus) // Get VM Status
+		// Make the libvirt connection and the store available to the handlers
+		r.Use(core.LibvirtMiddleware(connections))
+		r.Use(core.StoreMiddleware(store))
+
+		// New NICs get their MAC address from the allocator
+		r.Use(core.MACAllocatorMiddleware(macs))
+
+		// VMs are created from the base images of the catalog
+		r.Use(core.ImageCatalogMiddleware(images))
+
+		// Long running actions are tracked as operations
+		operations := core.NewOperationManager()
+		r.Use(core.OperationsMiddleware(operations))
+
+		r.POST("/vms", core.CreateVMHandler)                        // Create VM
+		r.GET("/vms", core.ListVMsHandler)                          // List VMs
+		r.DELETE("/vms/:id", core.DeleteVMHandler)                  // Delete VM
+		r.GET("/vms/:id/status", core.GetVMStatus)                  // Get VM Status
+		r.GET("/vms/:id/config", core.GetVMConfigHandler)           // Get VM configuration
+		r.PUT("/vms/:id/cpu", core.UpdateVMCPUHandler)              // Update VM CPU configuration
+		r.PUT("/vms/:id/memory", core.UpdateVMMemoryHandler)        // Update VM memory configuration
+		r.PUT("/vms/:id/disk", core.ResizeVMDiskHandler)            // Resize VM root disk
+		r.GET("/vms/:id/performance", core.GetVMPerformanceHandler) // Get VM performance metrics
+		r.PUT("/vms/:id/io-limits", core.UpdateVMIOLimitsHandler)   // Update VM disk I/O limits
+		r.GET("/vms/:id/interfaces", core.GetVMInterfacesHandler)   // Get VM NICs and IP addresses
+		r.GET("/vms/:id/console/serial", core.SerialConsoleHandler) // Stream VM serial console over WebSocket
+		r.GET("/vms/:id/console/vnc", core.VNCConsoleHandler)       // Proxy VM VNC display over WebSocket
+		r.POST("/vms/:id/agent/exec", core.AgentExecHandler)        // Run a command in the guest
+		r.GET("/vms/:id/agent/files", core.GetAgentFileHandler)     // Read a file of the guest
+		r.PUT("/vms/:id/agent/files", core.PutAgentFileHandler)     // Write a file in the guest
+		r.GET("/vms/:id/agent/fsinfo", core.GetAgentFSInfoHandler)  // List the guest filesystems
+
+		r.POST("/vms/:id/start", core.StartVMHandler)     // Start VM
+		r.POST("/vms/:id/stop", core.StopVMHandler)       // Stop VM
+		r.POST("/vms/:id/reboot", core.RebootVMHandler)   // Reboot VM
+		r.POST("/vms/:id/pause", core.PauseVMHandler)     // Pause VM
+		r.POST("/vms/:id/resume", core.ResumeVMHandler)   // Resume VM
+		r.POST("/vms/:id/save", core.SaveVMHandler)       // Save VM memory to disk
+		r.POST("/vms/:id/restore", core.RestoreVMHandler) // Restore VM from saved memory
+
+		r.GET("/vms/:id/snapshots", core.ListVMSnapshotsHandler)                // List VM snapshots
+		r.POST("/vms/:id/snapshots", core.CreateVMSnapshotHandler)              // Take a VM snapshot (async)
+		r.GET("/vms/:id/snapshots/:name", core.GetVMSnapshotHandler)            // Get a VM snapshot
+		r.DELETE("/vms/:id/snapshots/:name", core.DeleteVMSnapshotHandler)      // Delete a VM snapshot
+		r.POST("/vms/:id/snapshots/:name/revert", core.RevertVMSnapshotHandler) // Revert a VM to a snapshot
+		r.POST("/vms/:id/clone", core.CloneVMHandler)                           // Clone a VM (async)
+
+		r.GET("/images", core.ListImagesHandler)         // List base images
+		r.POST("/images", core.RegisterImageHandler)     // Register a base image (async)
+		r.GET("/images/:id", core.GetImageHandler)       // Inspect a base image
+		r.DELETE("/images/:id", core.DeleteImageHandler) // Remove a base image from the catalog
+
+		r.GET("/operations/:id", core.GetOperationHandler)       // Get operation status
+		r.DELETE("/operations/:id", core.CancelOperationHandler) // Cancel operation
+
+		r.GET("/networks", core.ListNetworksHandler)                 // List virtual networks
+		r.POST("/networks", core.CreateNetworkHandler)               // Create virtual network
+		r.GET("/networks/:name", core.GetNetworkHandler)             // Get virtual network
+		r.DELETE("/networks/:name", core.DeleteNetworkHandler)       // Delete virtual network
+		r.POST("/networks/:name/start", core.StartNetworkHandler)    // Start virtual network
+		r.POST("/networks/:name/stop", core.StopNetworkHandler)      // Stop virtual network
+		r.PUT("/networks/:name/dhcp", core.UpdateNetworkDHCPHandler) // Update virtual network DHCP range
 
 		// Start the server
 		srv := &http.Server{
@@ -72,6 +155,15 @@ var serverCmd = &cobra.Command{
 			}
 		}()
 
+		// Compare the store with libvirt in the background until shutdown
+		reconcileInterval := config.Store.ReconcileInterval
+		if reconcileInterval <= 0 {
+			reconcileInterval = time.Minute
+		}
+		reconcileCtx, stopReconciler := context.WithCancel(context.Background())
+		defer stopReconciler()
+		go core.NewReconciler(store, connections, reconcileInterval, logger).Run(reconcileCtx)
+
 		// Wait for shutdown signal
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -83,6 +175,10 @@ var serverCmd = &cobra.Command{
 		if err := srv.Shutdown(ctx); err != nil {
 			logger.Error("Server Shutdown:", "error", err) // Using logger here
 		}
+		// The operations still use the store and the libvirt connection, which are closed on return
+		if err := operations.Shutdown(ctx); err != nil {
+			logger.Error("Operations cancelled at shutdown", "error", err)
+		}
 		<-ctx.Done()
 		logger.Info("Server exiting")
 	},
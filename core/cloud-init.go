@@ -0,0 +1,154 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/kdomanski/iso9660"
+	"gopkg.in/yaml.v3"
+)
+
+// seedVolumeLabel is the volume label cloud-init looks for to find a NoCloud seed
+const seedVolumeLabel = "cidata"
+
+// cloudConfigHeader marks user data cloud-init parses as YAML rather than runs as a script
+const cloudConfigHeader = "#cloud-config"
+
+// hostnamePattern accepts RFC 1123 host names, optionally fully qualified
+var hostnamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$`)
+
+// seedImagePath is where the cloud-init seed ISO of a VM is stored, next to its root disk
+func seedImagePath(vmID string) string {
+	return fmt.Sprintf("/var/lib/libvirt/images/%s-seed.iso", vmID)
+}
+
+// hasCloudInit reports whether the request asks for a cloud-init seed
+func (r *VMCreationRequest) hasCloudInit() bool {
+	return r.UserData != "" || r.MetaData != "" || r.NetworkConfig != "" || len(r.SSHAuthorizedKeys) > 0 || r.Hostname != ""
+}
+
+// cloudInitFiles renders the files of the NoCloud seed of the VM. The SSH keys and the
+// host name are merged into the user data, which must then be a #cloud-config document.
+func cloudInitFiles(request *VMCreationRequest, vmID string) (map[string][]byte, error) {
+	if request.Hostname != "" && (len(request.Hostname) > 253 || !hostnamePattern.MatchString(request.Hostname)) {
+		return nil, NewBadRequestError("Invalid parameter: hostname %q is not a valid host name", request.Hostname)
+	}
+	for _, key := range request.SSHAuthorizedKeys {
+		if strings.TrimSpace(key) == "" || strings.ContainsAny(key, "\r\n") {
+			return nil, NewBadRequestError("Invalid parameter: ssh_authorized_keys must hold one non-empty key per entry")
+		}
+	}
+
+	metaData, err := cloudInitMetaData(request, vmID)
+	if err != nil {
+		return nil, err
+	}
+	userData, err := cloudInitUserData(request)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{
+		"meta-data": metaData,
+		"user-data": userData,
+	}
+
+	if request.NetworkConfig != "" {
+		var networkConfig map[string]any
+		if err := yaml.Unmarshal([]byte(request.NetworkConfig), &networkConfig); err != nil || networkConfig == nil {
+			return nil, NewBadRequestError("Invalid parameter: network_config must be a YAML mapping")
+		}
+		files["network-config"] = []byte(request.NetworkConfig)
+	}
+
+	return files, nil
+}
+
+// cloudInitMetaData sets the instance ID to the VM UUID unless the given meta data has one
+func cloudInitMetaData(request *VMCreationRequest, vmID string) ([]byte, error) {
+	metaData := map[string]any{}
+	if request.MetaData != "" {
+		if err := yaml.Unmarshal([]byte(request.MetaData), &metaData); err != nil || metaData == nil {
+			return nil, NewBadRequestError("Invalid parameter: meta_data must be a YAML mapping")
+		}
+	}
+
+	if _, ok := metaData["instance-id"]; !ok {
+		metaData["instance-id"] = vmID
+	}
+	if request.Hostname != "" {
+		metaData["local-hostname"] = request.Hostname
+	}
+
+	return yaml.Marshal(metaData)
+}
+
+// cloudInitUserData returns the user data as given, or a #cloud-config with the SSH keys and host name merged in
+func cloudInitUserData(request *VMCreationRequest) ([]byte, error) {
+	if len(request.SSHAuthorizedKeys) == 0 && request.Hostname == "" {
+		if request.UserData == "" {
+			return []byte(cloudConfigHeader + "\n"), nil
+		}
+		return []byte(request.UserData), nil
+	}
+
+	config := map[string]any{}
+	if request.UserData != "" {
+		if !strings.HasPrefix(request.UserData, cloudConfigHeader) {
+			return nil, NewBadRequestError("Invalid parameter: ssh_authorized_keys and hostname can only be merged into #cloud-config user_data")
+		}
+		if err := yaml.Unmarshal([]byte(request.UserData), &config); err != nil {
+			return nil, NewBadRequestError("Invalid parameter: user_data is not valid YAML: %v", err)
+		}
+		if config == nil {
+			config = map[string]any{}
+		}
+	}
+
+	if len(request.SSHAuthorizedKeys) > 0 {
+		keys, _ := config["ssh_authorized_keys"].([]any)
+		for _, key := range request.SSHAuthorizedKeys {
+			keys = append(keys, key)
+		}
+		config["ssh_authorized_keys"] = keys
+	}
+	if request.Hostname != "" {
+		config["hostname"] = request.Hostname
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render the user data: %v", err)
+	}
+	return append([]byte(cloudConfigHeader+"\n"), data...), nil
+}
+
+// writeSeedISO writes the files as an ISO 9660 image labelled for cloud-init
+func writeSeedISO(w io.Writer, files map[string][]byte) error {
+	writer, err := iso9660.NewWriter()
+	if err != nil {
+		return fmt.Errorf("failed to create the seed image writer: %v", err)
+	}
+	defer writer.Cleanup()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := writer.AddFile(bytes.NewReader(files[name]), name); err != nil {
+			return fmt.Errorf("failed to add %s to the seed image: %v", name, err)
+		}
+	}
+
+	if err := writer.WriteTo(w, seedVolumeLabel); err != nil {
+		return fmt.Errorf("failed to write the seed image: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/kdomanski/iso9660"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+// TestCloudInitFiles tests that the SSH keys and host name end up in a generated #cloud-config
+func TestCloudInitFiles(t *testing.T) {
+	request := &VMCreationRequest{
+		SSHAuthorizedKeys: []string{"ssh-ed25519 AAAAC3Nza alice@laptop"},
+		Hostname:          "web-1",
+	}
+
+	files, err := cloudInitFiles(request, lifecycleVMID)
+	assert.Nil(t, err)
+	assert.NotContains(t, files, "network-config")
+
+	userData := string(files["user-data"])
+	assert.True(t, bytes.HasPrefix(files["user-data"], []byte("#cloud-config\n")), userData)
+	var config map[string]any
+	assert.Nil(t, yaml.Unmarshal(files["user-data"], &config))
+	assert.Equal(t, []any{"ssh-ed25519 AAAAC3Nza alice@laptop"}, config["ssh_authorized_keys"])
+	assert.Equal(t, "web-1", config["hostname"])
+
+	var metaData map[string]any
+	assert.Nil(t, yaml.Unmarshal(files["meta-data"], &metaData))
+	assert.Equal(t, map[string]any{"instance-id": lifecycleVMID, "local-hostname": "web-1"}, metaData)
+}
+
+// TestCloudInitFilesMerge tests that the keys are added to the ones of a given #cloud-config
+func TestCloudInitFilesMerge(t *testing.T) {
+	request := &VMCreationRequest{
+		UserData:          "#cloud-config\npackages: [nginx]\nssh_authorized_keys:\n  - ssh-rsa AAAAB3 existing\n",
+		MetaData:          "instance-id: custom-id\n",
+		NetworkConfig:     "version: 2\nethernets:\n  eth0:\n    dhcp4: true\n",
+		SSHAuthorizedKeys: []string{"ssh-ed25519 AAAAC3Nza added"},
+	}
+
+	files, err := cloudInitFiles(request, lifecycleVMID)
+	assert.Nil(t, err)
+
+	var config map[string]any
+	assert.Nil(t, yaml.Unmarshal(files["user-data"], &config))
+	assert.Equal(t, []any{"nginx"}, config["packages"])
+	assert.Equal(t, []any{"ssh-rsa AAAAB3 existing", "ssh-ed25519 AAAAC3Nza added"}, config["ssh_authorized_keys"])
+
+	// A given instance ID is kept
+	var metaData map[string]any
+	assert.Nil(t, yaml.Unmarshal(files["meta-data"], &metaData))
+	assert.Equal(t, "custom-id", metaData["instance-id"])
+
+	assert.Equal(t, request.NetworkConfig, string(files["network-config"]))
+}
+
+// TestCloudInitFilesScript tests that a user data script is passed through untouched
+func TestCloudInitFilesScript(t *testing.T) {
+	request := &VMCreationRequest{UserData: "#!/bin/sh\necho hello\n"}
+
+	files, err := cloudInitFiles(request, lifecycleVMID)
+	assert.Nil(t, err)
+	assert.Equal(t, request.UserData, string(files["user-data"]))
+
+	// Keys can't be merged into a script
+	request.SSHAuthorizedKeys = []string{"ssh-ed25519 AAAAC3Nza alice@laptop"}
+	_, err = cloudInitFiles(request, lifecycleVMID)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestCloudInitFilesInvalid tests the validation of the cloud-init fields
+func TestCloudInitFilesInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		request VMCreationRequest
+		message string
+	}{
+		{"hostname", VMCreationRequest{Hostname: "web_1"}, "Invalid parameter: hostname"},
+		{"empty key", VMCreationRequest{SSHAuthorizedKeys: []string{" "}}, "Invalid parameter: ssh_authorized_keys"},
+		{"multi-line key", VMCreationRequest{SSHAuthorizedKeys: []string{"ssh-rsa A\nssh-rsa B"}}, "Invalid parameter: ssh_authorized_keys"},
+		{"meta data", VMCreationRequest{MetaData: "- not\n- a mapping\n"}, "Invalid parameter: meta_data"},
+		{"network config", VMCreationRequest{NetworkConfig: "version: [2"}, "Invalid parameter: network_config"},
+		{"user data", VMCreationRequest{UserData: "#cloud-config\npackages: [nginx\n", Hostname: "web-1"}, "Invalid parameter: user_data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := cloudInitFiles(&tt.request, lifecycleVMID)
+			assert.IsType(t, &BadRequestError{}, err)
+			assert.ErrorContains(t, err, tt.message)
+
+			// The handler rejects the same requests up front
+			assert.ErrorContains(t, validateVMCreationRequest(&tt.request), tt.message)
+		})
+	}
+}
+
+// TestWriteSeedISO tests that the seed image carries the cidata label and the files under their NoCloud names
+func TestWriteSeedISO(t *testing.T) {
+	files := map[string][]byte{
+		"meta-data":      []byte("instance-id: " + lifecycleVMID + "\n"),
+		"user-data":      []byte("#cloud-config\nhostname: web-1\n"),
+		"network-config": []byte("version: 2\n"),
+	}
+
+	var image bytes.Buffer
+	assert.Nil(t, writeSeedISO(&image, files))
+
+	iso, err := iso9660.OpenImage(bytes.NewReader(image.Bytes()))
+	assert.Nil(t, err)
+	label, err := iso.Label()
+	assert.Nil(t, err)
+	assert.Equal(t, "cidata", label)
+
+	root, err := iso.RootDir()
+	assert.Nil(t, err)
+	children, err := root.GetChildren()
+	assert.Nil(t, err)
+
+	found := map[string][]byte{}
+	for _, child := range children {
+		data, err := io.ReadAll(child.Reader())
+		assert.Nil(t, err)
+		found[child.Name()] = data
+	}
+	assert.Equal(t, files, found)
+}
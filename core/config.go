@@ -10,6 +10,10 @@ type (
 		Opentelemetry OpentelemetryConfig `yaml:"opentelemetry"`
 		Healthcheck   HealthcheckConfig   `yaml:"healthcheck"`
 		Logging       LoggingConfig       `yaml:"logging"` // Added logging section
+		Store         StoreConfig         `yaml:"store"`
+		Libvirt       LibvirtConfig       `yaml:"libvirt"`
+		MAC           MACConfig           `yaml:"mac"`
+		Images        ImagesConfig        `yaml:"images"`
 	}
 
 	ServerConfig struct {
@@ -30,6 +34,28 @@ type (
 		URL     string `yaml:"url"`
 	}
 
+	StoreConfig struct {
+		Driver            string        `yaml:"driver"`             // Store backend, "bolt" (default)
+		Path              string        `yaml:"path"`               // Path of the store file
+		ReconcileInterval time.Duration `yaml:"reconcile_interval"` // How often the store is compared with libvirt
+	}
+
+	LibvirtConfig struct {
+		URI               string        `yaml:"uri"`                // Libvirt URI, "qemu:///system" (default)
+		KeepaliveInterval time.Duration `yaml:"keepalive_interval"` // How often an idle connection is probed
+		KeepaliveCount    uint          `yaml:"keepalive_count"`    // Unanswered probes before the connection is closed
+	}
+
+	MACConfig struct {
+		Prefix     string `yaml:"prefix"`      // First three octets of the allocated MACs, "00:16:3e" (default)
+		RangeStart string `yaml:"range_start"` // First allocatable value of the last three octets, "00:00:00" (default)
+		RangeEnd   string `yaml:"range_end"`   // Last allocatable value of the last three octets, "ff:ff:ff" (default)
+	}
+
+	ImagesConfig struct {
+		Dirs []string `yaml:"dirs"` // Directories base images can be registered from, "/var/lib/libvirt/images" (default)
+	}
+
 	LoggingConfig struct {
 		LogLevel string `yaml:"log_level"` // Log level (debug, info, warn, error)
 	}
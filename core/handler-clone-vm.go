@@ -0,0 +1,79 @@
+package core
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VMCloneRequest represents the body of a request to clone a VM
+type VMCloneRequest struct {
+	Mode   string            `json:"mode,omitempty"`   // "full" (default) copies the disk, "linked" creates an overlay on the disk of the source.
+	Start  *bool             `json:"start,omitempty"`  // Start the clone once defined, true by default.
+	Labels map[string]string `json:"labels,omitempty"` // Labels of the clone, the labels of the source are kept when omitted.
+	Owner  string            `json:"owner,omitempty"`  // Owner of the clone recorded in the VM inventory, the owner of the source by default.
+}
+
+// VMCloneResponse represents the response structure for a VM clone
+type VMCloneResponse struct {
+	VMID         string            `json:"vm_id"`                // Unique UUID identifier for the clone
+	SourceVMID   string            `json:"source_vm_id"`         // The UUID of the cloned VM
+	Mode         string            `json:"mode"`                 // "full" or "linked"
+	Status       string            `json:"status"`               // Status of the clone (e.g., "running", "stopped")
+	VCPUs        int               `json:"vcpus"`                // Number of virtual CPUs assigned to the clone
+	Memory       int               `json:"memory"`               // Amount of memory (in MB) allocated to the clone
+	DiskFile     string            `json:"disk_file"`            // Path to the root disk image file of the clone
+	SeedImage    string            `json:"seed_image,omitempty"` // Path to the cloud-init seed ISO, if one was attached
+	MacAddress   string            `json:"mac_address"`          // The MAC address of the first NIC of the clone
+	MacAddresses []string          `json:"mac_addresses"`        // The MAC addresses of all NICs of the clone
+	Labels       map[string]string `json:"labels,omitempty"`     // The labels of the clone
+	Message      string            `json:"message"`              // Confirmation message about the clone
+}
+
+// CloneVMHandler handles cloning a VM. Copying a disk takes a while, the clone is made as an operation.
+func CloneVMHandler(c *gin.Context) {
+	var request VMCloneRequest
+
+	// The body is optional, a full clone of the source is made without one
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: ErrorDetails{
+					Code:    http.StatusBadRequest,
+					Message: err.Error(),
+				},
+			})
+			return
+		}
+	}
+
+	if request.Mode == "" {
+		request.Mode = "full"
+	}
+	if request.Mode != "full" && request.Mode != "linked" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: mode must be full or linked",
+			},
+		})
+		return
+	}
+
+	handleVMOperation(c, "clone vm", "clone_vm", func(c *gin.Context, vmID string, lq LibvirtQemu) (*VMCloneResponse, error) {
+		response, err := cloneVM(c, vmID, &request, lq)
+		if err != nil {
+			return nil, err
+		}
+
+		// The clone exists at this point, a failure to record it is left for the reconciler to flag
+		if store := storeFromContext(c); store != nil {
+			source, _ := store.GetVM(vmID) // nil when the source isn't in the store
+			if err := store.PutVM(newCloneRecord(source, &request, response, time.Now())); err != nil {
+				loggerFromContext(c).Error("Failed to record VM", "vm_id", response.VMID, "error", err)
+			}
+		}
+		return response, nil
+	})
+}
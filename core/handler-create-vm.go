@@ -4,41 +4,88 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
-	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 // VMCreationRequest represents the body of a request to create a new VM.
 type VMCreationRequest struct {
-	VCPUs      int         `json:"vcpus"`                 // Number of virtual CPUs to be assigned to the new VM.
-	Memory     int         `json:"memory"`                // Amount of memory (in MB) to be allocated to the new VM.
-	DiskSize   int         `json:"disk_size"`             // The desired root disk size for the VM in GB.
-	BaseImage  string      `json:"base_image"`            // Path to the base image that will be cloned for the VM.
-	CPUPinning *CPUPinning `json:"cpu_pinning,omitempty"` // Optional CPU pinning configuration.
-	IOLimits   *IOLimits   `json:"io_limits,omitempty"`   // Optional I/O tuning for limiting disk I/O.
+	VCPUs             int                 `json:"vcpus"`                         // Number of virtual CPUs to be assigned to the new VM.
+	Memory            int                 `json:"memory"`                        // Amount of memory (in MB) to be allocated to the new VM.
+	DiskSize          int                 `json:"disk_size"`                     // The desired root disk size for the VM in GB.
+	Image             string              `json:"image"`                         // ID of the registered base image the root disk is created on.
+	BaseImage         string              `json:"-"`                             // Path of the base image, resolved from the image catalog.
+	CPUPinning        *CPUPinning         `json:"cpu_pinning,omitempty"`         // Optional CPU pinning configuration.
+	IOLimits          *IOLimits           `json:"io_limits,omitempty"`           // Optional I/O tuning for limiting disk I/O.
+	Labels            map[string]string   `json:"labels,omitempty"`              // Optional key/value labels stored in the domain metadata.
+	Owner             string              `json:"owner,omitempty"`               // Optional owner recorded in the VM inventory.
+	UserData          string              `json:"user_data,omitempty"`           // Optional cloud-init user data, a #cloud-config document or a script.
+	MetaData          string              `json:"meta_data,omitempty"`           // Optional cloud-init meta data as YAML, the instance ID defaults to the VM UUID.
+	NetworkConfig     string              `json:"network_config,omitempty"`      // Optional cloud-init network configuration as YAML.
+	SSHAuthorizedKeys []string            `json:"ssh_authorized_keys,omitempty"` // Optional SSH public keys authorized for the default user.
+	Hostname          string              `json:"hostname,omitempty"`            // Optional host name of the guest.
+	Networks          []NetworkAttachment `json:"networks,omitempty"`            // Optional NICs of the VM, one virtio NIC on the "default" network when empty.
 }
 
 // CPUPinning represents the optional CPU pinning configuration for the VM.
 type CPUPinning struct {
-	Cores []int `json:"cores"` // List of physical CPU cores to pin the VM's vCPUs to.
+	Cores         []int `json:"cores"`                    // List of physical CPU cores to pin the VM's vCPUs to, one per vCPU.
+	EmulatorCores []int `json:"emulator_cores,omitempty"` // Optional host cores for the QEMU emulator threads.
+	IOThreadCores []int `json:"iothread_cores,omitempty"` // Optional host cores for a dedicated disk I/O thread.
 }
 
-// IOLimits represents the optional I/O limits configuration for the VM's disk.
+// IOLimits represents the optional I/O limits configuration for the VM's disk. A zero value
+// leaves the limit unset. Total limits can't be combined with the read/write ones of the same kind.
 type IOLimits struct {
-	IOPS int `json:"iops"` // The I/O operations per second (IOPS) limit for the VM's disk.
+	IOPS             int `json:"iops"`                          // The I/O operations per second (IOPS) limit for the VM's disk.
+	ReadIOPS         int `json:"read_iops,omitempty"`           // The read operations per second limit.
+	WriteIOPS        int `json:"write_iops,omitempty"`          // The write operations per second limit.
+	TotalBytesSec    int `json:"total_bytes_sec,omitempty"`     // The throughput limit in bytes per second.
+	ReadBytesSec     int `json:"read_bytes_sec,omitempty"`      // The read throughput limit in bytes per second.
+	WriteBytesSec    int `json:"write_bytes_sec,omitempty"`     // The write throughput limit in bytes per second.
+	IOPSMax          int `json:"iops_max,omitempty"`            // The burst IOPS limit, requires iops.
+	ReadIOPSMax      int `json:"read_iops_max,omitempty"`       // The burst read IOPS limit, requires read_iops.
+	WriteIOPSMax     int `json:"write_iops_max,omitempty"`      // The burst write IOPS limit, requires write_iops.
+	TotalBytesSecMax int `json:"total_bytes_sec_max,omitempty"` // The burst throughput limit, requires total_bytes_sec.
+	ReadBytesSecMax  int `json:"read_bytes_sec_max,omitempty"`  // The burst read throughput limit, requires read_bytes_sec.
+	WriteBytesSecMax int `json:"write_bytes_sec_max,omitempty"` // The burst write throughput limit, requires write_bytes_sec.
+	BurstLength      int `json:"burst_length,omitempty"`        // How many seconds the burst limits may be used for.
+}
+
+// NetworkAttachment represents a NIC of the new VM, attached to either a libvirt network or a host bridge.
+type NetworkAttachment struct {
+	Network    string            `json:"network,omitempty"`     // The libvirt network to attach the NIC to.
+	Bridge     string            `json:"bridge,omitempty"`      // The host bridge to attach the NIC to, instead of a network.
+	Model      string            `json:"model,omitempty"`       // The NIC model, "virtio" (default) or "e1000".
+	MacAddress string            `json:"mac_address,omitempty"` // Optional fixed MAC address, allocated when empty.
+	VLAN       int               `json:"vlan,omitempty"`        // Optional VLAN tag from 1 to 4094, the network or bridge must support tagging.
+	Bandwidth  *NetworkBandwidth `json:"bandwidth,omitempty"`   // Optional bandwidth limits of the NIC.
+}
+
+// NetworkBandwidth represents the bandwidth limits of a NIC, inbound and outbound as seen from the guest.
+// Rates are in KiB/s and bursts in KiB, a peak or burst requires the average of the same direction.
+type NetworkBandwidth struct {
+	InboundAverage  int `json:"inbound_average,omitempty"`  // The average inbound rate.
+	InboundPeak     int `json:"inbound_peak,omitempty"`     // The maximum inbound rate.
+	InboundBurst    int `json:"inbound_burst,omitempty"`    // How much can be received at the peak rate.
+	OutboundAverage int `json:"outbound_average,omitempty"` // The average outbound rate.
+	OutboundPeak    int `json:"outbound_peak,omitempty"`    // The maximum outbound rate.
+	OutboundBurst   int `json:"outbound_burst,omitempty"`   // How much can be sent at the peak rate.
 }
 
 // VMCreationResponse represents the response structure for VM creation
 type VMCreationResponse struct {
-	VMID       string `json:"vm_id"`       // Unique UUID identifier for the created VM
-	Status     string `json:"status"`      // Status of the VM creation process (e.g., "created")
-	VCPUs      int    `json:"vcpus"`       // Number of virtual CPUs assigned to the VM
-	Memory     int    `json:"memory"`      // Amount of memory (in MB) allocated to the VM
-	DiskSize   int    `json:"disk_size"`   // Disk size in GB allocated to the VM
-	DiskFile   string `json:"disk_file"`   // Path to the root disk image file
-	MacAddress string `json:"mac_address"` // The unique MAC address generated for the VM's network interface
-	Message    string `json:"message"`     // Confirmation message about the VM creation status
+	VMID         string   `json:"vm_id"`                // Unique UUID identifier for the created VM
+	Status       string   `json:"status"`               // Status of the VM creation process (e.g., "created")
+	VCPUs        int      `json:"vcpus"`                // Number of virtual CPUs assigned to the VM
+	Memory       int      `json:"memory"`               // Amount of memory (in MB) allocated to the VM
+	DiskSize     int      `json:"disk_size"`            // Disk size in GB allocated to the VM
+	DiskFile     string   `json:"disk_file"`            // Path to the root disk image file
+	SeedImage    string   `json:"seed_image,omitempty"` // Path to the cloud-init seed ISO, if one was attached
+	MacAddress   string   `json:"mac_address"`          // The unique MAC address generated for the VM's network interface
+	MacAddresses []string `json:"mac_addresses"`        // The MAC addresses of all NICs, in the order of the request
+	Message      string   `json:"message"`              // Confirmation message about the VM creation status
 }
 
 // Handler to create VM
@@ -83,36 +130,34 @@ func CreateVMHandler(c *gin.Context) {
 		return
 	}
 
-	// Check if the base image exists
-	if _, err := os.Stat(request.BaseImage); os.IsNotExist(err) {
-		// Base image not found, return 404 Not Found
-		logger.Error("Base image not found", "base_image", request.BaseImage)
-		c.JSON(http.StatusNotFound, ErrorResponse{
+	if err := validateVMCreationRequest(&request); err != nil {
+		logger.Error("Invalid request", "error", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
 			Error: ErrorDetails{
-				Code:    http.StatusNotFound,
-				Message: fmt.Sprintf("Base image not found: %s", request.BaseImage),
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
 			},
 		})
 		return
 	}
 
-	lq := &LibvirtQemuImpl{}
-	if err := lq.NewConnect("qemu:///system"); err != nil {
-		logger.Error("Fail to connect to libvirt", "error", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: ErrorDetails{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			},
-		})
+	// The base image comes from the catalog, a request can't point the API at any file of the host
+	catalog, ok := prepareImageCatalog(c, logger)
+	if !ok {
 		return
 	}
+	image, err := catalog.forVM(request.Image, request.DiskSize)
+	if err != nil {
+		logger.Error("Invalid base image", "image", request.Image, "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+	request.BaseImage = image.Path
 
-	var res *VMCreationResponse
-	var err error
-	if res, err = createVM(c, &request, lq); err != nil {
-		// Failure in VM creation process, return 500 Internal Server Error
-		logger.Error("Failed to create VM", "error", err)
+	lq, err := libvirtFromContext(c)
+	if err != nil {
+		logger.Error("Fail to connect to libvirt", "error", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: ErrorDetails{
 				Code:    http.StatusInternalServerError,
@@ -122,5 +167,46 @@ func CreateVMHandler(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, res)
+	// Cloning a large base image outlasts the HTTP timeouts, the creation runs as an operation
+	startOperation(c, logger, "create_vm", "", func(c *gin.Context) (any, error) {
+		progressFromContext(c).Report(0, "Verifying the base image")
+		if err := catalog.verify(image); err != nil {
+			return nil, err
+		}
+
+		res, err := createVM(c, &request, lq)
+		if err != nil {
+			return nil, err
+		}
+
+		// The VM exists at this point, a failure to record it is left for the reconciler to flag
+		if store := storeFromContext(c); store != nil {
+			if err := store.PutVM(newVMRecord(&request, res, time.Now())); err != nil {
+				logger.Error("Failed to record VM", "vm_id", res.VMID, "error", err)
+			}
+		}
+		return res, nil
+	})
+}
+
+// validateVMCreationRequest checks the parts of the request that don't depend on the host
+func validateVMCreationRequest(request *VMCreationRequest) error {
+	if request.CPUPinning != nil && len(request.CPUPinning.Cores) != request.VCPUs {
+		return fmt.Errorf("Invalid parameter: cpu_pinning must list exactly one core per vCPU")
+	}
+	if request.IOLimits != nil {
+		if err := validateIOLimits(request.IOLimits); err != nil {
+			return err
+		}
+	}
+	if err := validateNetworks(request.Networks); err != nil {
+		return err
+	}
+	if request.hasCloudInit() {
+		// The instance ID doesn't matter here, only whether the seed can be rendered
+		if _, err := cloudInitFiles(request, ""); err != nil {
+			return err
+		}
+	}
+	return nil
 }
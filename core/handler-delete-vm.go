@@ -1,12 +1,6 @@
 package core
 
-import (
-	"log/slog"
-	"net/http"
-
-	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-)
+import "github.com/gin-gonic/gin"
 
 // VMDeletionResponse represents the response structure for VM deletion
 type VMDeletionResponse struct {
@@ -16,85 +10,32 @@ type VMDeletionResponse struct {
 	DiskFile string `json:"disk_file"` // Path to the deleted disk image file
 }
 
+// DeleteVMHandler handles deleting a VM. Stopping the guest can take a while, so the deletion
+// runs as an operation and the response is the operation to poll. A VM whose disk backs linked
+// clones is kept until the clones are deleted.
 func DeleteVMHandler(c *gin.Context) {
-	// Get the logger from the Gin context
-	l, ok := c.Get("logger")
-	if !ok {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: ErrorDetails{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			},
-		})
-	}
-
-	logger, ok := l.(*slog.Logger)
-	if !ok {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: ErrorDetails{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			},
-		})
-	}
-
-	logger = logger.With("endpoint", "delete vm")
-	// Extract the VM ID from the URL path (e.g., /vms/{id})
-	vmID := c.Param("id")
-
-	// Validate that the VM ID is a valid UUID
-	_, err := uuid.Parse(vmID)
-	if err != nil {
-		// If not a valid UUID, return a 400 Bad Request error
-		// Log the error
-		logger.Error("Failed to parse id", "error", err)
-
-		// Validation failed, return 400 Bad Request
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Error: ErrorDetails{
-				Code:    http.StatusBadRequest,
-				Message: err.Error(),
-			},
-		})
-		return
-	}
-
-	lq := &LibvirtQemuImpl{}
-	if err := lq.NewConnect("qemu:///system"); err != nil {
-		logger.Error("Fail to connect to libvirt", "error", err)
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: ErrorDetails{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			},
-		})
-		return
-	}
-
-	// Call the DeleteVM function from libvirt to stop, undefine, and delete the disk
-	response, err := DeleteVM(c, vmID, lq)
-	if err != nil {
-		logger.Error("Failed to delete VM", "error", err)
-
-		if _, ok := err.(*NotFoundError); ok {
-            c.JSON(http.StatusNotFound, ErrorResponse{
-				Error: ErrorDetails{
-					Code:    http.StatusNotFound,
-					Message: "VM not found",
-				},
-			})
-			return			
+	handleVMOperation(c, "delete vm", "delete_vm", func(c *gin.Context, vmID string, lq LibvirtQemu) (*VMDeletionResponse, error) {
+		if store := storeFromContext(c); store != nil {
+			if err := checkNoLinkedClones(store, vmID); err != nil {
+				return nil, err
+			}
 		}
 
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Error: ErrorDetails{
-				Code:    http.StatusInternalServerError,
-				Message: "Internal server error",
-			},
-		})
-		return
-	}
+		response, err := DeleteVM(c, vmID, lq)
+		if err != nil {
+			return nil, err
+		}
 
-	// Return successful deletion response
-	c.JSON(http.StatusOK, response)
+		if store := storeFromContext(c); store != nil {
+			if err := store.DeleteVM(vmID); err != nil {
+				loggerFromContext(c).Error("Failed to remove the record of VM", "vm_id", vmID, "error", err)
+			}
+		}
+		if macs := macAllocatorFromContext(c); macs != nil {
+			if err := macs.Release(vmID); err != nil {
+				loggerFromContext(c).Error("Failed to release the MAC addresses of VM", "vm_id", vmID, "error", err)
+			}
+		}
+		return response, nil
+	})
 }
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var (
+	// imageNamePattern limits image names to characters that are safe in file names and URLs
+	imageNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+	// checksumPattern accepts a hex encoded SHA-256, optionally prefixed with "sha256:"
+	checksumPattern = regexp.MustCompile(`^(sha256:)?[0-9a-fA-F]{64}$`)
+)
+
+// ImageRegistrationRequest represents the body of a request to register a base image.
+type ImageRegistrationRequest struct {
+	Name        string `json:"name"`                  // Unique name of the image.
+	Path        string `json:"path"`                  // Absolute path of the qcow2 image file, inside one of the configured image directories.
+	Checksum    string `json:"checksum,omitempty"`    // Optional SHA-256 the image file must match, with or without the "sha256:" prefix.
+	Description string `json:"description,omitempty"` // Optional free text description.
+}
+
+// ImageResponse represents a registered base image
+type ImageResponse struct {
+	ID           string    `json:"id"`                      // The UUID of the image, passed as image when creating a VM
+	Name         string    `json:"name"`                    // The name of the image
+	Description  string    `json:"description,omitempty"`   // The description of the image
+	Path         string    `json:"path"`                    // The resolved path of the image file
+	Format       string    `json:"format"`                  // The image format, "qcow2"
+	VirtualSize  uint64    `json:"virtual_size"`            // The size of the disk the guest sees in bytes, the minimum disk_size of a VM
+	ActualSize   uint64    `json:"actual_size,omitempty"`   // The space the image file takes on the host in bytes, only reported by inspect
+	Checksum     string    `json:"checksum"`                // The SHA-256 of the image file when it was registered
+	BackingChain []string  `json:"backing_chain,omitempty"` // The backing files of the image, nearest first
+	CreatedAt    time.Time `json:"created_at"`              // When the image was registered
+}
+
+// ListImagesResponse represents the registered base images
+type ListImagesResponse struct {
+	Images []ImageResponse `json:"images"` // The images sorted by name
+}
+
+// ImageDeletionResponse represents the response structure for an image deletion
+type ImageDeletionResponse struct {
+	ID      string `json:"id"`      // The UUID of the deleted image
+	Name    string `json:"name"`    // The name of the deleted image
+	Status  string `json:"status"`  // The status of the deletion (e.g., "deleted")
+	Message string `json:"message"` // Message describing the result of the deletion
+}
+
+// RegisterImageHandler handles adding a base image to the catalog. Checksumming a large image
+// outlasts the HTTP timeouts, the registration runs as an operation.
+func RegisterImageHandler(c *gin.Context) {
+	logger, lq, ok := prepareLibvirtRequest(c, "register image")
+	if !ok {
+		return
+	}
+
+	var request ImageRegistrationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		logger.Error("Failed to bind request", "error", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if !imageNamePattern.MatchString(request.Name) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: name must be 1 to 64 letters, digits, '.', '_' or '-'",
+			},
+		})
+		return
+	}
+	if request.Checksum != "" && !checksumPattern.MatchString(request.Checksum) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: checksum must be a hex encoded SHA-256",
+			},
+		})
+		return
+	}
+
+	catalog, ok := prepareImageCatalog(c, logger)
+	if !ok {
+		return
+	}
+
+	startOperation(c, logger, "register_image", "", func(c *gin.Context) (any, error) {
+		return catalog.Register(&request, lq)
+	})
+}
+
+// ListImagesHandler handles listing the registered base images
+func ListImagesHandler(c *gin.Context) {
+	handleImageRequest(c, "list images", false, func(_ string, catalog *ImageCatalog, _ LibvirtQemu) (*ListImagesResponse, error) {
+		return catalog.List()
+	})
+}
+
+// GetImageHandler handles inspecting a registered base image with qemu-img
+func GetImageHandler(c *gin.Context) {
+	handleImageRequest(c, "get image", true, func(id string, catalog *ImageCatalog, lq LibvirtQemu) (*ImageResponse, error) {
+		return catalog.Inspect(id, lq)
+	})
+}
+
+// DeleteImageHandler handles removing a base image no VM is created from from the catalog
+func DeleteImageHandler(c *gin.Context) {
+	handleImageRequest(c, "delete image", true, func(id string, catalog *ImageCatalog, _ LibvirtQemu) (*ImageDeletionResponse, error) {
+		return catalog.Delete(id)
+	})
+}
+
+// handleImageRequest connects to libvirt, validates the image ID when the endpoint has one and
+// runs the action on the catalog, mapping its errors to the documented responses
+func handleImageRequest[T any](c *gin.Context, endpoint string, withID bool, action func(id string, catalog *ImageCatalog, lq LibvirtQemu) (*T, error)) {
+	logger, lq, ok := prepareLibvirtRequest(c, endpoint)
+	if !ok {
+		return
+	}
+
+	id := c.Param("id")
+	if withID {
+		if _, err := uuid.Parse(id); err != nil {
+			logger.Error("Failed to parse id", "error", err)
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error: ErrorDetails{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid UUID format",
+				},
+			})
+			return
+		}
+	}
+
+	catalog, ok := prepareImageCatalog(c, logger)
+	if !ok {
+		return
+	}
+
+	response, err := action(id, catalog, lq)
+	if err != nil {
+		logger.Error("Failed to "+endpoint, "image", id, "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// prepareImageCatalog gets the image catalog, it answers the request itself and returns false
+// when the server runs without one
+func prepareImageCatalog(c *gin.Context, logger *slog.Logger) (*ImageCatalog, bool) {
+	catalog := imageCatalogFromContext(c)
+	if catalog == nil {
+		logger.Error("No image catalog in the context")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, false
+	}
+	return catalog, true
+}
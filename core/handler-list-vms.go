@@ -0,0 +1,166 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultListLimit = 50  // Page size used when the request doesn't set one
+	maxListLimit     = 500 // Largest page size a client can ask for
+)
+
+// ListVMsQuery holds the parsed filters, sorting and pagination of a list request
+type ListVMsQuery struct {
+	States     []string          // Only VMs in one of these statuses (e.g., "running", "stopped")
+	NamePrefix string            // Only VMs whose name starts with this prefix
+	Labels     map[string]string // Only VMs carrying all of these labels
+	SortBy     string            // Field to sort by: "name", "id" or "status"
+	Desc       bool              // Sort in descending order
+	Limit      int               // Maximum number of VMs on the page
+	After      *listCursor       // Position of the last VM of the previous page
+}
+
+// VMSummary represents a single VM in the list response
+type VMSummary struct {
+	ID     string            `json:"id"`               // Unique UUID identifier of the VM
+	Name   string            `json:"name"`             // The name of the VM
+	Status string            `json:"status"`           // The current state of the VM (e.g., "running", "stopped")
+	Labels map[string]string `json:"labels,omitempty"` // Labels assigned to the VM at creation
+}
+
+// ListVMsResponse represents the response structure for listing VMs
+type ListVMsResponse struct {
+	VMs        []VMSummary `json:"vms"`                   // The VMs on this page
+	NextCursor string      `json:"next_cursor,omitempty"` // Cursor for the next page, empty on the last page
+}
+
+// ListVMsHandler handles listing the VMs on the hypervisor
+func ListVMsHandler(c *gin.Context) {
+	// Get the logger from the Gin context
+	l, ok := c.Get("logger")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	logger, ok := l.(*slog.Logger)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	logger = logger.With("endpoint", "list vms")
+
+	query, err := parseListVMsQuery(c)
+	if err != nil {
+		logger.Error("Failed to parse query", "error", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	lq, err := libvirtFromContext(c)
+	if err != nil {
+		logger.Error("Fail to connect to libvirt", "error", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	response, err := listVMs(c, query, lq)
+	if err != nil {
+		logger.Error("Failed to list VMs", "error", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseListVMsQuery reads the query string of a list request, e.g.
+// ?state=running,paused&label=team=ci&name_prefix=build-&sort=-name&limit=20&cursor=...
+func parseListVMsQuery(c *gin.Context) (*ListVMsQuery, error) {
+	query := &ListVMsQuery{
+		NamePrefix: c.Query("name_prefix"),
+		SortBy:     "name",
+		Limit:      defaultListLimit,
+	}
+
+	for _, param := range c.QueryArray("state") {
+		for _, state := range strings.Split(param, ",") {
+			switch state {
+			case "running", "stopped", "paused", "saved", "unknown":
+				query.States = append(query.States, state)
+			default:
+				return nil, fmt.Errorf("Invalid parameter: unknown state %q", state)
+			}
+		}
+	}
+
+	for _, param := range c.QueryArray("label") {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("Invalid parameter: label must be in key=value form, got %q", param)
+		}
+		if query.Labels == nil {
+			query.Labels = make(map[string]string)
+		}
+		query.Labels[key] = value
+	}
+
+	if sortBy := c.Query("sort"); sortBy != "" {
+		query.SortBy, query.Desc = strings.CutPrefix(sortBy, "-")
+		switch query.SortBy {
+		case "name", "id", "status":
+		default:
+			return nil, fmt.Errorf("Invalid parameter: cannot sort by %q", query.SortBy)
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return nil, fmt.Errorf("Invalid parameter: limit must be between 1 and %d", maxListLimit)
+		}
+		query.Limit = n
+	}
+
+	if token := c.Query("cursor"); token != "" {
+		cursor, err := decodeListCursor(token)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid parameter: malformed cursor")
+		}
+		query.After = cursor
+	}
+
+	return query, nil
+}
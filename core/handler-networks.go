@@ -0,0 +1,170 @@
+package core
+
+import (
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// networkNamePattern limits network names to characters that are safe in libvirt file names and URLs
+var networkNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// NetworkCreationRequest represents the body of a request to create a virtual network.
+type NetworkCreationRequest struct {
+	Name          string     `json:"name"`                     // Unique name of the network, used by the networks of a VM creation request.
+	Mode          string     `json:"mode"`                     // "isolated", "nat" or "routed".
+	Address       string     `json:"address,omitempty"`        // Address of the host on the network in CIDR form (e.g., "10.10.0.1/24"), required for nat and routed.
+	Bridge        string     `json:"bridge,omitempty"`         // Optional name of the host bridge, picked by libvirt when empty.
+	ForwardDevice string     `json:"forward_device,omitempty"` // Optional host interface nat and routed traffic is forwarded through.
+	DHCP          *DHCPRange `json:"dhcp,omitempty"`           // Optional DHCP range served to the guests, requires address.
+	Autostart     bool       `json:"autostart"`                // Start the network when libvirt starts.
+}
+
+// DHCPRange represents the range of IPv4 addresses a network hands out to its guests.
+type DHCPRange struct {
+	Start string `json:"start"` // The first address of the range.
+	End   string `json:"end"`   // The last address of the range.
+}
+
+// NetworkResponse represents a virtual network
+type NetworkResponse struct {
+	Name          string     `json:"name"`                     // The name of the network
+	UUID          string     `json:"uuid"`                     // The UUID libvirt assigned to the network
+	Mode          string     `json:"mode"`                     // "isolated", "nat", "routed" or the libvirt forward mode of networks defined outside the API
+	Address       string     `json:"address,omitempty"`        // Address of the host on the network in CIDR form
+	Bridge        string     `json:"bridge,omitempty"`         // The host bridge of the network
+	ForwardDevice string     `json:"forward_device,omitempty"` // The host interface traffic is forwarded through, if restricted
+	DHCP          *DHCPRange `json:"dhcp,omitempty"`           // The DHCP range, if DHCP is enabled
+	Active        bool       `json:"active"`                   // Whether the network is running
+	Autostart     bool       `json:"autostart"`                // Whether the network starts with libvirt
+}
+
+// ListNetworksResponse represents the response structure for listing virtual networks
+type ListNetworksResponse struct {
+	Networks []NetworkResponse `json:"networks"` // The networks sorted by name
+}
+
+// NetworkDeletionResponse represents the response structure for network deletion
+type NetworkDeletionResponse struct {
+	Name    string `json:"name"`    // The name of the deleted network
+	Message string `json:"message"` // Confirmation message
+}
+
+// ListNetworksHandler handles listing the virtual networks
+func ListNetworksHandler(c *gin.Context) {
+	logger, lq, ok := prepareLibvirtRequest(c, "list networks")
+	if !ok {
+		return
+	}
+
+	response, err := listNetworks(lq)
+	if err != nil {
+		logger.Error("Failed to list networks", "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// CreateNetworkHandler handles defining and starting a virtual network
+func CreateNetworkHandler(c *gin.Context) {
+	logger, lq, ok := prepareLibvirtRequest(c, "create network")
+	if !ok {
+		return
+	}
+
+	var request NetworkCreationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		logger.Error("Failed to bind request", "error", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	response, err := createNetwork(&request, lq)
+	if err != nil {
+		logger.Error("Failed to create network", "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	c.Header("Location", "/networks/"+response.Name)
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetNetworkHandler handles retrieving a virtual network
+func GetNetworkHandler(c *gin.Context) {
+	handleNetworkRequest(c, "get network", getNetwork)
+}
+
+// DeleteNetworkHandler handles stopping and undefining a virtual network no VM is attached to
+func DeleteNetworkHandler(c *gin.Context) {
+	handleNetworkRequest(c, "delete network", deleteNetwork)
+}
+
+// StartNetworkHandler handles starting a virtual network
+func StartNetworkHandler(c *gin.Context) {
+	handleNetworkRequest(c, "start network", startNetwork)
+}
+
+// StopNetworkHandler handles stopping a virtual network no running VM is attached to
+func StopNetworkHandler(c *gin.Context) {
+	handleNetworkRequest(c, "stop network", stopNetwork)
+}
+
+// UpdateNetworkDHCPHandler handles replacing the DHCP range of a virtual network
+func UpdateNetworkDHCPHandler(c *gin.Context) {
+	var request DHCPRange
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	handleNetworkRequest(c, "update network dhcp", func(name string, lq LibvirtQemu) (*NetworkResponse, error) {
+		return updateNetworkDHCP(name, &request, lq)
+	})
+}
+
+// handleNetworkRequest validates the network name, connects to libvirt and runs the action on
+// the network, mapping its errors to the documented responses
+func handleNetworkRequest[T any](c *gin.Context, endpoint string, action func(name string, lq LibvirtQemu) (*T, error)) {
+	logger, lq, ok := prepareLibvirtRequest(c, endpoint)
+	if !ok {
+		return
+	}
+
+	name := c.Param("name")
+	if !networkNamePattern.MatchString(name) {
+		logger.Error("Invalid network name", "name", name)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid network name",
+			},
+		})
+		return
+	}
+
+	response, err := action(name, lq)
+	if err != nil {
+		logger.Error("Failed to "+endpoint, "network", name, "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOperationHandler handles polling the status, progress, result and error of an operation
+func GetOperationHandler(c *gin.Context) {
+	operations := operationsFromContext(c)
+	if operations == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	op, ok := operations.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusNotFound,
+				Message: "Operation not found",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, op)
+}
+
+// CancelOperationHandler handles cancelling a running operation. The action stops at its next
+// step, so the operation may still be running when the response is sent.
+func CancelOperationHandler(c *gin.Context) {
+	operations := operationsFromContext(c)
+	if operations == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	op, err := operations.Cancel(c.Param("id"))
+	switch err {
+	case nil:
+		c.JSON(http.StatusAccepted, op)
+	case errOperationNotFound:
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusNotFound,
+				Message: "Operation not found",
+			},
+		})
+	default:
+		c.JSON(http.StatusConflict, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusConflict,
+				Message: "Operation already finished",
+			},
+		})
+	}
+}
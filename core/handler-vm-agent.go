@@ -0,0 +1,154 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAgentExecTimeout and maxAgentExecTimeout bound how long a command is waited for, in seconds
+const (
+	defaultAgentExecTimeout = 30
+	maxAgentExecTimeout     = 300
+)
+
+// AgentExecRequest represents the body of a request to run a command in the guest
+type AgentExecRequest struct {
+	Path    string   `json:"path"`              // The program to run (e.g., "/usr/bin/systemctl")
+	Args    []string `json:"args,omitempty"`    // The arguments passed to the program
+	Env     []string `json:"env,omitempty"`     // Environment variables as KEY=value, the guest agent environment is used otherwise
+	Input   string   `json:"input,omitempty"`   // Text written to the standard input of the program
+	Timeout int      `json:"timeout,omitempty"` // How long to wait for the program to exit in seconds (default 30, max 300)
+}
+
+// AgentExecResponse represents the result of a command run in the guest
+type AgentExecResponse struct {
+	VMID            string `json:"vm_id"`                      // The UUID of the VM
+	PID             int    `json:"pid"`                        // The process ID of the command in the guest
+	ExitCode        int    `json:"exit_code"`                  // The exit code, -1 when the program was killed by a signal
+	Signal          *int   `json:"signal,omitempty"`           // The signal that killed the program
+	Stdout          string `json:"stdout"`                     // The standard output of the program
+	Stderr          string `json:"stderr"`                     // The standard error of the program
+	StdoutTruncated bool   `json:"stdout_truncated,omitempty"` // The guest agent dropped the output beyond its buffer
+	StderrTruncated bool   `json:"stderr_truncated,omitempty"` // The guest agent dropped the error output beyond its buffer
+}
+
+// AgentFileResponse represents a file read from the guest
+type AgentFileResponse struct {
+	VMID     string `json:"vm_id"`    // The UUID of the VM
+	Path     string `json:"path"`     // The path of the file in the guest
+	Size     int    `json:"size"`     // The size of the file in bytes
+	Encoding string `json:"encoding"` // How the content is encoded: base64 or text
+	Content  string `json:"content"`  // The content of the file
+}
+
+// AgentFileWriteRequest represents the body of a request to write a file in the guest
+type AgentFileWriteRequest struct {
+	Path     string `json:"path"`               // The path of the file in the guest, created or replaced
+	Content  string `json:"content"`            // The content of the file
+	Encoding string `json:"encoding,omitempty"` // How the content is encoded: base64 (default) or text
+}
+
+// AgentFileWriteResponse represents the result of writing a file in the guest
+type AgentFileWriteResponse struct {
+	VMID    string `json:"vm_id"`   // The UUID of the VM
+	Path    string `json:"path"`    // The path of the file in the guest
+	Size    int    `json:"size"`    // The number of bytes written
+	Status  string `json:"status"`  // The status of the write (e.g., "written")
+	Message string `json:"message"` // Message describing the result of the write
+}
+
+// AgentFSInfoResponse represents the filesystems mounted in the guest
+type AgentFSInfoResponse struct {
+	VMID        string            `json:"vm_id"`       // The UUID of the VM
+	Filesystems []AgentFilesystem `json:"filesystems"` // The mounted filesystems as reported by the guest agent
+}
+
+// AgentFilesystem represents a filesystem mounted in the guest
+type AgentFilesystem struct {
+	Name       string                `json:"name"`                  // The guest device name (e.g., "vda1")
+	Mountpoint string                `json:"mountpoint"`            // Where the filesystem is mounted (e.g., "/")
+	Type       string                `json:"type"`                  // The filesystem type (e.g., "ext4")
+	UsedBytes  *uint64               `json:"used_bytes,omitempty"`  // The used space, unknown to older guest agents
+	TotalBytes *uint64               `json:"total_bytes,omitempty"` // The size of the filesystem, unknown to older guest agents
+	Disks      []AgentFilesystemDisk `json:"disks"`                 // The disks backing the filesystem
+}
+
+// AgentFilesystemDisk represents a disk backing a guest filesystem
+type AgentFilesystemDisk struct {
+	Device string `json:"device,omitempty"` // The guest device path (e.g., "/dev/vda1")
+	Serial string `json:"serial,omitempty"` // The serial number of the disk
+	Bus    string `json:"bus,omitempty"`    // The bus of the disk (e.g., "virtio")
+}
+
+// AgentExecHandler handles running a command in the guest through the QEMU guest agent
+func AgentExecHandler(c *gin.Context) {
+	var request AgentExecRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if request.Path == "" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: path is required",
+			},
+		})
+		return
+	}
+
+	if request.Timeout < 0 || request.Timeout > maxAgentExecTimeout {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: timeout must be between 0 and 300 seconds, 0 uses the default of 30 seconds",
+			},
+		})
+		return
+	}
+
+	// Waiting for the program outlasts the HTTP timeouts, the command runs as an operation
+	handleVMOperation(c, "agent exec", "agent_exec", func(c *gin.Context, vmID string, lq LibvirtQemu) (*AgentExecResponse, error) {
+		return execGuestCommand(c, vmID, &request, lq)
+	})
+}
+
+// GetAgentFileHandler handles reading a file of the guest, given by the path query parameter.
+// The optional encoding query parameter selects base64 (default) or text content.
+func GetAgentFileHandler(c *gin.Context) {
+	handleVMRequest(c, "agent read file", func(vmID string, lq LibvirtQemu) (*AgentFileResponse, error) {
+		return readGuestFile(vmID, c.Query("path"), c.DefaultQuery("encoding", "base64"), lq)
+	})
+}
+
+// PutAgentFileHandler handles creating or replacing a file of the guest
+func PutAgentFileHandler(c *gin.Context) {
+	var request AgentFileWriteRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	handleVMRequest(c, "agent write file", func(vmID string, lq LibvirtQemu) (*AgentFileWriteResponse, error) {
+		return writeGuestFile(vmID, &request, lq)
+	})
+}
+
+// GetAgentFSInfoHandler handles listing the filesystems mounted in the guest
+func GetAgentFSInfoHandler(c *gin.Context) {
+	handleVMRequest(c, "agent fsinfo", getGuestFSInfo)
+}
@@ -0,0 +1,54 @@
+package core
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// VMConfigResponse represents the response structure for retrieving a VM's configuration
+type VMConfigResponse struct {
+	ID            string          `json:"id"`                    // Unique UUID identifier of the VM
+	Name          string          `json:"name"`                  // The name of the VM
+	VCPUs         int             `json:"vcpus"`                 // Number of virtual CPUs assigned to the VM
+	Memory        int             `json:"memory"`                // Maximum memory (in MB) allocated to the VM
+	CurrentMemory int             `json:"current_memory"`        // Memory (in MB) currently available to the guest
+	DiskSize      int             `json:"disk_size"`             // Root disk size in GB
+	CPUPinning    *CPUPinning     `json:"cpu_pinning,omitempty"` // Host cores the vCPUs are pinned to, if any
+	VCPUPins      []VCPUPinConfig `json:"vcpu_pins,omitempty"`   // The raw vCPU pins, only when they aren't one core per vCPU
+	IOLimits      *IOLimits       `json:"io_limits,omitempty"`   // I/O limits of the root disk, if any
+	Disks         []VMDiskConfig  `json:"disks"`                 // All disks attached to the VM
+	NICs          []VMNICConfig   `json:"nics"`                  // All network interfaces of the VM
+}
+
+// VCPUPinConfig describes the host cores a vCPU is pinned to, as libvirt holds them
+type VCPUPinConfig struct {
+	VCPU   int    `json:"vcpu"`   // The index of the vCPU
+	CPUSet string `json:"cpuset"` // The libvirt cpuset of the host cores (e.g., "0-3,^2")
+}
+
+// VMDiskConfig describes a disk attached to the VM
+type VMDiskConfig struct {
+	Target    string    `json:"target"`              // Device name in the guest (e.g., "vda")
+	Bus       string    `json:"bus"`                 // Disk bus (e.g., "virtio")
+	Device    string    `json:"device"`              // Device kind (e.g., "disk", "cdrom")
+	Path      string    `json:"path,omitempty"`      // Path to the image or block device on the host
+	Format    string    `json:"format,omitempty"`    // Image format (e.g., "qcow2")
+	SizeBytes uint64    `json:"size_bytes"`          // Virtual size of the disk in bytes
+	IOLimits  *IOLimits `json:"io_limits,omitempty"` // I/O limits of the disk, if any
+}
+
+// VMNICConfig describes a network interface of the VM
+type VMNICConfig struct {
+	MacAddress string            `json:"mac_address"`         // MAC address of the interface
+	Type       string            `json:"type"`                // Interface type (e.g., "network", "bridge")
+	Source     string            `json:"source,omitempty"`    // Libvirt network or host bridge the interface is attached to
+	Model      string            `json:"model,omitempty"`     // Device model (e.g., "virtio")
+	VLAN       int               `json:"vlan,omitempty"`      // VLAN tag of the interface, if any
+	Bandwidth  *NetworkBandwidth `json:"bandwidth,omitempty"` // Bandwidth limits of the interface, if any
+}
+
+// GetVMConfigHandler handles retrieving the configuration of a specific VM
+func GetVMConfigHandler(c *gin.Context) {
+	handleVMRequest(c, "vm config", func(vmID string, lq LibvirtQemu) (*VMConfigResponse, error) {
+		return getVMConfig(c, vmID, lq)
+	})
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// consoleUpgrader upgrades console requests to WebSockets. noVNC asks for the "binary" subprotocol,
+// browsers on another origin are refused so a page can't open a console on the user's behalf.
+var consoleUpgrader = websocket.Upgrader{
+	ReadBufferSize:  consoleBufferSize,
+	WriteBufferSize: consoleBufferSize,
+	Subprotocols:    []string{"binary"},
+}
+
+// SerialConsoleHandler handles streaming the serial console of a running VM over a WebSocket
+func SerialConsoleHandler(c *gin.Context) {
+	logger, vmID, lq, ok := prepareVMRequest(c, "serial console")
+	if !ok {
+		return
+	}
+
+	// A console held by another client is only taken over when asked to
+	force, err := strconv.ParseBool(c.DefaultQuery("force", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: force must be true or false",
+			},
+		})
+		return
+	}
+
+	console, err := openSerialConsole(vmID, force, lq)
+	if err != nil {
+		logger.Error("Failed to open the serial console", "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	serveConsole(c, logger, console)
+}
+
+// VNCConsoleHandler handles proxying the VNC display of a running VM over a WebSocket, for noVNC
+func VNCConsoleHandler(c *gin.Context) {
+	logger, vmID, lq, ok := prepareVMRequest(c, "vnc console")
+	if !ok {
+		return
+	}
+
+	address, err := vncAddress(vmID, lq)
+	if err != nil {
+		logger.Error("Failed to find the VNC display", "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	display, err := dialVNC(address)
+	if err != nil {
+		logger.Error("Failed to connect to the VNC display", "address", address, "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	serveConsole(c, logger, display)
+}
+
+// serveConsole upgrades the request to a WebSocket and proxies it to the console until either side
+// closes. The console is closed in any case.
+func serveConsole(c *gin.Context, logger *slog.Logger, console io.ReadWriteCloser) {
+	ws, err := consoleUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader already answered the request
+		console.Close()
+		logger.Error("Failed to upgrade to a WebSocket", "error", err)
+		return
+	}
+
+	logger.Info("Console session started")
+	if err := proxyWebSocket(ws, console); err != nil {
+		logger.Info("Console session ended", "error", err)
+		return
+	}
+	logger.Info("Console session ended")
+}
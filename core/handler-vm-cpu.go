@@ -0,0 +1,67 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateVMCPURequest represents the body of a request to change the CPU configuration of a VM.
+type UpdateVMCPURequest struct {
+	VCPUs      int         `json:"vcpus"`                 // The number of virtual CPUs to assign to the VM.
+	CPUPinning *CPUPinning `json:"cpu_pinning,omitempty"` // Optional host cores to pin the vCPUs to, one per vCPU.
+}
+
+// UpdateVMCPUResponse represents the response structure for a CPU configuration update
+type UpdateVMCPUResponse struct {
+	ID             string      `json:"id"`                    // The UUID of the updated VM
+	VCPUs          int         `json:"vcpus"`                 // The number of virtual CPUs assigned to the VM
+	CPUPinning     *CPUPinning `json:"cpu_pinning,omitempty"` // The host cores the vCPUs are pinned to
+	Status         string      `json:"status"`                // The status of the update (e.g., "updated")
+	RebootRequired bool        `json:"reboot_required"`       // The change is saved but only takes effect after a reboot
+	Message        string      `json:"message"`               // Message describing the result of the update
+}
+
+// UpdateVMCPUHandler handles changing the vCPU count and pinning of a VM
+func UpdateVMCPUHandler(c *gin.Context) {
+	var request UpdateVMCPURequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := validateUpdateVMCPURequest(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	handleVMRequest(c, "update vm cpu", func(vmID string, lq LibvirtQemu) (*UpdateVMCPUResponse, error) {
+		return updateVMCPU(c, vmID, &request, lq)
+	})
+}
+
+// validateUpdateVMCPURequest checks the parts of the request that don't depend on the host
+func validateUpdateVMCPURequest(request *UpdateVMCPURequest) error {
+	if request.VCPUs <= 0 {
+		return fmt.Errorf("Invalid parameter: vcpus must be greater than 0")
+	}
+	if request.CPUPinning != nil && len(request.CPUPinning.Cores) != request.VCPUs {
+		return fmt.Errorf("Invalid parameter: cpu_pinning must list exactly one core per vCPU")
+	}
+	if request.CPUPinning != nil && (len(request.CPUPinning.EmulatorCores) > 0 || len(request.CPUPinning.IOThreadCores) > 0) {
+		return fmt.Errorf("Invalid parameter: emulator_cores and iothread_cores can only be set when creating the VM")
+	}
+	return nil
+}
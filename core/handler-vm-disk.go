@@ -0,0 +1,50 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResizeVMDiskRequest represents the body of a request to resize the root disk of a VM.
+type ResizeVMDiskRequest struct {
+	DiskSize    int  `json:"disk_size"`              // The new size for the root disk in GB.
+	AllowShrink bool `json:"allow_shrink,omitempty"` // Allow a size smaller than the current one, the VM must be stopped.
+}
+
+// ResizeVMDiskResponse represents the response structure for a disk resize
+type ResizeVMDiskResponse struct {
+	ID       string `json:"id"`        // The UUID of the updated VM
+	DiskSize int    `json:"disk_size"` // The new size of the root disk in GB
+	Status   string `json:"status"`    // The status of the resize (e.g., "updated")
+	Message  string `json:"message"`   // Message describing the result of the resize
+}
+
+// ResizeVMDiskHandler handles resizing the root disk of a VM
+func ResizeVMDiskHandler(c *gin.Context) {
+	var request ResizeVMDiskRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if request.DiskSize <= 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: disk_size must be greater than 0",
+			},
+		})
+		return
+	}
+
+	handleVMRequest(c, "resize vm disk", func(vmID string, lq LibvirtQemu) (*ResizeVMDiskResponse, error) {
+		return resizeVMDisk(c, vmID, &request, lq)
+	})
+}
@@ -0,0 +1,30 @@
+package core
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// VMInterfacesResponse represents the NICs of a VM and the IP addresses found for them
+type VMInterfacesResponse struct {
+	VMID       string        `json:"vm_id"`      // The UUID of the VM
+	Source     string        `json:"source"`     // Where the addresses were read from: lease, agent or arp
+	Interfaces []VMInterface `json:"interfaces"` // Every NIC of the VM, in the order of the domain definition
+}
+
+// VMInterface represents a NIC of the VM and its addresses
+type VMInterface struct {
+	Name       string   `json:"name,omitempty"`    // The device name reported by the source (e.g., "vnet0", or "eth0" from the agent)
+	MacAddress string   `json:"mac_address"`       // The MAC address of the NIC
+	Network    string   `json:"network,omitempty"` // The libvirt network the NIC is attached to
+	Bridge     string   `json:"bridge,omitempty"`  // The host bridge the NIC is attached to
+	IPv4       []string `json:"ipv4"`              // The IPv4 addresses in CIDR notation (e.g., "192.168.122.10/24")
+	IPv6       []string `json:"ipv6"`              // The IPv6 addresses in CIDR notation
+}
+
+// GetVMInterfacesHandler handles listing the NICs of a VM with their IP addresses. The
+// optional source query parameter selects where the addresses come from (lease by default).
+func GetVMInterfacesHandler(c *gin.Context) {
+	handleVMRequest(c, "get vm interfaces", func(vmID string, lq LibvirtQemu) (*VMInterfacesResponse, error) {
+		return getVMInterfaces(vmID, c.DefaultQuery("source", "lease"), lq)
+	})
+}
@@ -0,0 +1,61 @@
+package core
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateVMIOLimitsRequest represents the body of a request to change the I/O limits of a VM disk.
+type UpdateVMIOLimitsRequest struct {
+	Disk     string    `json:"disk,omitempty"` // Optional target of the disk (e.g., "vda"), defaults to the root disk.
+	IOLimits *IOLimits `json:"io_limits"`      // The new I/O limits, limits left out are cleared.
+}
+
+// UpdateVMIOLimitsResponse represents the response structure for an I/O limits update
+type UpdateVMIOLimitsResponse struct {
+	ID       string    `json:"id"`        // The UUID of the updated VM
+	Disk     string    `json:"disk"`      // The target of the throttled disk
+	IOLimits *IOLimits `json:"io_limits"` // The I/O limits now applied to the disk
+	Status   string    `json:"status"`    // The status of the update (e.g., "updated")
+	Message  string    `json:"message"`   // Message describing the result of the update
+}
+
+// UpdateVMIOLimitsHandler handles changing the I/O throttling of a VM disk
+func UpdateVMIOLimitsHandler(c *gin.Context) {
+	var request UpdateVMIOLimitsRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if request.IOLimits == nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: io_limits is required",
+			},
+		})
+		return
+	}
+
+	if err := validateIOLimits(request.IOLimits); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	handleVMRequest(c, "update vm io limits", func(vmID string, lq LibvirtQemu) (*UpdateVMIOLimitsResponse, error) {
+		return updateVMIOLimits(c, vmID, &request, lq)
+	})
+}
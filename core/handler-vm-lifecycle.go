@@ -0,0 +1,76 @@
+package core
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultStopTimeout is how long a graceful stop waits before forcing the VM off
+const defaultStopTimeout = 30 * time.Second
+
+// VMLifecycleResponse represents the response structure for lifecycle actions (start, stop, reboot)
+type VMLifecycleResponse struct {
+	ID      string `json:"id"`      // The UUID of the VM
+	Status  string `json:"status"`  // The status of the VM after the action (e.g., "running", "stopped")
+	Message string `json:"message"` // Message describing the result of the action
+}
+
+// StopVMRequest represents the optional body of a request to stop a VM.
+type StopVMRequest struct {
+	Force   bool `json:"force"`   // Skip the graceful shutdown and destroy the VM immediately.
+	Timeout int  `json:"timeout"` // Seconds to wait for a graceful shutdown before forcing the VM off.
+}
+
+// StartVMHandler handles starting a VM
+func StartVMHandler(c *gin.Context) {
+	handleVMRequest(c, "start vm", func(vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return startVM(c, vmID, lq)
+	})
+}
+
+// StopVMHandler handles stopping a VM, gracefully by default
+func StopVMHandler(c *gin.Context) {
+	var request StopVMRequest
+
+	// The body is optional, an empty one means a graceful stop with the default timeout
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if request.Timeout < 0 {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: timeout must not be negative",
+			},
+		})
+		return
+	}
+
+	timeout := defaultStopTimeout
+	if request.Timeout > 0 {
+		timeout = time.Duration(request.Timeout) * time.Second
+	}
+
+	// A graceful stop can take up to the timeout, it runs as an operation
+	handleVMOperation(c, "stop vm", "stop_vm", func(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return stopVM(c, vmID, request.Force, timeout, lq)
+	})
+}
+
+// RebootVMHandler handles rebooting a running VM
+func RebootVMHandler(c *gin.Context) {
+	handleVMRequest(c, "reboot vm", func(vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return rebootVM(c, vmID, lq)
+	})
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateVMMemoryRequest represents the body of a request to change the memory of a VM.
+type UpdateVMMemoryRequest struct {
+	Memory    int `json:"memory"`               // Memory (in MB) available to the guest, changed live through the balloon.
+	MaxMemory int `json:"max_memory,omitempty"` // Optional maximum memory (in MB), applied on the next VM start.
+}
+
+// UpdateVMMemoryResponse represents the response structure for a memory configuration update
+type UpdateVMMemoryResponse struct {
+	ID             string `json:"id"`              // The UUID of the updated VM
+	Memory         int    `json:"memory"`          // Memory (in MB) available to the guest
+	MaxMemory      int    `json:"max_memory"`      // Maximum memory (in MB) of the VM
+	Status         string `json:"status"`          // The status of the update (e.g., "updated")
+	RebootRequired bool   `json:"reboot_required"` // The change is saved but only takes effect after a reboot
+	Message        string `json:"message"`         // Message describing the result of the update
+}
+
+// UpdateVMMemoryHandler handles changing the memory of a VM
+func UpdateVMMemoryHandler(c *gin.Context) {
+	var request UpdateVMMemoryRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if err := validateUpdateVMMemoryRequest(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	handleVMRequest(c, "update vm memory", func(vmID string, lq LibvirtQemu) (*UpdateVMMemoryResponse, error) {
+		return updateVMMemory(c, vmID, &request, lq)
+	})
+}
+
+// validateUpdateVMMemoryRequest checks the parts of the request that don't depend on the host
+func validateUpdateVMMemoryRequest(request *UpdateVMMemoryRequest) error {
+	if request.Memory <= 0 {
+		return fmt.Errorf("Invalid parameter: memory must be greater than 0")
+	}
+	if request.MaxMemory < 0 {
+		return fmt.Errorf("Invalid parameter: max_memory must be greater than 0")
+	}
+	if request.MaxMemory > 0 && request.Memory > request.MaxMemory {
+		return fmt.Errorf("Invalid parameter: memory must not exceed max_memory")
+	}
+	return nil
+}
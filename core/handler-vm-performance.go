@@ -0,0 +1,58 @@
+package core
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// VMPerformanceResponse represents the performance metrics of a running VM. Totals are counters
+// since the VM started, rates are computed over the sampling interval.
+type VMPerformanceResponse struct {
+	ID                string              `json:"id"`                  // The UUID of the VM
+	SampleInterval    float64             `json:"sample_interval"`     // The interval between the two stats samples in seconds
+	CPUUsage          float64             `json:"cpu_usage"`           // The CPU usage percentage across all vCPUs of the VM
+	MemoryUsage       int                 `json:"memory_usage"`        // The memory used by the VM in MB
+	MemoryTotal       int                 `json:"memory_total"`        // The memory currently assigned to the VM in MB
+	DiskReadBytes     uint64              `json:"disk_read_bytes"`     // Total bytes read from all disks
+	DiskWriteBytes    uint64              `json:"disk_write_bytes"`    // Total bytes written to all disks
+	DiskReadIOPS      float64             `json:"disk_read_iops"`      // Disk read operations per second
+	DiskWriteIOPS     float64             `json:"disk_write_iops"`     // Disk write operations per second
+	NetworkInBytes    uint64              `json:"network_in_bytes"`    // Total bytes received on all NICs
+	NetworkOutBytes   uint64              `json:"network_out_bytes"`   // Total bytes sent on all NICs
+	NetworkInPackets  float64             `json:"network_in_packets"`  // Packets received per second
+	NetworkOutPackets float64             `json:"network_out_packets"` // Packets sent per second
+	Disks             []VMDiskPerformance `json:"disks"`               // Per disk I/O metrics
+	NICs              []VMNICPerformance  `json:"nics"`                // Per NIC traffic metrics
+}
+
+// VMDiskPerformance represents the I/O metrics of a single disk of the VM
+type VMDiskPerformance struct {
+	Target           string  `json:"target"`              // The target device of the disk (e.g., "vda")
+	ReadBytes        uint64  `json:"read_bytes"`          // Total bytes read
+	WriteBytes       uint64  `json:"write_bytes"`         // Total bytes written
+	ReadOps          uint64  `json:"read_ops"`            // Total read operations
+	WriteOps         uint64  `json:"write_ops"`           // Total write operations
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`  // Bytes read per second
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"` // Bytes written per second
+	ReadIOPS         float64 `json:"read_iops"`           // Read operations per second
+	WriteIOPS        float64 `json:"write_iops"`          // Write operations per second
+}
+
+// VMNICPerformance represents the traffic metrics of a single NIC of the VM
+type VMNICPerformance struct {
+	Name            string  `json:"name"`               // The host side device of the NIC (e.g., "vnet0")
+	RxBytes         uint64  `json:"rx_bytes"`           // Total bytes received
+	TxBytes         uint64  `json:"tx_bytes"`           // Total bytes sent
+	RxPackets       uint64  `json:"rx_packets"`         // Total packets received
+	TxPackets       uint64  `json:"tx_packets"`         // Total packets sent
+	RxBytesPerSec   float64 `json:"rx_bytes_per_sec"`   // Bytes received per second
+	TxBytesPerSec   float64 `json:"tx_bytes_per_sec"`   // Bytes sent per second
+	RxPacketsPerSec float64 `json:"rx_packets_per_sec"` // Packets received per second
+	TxPacketsPerSec float64 `json:"tx_packets_per_sec"` // Packets sent per second
+}
+
+// GetVMPerformanceHandler handles retrieving the real-time performance metrics of a VM
+func GetVMPerformanceHandler(c *gin.Context) {
+	handleVMRequest(c, "get vm performance", func(vmID string, lq LibvirtQemu) (*VMPerformanceResponse, error) {
+		return getVMPerformance(c, vmID, lq)
+	})
+}
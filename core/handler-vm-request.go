@@ -0,0 +1,213 @@
+package core
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// handleVMRequest validates the VM ID, connects to libvirt and runs the action on the VM,
+// mapping its errors to the documented responses
+func handleVMRequest[T any](c *gin.Context, endpoint string, action func(vmID string, lq LibvirtQemu) (*T, error)) {
+	logger, vmID, lq, ok := prepareVMRequest(c, endpoint)
+	if !ok {
+		return
+	}
+
+	response, err := action(vmID, lq)
+	if err != nil {
+		logger.Error("Failed to "+endpoint, "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// handleVMOperation validates the VM ID, connects to libvirt and runs the action on the VM in
+// the background, answering 202 with the operation to poll
+func handleVMOperation[T any](c *gin.Context, endpoint, opType string, action func(c *gin.Context, vmID string, lq LibvirtQemu) (*T, error)) {
+	logger, vmID, lq, ok := prepareVMRequest(c, endpoint)
+	if !ok {
+		return
+	}
+
+	startOperation(c, logger, opType, vmID, func(c *gin.Context) (any, error) {
+		return action(c, vmID, lq)
+	})
+}
+
+// startOperation runs the action in the background and answers 202 with the operation. The action
+// gets a copy of the Gin context, safe to use after the handler returned, carrying its progress
+// and the logger of the endpoint.
+func startOperation(c *gin.Context, logger *slog.Logger, opType, vmID string, action func(c *gin.Context) (any, error)) {
+	operations := operationsFromContext(c)
+	if operations == nil {
+		logger.Error("No operation manager in the context")
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return
+	}
+
+	background := c.Copy()
+	background.Set("logger", logger)
+	op, err := operations.Start(opType, vmID, func(progress *Progress) (any, error) {
+		background.Set("progress", progress)
+		result, err := action(background)
+		if err != nil {
+			logger.Error("Operation failed", "operation_id", progress.id, "type", opType, "error", err)
+		}
+		return result, err
+	})
+	if err != nil {
+		logger.Error("Failed to start the operation", "type", opType, "error", err)
+		code, details := errorDetails(err)
+		c.JSON(code, ErrorResponse{Error: details})
+		return
+	}
+
+	c.Header("Location", "/operations/"+op.ID)
+	c.JSON(http.StatusAccepted, op)
+}
+
+// loggerFromContext returns the logger of the request, or the default logger when there is none
+func loggerFromContext(c *gin.Context) *slog.Logger {
+	if c == nil {
+		return slog.Default()
+	}
+	if l, ok := c.Get("logger"); ok {
+		if logger, ok := l.(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// prepareVMRequest gets the logger, validates the VM ID and connects to libvirt,
+// it answers the request itself and returns false when one of them fails
+func prepareVMRequest(c *gin.Context, endpoint string) (*slog.Logger, string, LibvirtQemu, bool) {
+	// Get the logger from the Gin context
+	l, ok := c.Get("logger")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, "", nil, false
+	}
+
+	logger, ok := l.(*slog.Logger)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, "", nil, false
+	}
+
+	logger = logger.With("endpoint", endpoint)
+
+	// Extract the VM ID from the URL path (e.g., /vms/{id}/start)
+	vmID := c.Param("id")
+
+	// Validate that the VM ID is a valid UUID
+	if _, err := uuid.Parse(vmID); err != nil {
+		logger.Error("Failed to parse id", "error", err)
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid UUID format",
+			},
+		})
+		return nil, "", nil, false
+	}
+
+	lq, err := libvirtFromContext(c)
+	if err != nil {
+		logger.Error("Fail to connect to libvirt", "error", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, "", nil, false
+	}
+
+	return logger, vmID, lq, true
+}
+
+// errorDetails maps the error of an action to the documented status code and error body
+func errorDetails(err error) (int, ErrorDetails) {
+	switch e := err.(type) {
+	case *NotFoundError:
+		return http.StatusNotFound, ErrorDetails{Code: http.StatusNotFound, Message: resourceKind(e.Resource) + " not found"}
+	case *BadRequestError:
+		return http.StatusBadRequest, ErrorDetails{Code: http.StatusBadRequest, Message: e.Message}
+	case *ConflictError:
+		return http.StatusConflict, ErrorDetails{Code: http.StatusConflict, Message: e.Message}
+	case *StepError:
+		message := fmt.Sprintf("Step %s failed, the changes made before it were rolled back", e.Step)
+		if len(e.RollbackErrors) > 0 {
+			message = fmt.Sprintf("Step %s failed and the rollback is incomplete", e.Step)
+		}
+		return http.StatusInternalServerError, ErrorDetails{Code: http.StatusInternalServerError, Message: message, Step: e.Step}
+	default:
+		return http.StatusInternalServerError, ErrorDetails{Code: http.StatusInternalServerError, Message: "Internal server error"}
+	}
+}
+
+// prepareLibvirtRequest gets the logger and connects to libvirt, it answers the request
+// itself and returns false when one of them fails
+func prepareLibvirtRequest(c *gin.Context, endpoint string) (*slog.Logger, LibvirtQemu, bool) {
+	// Get the logger from the Gin context
+	l, ok := c.Get("logger")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, nil, false
+	}
+
+	logger, ok := l.(*slog.Logger)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, nil, false
+	}
+
+	logger = logger.With("endpoint", endpoint)
+
+	lq, err := libvirtFromContext(c)
+	if err != nil {
+		logger.Error("Fail to connect to libvirt", "error", err)
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusInternalServerError,
+				Message: "Internal server error",
+			},
+		})
+		return nil, nil, false
+	}
+
+	return logger, lq, true
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// snapshotNamePattern limits snapshot names to characters that are safe in file names and URLs
+var snapshotNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+
+// SnapshotRequest represents the body of a request to take a snapshot of a VM.
+type SnapshotRequest struct {
+	Name        string `json:"name"`                  // Unique name of the snapshot within the VM.
+	Description string `json:"description,omitempty"` // Optional free text description.
+	Type        string `json:"type,omitempty"`        // "internal" (default) keeps the snapshot in the qcow2 image, "external" writes the changes to overlay files.
+	Memory      *bool  `json:"memory,omitempty"`      // Save the memory state of a running VM, the default for internal snapshots of a running VM.
+}
+
+// SnapshotResponse represents a snapshot of a VM
+type SnapshotResponse struct {
+	VMID        string    `json:"vm_id"`                 // The UUID of the VM
+	Name        string    `json:"name"`                  // The name of the snapshot
+	Description string    `json:"description,omitempty"` // The description of the snapshot
+	Type        string    `json:"type"`                  // "internal" or "external"
+	State       string    `json:"state"`                 // The state of the VM when the snapshot was taken (e.g., "running", "shutoff", "disk-snapshot")
+	Memory      bool      `json:"memory"`                // Whether the memory state was saved, reverting resumes the VM where it was
+	Quiesced    bool      `json:"quiesced,omitempty"`    // Whether the guest filesystems were frozen by the guest agent, only reported on creation
+	Parent      string    `json:"parent,omitempty"`      // The snapshot this one was taken on top of
+	Current     bool      `json:"current"`               // Whether the VM was last reverted to or snapshotted as this snapshot
+	CreatedAt   time.Time `json:"created_at"`            // When the snapshot was taken
+}
+
+// ListSnapshotsResponse represents the snapshots of a VM
+type ListSnapshotsResponse struct {
+	VMID      string             `json:"vm_id"`     // The UUID of the VM
+	Snapshots []SnapshotResponse `json:"snapshots"` // The snapshots, oldest first
+}
+
+// SnapshotRevertResponse represents the result of reverting a VM to a snapshot
+type SnapshotRevertResponse struct {
+	VMID    string `json:"vm_id"`   // The UUID of the VM
+	Name    string `json:"name"`    // The name of the snapshot
+	Status  string `json:"status"`  // The status of the VM after the revert (e.g., "running", "stopped")
+	Message string `json:"message"` // Message describing the result of the revert
+}
+
+// SnapshotDeletionResponse represents the response structure for a snapshot deletion
+type SnapshotDeletionResponse struct {
+	VMID    string `json:"vm_id"`   // The UUID of the VM
+	Name    string `json:"name"`    // The name of the deleted snapshot
+	Status  string `json:"status"`  // The status of the deletion (e.g., "deleted")
+	Message string `json:"message"` // Message describing the result of the deletion
+}
+
+// ListVMSnapshotsHandler handles listing the snapshots of a VM
+func ListVMSnapshotsHandler(c *gin.Context) {
+	handleVMRequest(c, "list vm snapshots", listVMSnapshots)
+}
+
+// CreateVMSnapshotHandler handles taking a snapshot of a VM. Saving the memory of a large VM
+// takes a while, the snapshot is taken as an operation.
+func CreateVMSnapshotHandler(c *gin.Context) {
+	var request SnapshotRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		})
+		return
+	}
+
+	if !snapshotNamePattern.MatchString(request.Name) {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: name must be 1 to 64 letters, digits, '.', '_' or '-'",
+			},
+		})
+		return
+	}
+
+	if request.Type == "" {
+		request.Type = "internal"
+	}
+	if request.Type != "internal" && request.Type != "external" {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error: ErrorDetails{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid parameter: type must be internal or external",
+			},
+		})
+		return
+	}
+
+	handleVMOperation(c, "create vm snapshot", "create_snapshot", func(c *gin.Context, vmID string, lq LibvirtQemu) (*SnapshotResponse, error) {
+		return createVMSnapshot(c, vmID, &request, lq)
+	})
+}
+
+// GetVMSnapshotHandler handles retrieving a snapshot of a VM
+func GetVMSnapshotHandler(c *gin.Context) {
+	handleVMRequest(c, "get vm snapshot", func(vmID string, lq LibvirtQemu) (*SnapshotResponse, error) {
+		return getVMSnapshot(vmID, c.Param("name"), lq)
+	})
+}
+
+// RevertVMSnapshotHandler handles reverting a VM to one of its snapshots
+func RevertVMSnapshotHandler(c *gin.Context) {
+	handleVMRequest(c, "revert vm snapshot", func(vmID string, lq LibvirtQemu) (*SnapshotRevertResponse, error) {
+		return revertVMSnapshot(vmID, c.Param("name"), lq)
+	})
+}
+
+// DeleteVMSnapshotHandler handles deleting a snapshot of a VM
+func DeleteVMSnapshotHandler(c *gin.Context) {
+	handleVMRequest(c, "delete vm snapshot", func(vmID string, lq LibvirtQemu) (*SnapshotDeletionResponse, error) {
+		return deleteVMSnapshot(vmID, c.Param("name"), lq)
+	})
+}
@@ -61,8 +61,8 @@ func GetVMStatus(c *gin.Context) {
 		return
 	}
 
-	lq := &LibvirtQemuImpl{}
-	if err := lq.NewConnect("qemu:///system"); err != nil {
+	lq, err := libvirtFromContext(c)
+	if err != nil {
 		logger.Error("Fail to connect to libvirt", "error", err)
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
 			Error: ErrorDetails{
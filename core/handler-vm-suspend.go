@@ -0,0 +1,33 @@
+package core
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// PauseVMHandler handles pausing a running VM
+func PauseVMHandler(c *gin.Context) {
+	handleVMRequest(c, "pause vm", func(vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return pauseVM(c, vmID, lq)
+	})
+}
+
+// ResumeVMHandler handles resuming a paused VM
+func ResumeVMHandler(c *gin.Context) {
+	handleVMRequest(c, "resume vm", func(vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return resumeVM(c, vmID, lq)
+	})
+}
+
+// SaveVMHandler handles saving a VM's memory to disk and stopping it
+func SaveVMHandler(c *gin.Context) {
+	handleVMRequest(c, "save vm", func(vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return saveVM(c, vmID, lq)
+	})
+}
+
+// RestoreVMHandler handles restoring a VM from its saved state
+func RestoreVMHandler(c *gin.Context) {
+	handleVMRequest(c, "restore vm", func(vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+		return restoreVM(c, vmID, lq)
+	})
+}
@@ -0,0 +1,414 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const defaultImageDir = "/var/lib/libvirt/images"
+
+// vmFilePattern matches the disks, seeds and snapshot files the API keeps for its VMs next to the
+// base images, they are never accepted as images so one VM can't be created on the disk of another
+var vmFilePattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}(\.qcow2|-seed\.iso|-snap-.*)$`)
+
+// ImageCatalog keeps the base images VMs are created from. Images can only be registered from a
+// set of allowed directories, so a request can't make the API clone or read any file of the host.
+type ImageCatalog struct {
+	dirs  []string // The resolved directories images and their backing files must live in
+	store Store    // Where the image records are kept
+
+	// Serializes the uniqueness checks and the record of concurrent registrations
+	mu sync.Mutex
+}
+
+// qemuImageInfo is the part of the qemu-img info JSON the catalog uses, one per image of the backing chain
+type qemuImageInfo struct {
+	Filename            string `json:"filename"`
+	Format              string `json:"format"`
+	VirtualSize         uint64 `json:"virtual-size"`
+	ActualSize          uint64 `json:"actual-size"`
+	FullBackingFilename string `json:"full-backing-filename"`
+}
+
+// NewImageCatalog validates the configured image directories and returns a catalog recording in store
+func NewImageCatalog(config ImagesConfig, store Store) (*ImageCatalog, error) {
+	dirs := config.Dirs
+	if len(dirs) == 0 {
+		dirs = []string{defaultImageDir}
+	}
+
+	catalog := &ImageCatalog{store: store}
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			return nil, fmt.Errorf("invalid image directory %q: the path must be absolute", dir)
+		}
+		// Images are checked by their resolved path, a directory behind a symlink is resolved too
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			dir = resolved
+		}
+		catalog.dirs = append(catalog.dirs, filepath.Clean(dir))
+	}
+	return catalog, nil
+}
+
+// Register inspects an image file of the allowed directories and adds it to the catalog. Only
+// qcow2 images whose backing chain stays in the allowed directories are accepted, and the file
+// must match the checksum when one is given.
+func (c *ImageCatalog) Register(request *ImageRegistrationRequest, lq LibvirtQemu) (*ImageResponse, error) {
+	path, err := c.resolve(request.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := inspectImage(path, lq)
+	if err != nil {
+		return nil, err
+	}
+	if chain[0].Format != "qcow2" {
+		return nil, NewBadRequestError("Image format %s is not supported, VM disks are created on qcow2 images", chain[0].Format)
+	}
+	backingChain, err := c.backingChain(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the image file: %v", err)
+	}
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return nil, err
+	}
+	if request.Checksum != "" && normalizeChecksum(request.Checksum) != checksum {
+		return nil, NewBadRequestError("Checksum mismatch: the image file has %s", checksum)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	records, err := c.store.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		if record.Name == request.Name {
+			return nil, NewResourceConflictError("Image", record.ID, fmt.Sprintf("Image name %s is already taken", request.Name))
+		}
+		if record.Path == path {
+			return nil, NewResourceConflictError("Image", record.ID, fmt.Sprintf("Image file is already registered as %s", record.Name))
+		}
+	}
+
+	record := &ImageRecord{
+		ID:           uuid.New().String(),
+		Name:         request.Name,
+		Description:  request.Description,
+		Path:         path,
+		Format:       chain[0].Format,
+		VirtualSize:  chain[0].VirtualSize,
+		FileSize:     info.Size(),
+		Checksum:     checksum,
+		BackingChain: backingChain,
+		CreatedAt:    time.Now(),
+	}
+	if err := c.store.PutImage(record); err != nil {
+		return nil, err
+	}
+	return imageResponse(record), nil
+}
+
+// List returns the registered images sorted by name
+func (c *ImageCatalog) List() (*ListImagesResponse, error) {
+	records, err := c.store.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+
+	response := &ListImagesResponse{Images: []ImageResponse{}}
+	for _, record := range records {
+		response.Images = append(response.Images, *imageResponse(record))
+	}
+	return response, nil
+}
+
+// Inspect returns a registered image as qemu-img sees it now
+func (c *ImageCatalog) Inspect(id string, lq LibvirtQemu) (*ImageResponse, error) {
+	record, err := c.store.GetImage(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(record.Path); os.IsNotExist(err) {
+		return nil, NewResourceConflictError("Image", id, "Image file is missing")
+	}
+
+	chain, err := inspectImage(record.Path, lq)
+	if err != nil {
+		return nil, err
+	}
+	backingChain, err := c.backingChain(chain)
+	if err != nil {
+		return nil, err
+	}
+
+	response := imageResponse(record)
+	response.Format = chain[0].Format
+	response.VirtualSize = chain[0].VirtualSize
+	response.ActualSize = chain[0].ActualSize
+	response.BackingChain = backingChain
+	return response, nil
+}
+
+// Delete removes an image no VM of the inventory and no other image is based on from the catalog.
+// The image file is left in place.
+func (c *ImageCatalog) Delete(id string) (*ImageDeletionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	record, err := c.store.GetImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The disks of the VMs are overlays on the image, it has to outlive them
+	vms, err := c.store.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+	var users []string
+	for _, vm := range vms {
+		if vm.Spec.Image == id {
+			users = append(users, vm.ID)
+		}
+	}
+	if len(users) > 0 {
+		sort.Strings(users)
+		return nil, NewResourceConflictError("Image", id, fmt.Sprintf("Image backs VMs, delete them first: %s", strings.Join(users, ", ")))
+	}
+
+	images, err := c.store.ListImages()
+	if err != nil {
+		return nil, err
+	}
+	for _, image := range images {
+		for _, backing := range image.BackingChain {
+			if backing == record.Path {
+				return nil, NewResourceConflictError("Image", id, fmt.Sprintf("Image backs image %s, delete it first", image.Name))
+			}
+		}
+	}
+
+	if err := c.store.DeleteImage(id); err != nil {
+		return nil, err
+	}
+	return &ImageDeletionResponse{
+		ID:      id,
+		Name:    record.Name,
+		Status:  "deleted",
+		Message: fmt.Sprintf("Image %s removed from the catalog, the file %s is left in place", record.Name, record.Path),
+	}, nil
+}
+
+// forVM returns the image a VM is created from, once the root disk of the VM is known to hold it and
+// the image file is still where and as large as it was registered
+func (c *ImageCatalog) forVM(id string, diskSizeGB int) (*ImageRecord, error) {
+	if id == "" {
+		return nil, NewBadRequestError("Invalid parameter: image is required")
+	}
+	record, err := c.store.GetImage(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// The disk of the VM is an overlay on the file, which must be the one that was checked. A link
+	// swapped in at the path resolves elsewhere, its content is checksummed by verify.
+	path, err := c.resolve(record.Path)
+	if err != nil || path != record.Path {
+		return nil, NewResourceConflictError("Image", id, "Image file is missing or changed since it was registered")
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != record.FileSize {
+		return nil, NewResourceConflictError("Image", id, "Image file is missing or changed since it was registered")
+	}
+
+	minSizeGB := int((record.VirtualSize + 1<<30 - 1) >> 30)
+	if diskSizeGB < minSizeGB {
+		return nil, NewBadRequestError("Invalid parameter: disk_size must be at least %d GB, the virtual size of image %s", minSizeGB, record.Name)
+	}
+	return record, nil
+}
+
+// verify checks that the image file still has the checksum recorded when it was registered. Reading
+// a large image takes a while, it's called from the operation creating the VM.
+func (c *ImageCatalog) verify(record *ImageRecord) error {
+	checksum, err := fileChecksum(record.Path)
+	if err != nil {
+		return err
+	}
+	if checksum != record.Checksum {
+		return NewResourceConflictError("Image", record.ID, fmt.Sprintf("Image file changed since it was registered, its checksum is now %s", checksum))
+	}
+	return nil
+}
+
+// resolve returns the real path of an image file in the allowed directories. The path is checked
+// before and after following symlinks, neither the request nor a link can lead outside of them.
+func (c *ImageCatalog) resolve(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", NewBadRequestError("Invalid parameter: path must be absolute")
+	}
+	path = filepath.Clean(path)
+	if !c.allowed(path) {
+		return "", c.outside(path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if os.IsNotExist(err) {
+		return "", NewBadRequestError("Image file not found: %s", path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the image path: %v", err)
+	}
+	if !c.allowed(resolved) {
+		return "", c.outside(path)
+	}
+
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the image file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", NewBadRequestError("Image path %s is not a regular file", path)
+	}
+	return resolved, nil
+}
+
+// backingChain lists the backing files of the inspected image, refusing any outside the allowed directories
+func (c *ImageCatalog) backingChain(chain []qemuImageInfo) ([]string, error) {
+	var backingChain []string
+	for _, info := range chain {
+		if info.FullBackingFilename == "" {
+			break
+		}
+		backing := info.FullBackingFilename
+		if resolved, err := filepath.EvalSymlinks(backing); err == nil {
+			backing = resolved
+		}
+		if !c.allowed(backing) {
+			return nil, NewBadRequestError("Backing file %s is outside the image directories or a file of a VM", info.FullBackingFilename)
+		}
+		backingChain = append(backingChain, backing)
+	}
+	return backingChain, nil
+}
+
+// allowed tells whether the path is inside one of the image directories and not a file of a VM
+func (c *ImageCatalog) allowed(path string) bool {
+	if vmFilePattern.MatchString(filepath.Base(path)) {
+		return false
+	}
+	for _, dir := range c.dirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ImageCatalog) outside(path string) error {
+	if vmFilePattern.MatchString(filepath.Base(path)) {
+		return NewBadRequestError("Image path %s is a file of a VM", path)
+	}
+	return NewBadRequestError("Image path %s is outside the image directories %s", path, strings.Join(c.dirs, ", "))
+}
+
+// inspectImage runs qemu-img info on the image, the first entry is the image and the next ones its backing files
+func inspectImage(path string, lq LibvirtQemu) ([]qemuImageInfo, error) {
+	output, err := lq.ImageInfo(path)
+	if err != nil {
+		return nil, NewBadRequestError("Image %s can't be read by qemu-img: %v", path, err)
+	}
+	var chain []qemuImageInfo
+	if err := json.Unmarshal([]byte(output), &chain); err != nil {
+		return nil, fmt.Errorf("failed to parse the qemu-img info output: %v", err)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("qemu-img info returned no image for %s", path)
+	}
+	return chain, nil
+}
+
+// fileChecksum computes the SHA-256 of a file as "sha256:<hex>"
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open the image file: %v", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read the image file: %v", err)
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// normalizeChecksum accepts a SHA-256 with or without its "sha256:" prefix, in any case
+func normalizeChecksum(checksum string) string {
+	checksum = strings.ToLower(checksum)
+	if !strings.HasPrefix(checksum, "sha256:") {
+		checksum = "sha256:" + checksum
+	}
+	return checksum
+}
+
+// imageResponse represents a registered image as the catalog recorded it
+func imageResponse(record *ImageRecord) *ImageResponse {
+	return &ImageResponse{
+		ID:           record.ID,
+		Name:         record.Name,
+		Description:  record.Description,
+		Path:         record.Path,
+		Format:       record.Format,
+		VirtualSize:  record.VirtualSize,
+		Checksum:     record.Checksum,
+		BackingChain: record.BackingChain,
+		CreatedAt:    record.CreatedAt,
+	}
+}
+
+// ImageCatalogMiddleware makes the image catalog available to the handlers through the Gin context
+func ImageCatalogMiddleware(catalog *ImageCatalog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("images", catalog)
+		c.Next()
+	}
+}
+
+// imageCatalogFromContext returns the image catalog of the request, or nil when the server runs without one
+func imageCatalogFromContext(c *gin.Context) *ImageCatalog {
+	if c == nil {
+		return nil
+	}
+	i, ok := c.Get("images")
+	if !ok {
+		return nil
+	}
+	catalog, _ := i.(*ImageCatalog)
+	return catalog
+}
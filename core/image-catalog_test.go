@@ -0,0 +1,193 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// imageContent is the content of the image files of the tests, qemu-img is mocked
+var imageContent = []byte("QFI\xfb test image")
+
+// newTestImageCatalog returns a catalog allowing a temporary directory, and the directory
+func newTestImageCatalog(t *testing.T) (*ImageCatalog, string) {
+	dir := t.TempDir()
+	catalog, err := NewImageCatalog(ImagesConfig{Dirs: []string{dir}}, newTestBoltStore(t))
+	assert.Nil(t, err)
+	return catalog, catalog.dirs[0]
+}
+
+// writeImage writes an image file and returns its path
+func writeImage(t *testing.T, dir, name string) string {
+	path := filepath.Join(dir, name)
+	assert.Nil(t, os.WriteFile(path, imageContent, 0o644))
+	return path
+}
+
+// expectImageInfo makes qemu-img describe the image as a 10 GiB qcow2 image on the given backing file
+func expectImageInfo(mockLibvirt *mocks.MockLibvirtQemu, path, format, backing string) {
+	info := `[{"filename": "` + path + `", "format": "` + format + `", "virtual-size": 10737418240, "actual-size": 1048576`
+	if backing != "" {
+		info += `, "full-backing-filename": "` + backing + `"}, {"filename": "` + backing + `", "format": "qcow2", "virtual-size": 10737418240}]`
+	} else {
+		info += `}]`
+	}
+	mockLibvirt.EXPECT().ImageInfo(path).Return(info, nil)
+}
+
+// TestRegisterImage tests that an image is recorded with its qemu-img details and checksum
+func TestRegisterImage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	catalog, dir := newTestImageCatalog(t)
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	base := writeImage(t, dir, "ubuntu-base.qcow2")
+	path := writeImage(t, dir, "ubuntu.qcow2")
+	expectImageInfo(mockLibvirt, path, "qcow2", base)
+
+	sum := sha256.Sum256(imageContent)
+	response, err := catalog.Register(&ImageRegistrationRequest{Name: "ubuntu", Path: path, Checksum: hex.EncodeToString(sum[:])}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "qcow2", response.Format)
+	assert.Equal(t, uint64(10737418240), response.VirtualSize)
+	assert.Equal(t, "sha256:"+hex.EncodeToString(sum[:]), response.Checksum)
+	assert.Equal(t, []string{base}, response.BackingChain)
+
+	list, err := catalog.List()
+	assert.Nil(t, err)
+	assert.Len(t, list.Images, 1)
+	assert.Equal(t, response.ID, list.Images[0].ID)
+
+	expectImageInfo(mockLibvirt, path, "qcow2", base)
+	inspected, err := catalog.Inspect(response.ID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1048576), inspected.ActualSize)
+
+	// Names and files are registered once
+	expectImageInfo(mockLibvirt, base, "qcow2", "")
+	_, err = catalog.Register(&ImageRegistrationRequest{Name: "ubuntu", Path: base}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	expectImageInfo(mockLibvirt, path, "qcow2", base)
+	_, err = catalog.Register(&ImageRegistrationRequest{Name: "other", Path: path}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+}
+
+// TestRegisterImageRefused tests that files outside the image directories, VM disks, other formats
+// and mismatching checksums are refused
+func TestRegisterImageRefused(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	catalog, dir := newTestImageCatalog(t)
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	outside := writeImage(t, t.TempDir(), "outside.qcow2")
+
+	for _, path := range []string{
+		"relative.qcow2",
+		outside,
+		filepath.Join(dir, "missing.qcow2"),
+		writeImage(t, dir, lifecycleVMID+".qcow2"),
+	} {
+		_, err := catalog.Register(&ImageRegistrationRequest{Name: "image", Path: path}, mockLibvirt)
+		assert.IsType(t, &BadRequestError{}, err, path)
+	}
+
+	// A link in the image directory can't lead outside of it
+	link := filepath.Join(dir, "link.qcow2")
+	assert.Nil(t, os.Symlink(outside, link))
+	_, err := catalog.Register(&ImageRegistrationRequest{Name: "image", Path: link}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+
+	raw := writeImage(t, dir, "raw.img")
+	expectImageInfo(mockLibvirt, raw, "raw", "")
+	_, err = catalog.Register(&ImageRegistrationRequest{Name: "image", Path: raw}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+
+	backed := writeImage(t, dir, "backed.qcow2")
+	expectImageInfo(mockLibvirt, backed, "qcow2", outside)
+	_, err = catalog.Register(&ImageRegistrationRequest{Name: "image", Path: backed}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+
+	path := writeImage(t, dir, "ubuntu.qcow2")
+	expectImageInfo(mockLibvirt, path, "qcow2", "")
+	_, err = catalog.Register(&ImageRegistrationRequest{Name: "image", Path: path, Checksum: "sha256:" + hex.EncodeToString(make([]byte, 32))}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+	assert.Contains(t, err.Error(), "Checksum mismatch")
+
+	list, err := catalog.List()
+	assert.Nil(t, err)
+	assert.Empty(t, list.Images)
+}
+
+// TestImageForVM tests that a VM disk must hold the virtual size of its image and that an image
+// used by a VM can't be deleted
+func TestImageForVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	catalog, dir := newTestImageCatalog(t)
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	path := writeImage(t, dir, "ubuntu.qcow2")
+	expectImageInfo(mockLibvirt, path, "qcow2", "")
+	image, err := catalog.Register(&ImageRegistrationRequest{Name: "ubuntu", Path: path}, mockLibvirt)
+	assert.Nil(t, err)
+
+	_, err = catalog.forVM("", 20)
+	assert.IsType(t, &BadRequestError{}, err)
+	_, err = catalog.forVM(lifecycleVMID, 20)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	_, err = catalog.forVM(image.ID, 9)
+	assert.IsType(t, &BadRequestError{}, err)
+	assert.Contains(t, err.Error(), "at least 10 GB")
+
+	record, err := catalog.forVM(image.ID, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, path, record.Path)
+
+	// The VM disk is an overlay on the image, it stays in the catalog while the VM exists
+	assert.Nil(t, catalog.store.PutVM(&VMRecord{ID: lifecycleVMID, Spec: VMCreationRequest{Image: image.ID}}))
+	_, err = catalog.Delete(image.ID)
+	assert.IsType(t, &ConflictError{}, err)
+
+	assert.Nil(t, catalog.store.DeleteVM(lifecycleVMID))
+	response, err := catalog.Delete(image.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", response.Status)
+	_, err = os.Stat(path)
+	assert.Nil(t, err, "the image file is left in place")
+
+	// A file changed after the registration isn't used
+	expectImageInfo(mockLibvirt, path, "qcow2", "")
+	image, err = catalog.Register(&ImageRegistrationRequest{Name: "ubuntu", Path: path}, mockLibvirt)
+	assert.Nil(t, err)
+	record, err = catalog.forVM(image.ID, 20)
+	assert.Nil(t, err)
+	assert.Nil(t, catalog.verify(record))
+
+	// A file of the same size is only told apart by its checksum
+	assert.Nil(t, os.WriteFile(path, bytes.Replace(imageContent, []byte("test"), []byte("evil"), 1), 0o644))
+	record, err = catalog.forVM(image.ID, 20)
+	assert.Nil(t, err)
+	assert.IsType(t, &ConflictError{}, catalog.verify(record))
+
+	// A link swapped in at the path isn't followed
+	other := writeImage(t, dir, "other.qcow2")
+	assert.Nil(t, os.Remove(path))
+	assert.Nil(t, os.Symlink(other, path))
+	_, err = catalog.forVM(image.ID, 20)
+	assert.IsType(t, &ConflictError{}, err)
+
+	assert.Nil(t, os.Remove(path))
+	assert.Nil(t, os.WriteFile(path, append(imageContent, 0), 0o644))
+	_, err = catalog.forVM(image.ID, 20)
+	assert.IsType(t, &ConflictError{}, err)
+}
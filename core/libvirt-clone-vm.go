@@ -0,0 +1,357 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"libvirt.org/go/libvirt"
+)
+
+// cloneVM creates a new VM from the disk and definition of an existing one. A full clone copies the
+// disk, a linked clone is an overlay on the source disk, which is frozen under an external snapshot
+// the source keeps running on. A running source is copied from a transient external snapshot, which
+// is merged back with a block commit.
+func cloneVM(c *gin.Context, sourceID string, request *VMCloneRequest, lq LibvirtQemu) (*VMCloneResponse, error) {
+	progress := progressFromContext(c)
+	if err := progress.Step(0, "Reading the source VM"); err != nil {
+		return nil, err
+	}
+
+	domain, err := lookupDomain(sourceID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	running := state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED
+
+	// The persistent definition, the live one holds runtime details such as the VNC port
+	xmlDesc, err := lq.GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the domain XML: %v", err)
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	rootDisk := def.rootDisk()
+	if rootDisk == nil {
+		return nil, NewConflictError(sourceID, "VM has no disk to clone")
+	}
+	for _, disk := range def.Devices.Disks {
+		if disk.Device == "disk" && disk.ReadOnly == nil && disk.Source != nil && disk.Source.File != rootDisk.Source.File {
+			return nil, NewConflictError(sourceID, "Only VMs with a single writable disk can be cloned")
+		}
+	}
+	sourceDisk := rootDisk.Source.File
+
+	// Every linked clone leaves the source one overlay deeper, for good
+	if request.Mode == "linked" {
+		layers, err := linkedCloneLayers(sourceID, sourceDisk, lq)
+		if err != nil {
+			return nil, err
+		}
+		if layers >= maxLinkedCloneLayers {
+			return nil, NewConflictError(sourceID, fmt.Sprintf("VM disk already carries %d linked clone layers, make a full clone instead", layers))
+		}
+	}
+
+	vmID := uuid.New().String()
+	diskPath := fmt.Sprintf("/var/lib/libvirt/images/%s.qcow2", vmID)
+
+	// The clone gets its own cloud-init instance, rendered from the spec the source was created from
+	var seedFiles map[string][]byte
+	if store := storeFromContext(c); store != nil {
+		if record, err := store.GetVM(sourceID); err == nil && record.Spec.hasCloudInit() {
+			files, err := cloudInitFiles(&record.Spec, vmID)
+			if err != nil {
+				return nil, err
+			}
+			seedFiles = files
+		}
+	}
+
+	// Every step registers how to undo it, a failure removes what the previous steps left behind
+	var undo rollback
+
+	macs := macAllocatorFromContext(c)
+	if macs == nil {
+		macs, _ = NewMACAllocator(MACConfig{}, nil)
+	}
+	undo.add("release the MAC addresses", func() error { return macs.Release(vmID) })
+	macAddresses, err := macs.Allocate(vmID, make([]string, len(def.Devices.Interfaces)), lq)
+	if err != nil {
+		return nil, undo.fail("allocate_mac", err)
+	}
+
+	if err := progress.Step(10, "Cloning the disk"); err != nil {
+		undo.run()
+		return nil, err
+	}
+
+	undo.add("remove the cloned disk", func() error { return lq.RemoveDisk(diskPath) })
+	snapshotName := "clone-" + vmID
+	sourceDev := rootDisk.Target.Dev
+	if request.Mode == "linked" {
+		// The source moves on to an overlay and its current disk becomes the shared, read-only
+		// base. Without metadata the snapshot can't be deleted, which would merge into the base.
+		if _, err := snapshotDomainDisks(sourceID, domain, state, snapshotName, def, libvirt.DOMAIN_SNAPSHOT_CREATE_NO_METADATA, lq); err != nil {
+			return nil, undo.fail("snapshot_source", err)
+		}
+		undo.add("merge the snapshot of the source back", func() error {
+			return mergeSnapshotOverlay(sourceID, snapshotName, sourceDev, domain, running, xmlDesc, lq)
+		})
+		if err := lq.CreateOverlay(sourceDisk, diskPath); err != nil {
+			return nil, undo.fail("create_overlay", err)
+		}
+	} else if running {
+		// The copy is made from a transient snapshot, which keeps the copied image still
+		if _, err := snapshotDomainDisks(sourceID, domain, state, snapshotName, def, libvirt.DOMAIN_SNAPSHOT_CREATE_NO_METADATA, lq); err != nil {
+			return nil, undo.fail("snapshot_source", err)
+		}
+		copyErr := lq.CopyDisk(sourceDisk, diskPath)
+		if err := mergeSnapshotOverlay(sourceID, snapshotName, sourceDev, domain, running, xmlDesc, lq); err != nil {
+			return nil, undo.fail("merge_snapshot", err)
+		}
+		if copyErr != nil {
+			return nil, undo.fail("copy_disk", copyErr)
+		}
+	} else {
+		if err := lq.CopyDisk(sourceDisk, diskPath); err != nil {
+			return nil, undo.fail("copy_disk", err)
+		}
+	}
+
+	var seedPath string
+	if seedFiles != nil {
+		seedPath = seedImagePath(vmID)
+		undo.add("remove the seed image", func() error { return lq.RemoveDisk(seedPath) })
+		if err := lq.CreateSeedImage(seedPath, seedFiles); err != nil {
+			return nil, undo.fail("create_seed", fmt.Errorf("failed to create the cloud-init seed image: %v", err))
+		}
+	}
+
+	if err := progress.Step(70, "Defining the clone"); err != nil {
+		undo.run()
+		return nil, err
+	}
+
+	// The clone is named after its UUID like any created VM
+	def.Name = vmID
+	def.UUID = vmID
+
+	// The seed of the source belongs to the source instance, the clone only keeps its own
+	disks := []domainDisk{}
+	for _, disk := range def.Devices.Disks {
+		if disk.Device == "cdrom" && disk.Source != nil && disk.Source.File == seedImagePath(sourceID) {
+			continue
+		}
+		if disk.Source != nil && disk.Source.File == sourceDisk {
+			disk.Source.File = diskPath
+		}
+		disks = append(disks, disk)
+	}
+	def.Devices.Disks = disks
+	if seedPath != "" {
+		def.addCDROM(seedPath, "sda")
+	}
+
+	for i := range def.Devices.Interfaces {
+		def.Devices.Interfaces[i].MAC = &domainInterfaceMAC{Address: macAddresses[i]}
+	}
+
+	// Host side paths and ports are picked again by libvirt for the clone
+	for _, chardevs := range [][]domainChardev{def.Devices.Serials, def.Devices.Consoles, def.Devices.Channels} {
+		for i := range chardevs {
+			chardevs[i].Source = nil
+		}
+	}
+	for i := range def.Devices.Graphics {
+		def.Devices.Graphics[i].Port = -1
+		def.Devices.Graphics[i].AutoPort = "yes"
+	}
+
+	labels := request.Labels
+	if labels != nil {
+		def.Metadata = &domainMetadata{Labels: newDomainLabels(labels)}
+	} else if def.Metadata != nil && def.Metadata.Labels != nil {
+		labels = def.Metadata.Labels.toMap()
+	}
+
+	xmlConfig, err := def.marshal()
+	if err != nil {
+		return nil, undo.fail("define_domain", err)
+	}
+
+	clone, err := lq.DomainDefineXML(xmlConfig)
+	if err != nil {
+		return nil, undo.fail("define_domain", fmt.Errorf("failed to define the domain: %v", err))
+	}
+	undo.add("undefine the domain", func() error { return lq.Undefine(clone) })
+
+	status := "stopped"
+	if request.Start == nil || *request.Start {
+		progress.Report(90, "Starting the clone")
+		if err := lq.Create(clone); err != nil {
+			return nil, undo.fail("start_domain", fmt.Errorf("failed to start the domain: %v", err))
+		}
+		status = "running"
+	}
+
+	memory, err := def.Memory.inMiB()
+	if err != nil {
+		return nil, err
+	}
+	vcpus := def.VCPU.Value
+	if def.VCPU.Current > 0 {
+		vcpus = def.VCPU.Current
+	}
+
+	response := &VMCloneResponse{
+		VMID:         vmID,
+		SourceVMID:   sourceID,
+		Mode:         request.Mode,
+		Status:       status,
+		VCPUs:        vcpus,
+		Memory:       memory,
+		DiskFile:     diskPath,
+		SeedImage:    seedPath,
+		MacAddresses: macAddresses,
+		Labels:       labels,
+		Message:      "VM successfully cloned",
+	}
+	if len(macAddresses) > 0 {
+		response.MacAddress = macAddresses[0]
+	}
+	return response, nil
+}
+
+// snapshotDomainDisks takes an external disk-only snapshot of the domain, quiesced when the guest agent answers.
+// The writable disks of the domain become read-only and its changes go to new overlays.
+func snapshotDomainDisks(vmID string, domain *libvirt.Domain, state libvirt.DomainState, name string, def *domainDef, flags libvirt.DomainSnapshotCreateFlags, lq LibvirtQemu) (*libvirt.DomainSnapshot, error) {
+	snapshotXML, err := newSnapshotDef(vmID, name, "", true, false, def).marshal()
+	if err != nil {
+		return nil, err
+	}
+	snapshot, _, err := takeSnapshot(vmID, domain, state, snapshotXML, flags|libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC|libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY, lq)
+	return snapshot, err
+}
+
+// mergeSnapshotOverlay undoes an external disk-only snapshot taken without metadata: the domain goes
+// back to the disk with the given target and the overlay file is removed. A running domain commits
+// the writes made since the snapshot with an active block commit and pivots to the disk, a stopped
+// one didn't write to the overlay and only gets its previous definition back.
+func mergeSnapshotOverlay(vmID, name, dev string, domain *libvirt.Domain, running bool, inactiveXML string, lq LibvirtQemu) error {
+	if running {
+		// Shallow, the disk below the overlay is itself an overlay on a base image other VMs share
+		if err := lq.BlockCommit(domain, dev, libvirt.DOMAIN_BLOCK_COMMIT_ACTIVE|libvirt.DOMAIN_BLOCK_COMMIT_SHALLOW); err != nil {
+			return err
+		}
+		if err := waitBlockJobReady(domain, dev, lq); err != nil {
+			if abortErr := lq.BlockJobAbort(domain, dev, 0); abortErr != nil {
+				log.Printf("Failed to abort the block commit of disk %s of VM %s: %v", dev, vmID, abortErr)
+			}
+			return err
+		}
+		if err := lq.BlockJobAbort(domain, dev, libvirt.DOMAIN_BLOCK_JOB_ABORT_PIVOT); err != nil {
+			return err
+		}
+	}
+
+	// The persistent definition still points at the overlay, it's replaced by the one read before the snapshot
+	if _, err := lq.DomainDefineXML(inactiveXML); err != nil {
+		return fmt.Errorf("failed to restore the domain definition: %v", err)
+	}
+	return lq.RemoveDisk(snapshotDiskPath(vmID, name, dev))
+}
+
+// blockJobPollInterval is how often a block commit is checked for being ready to pivot
+var blockJobPollInterval = 500 * time.Millisecond
+
+// blockCommitTimeout bounds the wait for a block commit, the guest may write faster than it merges
+const blockCommitTimeout = 10 * time.Minute
+
+// waitBlockJobReady waits until the active block commit of the disk has merged everything and can pivot
+func waitBlockJobReady(domain *libvirt.Domain, dev string, lq LibvirtQemu) error {
+	deadline := time.Now().Add(blockCommitTimeout)
+	for {
+		info, err := lq.GetBlockJobInfo(domain, dev)
+		if err != nil {
+			return err
+		}
+		if info.Type != libvirt.DOMAIN_BLOCK_JOB_TYPE_ACTIVE_COMMIT {
+			return fmt.Errorf("the block commit of disk %s ended before it could pivot", dev)
+		}
+		if info.Cur == info.End {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for the block commit of disk %s", dev)
+		}
+		time.Sleep(blockJobPollInterval)
+	}
+}
+
+// maxLinkedCloneLayers caps the overlays linked clones stack under the disk of a source VM, each one
+// slows down the reads of the source a little
+const maxLinkedCloneLayers = 8
+
+// linkedCloneLayers counts the overlays linked clones left in the backing chain of the VM disk
+func linkedCloneLayers(vmID, diskPath string, lq LibvirtQemu) (int, error) {
+	output, err := lq.ImageInfo(diskPath)
+	if err != nil {
+		return 0, err
+	}
+	var chain []qemuImageInfo
+	if err := json.Unmarshal([]byte(output), &chain); err != nil {
+		return 0, fmt.Errorf("failed to parse the qemu-img info output: %v", err)
+	}
+
+	layers := 0
+	for _, image := range chain {
+		if strings.HasPrefix(filepath.Base(image.Filename), vmID+"-snap-clone-") {
+			layers++
+		}
+	}
+	return layers, nil
+}
+
+// linkedClones lists the linked clones of the VM in the inventory, their disks are overlays on its disk
+func linkedClones(store Store, vmID string) ([]string, error) {
+	records, err := store.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	var clones []string
+	for _, record := range records {
+		if record.LinkedClone && record.ClonedFrom == vmID {
+			clones = append(clones, record.ID)
+		}
+	}
+	sort.Strings(clones)
+	return clones, nil
+}
+
+// checkNoLinkedClones refuses to delete a VM whose disk backs linked clones
+func checkNoLinkedClones(store Store, vmID string) error {
+	clones, err := linkedClones(store, vmID)
+	if err != nil {
+		return err
+	}
+	if len(clones) > 0 {
+		return NewConflictError(vmID, fmt.Sprintf("VM disk backs linked clones, delete them first: %s", strings.Join(clones, ", ")))
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// cloneSourceDisk is the root disk of the VM the tests clone
+var cloneSourceDisk = "/var/lib/libvirt/images/" + lifecycleVMID + ".qcow2"
+
+// cloneDomainXML renders the persistent definition of a VM created by createVM, with the runtime
+// details libvirt fills in once it ran
+func cloneDomainXML(t *testing.T) string {
+	def := newDomainDef("vm", lifecycleVMID, 2048, 2)
+	def.addDisk(cloneSourceDisk, "vda", nil)
+	def.addCDROM(seedImagePath(lifecycleVMID), "sda")
+	def.addNetworkInterface(defaultNetwork, "00:16:3e:00:00:01", "virtio")
+	def.addSerialConsole()
+	def.addGuestAgentChannel()
+	def.Devices.Channels[0].Source = &domainChardevSource{Mode: "bind", Path: "/run/libvirt/qemu/channel/1-vm/" + guestAgentChannel}
+	def.addVNCGraphics(vncListenAddress)
+	def.Devices.Graphics[0].Port = 5901
+	def.Metadata = &domainMetadata{Labels: newDomainLabels(map[string]string{"team": "ci"})}
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+	return xmlDesc
+}
+
+// expectCloneSource serves the source domain in the given state
+func expectCloneSource(t *testing.T, mockLibvirt *mocks.MockLibvirtQemu, domain *libvirt.Domain, state libvirt.DomainState) {
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil)
+	mockLibvirt.EXPECT().GetState(domain).Return(state, nil)
+	mockLibvirt.EXPECT().GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE).Return(cloneDomainXML(t), nil)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil)
+}
+
+// expectCloneLayers makes qemu-img report the source disk with the given number of linked clone overlays below it
+func expectCloneLayers(t *testing.T, mockLibvirt *mocks.MockLibvirtQemu, layers int) {
+	chain := []qemuImageInfo{{Filename: cloneSourceDisk, Format: "qcow2"}}
+	for i := 0; i < layers; i++ {
+		chain = append(chain, qemuImageInfo{Filename: snapshotDiskPath(lifecycleVMID, fmt.Sprintf("clone-%d", i), "vda"), Format: "qcow2"})
+	}
+	chain = append(chain, qemuImageInfo{Filename: "/var/lib/libvirt/images/ubuntu.qcow2", Format: "qcow2"})
+	output, err := json.Marshal(chain)
+	assert.Nil(t, err)
+	mockLibvirt.EXPECT().ImageInfo(cloneSourceDisk).Return(string(output), nil)
+}
+
+// TestCloneVMFull tests that a stopped VM is cloned by copying its disk, with a new identity
+func TestCloneVMFull(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	expectCloneSource(t, mockLibvirt, domain, libvirt.DOMAIN_SHUTOFF)
+
+	var diskPath string
+	mockLibvirt.EXPECT().CopyDisk(cloneSourceDisk, gomock.Any()).
+		Do(func(_ string, path string) { diskPath = path }).
+		Return(nil)
+
+	var defined *domainDef
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).
+		DoAndReturn(func(xmlConfig string) (*libvirt.Domain, error) {
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			defined = def
+			return &libvirt.Domain{}, nil
+		})
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil)
+
+	response, err := cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "full"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.NotEqual(t, lifecycleVMID, response.VMID)
+	assert.Equal(t, lifecycleVMID, response.SourceVMID)
+	assert.Equal(t, "running", response.Status)
+	assert.Equal(t, 2, response.VCPUs)
+	assert.Equal(t, 2048, response.Memory)
+	assert.Equal(t, "/var/lib/libvirt/images/"+response.VMID+".qcow2", diskPath)
+	assert.Equal(t, diskPath, response.DiskFile)
+	assert.Equal(t, map[string]string{"team": "ci"}, response.Labels)
+
+	// The clone gets a new name, UUID and MAC, and libvirt picks its host side paths and ports again
+	assert.Equal(t, response.VMID, defined.Name)
+	assert.Equal(t, response.VMID, defined.UUID)
+	assert.Equal(t, response.MacAddress, defined.Devices.Interfaces[0].MAC.Address)
+	assert.NotEqual(t, "00:16:3e:00:00:01", response.MacAddress)
+	assert.Nil(t, defined.Devices.Channels[0].Source)
+	assert.Equal(t, guestAgentChannel, defined.Devices.Channels[0].Target.Name)
+	assert.Equal(t, []domainGraphics{{Type: "vnc", Port: -1, AutoPort: "yes", Listen: vncListenAddress}}, defined.Devices.Graphics)
+
+	// Without a store record there is no spec to render a seed from, the seed of the source isn't shared
+	assert.Len(t, defined.Devices.Disks, 1)
+	assert.Equal(t, diskPath, defined.rootDisk().Source.File)
+	assert.Empty(t, response.SeedImage)
+}
+
+// TestCloneVMFullRunning tests that a running VM is copied from a transient external snapshot,
+// which is merged back with an active block commit once the copy is done
+func TestCloneVMFullRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	snapshot := &libvirt.DomainSnapshot{}
+	expectCloneSource(t, mockLibvirt, domain, libvirt.DOMAIN_RUNNING)
+	mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-ping", nil), agentCommandTimeout).
+		Return("", libvirt.Error{Code: libvirt.ERR_AGENT_UNRESPONSIVE})
+
+	var created *snapshotDef
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), libvirt.DOMAIN_SNAPSHOT_CREATE_NO_METADATA|libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC|libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY).
+			DoAndReturn(func(_ *libvirt.Domain, xmlConfig string, _ libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+				def, err := parseSnapshotXML(xmlConfig)
+				assert.Nil(t, err)
+				created = def
+				return snapshot, nil
+			}),
+		mockLibvirt.EXPECT().CopyDisk(cloneSourceDisk, gomock.Any()).Return(nil),
+		mockLibvirt.EXPECT().BlockCommit(domain, "vda", libvirt.DOMAIN_BLOCK_COMMIT_ACTIVE|libvirt.DOMAIN_BLOCK_COMMIT_SHALLOW).Return(nil),
+		mockLibvirt.EXPECT().GetBlockJobInfo(domain, "vda").
+			Return(&libvirt.DomainBlockJobInfo{Type: libvirt.DOMAIN_BLOCK_JOB_TYPE_ACTIVE_COMMIT, Cur: 1024, End: 1024}, nil),
+		mockLibvirt.EXPECT().BlockJobAbort(domain, "vda", libvirt.DOMAIN_BLOCK_JOB_ABORT_PIVOT).Return(nil),
+		mockLibvirt.EXPECT().DomainDefineXML(cloneDomainXML(t)).Return(domain, nil),
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).
+			Do(func(path string) { assert.Equal(t, snapshotDiskPath(lifecycleVMID, created.Name, "vda"), path) }).
+			Return(nil),
+		mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Return(&libvirt.Domain{}, nil),
+	)
+
+	start := false
+	response, err := cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "full", Start: &start}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", response.Status)
+
+	assert.Equal(t, "clone-"+response.VMID, created.Name)
+	assert.Equal(t, "no", created.Memory.Snapshot)
+	assert.Equal(t, snapshotDiskPath(lifecycleVMID, created.Name, "vda"), created.Disks.Disks[0].Source.File)
+	assert.Equal(t, "no", created.Disks.Disks[1].Snapshot)
+}
+
+// TestCloneVMLinked tests that a linked clone is an overlay on the source disk, frozen under an
+// external snapshot without metadata, and gets its own cloud-init seed from the recorded spec
+func TestCloneVMLinked(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := newTestBoltStore(t)
+	assert.Nil(t, store.PutVM(&VMRecord{ID: lifecycleVMID, Spec: VMCreationRequest{VCPUs: 2, Memory: 2048, Hostname: "web-1"}}))
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("store", store)
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	expectCloneSource(t, mockLibvirt, domain, libvirt.DOMAIN_SHUTOFF)
+	expectCloneLayers(t, mockLibvirt, 1)
+
+	var created *snapshotDef
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), libvirt.DOMAIN_SNAPSHOT_CREATE_NO_METADATA|libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC|libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY).
+			DoAndReturn(func(_ *libvirt.Domain, xmlConfig string, _ libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+				def, err := parseSnapshotXML(xmlConfig)
+				assert.Nil(t, err)
+				created = def
+				return &libvirt.DomainSnapshot{}, nil
+			}),
+		mockLibvirt.EXPECT().CreateOverlay(cloneSourceDisk, gomock.Any()).Return(nil),
+	)
+
+	var seedPath string
+	mockLibvirt.EXPECT().CreateSeedImage(gomock.Any(), gomock.Any()).
+		Do(func(path string, files map[string][]byte) {
+			seedPath = path
+			assert.Contains(t, string(files["meta-data"]), "local-hostname: web-1")
+		}).
+		Return(nil)
+
+	var defined *domainDef
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).
+		DoAndReturn(func(xmlConfig string) (*libvirt.Domain, error) {
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			defined = def
+			return &libvirt.Domain{}, nil
+		})
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil)
+
+	request := &VMCloneRequest{Mode: "linked", Labels: map[string]string{"team": "qa"}}
+	response, err := cloneVM(c, lifecycleVMID, request, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "clone-"+response.VMID, created.Name)
+	assert.Equal(t, seedImagePath(response.VMID), seedPath)
+	assert.Equal(t, seedPath, response.SeedImage)
+
+	assert.Len(t, defined.Devices.Disks, 2)
+	assert.Equal(t, response.DiskFile, defined.rootDisk().Source.File)
+	assert.Equal(t, seedPath, defined.Devices.Disks[1].Source.File)
+	assert.Equal(t, map[string]string{"team": "qa"}, defined.Metadata.Labels.toMap())
+
+	// The source can't be deleted while the clone is backed by its disk
+	source, err := store.GetVM(lifecycleVMID)
+	assert.Nil(t, err)
+	assert.Nil(t, store.PutVM(newCloneRecord(source, request, response, time.Now())))
+
+	record, err := store.GetVM(response.VMID)
+	assert.Nil(t, err)
+	assert.Equal(t, lifecycleVMID, record.ClonedFrom)
+	assert.True(t, record.LinkedClone)
+	assert.Equal(t, "web-1", record.Spec.Hostname)
+	assert.Equal(t, map[string]string{"team": "qa"}, record.Labels)
+
+	err = checkNoLinkedClones(store, lifecycleVMID)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.Contains(t, err.Error(), response.VMID)
+	assert.Nil(t, checkNoLinkedClones(store, response.VMID))
+}
+
+// TestCloneVMRefused tests that VMs with several writable disks are refused and that a failed
+// copy removes what was left behind
+func TestCloneVMRefused(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.addDisk(cloneSourceDisk, "vda", nil)
+	def.addDisk("/var/lib/libvirt/images/data.qcow2", "vdb", nil)
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(2)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(2)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE).Return(xmlDesc, nil),
+		mockLibvirt.EXPECT().GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE).Return(cloneDomainXML(t), nil),
+	)
+
+	_, err = cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "full"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+
+	// The overlays linked clones leave under the source are capped
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil)
+	mockLibvirt.EXPECT().GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE).Return(cloneDomainXML(t), nil)
+	expectCloneLayers(t, mockLibvirt, maxLinkedCloneLayers)
+	_, err = cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "linked"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "linked clone layers")
+
+	var diskPath string
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil)
+	mockLibvirt.EXPECT().CopyDisk(cloneSourceDisk, gomock.Any()).
+		Do(func(_ string, path string) { diskPath = path }).
+		Return(errors.New("no space left on device"))
+	mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).
+		Do(func(path string) { assert.Equal(t, diskPath, path) }).
+		Return(nil)
+
+	_, err = cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "full"}, mockLibvirt)
+	assert.IsType(t, &StepError{}, err)
+	assert.Equal(t, "copy_disk", err.(*StepError).Step)
+}
+
+// TestCloneVMRollback tests that a failed clone puts the source back on its disk, whether the
+// snapshot of a running source can't be merged or a linked clone fails after freezing the source
+func TestCloneVMRollback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	expectCloneSource(t, mockLibvirt, domain, libvirt.DOMAIN_RUNNING)
+	mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-ping", nil), agentCommandTimeout).
+		Return("", libvirt.Error{Code: libvirt.ERR_AGENT_UNRESPONSIVE})
+
+	var diskPath string
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), gomock.Any()).Return(&libvirt.DomainSnapshot{}, nil),
+		mockLibvirt.EXPECT().CopyDisk(cloneSourceDisk, gomock.Any()).
+			Do(func(_ string, path string) { diskPath = path }).
+			Return(nil),
+		mockLibvirt.EXPECT().BlockCommit(domain, "vda", libvirt.DOMAIN_BLOCK_COMMIT_ACTIVE|libvirt.DOMAIN_BLOCK_COMMIT_SHALLOW).Return(nil),
+		mockLibvirt.EXPECT().GetBlockJobInfo(domain, "vda").Return(&libvirt.DomainBlockJobInfo{}, nil),
+		mockLibvirt.EXPECT().BlockJobAbort(domain, "vda", libvirt.DomainBlockJobAbortFlags(0)).Return(nil),
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).
+			Do(func(path string) { assert.Equal(t, diskPath, path) }).
+			Return(nil),
+	)
+
+	_, err := cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "full"}, mockLibvirt)
+	assert.IsType(t, &StepError{}, err)
+	assert.Equal(t, "merge_snapshot", err.(*StepError).Step)
+
+	// A stopped source didn't write to its overlay, it only gets its definition back
+	expectCloneSource(t, mockLibvirt, domain, libvirt.DOMAIN_SHUTOFF)
+	expectCloneLayers(t, mockLibvirt, 0)
+	var snapshotName string
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ *libvirt.Domain, xmlConfig string, _ libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+				def, err := parseSnapshotXML(xmlConfig)
+				assert.Nil(t, err)
+				snapshotName = def.Name
+				return &libvirt.DomainSnapshot{}, nil
+			}),
+		mockLibvirt.EXPECT().CreateOverlay(cloneSourceDisk, gomock.Any()).
+			Do(func(_ string, path string) { diskPath = path }).
+			Return(errors.New("no space left on device")),
+		mockLibvirt.EXPECT().DomainDefineXML(cloneDomainXML(t)).Return(domain, nil),
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).
+			Do(func(path string) { assert.Equal(t, snapshotDiskPath(lifecycleVMID, snapshotName, "vda"), path) }).
+			Return(nil),
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).
+			Do(func(path string) { assert.Equal(t, diskPath, path) }).
+			Return(nil),
+	)
+
+	_, err = cloneVM(nil, lifecycleVMID, &VMCloneRequest{Mode: "linked"}, mockLibvirt)
+	assert.IsType(t, &StepError{}, err)
+	assert.Equal(t, "create_overlay", err.(*StepError).Step)
+	assert.Empty(t, err.(*StepError).RollbackErrors)
+}
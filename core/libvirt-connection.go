@@ -0,0 +1,247 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+const (
+	defaultLibvirtURI        = "qemu:///system"
+	defaultKeepaliveInterval = 5 * time.Second
+	defaultKeepaliveCount    = 3
+)
+
+// errConnectionClosed is returned once the manager has been closed on shutdown
+var errConnectionClosed = errors.New("the libvirt connection manager is closed")
+
+// startEventLoop registers the default libvirt event loop once, keepalive and close callbacks need it running
+var startEventLoop = sync.OnceValue(func() error {
+	if err := libvirt.EventRegisterDefaultImpl(); err != nil {
+		return err
+	}
+	go func() {
+		for {
+			if err := libvirt.EventRunDefaultImpl(); err != nil {
+				slog.Error("Libvirt event loop failed", "error", err)
+				time.Sleep(time.Second)
+			}
+		}
+	}()
+	return nil
+})
+
+// ConnectionManager shares one long-lived libvirt connection between all requests.
+// libvirt connections are safe for concurrent use, so a single connection serves the
+// whole API instead of one per request. The connection is opened on first use, kept
+// alive with keepalive probes and reopened transparently once libvirt closes it.
+// A replaced connection is only released once the calls still using it returned.
+type ConnectionManager struct {
+	uri               string
+	keepaliveInterval time.Duration
+	keepaliveCount    uint
+	logger            *slog.Logger
+
+	mu     sync.Mutex
+	conn   *libvirt.Connect
+	broken bool // Set by the close callback, the connection is reopened on next use
+	closed bool
+	users  map[*libvirt.Connect]int // Calls in progress on each connection
+
+	// Hooks onto libvirt, replaced in tests
+	open    func() (*libvirt.Connect, error)
+	isAlive func(conn *libvirt.Connect) bool
+	release func(conn *libvirt.Connect)
+}
+
+// NewConnectionManager returns a manager for the configured libvirt URI, it doesn't connect until first used
+func NewConnectionManager(config LibvirtConfig, logger *slog.Logger) *ConnectionManager {
+	m := &ConnectionManager{
+		uri:               config.URI,
+		keepaliveInterval: config.KeepaliveInterval,
+		keepaliveCount:    config.KeepaliveCount,
+		logger:            logger.With("component", "libvirt", "uri", config.URI),
+		users:             make(map[*libvirt.Connect]int),
+	}
+	if m.uri == "" {
+		m.uri = defaultLibvirtURI
+	}
+	if m.keepaliveInterval <= 0 {
+		m.keepaliveInterval = defaultKeepaliveInterval
+	}
+	// libvirt counts the interval in whole seconds and an interval of 0 turns keepalive off
+	m.keepaliveInterval = (m.keepaliveInterval + time.Second - 1).Truncate(time.Second)
+	if m.keepaliveCount == 0 {
+		m.keepaliveCount = defaultKeepaliveCount
+	}
+
+	m.open = m.dial
+	m.isAlive = func(conn *libvirt.Connect) bool {
+		alive, err := conn.IsAlive()
+		return err == nil && alive
+	}
+	m.release = func(conn *libvirt.Connect) {
+		conn.UnregisterCloseCallback()
+		conn.Close()
+	}
+	return m
+}
+
+// Get returns a LibvirtQemu over the shared connection, reconnecting if the previous one was lost.
+// Its calls resolve the connection each time, so it outlives a reconnect.
+func (m *ConnectionManager) Get() (LibvirtQemu, error) {
+	_, done, err := m.acquire()
+	if err != nil {
+		return nil, err
+	}
+	done()
+	return &LibvirtQemuImpl{connections: m}, nil
+}
+
+// acquire returns the shared connection, reconnecting if the previous one was lost. The
+// connection isn't released before done is called, once the libvirt call using it returned.
+func (m *ConnectionManager) acquire() (*libvirt.Connect, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, nil, errConnectionClosed
+	}
+
+	if m.conn == nil || m.broken || !m.isAlive(m.conn) {
+		if m.conn != nil {
+			m.logger.Warn("Reconnecting to libvirt")
+			m.retire()
+		}
+
+		conn, err := m.open()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to connect to libvirt: %v", err)
+		}
+		m.conn = conn
+		m.broken = false
+	}
+
+	conn := m.conn
+	m.users[conn]++
+	return conn, func() { m.done(conn) }, nil
+}
+
+// done ends a call on the connection, a connection replaced meanwhile is released by its last call
+func (m *ConnectionManager) done(conn *libvirt.Connect) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.users[conn]--
+	if m.users[conn] > 0 {
+		return
+	}
+	delete(m.users, conn)
+	if conn != m.conn {
+		m.release(conn)
+	}
+}
+
+// retire drops the current connection, it is released now or by the last call still using it
+func (m *ConnectionManager) retire() {
+	if m.users[m.conn] == 0 {
+		m.release(m.conn)
+	}
+	m.conn = nil
+}
+
+// Close closes the shared connection, later calls to Get fail
+func (m *ConnectionManager) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	if m.conn != nil {
+		m.retire()
+	}
+	return nil
+}
+
+// dial opens a new connection with keepalive probes and a close callback
+func (m *ConnectionManager) dial() (*libvirt.Connect, error) {
+	if err := startEventLoop(); err != nil {
+		return nil, err
+	}
+
+	conn, err := libvirt.NewConnect(m.uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// libvirt closes the connection itself when keepaliveCount probes in a row go unanswered
+	if err := conn.SetKeepAlive(int(m.keepaliveInterval/time.Second), m.keepaliveCount); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// The callback gets a fresh wrapper of the connection, so the one it belongs to is captured here
+	if err := conn.RegisterCloseCallback(func(_ *libvirt.Connect, reason libvirt.ConnectCloseReason) {
+		m.onClose(conn, reason)
+	}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	m.logger.Info("Connected to libvirt")
+	return conn, nil
+}
+
+// onClose marks the connection as lost so the next Get reopens it
+func (m *ConnectionManager) onClose(conn *libvirt.Connect, reason libvirt.ConnectCloseReason) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.conn == conn {
+		m.logger.Warn("Libvirt connection closed", "reason", closeReasonString(reason))
+		m.broken = true
+	}
+}
+
+func closeReasonString(reason libvirt.ConnectCloseReason) string {
+	switch reason {
+	case libvirt.CONNECT_CLOSE_REASON_ERROR:
+		return "error"
+	case libvirt.CONNECT_CLOSE_REASON_EOF:
+		return "eof"
+	case libvirt.CONNECT_CLOSE_REASON_KEEPALIVE:
+		return "keepalive"
+	case libvirt.CONNECT_CLOSE_REASON_CLIENT:
+		return "client"
+	default:
+		return "unknown"
+	}
+}
+
+// LibvirtMiddleware makes the connection manager available to the handlers
+func LibvirtMiddleware(connections *ConnectionManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("libvirt", connections)
+		c.Next()
+	}
+}
+
+// libvirtFromContext returns a LibvirtQemu over the connection shared through the Gin context
+func libvirtFromContext(c *gin.Context) (LibvirtQemu, error) {
+	if c == nil {
+		return nil, errors.New("no libvirt connection manager in the context")
+	}
+	connections, ok := c.Get("libvirt")
+	if !ok {
+		return nil, errors.New("no libvirt connection manager in the context")
+	}
+	manager, ok := connections.(*ConnectionManager)
+	if !ok {
+		return nil, fmt.Errorf("unexpected libvirt connection manager type %T in the context", connections)
+	}
+	return manager.Get()
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"libvirt.org/go/libvirt"
+)
+
+// fakeConnections is a connection manager whose libvirt hooks hand out placeholder connections
+type fakeConnections struct {
+	*ConnectionManager
+	opened   []*libvirt.Connect
+	released []*libvirt.Connect
+	dead     *libvirt.Connect // Reported dead although no close callback fired
+	openErr  error
+}
+
+func newFakeConnections() *fakeConnections {
+	f := &fakeConnections{
+		ConnectionManager: NewConnectionManager(LibvirtConfig{}, slog.Default()),
+	}
+	f.open = func() (*libvirt.Connect, error) {
+		if f.openErr != nil {
+			return nil, f.openErr
+		}
+		conn := &libvirt.Connect{}
+		f.opened = append(f.opened, conn)
+		return conn, nil
+	}
+	f.isAlive = func(conn *libvirt.Connect) bool { return conn != f.dead }
+	f.release = func(conn *libvirt.Connect) { f.released = append(f.released, conn) }
+	return f
+}
+
+func connOf(t *testing.T, lq LibvirtQemu) *libvirt.Connect {
+	impl, ok := lq.(*LibvirtQemuImpl)
+	assert.True(t, ok)
+	conn, done, err := impl.connections.acquire()
+	assert.Nil(t, err)
+	done()
+	return conn
+}
+
+// TestConnectionManagerDefaults tests the defaults used when the libvirt section is left out of the config
+func TestConnectionManagerDefaults(t *testing.T) {
+	m := NewConnectionManager(LibvirtConfig{}, slog.Default())
+	assert.Equal(t, "qemu:///system", m.uri)
+	assert.Equal(t, defaultKeepaliveInterval, m.keepaliveInterval)
+	assert.Equal(t, uint(defaultKeepaliveCount), m.keepaliveCount)
+
+	m = NewConnectionManager(LibvirtConfig{URI: "qemu+ssh://host/system", KeepaliveCount: 5}, slog.Default())
+	assert.Equal(t, "qemu+ssh://host/system", m.uri)
+	assert.Equal(t, uint(5), m.keepaliveCount)
+
+	// libvirt takes whole seconds, a sub-second interval must not turn keepalive off
+	m = NewConnectionManager(LibvirtConfig{KeepaliveInterval: 500 * time.Millisecond}, slog.Default())
+	assert.Equal(t, time.Second, m.keepaliveInterval)
+	m = NewConnectionManager(LibvirtConfig{KeepaliveInterval: 1500 * time.Millisecond}, slog.Default())
+	assert.Equal(t, 2*time.Second, m.keepaliveInterval)
+}
+
+// TestConnectionManagerSharesConnection tests that every request gets the same connection
+func TestConnectionManagerSharesConnection(t *testing.T) {
+	f := newFakeConnections()
+
+	first, err := f.Get()
+	assert.Nil(t, err)
+	second, err := f.Get()
+	assert.Nil(t, err)
+
+	assert.Len(t, f.opened, 1)
+	assert.Same(t, connOf(t, first), connOf(t, second))
+	assert.Empty(t, f.released)
+}
+
+// TestConnectionManagerReconnect tests that a connection closed by libvirt or found dead is replaced
+func TestConnectionManagerReconnect(t *testing.T) {
+	f := newFakeConnections()
+
+	lq, err := f.Get()
+	assert.Nil(t, err)
+	first := connOf(t, lq)
+
+	// A close callback for another connection is ignored
+	f.onClose(&libvirt.Connect{}, libvirt.CONNECT_CLOSE_REASON_EOF)
+	lq, err = f.Get()
+	assert.Nil(t, err)
+	assert.Same(t, first, connOf(t, lq))
+
+	// The keepalive timed out
+	f.onClose(first, libvirt.CONNECT_CLOSE_REASON_KEEPALIVE)
+	lq, err = f.Get()
+	assert.Nil(t, err)
+	second := connOf(t, lq)
+	assert.NotSame(t, first, second)
+	assert.Equal(t, []*libvirt.Connect{first}, f.released)
+
+	// The connection died without the callback firing
+	f.dead = second
+	lq, err = f.Get()
+	assert.Nil(t, err)
+	assert.NotSame(t, second, connOf(t, lq))
+	assert.Equal(t, []*libvirt.Connect{first, second}, f.released)
+	assert.Len(t, f.opened, 3)
+}
+
+// TestConnectionManagerOpenFailure tests that a failed connection attempt is retried on the next request
+func TestConnectionManagerOpenFailure(t *testing.T) {
+	f := newFakeConnections()
+
+	f.openErr = errors.New("libvirtd is down")
+	_, err := f.Get()
+	assert.ErrorContains(t, err, "libvirtd is down")
+
+	f.openErr = nil
+	_, err = f.Get()
+	assert.Nil(t, err)
+	assert.Len(t, f.opened, 1)
+}
+
+// TestConnectionManagerClose tests that closing releases the connection and refuses new requests
+func TestConnectionManagerClose(t *testing.T) {
+	f := newFakeConnections()
+
+	lq, err := f.Get()
+	assert.Nil(t, err)
+	conn := connOf(t, lq)
+
+	assert.Nil(t, f.Close())
+	assert.Equal(t, []*libvirt.Connect{conn}, f.released)
+
+	_, err = f.Get()
+	assert.Equal(t, errConnectionClosed, err)
+
+	// Values handed out before the shutdown fail cleanly instead of using the released connection
+	_, err = lq.LookupNetworkByName("default")
+	assert.Equal(t, errConnectionClosed, err)
+}
+
+// TestConnectionManagerKeepsConnectionInUse tests that a connection replaced or closed during a
+// call is only released once that call returned
+func TestConnectionManagerKeepsConnectionInUse(t *testing.T) {
+	f := newFakeConnections()
+
+	first, done, err := f.acquire()
+	assert.Nil(t, err)
+
+	// The connection is lost while a call is still running on it
+	f.onClose(first, libvirt.CONNECT_CLOSE_REASON_KEEPALIVE)
+	lq, err := f.Get()
+	assert.Nil(t, err)
+	second := connOf(t, lq)
+	assert.NotSame(t, first, second)
+	assert.Empty(t, f.released)
+
+	done()
+	assert.Equal(t, []*libvirt.Connect{first}, f.released)
+
+	// Shutdown during a call
+	_, done, err = f.acquire()
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+	assert.Equal(t, []*libvirt.Connect{first}, f.released)
+
+	done()
+	assert.Equal(t, []*libvirt.Connect{first, second}, f.released)
+}
+
+// TestLibvirtFromContext tests that a missing or mistyped connection manager is reported instead of panicking
+func TestLibvirtFromContext(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	_, err := libvirtFromContext(c)
+	assert.ErrorContains(t, err, "no libvirt connection manager")
+
+	c.Set("libvirt", "qemu:///system")
+	_, err = libvirtFromContext(c)
+	assert.ErrorContains(t, err, "unexpected libvirt connection manager type string")
+
+	c.Set("libvirt", newFakeConnections().ConnectionManager)
+	_, err = libvirtFromContext(c)
+	assert.Nil(t, err)
+}
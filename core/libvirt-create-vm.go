@@ -9,77 +9,146 @@ import (
 	// "libvirt.org/go/libvirt"
 )
 
+// defaultNetwork is the libvirt network the NIC of a new VM is attached to
+var defaultNetwork = "default"
+
 // CreateVM creates a virtual machine based on the VMCreationRequest
 func createVM(c *gin.Context, request *VMCreationRequest, lq LibvirtQemu) (*VMCreationResponse, error) {
 	// Generate a new UUID for the VM
 	vmID := uuid.New().String()
 	diskPath := fmt.Sprintf("/var/lib/libvirt/images/%s.qcow2", vmID)
 
-	// Clone the base image and resize it
-	err := lq.CloneAndResizeDisk(request.BaseImage, diskPath, request.DiskSize, true)
+	// Pinning is checked against the host before anything is defined
+	if request.CPUPinning != nil {
+		hostCPUs, err := lq.GetHostCPUMap()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the host CPU map: %v", err)
+		}
+		if err := request.CPUPinning.validateHost(hostCPUs); err != nil {
+			return nil, err
+		}
+	}
+
+	// The cloud-init seed is rendered up front so a bad document fails before anything is created
+	var seedFiles map[string][]byte
+	if request.hasCloudInit() {
+		files, err := cloudInitFiles(request, vmID)
+		if err != nil {
+			return nil, err
+		}
+		seedFiles = files
+	}
+
+	// Every step registers how to undo it, a failure removes what the previous steps left behind
+	var undo rollback
+
+	networks := request.Networks
+	if len(networks) == 0 {
+		networks = defaultNetworks()
+	}
+
+	// Without an allocator from the server the MACs are only checked against the libvirt domains.
+	// The release is registered first since a failure can leave some addresses reserved.
+	macs := macAllocatorFromContext(c)
+	if macs == nil {
+		macs, _ = NewMACAllocator(MACConfig{}, nil)
+	}
+	undo.add("release the MAC addresses", func() error { return macs.Release(vmID) })
+	macAddresses, err := macs.Allocate(vmID, requestedMACs(networks), lq)
 	if err != nil {
-		return  nil, fmt.Errorf("failed to clone and resize disk: %v", err)
+		// A fixed address taken by another VM is the client's to fix, not a failed step
+		if conflict, ok := err.(*ConflictError); ok {
+			undo.run()
+			return nil, conflict
+		}
+		return nil, undo.fail("allocate_mac", err)
+	}
+
+	// When run as an operation, it can be cancelled until the domain is defined
+	progress := progressFromContext(c)
+	if err := progress.Step(10, "Cloning the base image"); err != nil {
+		undo.run()
+		return nil, err
+	}
+
+	// Clone the base image and resize it, never shrinking below the base image so the guest filesystem stays intact.
+	// The removal is registered first since a failed resize leaves the clone in place.
+	undo.add("remove the cloned disk", func() error { return lq.RemoveDisk(diskPath) })
+	if err := lq.CloneAndResizeDisk(request.BaseImage, diskPath, request.DiskSize, false); err != nil {
+		return nil, undo.fail("clone_disk", fmt.Errorf("failed to clone and resize disk: %v", err))
+	}
+
+	// The NoCloud seed is attached as a CD-ROM, cloud-init in the guest finds it by its label
+	var seedPath string
+	if seedFiles != nil {
+		seedPath = seedImagePath(vmID)
+		undo.add("remove the seed image", func() error { return lq.RemoveDisk(seedPath) })
+		if err := lq.CreateSeedImage(seedPath, seedFiles); err != nil {
+			return nil, undo.fail("create_seed", fmt.Errorf("failed to create the cloud-init seed image: %v", err))
+		}
 	}
 
-	// Initialize the CPU pinning XML section as an empty string by default
-	cpuPinningXML := ""
+	if err := progress.Step(60, "Defining the VM"); err != nil {
+		undo.run()
+		return nil, err
+	}
+
+	// Compose the domain, the VM is named after its UUID
+	def := newDomainDef(vmID, vmID, request.Memory, request.VCPUs)
+
+	// Labels are kept in the domain metadata so they can be used to filter the VM list
+	if labels := newDomainLabels(request.Labels); labels != nil {
+		def.Metadata = &domainMetadata{Labels: labels}
+	}
+
+	// I/O limits are part of the disk definition so they apply from the first boot
+	var ioTune *domainIOTune
+	if request.IOLimits != nil && *request.IOLimits != (IOLimits{}) {
+		ioTune = request.IOLimits.ioTune()
+	}
+	rootDisk := def.addDisk(diskPath, "vda", ioTune)
+	if seedPath != "" {
+		def.addCDROM(seedPath, "sda")
+	}
 
-	// If CPU pinning is specified, modify the CPU section
 	if request.CPUPinning != nil {
-		// Create the CPU pinning section
-		pinningXML := "<cpu mode='host-passthrough' check='full'><numactrls>"
-		for _, core := range request.CPUPinning.Cores {
-			pinningXML += fmt.Sprintf("<numactrl id='%d'/>", core)
+		def.CPUTune = request.CPUPinning.cpuTune()
+
+		// A pinned I/O thread only helps if the root disk is served by it
+		if len(request.CPUPinning.IOThreadCores) > 0 {
+			def.IOThreads = 1
+			rootDisk.Driver.IOThread = 1
 		}
-		pinningXML += "</numactrls></cpu>"
-
-		// Set the CPU pinning section
-		cpuPinningXML = pinningXML
-	}
-
-	// Set the VM's XML configuration with a placeholder for CPU pinning
-	xmlConfig := fmt.Sprintf(`
-<domain type='kvm'>
-  <name>%s</name>
-  <uuid>%s</uuid>
-  <memory unit='KiB'>%d</memory>
-  <vcpu placement='static'>%d</vcpu>
-  <os>
-    <type arch='x86_64' machine='pc-i440fx-2.9'>hvm</type>
-    <boot dev='hd'/>
-  </os>
-  <devices>
-    <disk type='file' device='disk'>
-      <driver name='qemu' type='qcow2'/>
-      <source file='%s'/>
-      <target dev='vda' bus='virtio'/>
-      <address type='pci' domain='0x0000' bus='0x00' slot='0x04' function='0x0'/>
-    </disk>
-    <interface type='network'>
-      <mac address='%s'/>
-      <source network='default'/>
-      <model type='virtio'/>
-      <address type='pci' domain='0x0000' bus='0x00' slot='0x03' function='0x0'/>
-    </interface>
-  </devices>
-  %s
-</domain>`, vmID, vmID, request.Memory*1024, request.VCPUs, diskPath, generateMACAddress(), cpuPinningXML)
+	}
+
+	for i, nic := range networks {
+		def.addNIC(nic, macAddresses[i])
+	}
+
+	// The serial console and the VNC display make a VM that fails to boot debuggable through the API
+	def.addSerialConsole()
+	def.addVNCGraphics(vncListenAddress)
+
+	// The guest agent channel lets the API run commands and copy files without SSH, once the
+	// image has qemu-guest-agent installed
+	def.addGuestAgentChannel()
+
+	xmlConfig, err := def.marshal()
+	if err != nil {
+		return nil, undo.fail("define_domain", err)
+	}
 
 	// Create the VM from the generated XML configuration
 	domain, err := lq.DomainDefineXML(xmlConfig)
 	if err != nil {
-		return  nil, fmt.Errorf("failed to define the domain: %v", err)
+		return nil, undo.fail("define_domain", fmt.Errorf("failed to define the domain: %v", err))
 	}
+	undo.add("undefine the domain", func() error { return lq.Undefine(domain) })
 
 	// Start the VM
+	progress.Report(80, "Starting the VM")
 	if err := lq.Create(domain); err != nil {
-		return  nil, fmt.Errorf("failed to start the domain: %v", err)
-	}
-
-	// If I/O limits are specified, apply them
-	if request.IOLimits != nil {
-		// Implement I/O limits logic using libvirt's API (optional step, based on your libvirt's capabilities)
-		// This can be done by adjusting the disk's I/O limits after the VM creation
+		return nil, undo.fail("start_domain", fmt.Errorf("failed to start the domain: %v", err))
 	}
 
 	// Create the response object with the relevant details
@@ -90,24 +159,16 @@ func createVM(c *gin.Context, request *VMCreationRequest, lq LibvirtQemu) (*VMCr
 		Memory:     request.Memory,
 		DiskSize:   request.DiskSize,
 		DiskFile:   diskPath,
-		MacAddress: generateMACAddress(),
-		Message:    "VM successfully created and storage cloned",
+		SeedImage:  seedPath,
+		MacAddress:   macAddresses[0],
+		MacAddresses: macAddresses,
+		Message:      "VM successfully created and storage cloned",
 	}
 
 	// Return the response object and nil error (success)
 	return response, nil
 }
 
-// generateMACAddress generates a unique MAC address from the UUID
-// TODO: generate it from private range according to the requirements
-func generateMACAddress() string {
-	// Generate a new UUID
-	uuid := uuid.New()
-
-	// MAC address format: 00:16:3e:XX:XX:XX, where XX comes from the UUID
-	return fmt.Sprintf("00:16:3e:%02x:%02x:%02x", uuid[0], uuid[1], uuid[2])
-}
-
 // cloneAndResizeDisk clones the base image and resizes the cloned disk
 func cloneAndResizeDisk(baseImage string, newDiskPath string, diskSizeGB int, shrink bool) error {
 	// Step 1: Clone the base image into a new disk file
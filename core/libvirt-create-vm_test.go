@@ -1,8 +1,14 @@
 package core
 
 import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/gin-gonic/gin"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/vzahanych/vm-api/core/mocks"
 	"go.uber.org/mock/gomock"
@@ -18,13 +24,25 @@ func TestCreateVM(t *testing.T) {
 	// Step 2: Create a mock of the LibvirtQemu interface
 	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
 
+	// The MAC address is checked against the NICs of the existing domains
+	mockLibvirt.EXPECT().
+		ListAllDomains().
+		Return(nil, nil).
+		Times(1)
+
 	// Step 3: Define the expected behavior for CloneAndResizeDisk
 	baseImage := "/var/lib/libvirt/images/ubuntu-base.qcow2"
 	diskSizeGB := 20
 	mockLibvirt.EXPECT().
-		CloneAndResizeDisk(baseImage, gomock.Any(), diskSizeGB, true).
+		CloneAndResizeDisk(baseImage, gomock.Any(), diskSizeGB, false).
 		Return(nil) // Simulate successful cloning and resizing
 
+	// The pinned cores are checked against the host before the disk is cloned
+	mockLibvirt.EXPECT().
+		GetHostCPUMap().
+		Return([]bool{true, true, true, true}, nil).
+		Times(1)
+
 	// Step 4: Define the expected behavior for DomainDefineXML
 	// We will now use a regular assertion inside the Do method to check if the XML contains the necessary fields
 	mockLibvirt.EXPECT().
@@ -33,7 +51,26 @@ func TestCreateVM(t *testing.T) {
 			// Validate that the XML contains the necessary tags
 			assert.Contains(t, xmlConfig, "<name>")
 			assert.Contains(t, xmlConfig, "<uuid>")
-			assert.Contains(t, xmlConfig, "<mac address='00:16:3e:") // Check MAC address format
+			assert.Contains(t, xmlConfig, `<mac address="00:16:3e:`) // Check MAC address format
+
+			// Check that each vCPU is pinned to its core
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			assert.NotNil(t, def.CPUTune)
+			assert.Equal(t, []domainVCPUPin{{VCPU: 0, CPUSet: "0"}, {VCPU: 1, CPUSet: "1"}}, def.CPUTune.VCPUPins)
+			assert.Nil(t, def.CPUTune.EmulatorPin)
+			assert.NotContains(t, xmlConfig, "numactrl")
+
+			// The machine type and PCI addresses are left to libvirt
+			assert.Empty(t, def.OS.Type.Machine)
+			assert.NotContains(t, xmlConfig, "<address")
+			assert.Equal(t, defaultNetwork, def.Devices.Interfaces[0].Source.Network)
+
+			// The serial console and the VNC display are reachable through the console endpoints
+			assert.Len(t, def.Devices.Serials, 1)
+			assert.Len(t, def.Devices.Consoles, 1)
+			assert.Equal(t, []domainChardev{{Type: "unix", Target: &domainChardevTarget{Type: "virtio", Name: guestAgentChannel}}}, def.Devices.Channels)
+			assert.Equal(t, []domainGraphics{{Type: "vnc", Port: -1, AutoPort: "yes", Listen: vncListenAddress}}, def.Devices.Graphics)
 		}).
 		Return(&libvirt.Domain{}, nil).Times(1) // Simulate successful domain definition
 
@@ -72,3 +109,341 @@ func TestCreateVM(t *testing.T) {
 
 
 
+
+// TestCreateVMWithIOLimits tests that the I/O limits are rendered as <iotune> in the root disk
+func TestCreateVMWithIOLimits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(nil).Times(1)
+	mockLibvirt.EXPECT().
+		DomainDefineXML(gomock.Any()).
+		Do(func(xmlConfig string) {
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			assert.Len(t, def.Devices.Disks, 1)
+
+			tune := def.Devices.Disks[0].IOTune
+			assert.NotNil(t, tune)
+			assert.Equal(t, uint64(500), tune.TotalIOPSSec)
+			assert.Equal(t, uint64(1000), tune.TotalIOPSSecMax)
+			assert.Equal(t, uint64(30), tune.TotalIOPSSecMaxLength)
+			assert.Equal(t, uint64(10485760), tune.ReadBytesSec)
+			assert.Zero(t, tune.TotalBytesSec)
+		}).
+		Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil).Times(1)
+
+	request := &VMCreationRequest{
+		VCPUs:     2,
+		Memory:    4096,
+		DiskSize:  20,
+		BaseImage: "/var/lib/libvirt/images/ubuntu-base.qcow2",
+		IOLimits: &IOLimits{
+			IOPS:         500,
+			IOPSMax:      1000,
+			BurstLength:  30,
+			ReadBytesSec: 10485760,
+		},
+	}
+
+	_, err := createVM(nil, request, mockLibvirt)
+
+	assert.Nil(t, err)
+}
+
+// TestCreateVMWithEmulatorAndIOThreadPinning tests the emulatorpin and iothreadpin rendering
+func TestCreateVMWithEmulatorAndIOThreadPinning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().GetHostCPUMap().Return([]bool{true, true, true, true, true, true}, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(nil).Times(1)
+	mockLibvirt.EXPECT().
+		DomainDefineXML(gomock.Any()).
+		Do(func(xmlConfig string) {
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			assert.Equal(t, []domainVCPUPin{{VCPU: 0, CPUSet: "2"}, {VCPU: 1, CPUSet: "3"}}, def.CPUTune.VCPUPins)
+			assert.Equal(t, &domainEmulatorPin{CPUSet: "0"}, def.CPUTune.EmulatorPin)
+			assert.Equal(t, []domainIOThreadPin{{IOThread: 1, CPUSet: "4,5"}}, def.CPUTune.IOThreadPins)
+
+			// The root disk is served by the pinned I/O thread
+			assert.Equal(t, 1, def.IOThreads)
+			assert.Equal(t, 1, def.Devices.Disks[0].Driver.IOThread)
+		}).
+		Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil).Times(1)
+
+	request := &VMCreationRequest{
+		VCPUs:     2,
+		Memory:    4096,
+		DiskSize:  20,
+		BaseImage: "/var/lib/libvirt/images/ubuntu-base.qcow2",
+		CPUPinning: &CPUPinning{
+			Cores:         []int{2, 3},
+			EmulatorCores: []int{0},
+			IOThreadCores: []int{4, 5},
+		},
+	}
+
+	_, err := createVM(nil, request, mockLibvirt)
+
+	assert.Nil(t, err)
+}
+
+// TestCreateVMPinningMissingHostCore tests that pinning to a core the host lacks fails before cloning
+func TestCreateVMPinningMissingHostCore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().GetHostCPUMap().Return([]bool{true, true}, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Times(0)
+
+	request := &VMCreationRequest{
+		VCPUs:      2,
+		Memory:     4096,
+		DiskSize:   20,
+		BaseImage:  "/var/lib/libvirt/images/ubuntu-base.qcow2",
+		CPUPinning: &CPUPinning{Cores: []int{1, 8}},
+	}
+
+	response, err := createVM(nil, request, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// rollbackRequest is a creation request without pinning, the failure tests don't need the host CPU map
+var rollbackRequest = &VMCreationRequest{
+	VCPUs:     2,
+	Memory:    4096,
+	DiskSize:  20,
+	BaseImage: "/var/lib/libvirt/images/ubuntu-base.qcow2",
+}
+
+// TestCreateVMCloneFailure tests that a failed clone removes whatever the clone left behind
+func TestCreateVMCloneFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+
+	var diskPath string
+	mockLibvirt.EXPECT().CloneAndResizeDisk(rollbackRequest.BaseImage, gomock.Any(), 20, false).
+		DoAndReturn(func(_, newDiskPath string, _ int, _ bool) error {
+			diskPath = newDiskPath
+			return errors.New("no space left on device")
+		}).Times(1)
+	mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).
+		Do(func(path string) { assert.Equal(t, diskPath, path) }).
+		Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Times(0)
+
+	response, err := createVM(nil, rollbackRequest, mockLibvirt)
+
+	assert.Nil(t, response)
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "clone_disk", stepErr.Step)
+	assert.Empty(t, stepErr.RollbackErrors)
+	assert.ErrorContains(t, err, "no space left on device")
+}
+
+// TestCreateVMDefineFailure tests that the cloned disk is removed when the domain can't be defined
+func TestCreateVMDefineFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Return(nil, errors.New("invalid XML")).Times(1)
+	mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().Undefine(gomock.Any()).Times(0)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Times(0)
+
+	response, err := createVM(nil, rollbackRequest, mockLibvirt)
+
+	assert.Nil(t, response)
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "define_domain", stepErr.Step)
+	assert.Empty(t, stepErr.RollbackErrors)
+}
+
+// TestCreateVMStartFailure tests that the domain is undefined before its disk is removed when it fails to start
+func TestCreateVMStartFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Return(domain, nil).Times(1)
+	mockLibvirt.EXPECT().Create(domain).Return(errors.New("not enough memory")).Times(1)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().Undefine(domain).Return(nil).Times(1),
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).Return(nil).Times(1),
+	)
+
+	response, err := createVM(nil, rollbackRequest, mockLibvirt)
+
+	assert.Nil(t, response)
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "start_domain", stepErr.Step)
+
+	status, details := errorDetails(err)
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, "start_domain", details.Step)
+	assert.Equal(t, "Step start_domain failed, the changes made before it were rolled back", details.Message)
+}
+
+// TestCreateVMRollbackFailure tests that a failing compensating action doesn't stop the others and is reported
+func TestCreateVMRollbackFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(errors.New("not enough memory")).Times(1)
+	mockLibvirt.EXPECT().Undefine(gomock.Any()).Return(errors.New("libvirtd went away")).Times(1)
+	mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).Return(nil).Times(1)
+
+	_, err := createVM(nil, rollbackRequest, mockLibvirt)
+
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "start_domain", stepErr.Step)
+	assert.Len(t, stepErr.RollbackErrors, 1)
+	assert.ErrorContains(t, err, "rollback incomplete: failed to undefine the domain: libvirtd went away")
+
+	_, details := errorDetails(err)
+	assert.Equal(t, "Step start_domain failed and the rollback is incomplete", details.Message)
+}
+
+// TestCreateVMCancelled tests that cancelling the creation after the clone removes the cloned disk
+func TestCreateVMCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+
+	cloning := make(chan struct{})
+	cancelled := make(chan struct{})
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(string, string, int, bool) error {
+			close(cloning)
+			<-cancelled
+			return nil
+		}).Times(1)
+	mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Times(0)
+
+	m := NewOperationManager()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	op, err := m.Start("create_vm", "", func(progress *Progress) (any, error) {
+		c.Set("progress", progress)
+		return createVM(c, rollbackRequest, mockLibvirt)
+	})
+	assert.Nil(t, err)
+
+	<-cloning
+	_, err = m.Cancel(op.ID)
+	assert.Nil(t, err)
+	close(cancelled)
+
+	op = waitOperation(t, m, op.ID)
+	assert.Equal(t, OperationCancelled, op.Status)
+}
+
+// TestCreateVMWithCloudInit tests that the seed image is written and attached as a CD-ROM
+func TestCreateVMWithCloudInit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+
+	var seedPath string
+	mockLibvirt.EXPECT().CreateSeedImage(gomock.Any(), gomock.Any()).
+		Do(func(path string, files map[string][]byte) {
+			seedPath = path
+			assert.Contains(t, string(files["user-data"]), "ssh-ed25519 AAAAC3Nza alice@laptop")
+			assert.Contains(t, string(files["meta-data"]), "local-hostname: web-1")
+		}).
+		Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).
+		Do(func(xmlConfig string) {
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			assert.Len(t, def.Devices.Disks, 2)
+
+			cdrom := def.Devices.Disks[1]
+			assert.Equal(t, "cdrom", cdrom.Device)
+			assert.Equal(t, seedPath, cdrom.Source.File)
+			assert.NotNil(t, cdrom.ReadOnly)
+
+			// The root disk stays the boot disk
+			assert.Equal(t, "vda", def.rootDisk().Target.Dev)
+		}).
+		Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil).Times(1)
+
+	request := *rollbackRequest
+	request.SSHAuthorizedKeys = []string{"ssh-ed25519 AAAAC3Nza alice@laptop"}
+	request.Hostname = "web-1"
+
+	response, err := createVM(nil, &request, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, seedImagePath(response.VMID), seedPath)
+	assert.Equal(t, seedPath, response.SeedImage)
+}
+
+// TestCreateVMSeedFailure tests that a failed seed image is removed along with the cloned disk
+func TestCreateVMSeedFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().CreateSeedImage(gomock.Any(), gomock.Any()).Return(errors.New("permission denied")).Times(1)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Cond(func(path string) bool { return strings.HasSuffix(path, "-seed.iso") })).Return(nil).Times(1),
+		mockLibvirt.EXPECT().RemoveDisk(gomock.Cond(func(path string) bool { return strings.HasSuffix(path, ".qcow2") })).Return(nil).Times(1),
+	)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Times(0)
+
+	request := *rollbackRequest
+	request.UserData = "#cloud-config\n"
+
+	_, err := createVM(nil, &request, mockLibvirt)
+
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "create_seed", stepErr.Step)
+}
+
+// TestValidateVMCreationRequest tests that the pinning must list one core per vCPU
+func TestValidateVMCreationRequest(t *testing.T) {
+	request := &VMCreationRequest{VCPUs: 2, CPUPinning: &CPUPinning{Cores: []int{0}}}
+	assert.NotNil(t, validateVMCreationRequest(request))
+
+	request.CPUPinning.Cores = []int{0, 1}
+	assert.Nil(t, validateVMCreationRequest(request))
+}
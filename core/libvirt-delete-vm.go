@@ -11,17 +11,28 @@ import (
 
 // DeleteVM deletes the virtual machine, stops it (gracefully or forced), undefines it, and deletes the associated disk file
 func DeleteVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMDeletionResponse, error) {
+	// The VM can only be left alone before anything is touched
+	progress := progressFromContext(c)
+	if err := progress.Step(0, "Deleting the VM"); err != nil {
+		return nil, err
+	}
+
 	// Delete the associated disk file
 	diskFile := fmt.Sprintf("/var/lib/libvirt/images/%s.qcow2", vmID)
 	
 	defer func() {
-		// Check if the file exists
-		if _, err := os.Stat(diskFile); err == nil {
-			// File exists, attempt to remove it
-			err = os.Remove(diskFile)
-			if err != nil {
-				// Log the error but still report success for the VM deletion (disk deletion failure is logged)
-				log.Printf("Failed to delete the disk file %s: %v", diskFile, err)
+		// The cloud-init seed image only exists for VMs created with cloud-init data, the
+		// snapshot files for VMs with external snapshots
+		files := append([]string{diskFile, seedImagePath(vmID)}, snapshotFiles(vmID)...)
+		for _, file := range files {
+			// Check if the file exists
+			if _, err := os.Stat(file); err == nil {
+				// File exists, attempt to remove it
+				err = os.Remove(file)
+				if err != nil {
+					// Log the error but still report success for the VM deletion (disk deletion failure is logged)
+					log.Printf("Failed to delete the disk file %s: %v", file, err)
+				}
 			}
 		}
 	}()
@@ -37,31 +48,55 @@ func DeleteVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMDeletionResponse,
 		return nil, err
 	}
 
-	// Stop the VM gracefully
-	err = lq.Shutdown(domain)
-	if err != nil {
-		// If graceful shutdown fails, forcefully stop the VM
-		log.Printf("Graceful shutdown failed, forcing stop for VM %s", vmID)
-		err = lq.Destroy(domain) // Force stop
-		if err != nil {
-			return nil, fmt.Errorf("failed to forcefully stop the domain: %v", err)
-		}
-	}
-
-	// Step 2: Ensure the VM is stopped (check state)
+	// A VM that is already stopped, or saved with /save, is undefined right away: libvirt refuses
+	// to shut down or destroy an inactive domain
 	state, err := lq.GetState(domain)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get VM state: %v", err)
 	}
 
-	// If VM is still running after forceful stop, return an error
-	if state != libvirt.DOMAIN_SHUTOFF {
-		err = lq.Destroy(domain) // Force stop
+	if state == libvirt.DOMAIN_SHUTOFF {
+		// libvirt refuses to undefine a domain with a managed save image, the saved memory goes first
+		saved, err := lq.HasManagedSaveImage(domain)
 		if err != nil {
-			return nil, fmt.Errorf("failed to forcefully stop the domain: %v", err)
+			return nil, err
+		}
+		if saved {
+			progress.Report(20, "Removing the saved state")
+			if err := lq.ManagedSaveRemove(domain); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// Stop the VM gracefully
+		progress.Report(20, "Stopping the VM")
+		err = lq.Shutdown(domain)
+		if err != nil {
+			// If graceful shutdown fails, forcefully stop the VM
+			log.Printf("Graceful shutdown failed, forcing stop for VM %s", vmID)
+			err = lq.Destroy(domain) // Force stop
+			if err != nil {
+				return nil, fmt.Errorf("failed to forcefully stop the domain: %v", err)
+			}
+		}
+
+		// Step 2: Ensure the VM is stopped (check state)
+		state, err = lq.GetState(domain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get VM state: %v", err)
+		}
+
+		// If VM is still running after forceful stop, return an error
+		if state != libvirt.DOMAIN_SHUTOFF {
+			err = lq.Destroy(domain) // Force stop
+			if err != nil {
+				return nil, fmt.Errorf("failed to forcefully stop the domain: %v", err)
+			}
 		}
 	}
+
 	// Undefine the domain (remove from libvirt)
+	progress.Report(70, "Undefining the VM")
 	err = lq.Undefine(domain)
 	if err != nil {
 		return nil, fmt.Errorf("failed to undefine domain: %v", err)
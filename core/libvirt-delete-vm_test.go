@@ -28,15 +28,18 @@ func TestDeleteVM(t *testing.T) {
 		LookupDomainByUUIDString(vmID).
 		Return(&libvirt.Domain{}, nil).Times(1)
 
-	// Mock graceful shutdown of the VM
-	mockLibvirt.EXPECT().
-		Shutdown(gomock.Any()).
-		Return(nil).Times(1)
-
-	// Mock the state retrieval to ensure the VM is shut down
-	mockLibvirt.EXPECT().
-		GetState(gomock.Any()).
-		Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	// Mock graceful shutdown of the running VM, then the state retrieval to ensure it is shut down
+	gomock.InOrder(
+		mockLibvirt.EXPECT().
+			GetState(gomock.Any()).
+			Return(libvirt.DOMAIN_RUNNING, nil).Times(1),
+		mockLibvirt.EXPECT().
+			Shutdown(gomock.Any()).
+			Return(nil).Times(1),
+		mockLibvirt.EXPECT().
+			GetState(gomock.Any()).
+			Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1),
+	)
 
 	// Mock undefining the domain
 	mockLibvirt.EXPECT().
@@ -54,4 +57,45 @@ func TestDeleteVM(t *testing.T) {
 	assert.Contains(t, response.Message, "VM successfully deleted")
 }
 
+// TestDeleteStoppedVM tests that a stopped VM is undefined without trying to stop it again
+func TestDeleteStoppedVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	vmID := "123e4567-e89b-12d3-a456-426614174000"
+	domain := &libvirt.Domain{}
+
+	// Shutdown and Destroy fail on an inactive domain, they must not be called
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(vmID).Return(domain, nil)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil)
+	mockLibvirt.EXPECT().HasManagedSaveImage(domain).Return(false, nil)
+	mockLibvirt.EXPECT().Undefine(domain).Return(nil)
+
+	response, err := DeleteVM(nil, vmID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", response.Status)
+}
+
+// TestDeleteSavedVM tests that the managed save image of a VM put away with /save is removed
+// before the domain is undefined
+func TestDeleteSavedVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
 
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	vmID := "123e4567-e89b-12d3-a456-426614174000"
+	domain := &libvirt.Domain{}
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(vmID).Return(domain, nil)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil)
+	mockLibvirt.EXPECT().HasManagedSaveImage(domain).Return(true, nil)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().ManagedSaveRemove(domain).Return(nil),
+		mockLibvirt.EXPECT().Undefine(domain).Return(nil),
+	)
+
+	response, err := DeleteVM(nil, vmID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", response.Status)
+}
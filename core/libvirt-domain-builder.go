@@ -0,0 +1,121 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// newDomainDef returns a KVM domain with the settings every VM gets and no devices. Devices are
+// composed with the add* methods, the machine type and PCI addresses are left to libvirt.
+func newDomainDef(name, vmID string, memoryMiB, vcpus int) *domainDef {
+	return &domainDef{
+		Type:   "kvm",
+		Name:   name,
+		UUID:   vmID,
+		Memory: domainMemory{Unit: "MiB", Value: uint64(memoryMiB)},
+		VCPU:   domainVCPU{Placement: "static", Value: vcpus},
+		OS: &domainOS{
+			Type: domainOSType{Value: "hvm"},
+			Boot: []domainOSBoot{{Dev: "hd"}},
+		},
+		// ACPI lets the guest handle graceful shutdown and reboot requests
+		Features: &domainFeatures{ACPI: &struct{}{}, APIC: &struct{}{}},
+	}
+}
+
+// addDisk attaches a qcow2 image as a virtio disk with the given target (e.g. "vda")
+func (d *domainDef) addDisk(path, target string, ioTune *domainIOTune) *domainDisk {
+	d.Devices.Disks = append(d.Devices.Disks, domainDisk{
+		Type:   "file",
+		Device: "disk",
+		Driver: &domainDiskDriver{Name: "qemu", Type: "qcow2"},
+		Source: &domainDiskSource{File: path},
+		Target: domainDiskTarget{Dev: target, Bus: "virtio"},
+		IOTune: ioTune,
+	})
+	return &d.Devices.Disks[len(d.Devices.Disks)-1]
+}
+
+// addCDROM attaches a read-only ISO image as a SATA CD-ROM with the given target (e.g. "sda")
+func (d *domainDef) addCDROM(path, target string) {
+	d.Devices.Disks = append(d.Devices.Disks, domainDisk{
+		Type:     "file",
+		Device:   "cdrom",
+		Driver:   &domainDiskDriver{Name: "qemu", Type: "raw"},
+		Source:   &domainDiskSource{File: path},
+		Target:   domainDiskTarget{Dev: target, Bus: "sata"},
+		ReadOnly: &domainDiskReadOnly{},
+	})
+}
+
+// addNetworkInterface attaches a NIC to a libvirt network
+func (d *domainDef) addNetworkInterface(network, mac, model string) *domainInterface {
+	d.Devices.Interfaces = append(d.Devices.Interfaces, domainInterface{
+		Type:   "network",
+		MAC:    &domainInterfaceMAC{Address: mac},
+		Source: &domainInterfaceSource{Network: network},
+		Model:  &domainInterfaceModel{Type: model},
+	})
+	return &d.Devices.Interfaces[len(d.Devices.Interfaces)-1]
+}
+
+// addBridgeInterface attaches a NIC to a host bridge
+func (d *domainDef) addBridgeInterface(bridge, mac, model string) *domainInterface {
+	d.Devices.Interfaces = append(d.Devices.Interfaces, domainInterface{
+		Type:   "bridge",
+		MAC:    &domainInterfaceMAC{Address: mac},
+		Source: &domainInterfaceSource{Bridge: bridge},
+		Model:  &domainInterfaceModel{Type: model},
+	})
+	return &d.Devices.Interfaces[len(d.Devices.Interfaces)-1]
+}
+
+// addSerialConsole attaches a pty backed serial port and the console bound to it
+func (d *domainDef) addSerialConsole() {
+	port := 0
+	d.Devices.Serials = append(d.Devices.Serials, domainChardev{
+		Type:   "pty",
+		Target: &domainChardevTarget{Port: &port},
+	})
+	d.Devices.Consoles = append(d.Devices.Consoles, domainChardev{
+		Type:   "pty",
+		Target: &domainChardevTarget{Type: "serial", Port: &port},
+	})
+}
+
+// addGuestAgentChannel attaches the virtio-serial channel the QEMU guest agent listens on,
+// libvirt creates the host side socket
+func (d *domainDef) addGuestAgentChannel() {
+	d.Devices.Channels = append(d.Devices.Channels, domainChardev{
+		Type:   "unix",
+		Target: &domainChardevTarget{Type: "virtio", Name: guestAgentChannel},
+	})
+}
+
+// addRNG attaches a virtio RNG fed from the host /dev/urandom
+func (d *domainDef) addRNG() {
+	d.Devices.RNGs = append(d.Devices.RNGs, domainRNG{
+		Model:   "virtio",
+		Backend: domainRNGBackend{Model: "random", Value: "/dev/urandom"},
+	})
+}
+
+// addVNCGraphics attaches a VNC display with an automatically allocated port and a video card
+func (d *domainDef) addVNCGraphics(listen string) {
+	d.Devices.Graphics = append(d.Devices.Graphics, domainGraphics{
+		Type:     "vnc",
+		Port:     -1,
+		AutoPort: "yes",
+		Listen:   listen,
+	})
+	d.Devices.Videos = append(d.Devices.Videos, domainVideo{Model: domainVideoModel{Type: "virtio"}})
+}
+
+// marshal renders the domain XML passed to DomainDefineXML
+func (d *domainDef) marshal() (string, error) {
+	data, err := xml.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render the domain XML: %v", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFullDomainDef composes a domain with every device the builder supports
+func testFullDomainDef() *domainDef {
+	def := newDomainDef("vm", lifecycleVMID, 2048, 2)
+	def.Metadata = &domainMetadata{Labels: newDomainLabels(map[string]string{"team": "ci"})}
+	def.CPUTune = (&CPUPinning{Cores: []int{0, 1}, EmulatorCores: []int{2}, IOThreadCores: []int{3}}).cpuTune()
+	def.IOThreads = 1
+
+	root := def.addDisk("/var/lib/libvirt/images/vm.qcow2", "vda", (&IOLimits{IOPS: 500}).ioTune())
+	root.Driver.IOThread = 1
+	def.addCDROM("/var/lib/libvirt/images/vm-seed.iso", "sda")
+	def.addNetworkInterface("default", "00:16:3e:00:00:01", "virtio")
+	def.addBridgeInterface("br0", "00:16:3e:00:00:02", "e1000")
+	def.addSerialConsole()
+	def.addGuestAgentChannel()
+	def.addRNG()
+	def.addVNCGraphics("127.0.0.1")
+	return def
+}
+
+// TestDomainDefRoundTrip tests that every composed device survives marshalling and parsing back
+func TestDomainDefRoundTrip(t *testing.T) {
+	data, err := testFullDomainDef().marshal()
+	assert.Nil(t, err)
+
+	def, err := parseDomainXML(data)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "kvm", def.Type)
+	assert.Equal(t, lifecycleVMID, def.UUID)
+	assert.Equal(t, "hvm", def.OS.Type.Value)
+	assert.NotNil(t, def.Features.ACPI)
+
+	memory, err := def.Memory.inMiB()
+	assert.Nil(t, err)
+	assert.Equal(t, 2048, memory)
+	assert.Equal(t, 2, def.VCPU.Value)
+
+	assert.Len(t, def.Devices.Disks, 2)
+	assert.Equal(t, "vda", def.rootDisk().Target.Dev)
+	assert.Equal(t, uint64(500), def.rootDisk().IOTune.TotalIOPSSec)
+	assert.Equal(t, "cdrom", def.Devices.Disks[1].Device)
+	assert.NotNil(t, def.Devices.Disks[1].ReadOnly)
+
+	assert.Len(t, def.Devices.Interfaces, 2)
+	assert.Equal(t, "default", def.Devices.Interfaces[0].Source.Network)
+	assert.Equal(t, "br0", def.Devices.Interfaces[1].Source.Bridge)
+	assert.Equal(t, "e1000", def.Devices.Interfaces[1].Model.Type)
+
+	assert.Len(t, def.Devices.Serials, 1)
+	assert.Equal(t, "serial", def.Devices.Consoles[0].Target.Type)
+	assert.Equal(t, guestAgentChannel, def.Devices.Channels[0].Target.Name)
+	assert.Equal(t, "/dev/urandom", def.Devices.RNGs[0].Backend.Value)
+	assert.Equal(t, "vnc", def.Devices.Graphics[0].Type)
+	assert.Equal(t, []domainIOThreadPin{{IOThread: 1, CPUSet: "3"}}, def.CPUTune.IOThreadPins)
+	assert.Equal(t, "ci", def.Metadata.Labels.Labels[0].Value)
+}
+
+// TestDomainDefSchema validates the generated XML against the libvirt RelaxNG schema. It needs
+// virt-xml-validate from the libvirt client tools and is skipped where they aren't installed.
+func TestDomainDefSchema(t *testing.T) {
+	validator, err := exec.LookPath("virt-xml-validate")
+	if err != nil {
+		t.Skip("virt-xml-validate not installed")
+	}
+
+	data, err := testFullDomainDef().marshal()
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "domain.xml")
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0o644))
+
+	output, err := exec.Command(validator, path, "domain").CombinedOutput()
+	assert.Nil(t, err, string(output))
+}
@@ -0,0 +1,340 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// domainDef is the libvirt domain XML, the API marshals it to define new VMs and reads
+// back the parts it needs from GetXMLDesc. Unknown elements are ignored when parsing.
+type domainDef struct {
+	XMLName       xml.Name        `xml:"domain"`
+	Type          string          `xml:"type,attr,omitempty"`
+	Name          string          `xml:"name"`
+	UUID          string          `xml:"uuid"`
+	Metadata      *domainMetadata `xml:"metadata"`
+	Memory        domainMemory    `xml:"memory"`
+	CurrentMemory *domainMemory   `xml:"currentMemory"`
+	VCPU          domainVCPU      `xml:"vcpu"`
+	IOThreads     int             `xml:"iothreads,omitempty"`
+	CPUTune       *domainCPUTune  `xml:"cputune"`
+	OS            *domainOS       `xml:"os"`
+	Features      *domainFeatures `xml:"features"`
+	Devices       domainDevices   `xml:"devices"`
+}
+
+type domainMetadata struct {
+	Labels *domainLabels
+}
+
+type domainOS struct {
+	Type domainOSType   `xml:"type"`
+	Boot []domainOSBoot `xml:"boot"`
+}
+
+// domainOSType leaves the architecture and machine type to libvirt, which picks the host defaults
+type domainOSType struct {
+	Arch    string `xml:"arch,attr,omitempty"`
+	Machine string `xml:"machine,attr,omitempty"`
+	Value   string `xml:",chardata"`
+}
+
+type domainOSBoot struct {
+	Dev string `xml:"dev,attr"`
+}
+
+type domainFeatures struct {
+	ACPI *struct{} `xml:"acpi"`
+	APIC *struct{} `xml:"apic"`
+}
+
+type domainMemory struct {
+	Unit  string `xml:"unit,attr,omitempty"`
+	Value uint64 `xml:",chardata"`
+}
+
+type domainVCPU struct {
+	Placement string `xml:"placement,attr,omitempty"`
+	Current   int    `xml:"current,attr,omitempty"`
+	Value     int    `xml:",chardata"`
+}
+
+type domainCPUTune struct {
+	XMLName      xml.Name            `xml:"cputune"`
+	VCPUPins     []domainVCPUPin     `xml:"vcpupin"`
+	EmulatorPin  *domainEmulatorPin  `xml:"emulatorpin"`
+	IOThreadPins []domainIOThreadPin `xml:"iothreadpin"`
+}
+
+type domainVCPUPin struct {
+	VCPU   int    `xml:"vcpu,attr"`
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+type domainEmulatorPin struct {
+	CPUSet string `xml:"cpuset,attr"`
+}
+
+type domainIOThreadPin struct {
+	IOThread int    `xml:"iothread,attr"`
+	CPUSet   string `xml:"cpuset,attr"`
+}
+
+type domainDevices struct {
+	Disks      []domainDisk      `xml:"disk"`
+	Interfaces []domainInterface `xml:"interface"`
+	Serials    []domainChardev   `xml:"serial"`
+	Consoles   []domainChardev   `xml:"console"`
+	Channels   []domainChardev   `xml:"channel"`
+	RNGs       []domainRNG       `xml:"rng"`
+	Graphics   []domainGraphics  `xml:"graphics"`
+	Videos     []domainVideo     `xml:"video"`
+}
+
+type domainDisk struct {
+	Type     string              `xml:"type,attr"`
+	Device   string              `xml:"device,attr"`
+	Driver   *domainDiskDriver   `xml:"driver"`
+	Source   *domainDiskSource   `xml:"source"`
+	Target   domainDiskTarget    `xml:"target"`
+	IOTune   *domainIOTune       `xml:"iotune"`
+	ReadOnly *domainDiskReadOnly `xml:"readonly"`
+}
+
+type domainDiskDriver struct {
+	Name     string `xml:"name,attr"`
+	Type     string `xml:"type,attr"`
+	IOThread int    `xml:"iothread,attr,omitempty"`
+}
+
+type domainDiskSource struct {
+	File string `xml:"file,attr,omitempty"`
+	Dev  string `xml:"dev,attr,omitempty"`
+}
+
+type domainDiskTarget struct {
+	Dev string `xml:"dev,attr"`
+	Bus string `xml:"bus,attr"`
+}
+
+type domainDiskReadOnly struct{}
+
+type domainIOTune struct {
+	XMLName                xml.Name `xml:"iotune"`
+	TotalBytesSec          uint64   `xml:"total_bytes_sec,omitempty"`
+	ReadBytesSec           uint64   `xml:"read_bytes_sec,omitempty"`
+	WriteBytesSec          uint64   `xml:"write_bytes_sec,omitempty"`
+	TotalIOPSSec           uint64   `xml:"total_iops_sec,omitempty"`
+	ReadIOPSSec            uint64   `xml:"read_iops_sec,omitempty"`
+	WriteIOPSSec           uint64   `xml:"write_iops_sec,omitempty"`
+	TotalBytesSecMax       uint64   `xml:"total_bytes_sec_max,omitempty"`
+	ReadBytesSecMax        uint64   `xml:"read_bytes_sec_max,omitempty"`
+	WriteBytesSecMax       uint64   `xml:"write_bytes_sec_max,omitempty"`
+	TotalIOPSSecMax        uint64   `xml:"total_iops_sec_max,omitempty"`
+	ReadIOPSSecMax         uint64   `xml:"read_iops_sec_max,omitempty"`
+	WriteIOPSSecMax        uint64   `xml:"write_iops_sec_max,omitempty"`
+	TotalBytesSecMaxLength uint64   `xml:"total_bytes_sec_max_length,omitempty"`
+	ReadBytesSecMaxLength  uint64   `xml:"read_bytes_sec_max_length,omitempty"`
+	WriteBytesSecMaxLength uint64   `xml:"write_bytes_sec_max_length,omitempty"`
+	TotalIOPSSecMaxLength  uint64   `xml:"total_iops_sec_max_length,omitempty"`
+	ReadIOPSSecMaxLength   uint64   `xml:"read_iops_sec_max_length,omitempty"`
+	WriteIOPSSecMaxLength  uint64   `xml:"write_iops_sec_max_length,omitempty"`
+}
+
+type domainInterface struct {
+	Type      string                 `xml:"type,attr"`
+	MAC       *domainInterfaceMAC    `xml:"mac"`
+	Source    *domainInterfaceSource `xml:"source"`
+	Model     *domainInterfaceModel  `xml:"model"`
+	VLAN      *domainVLAN            `xml:"vlan"`
+	Bandwidth *domainBandwidth       `xml:"bandwidth"`
+}
+
+type domainInterfaceMAC struct {
+	Address string `xml:"address,attr"`
+}
+
+type domainInterfaceSource struct {
+	Network string `xml:"network,attr,omitempty"`
+	Bridge  string `xml:"bridge,attr,omitempty"`
+}
+
+type domainInterfaceModel struct {
+	Type string `xml:"type,attr"`
+}
+
+type domainVLAN struct {
+	Tags []domainVLANTag `xml:"tag"`
+}
+
+type domainVLANTag struct {
+	ID int `xml:"id,attr"`
+}
+
+// domainBandwidth limits the traffic of a NIC, as seen from the guest
+type domainBandwidth struct {
+	Inbound  *domainBandwidthLimit `xml:"inbound"`
+	Outbound *domainBandwidthLimit `xml:"outbound"`
+}
+
+// domainBandwidthLimit rates are in KiB/s and the burst in KiB
+type domainBandwidthLimit struct {
+	Average int `xml:"average,attr"`
+	Peak    int `xml:"peak,attr,omitempty"`
+	Burst   int `xml:"burst,attr,omitempty"`
+}
+
+// domainChardev is a character device such as a <serial> port, a <console> or a <channel>
+type domainChardev struct {
+	Type   string               `xml:"type,attr"`
+	Source *domainChardevSource `xml:"source"`
+	Target *domainChardevTarget `xml:"target"`
+}
+
+type domainChardevSource struct {
+	Path string `xml:"path,attr,omitempty"`
+	Mode string `xml:"mode,attr,omitempty"`
+}
+
+type domainChardevTarget struct {
+	Type string `xml:"type,attr,omitempty"`
+	Name string `xml:"name,attr,omitempty"`
+	Port *int   `xml:"port,attr"`
+}
+
+type domainRNG struct {
+	Model   string           `xml:"model,attr"`
+	Backend domainRNGBackend `xml:"backend"`
+}
+
+type domainRNGBackend struct {
+	Model string `xml:"model,attr"`
+	Value string `xml:",chardata"`
+}
+
+type domainGraphics struct {
+	Type     string `xml:"type,attr"`
+	Port     int    `xml:"port,attr,omitempty"`
+	AutoPort string `xml:"autoport,attr,omitempty"`
+	Listen   string `xml:"listen,attr,omitempty"`
+}
+
+type domainVideo struct {
+	Model domainVideoModel `xml:"model"`
+}
+
+type domainVideoModel struct {
+	Type string `xml:"type,attr"`
+}
+
+// parseDomainXML decodes the XML returned by libvirt's GetXMLDesc
+func parseDomainXML(data string) (*domainDef, error) {
+	var def domainDef
+	if err := xml.Unmarshal([]byte(data), &def); err != nil {
+		return nil, fmt.Errorf("failed to parse the domain XML: %v", err)
+	}
+	return &def, nil
+}
+
+// rootDisk returns the first image backed disk of the domain, the one cloned from the base image
+func (d *domainDef) rootDisk() *domainDisk {
+	for i := range d.Devices.Disks {
+		if d.Devices.Disks[i].Device == "disk" && d.Devices.Disks[i].Source != nil {
+			return &d.Devices.Disks[i]
+		}
+	}
+	return nil
+}
+
+// hasDisk tells whether the domain has a disk attached as the target device (e.g., vdb)
+func (d *domainDef) hasDisk(target string) bool {
+	for _, disk := range d.Devices.Disks {
+		if disk.Target.Dev == target {
+			return true
+		}
+	}
+	return false
+}
+
+// inMiB converts the memory amount to mebibytes, libvirt defaults to KiB when no unit is given
+func (m domainMemory) inMiB() (int, error) {
+	var bytes uint64
+	switch m.Unit {
+	case "b", "bytes":
+		bytes = m.Value
+	case "", "k", "KiB":
+		bytes = m.Value << 10
+	case "KB":
+		bytes = m.Value * 1000
+	case "M", "MiB":
+		bytes = m.Value << 20
+	case "MB":
+		bytes = m.Value * 1000 * 1000
+	case "G", "GiB":
+		bytes = m.Value << 30
+	case "GB":
+		bytes = m.Value * 1000 * 1000 * 1000
+	case "T", "TiB":
+		bytes = m.Value << 40
+	case "TB":
+		bytes = m.Value * 1000 * 1000 * 1000 * 1000
+	default:
+		return 0, fmt.Errorf("unknown memory unit %q", m.Unit)
+	}
+	return int(bytes >> 20), nil
+}
+
+// parseCPUSet expands a libvirt cpuset such as "0-3,^2,6" into the list of CPUs it contains
+func parseCPUSet(cpuset string) ([]int, error) {
+	included := map[int]bool{}
+	excluded := map[int]bool{}
+
+	for _, part := range strings.Split(cpuset, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		target := included
+		if strings.HasPrefix(part, "^") {
+			target = excluded
+			part = part[1:]
+		}
+
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpuset %q", cpuset)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid cpuset %q", cpuset)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			target[cpu] = true
+		}
+	}
+
+	cpus := make([]int, 0, len(included))
+	for cpu := range included {
+		if !excluded[cpu] {
+			cpus = append(cpus, cpu)
+		}
+	}
+	sort.Ints(cpus)
+	return cpus, nil
+}
+
+// formatCPUSet renders a list of CPUs as a libvirt cpuset such as "0,2,3"
+func formatCPUSet(cpus []int) string {
+	parts := make([]string, len(cpus))
+	for i, cpu := range cpus {
+		parts[i] = strconv.Itoa(cpu)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// listCursor marks the position of the last VM returned on the previous page
+type listCursor struct {
+	Key string `json:"k"`  // Value of the sort field of the last VM
+	ID  string `json:"id"` // UUID of the last VM, breaks ties between equal sort keys
+}
+
+// encodeListCursor turns the cursor into the opaque token handed out to clients
+func encodeListCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeListCursor parses a token produced by encodeListCursor
+func decodeListCursor(token string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// listVMs returns one page of the VMs matching the query
+func listVMs(c *gin.Context, query *ListVMsQuery, lq LibvirtQemu) (*ListVMsResponse, error) {
+	domains, err := lq.ListAllDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	vms := make([]VMSummary, 0, len(domains))
+	for _, domain := range domains {
+		vm, err := summarizeDomain(domain, lq)
+		if err != nil {
+			return nil, err
+		}
+		if query.matches(vm) {
+			vms = append(vms, vm)
+		}
+	}
+
+	// Sort by the requested field, the UUID keeps the order stable between pages
+	less := func(a, b VMSummary) bool {
+		ka, kb := query.sortKey(a), query.sortKey(b)
+		if ka != kb {
+			return ka < kb
+		}
+		return a.ID < b.ID
+	}
+	sort.Slice(vms, func(i, j int) bool {
+		if query.Desc {
+			return less(vms[j], vms[i])
+		}
+		return less(vms[i], vms[j])
+	})
+
+	// Skip everything up to and including the VM the cursor points at
+	start := 0
+	if query.After != nil {
+		start = sort.Search(len(vms), func(i int) bool {
+			ki := query.sortKey(vms[i])
+			if ki == query.After.Key {
+				if query.Desc {
+					return vms[i].ID < query.After.ID
+				}
+				return vms[i].ID > query.After.ID
+			}
+			if query.Desc {
+				return ki < query.After.Key
+			}
+			return ki > query.After.Key
+		})
+	}
+
+	end := start + query.Limit
+	if end > len(vms) {
+		end = len(vms)
+	}
+
+	response := &ListVMsResponse{
+		VMs: vms[start:end],
+	}
+	if end < len(vms) {
+		last := vms[end-1]
+		response.NextCursor = encodeListCursor(listCursor{Key: query.sortKey(last), ID: last.ID})
+	}
+
+	return response, nil
+}
+
+// summarizeDomain collects the identity, status and labels of a domain
+func summarizeDomain(domain *libvirt.Domain, lq LibvirtQemu) (VMSummary, error) {
+	var vm VMSummary
+	var err error
+
+	if vm.ID, err = lq.GetUUIDString(domain); err != nil {
+		return vm, err
+	}
+	if vm.Name, err = lq.GetName(domain); err != nil {
+		return vm, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return vm, err
+	}
+	saved := false
+	if state == libvirt.DOMAIN_SHUTOFF {
+		if saved, err = lq.HasManagedSaveImage(domain); err != nil {
+			return vm, err
+		}
+	}
+	vm.Status = domainStatus(state, saved)
+
+	metadata, err := lq.GetMetadata(domain, labelsNamespace)
+	if err != nil {
+		// Domains created outside of the API have no labels
+		if er, ok := err.(libvirt.Error); ok && er.Code == libvirt.ERR_NO_DOMAIN_METADATA {
+			return vm, nil
+		}
+		return vm, fmt.Errorf("failed to get the domain metadata: %v", err)
+	}
+	if vm.Labels, err = unmarshalLabels(metadata); err != nil {
+		return vm, fmt.Errorf("failed to parse the domain labels: %v", err)
+	}
+
+	return vm, nil
+}
+
+// matches reports whether the VM passes all of the query filters
+func (q *ListVMsQuery) matches(vm VMSummary) bool {
+	if len(q.States) > 0 {
+		found := false
+		for _, s := range q.States {
+			if s == vm.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.NamePrefix != "" && !strings.HasPrefix(vm.Name, q.NamePrefix) {
+		return false
+	}
+
+	for k, v := range q.Labels {
+		if value, ok := vm.Labels[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortKey returns the value of the field the query sorts by
+func (q *ListVMsQuery) sortKey(vm VMSummary) string {
+	switch q.SortBy {
+	case "id":
+		return vm.ID
+	case "status":
+		return vm.Status
+	default:
+		return vm.Name
+	}
+}
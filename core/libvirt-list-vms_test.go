@@ -0,0 +1,150 @@
+package core
+
+import (
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// testDomain describes a domain served by the mocked LibvirtQemu
+type testDomain struct {
+	id     string
+	name   string
+	state  libvirt.DomainState
+	saved  bool
+	labels map[string]string
+}
+
+// testDomains is the set of domains returned by the mocked ListAllDomains
+var testDomains = []testDomain{
+	{id: "00000000-0000-0000-0000-000000000003", name: "build-2", state: libvirt.DOMAIN_RUNNING, labels: map[string]string{"team": "ci"}},
+	{id: "00000000-0000-0000-0000-000000000001", name: "build-1", state: libvirt.DOMAIN_SHUTOFF, labels: map[string]string{"team": "ci"}},
+	{id: "00000000-0000-0000-0000-000000000002", name: "db", state: libvirt.DOMAIN_RUNNING, labels: map[string]string{"team": "data"}},
+	{id: "00000000-0000-0000-0000-000000000004", name: "build-3", state: libvirt.DOMAIN_SHUTOFF, saved: true},
+}
+
+// expectDomains makes the mock serve the given domains, telling them apart by pointer
+func expectDomains(mockLibvirt *mocks.MockLibvirtQemu, domains []testDomain) {
+	byDomain := make(map[*libvirt.Domain]testDomain, len(domains))
+	list := make([]*libvirt.Domain, 0, len(domains))
+	for _, d := range domains {
+		domain := &libvirt.Domain{}
+		byDomain[domain] = d
+		list = append(list, domain)
+	}
+
+	mockLibvirt.EXPECT().ListAllDomains().Return(list, nil).AnyTimes()
+	mockLibvirt.EXPECT().GetUUIDString(gomock.Any()).DoAndReturn(func(domain *libvirt.Domain) (string, error) {
+		return byDomain[domain].id, nil
+	}).AnyTimes()
+	mockLibvirt.EXPECT().GetName(gomock.Any()).DoAndReturn(func(domain *libvirt.Domain) (string, error) {
+		return byDomain[domain].name, nil
+	}).AnyTimes()
+	mockLibvirt.EXPECT().GetState(gomock.Any()).DoAndReturn(func(domain *libvirt.Domain) (libvirt.DomainState, error) {
+		return byDomain[domain].state, nil
+	}).AnyTimes()
+	mockLibvirt.EXPECT().HasManagedSaveImage(gomock.Any()).DoAndReturn(func(domain *libvirt.Domain) (bool, error) {
+		return byDomain[domain].saved, nil
+	}).AnyTimes()
+	mockLibvirt.EXPECT().GetMetadata(gomock.Any(), labelsNamespace).DoAndReturn(func(domain *libvirt.Domain, namespace string) (string, error) {
+		labels := byDomain[domain].labels
+		if labels == nil {
+			return "", libvirt.Error{Code: libvirt.ERR_NO_DOMAIN_METADATA}
+		}
+		metadata, _ := xml.Marshal(newDomainLabels(labels))
+		return string(metadata), nil
+	}).AnyTimes()
+}
+
+// TestListVMs tests that VMs are sorted by name by default
+func TestListVMs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomains(mockLibvirt, testDomains)
+
+	response, err := listVMs(nil, &ListVMsQuery{SortBy: "name", Limit: defaultListLimit}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Empty(t, response.NextCursor)
+	assert.Len(t, response.VMs, 4)
+	assert.Equal(t, []string{"build-1", "build-2", "build-3", "db"}, vmNames(response.VMs))
+	assert.Equal(t, "stopped", response.VMs[0].Status)
+	assert.Equal(t, "saved", response.VMs[2].Status)
+}
+
+// TestListVMsFilters tests the state, label and name prefix filters
+func TestListVMsFilters(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomains(mockLibvirt, testDomains)
+
+	byState, err := listVMs(nil, &ListVMsQuery{States: []string{"running"}, Limit: defaultListLimit}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"build-2", "db"}, vmNames(byState.VMs))
+
+	byLabel, err := listVMs(nil, &ListVMsQuery{Labels: map[string]string{"team": "ci"}, Limit: defaultListLimit}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"build-1", "build-2"}, vmNames(byLabel.VMs))
+
+	byPrefix, err := listVMs(nil, &ListVMsQuery{NamePrefix: "build-", States: []string{"stopped", "saved"}, Limit: defaultListLimit}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"build-1", "build-3"}, vmNames(byPrefix.VMs))
+}
+
+// TestListVMsPagination tests that following the cursor walks every VM exactly once
+func TestListVMsPagination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomains(mockLibvirt, testDomains)
+
+	query := &ListVMsQuery{SortBy: "name", Desc: true, Limit: 3}
+
+	first, err := listVMs(nil, query, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db", "build-3", "build-2"}, vmNames(first.VMs))
+	assert.NotEmpty(t, first.NextCursor)
+
+	query.After, err = decodeListCursor(first.NextCursor)
+	assert.Nil(t, err)
+
+	second, err := listVMs(nil, query, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"build-1"}, vmNames(second.VMs))
+	assert.Empty(t, second.NextCursor)
+}
+
+// TestLabelsRoundTrip tests that labels rendered into the domain metadata can be read back
+func TestLabelsRoundTrip(t *testing.T) {
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.Metadata = &domainMetadata{Labels: newDomainLabels(map[string]string{"team": "ci", "owner": "a&b"})}
+	data, err := def.marshal()
+	assert.Nil(t, err)
+	assert.Contains(t, data, `xmlns="`+labelsNamespace+`"`)
+
+	// libvirt returns the element without the surrounding <metadata>
+	parsed, err := parseDomainXML(data)
+	assert.Nil(t, err)
+	element, err := xml.Marshal(parsed.Metadata.Labels)
+	assert.Nil(t, err)
+	labels, err := unmarshalLabels(string(element))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"team": "ci", "owner": "a&b"}, labels)
+}
+
+func vmNames(vms []VMSummary) []string {
+	names := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		names = append(names, vm.Name)
+	}
+	return names
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"encoding/xml"
+	"sort"
+)
+
+// labelsNamespace is the XML namespace of the labels element stored in the domain <metadata>
+const labelsNamespace = "https://github.com/vzahanych/vm-api/labels"
+
+// domainLabels is the custom metadata element holding the VM labels
+type domainLabels struct {
+	XMLName xml.Name      `xml:"https://github.com/vzahanych/vm-api/labels labels"`
+	Labels  []domainLabel `xml:"label"`
+}
+
+type domainLabel struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// newDomainLabels builds the labels element stored in the domain <metadata>,
+// it returns nil when there are no labels
+func newDomainLabels(labels map[string]string) *domainLabels {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	// Sort the keys so the rendered XML is stable
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	meta := &domainLabels{}
+	for _, k := range keys {
+		meta.Labels = append(meta.Labels, domainLabel{Key: k, Value: labels[k]})
+	}
+	return meta
+}
+
+// unmarshalLabels parses the labels element returned by libvirt's GetMetadata
+func unmarshalLabels(data string) (map[string]string, error) {
+	var meta domainLabels
+	if err := xml.Unmarshal([]byte(data), &meta); err != nil {
+		return nil, err
+	}
+
+	return meta.toMap(), nil
+}
+
+// toMap returns the labels as key/value pairs
+func (l *domainLabels) toMap() map[string]string {
+	labels := make(map[string]string, len(l.Labels))
+	for _, label := range l.Labels {
+		labels[label.Key] = label.Value
+	}
+	return labels
+}
@@ -0,0 +1,87 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net"
+)
+
+// networkDef is the libvirt network XML, the API marshals it to define new networks and
+// reads back the parts it needs from the network XML description
+type networkDef struct {
+	XMLName xml.Name        `xml:"network"`
+	Name    string          `xml:"name"`
+	UUID    string          `xml:"uuid,omitempty"`
+	Forward *networkForward `xml:"forward"`
+	Bridge  *networkBridge  `xml:"bridge"`
+	IPs     []networkIP     `xml:"ip"`
+}
+
+// networkForward connects the network to the outside, a network without one is isolated
+type networkForward struct {
+	Mode string `xml:"mode,attr,omitempty"`
+	Dev  string `xml:"dev,attr,omitempty"`
+}
+
+type networkBridge struct {
+	Name  string `xml:"name,attr,omitempty"`
+	STP   string `xml:"stp,attr,omitempty"`
+	Delay string `xml:"delay,attr,omitempty"`
+}
+
+// networkIP is the address of the host on the network, with either a netmask or a prefix
+type networkIP struct {
+	Family  string       `xml:"family,attr,omitempty"`
+	Address string       `xml:"address,attr"`
+	Netmask string       `xml:"netmask,attr,omitempty"`
+	Prefix  int          `xml:"prefix,attr,omitempty"`
+	DHCP    *networkDHCP `xml:"dhcp"`
+}
+
+type networkDHCP struct {
+	Ranges []networkDHCPRange `xml:"range"`
+}
+
+type networkDHCPRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// parseNetworkXML decodes the XML returned by libvirt's network GetXMLDesc
+func parseNetworkXML(data string) (*networkDef, error) {
+	var def networkDef
+	if err := xml.Unmarshal([]byte(data), &def); err != nil {
+		return nil, fmt.Errorf("failed to parse the network XML: %v", err)
+	}
+	return &def, nil
+}
+
+// marshal renders the network XML passed to NetworkDefineXML
+func (n *networkDef) marshal() (string, error) {
+	data, err := xml.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render the network XML: %v", err)
+	}
+	return string(data), nil
+}
+
+// ipv4 returns the IPv4 address of the host on the network, nil for a network without one
+func (n *networkDef) ipv4() *networkIP {
+	for i := range n.IPs {
+		if n.IPs[i].Family == "" || n.IPs[i].Family == "ipv4" {
+			return &n.IPs[i]
+		}
+	}
+	return nil
+}
+
+// cidr formats the address with its prefix length, e.g. "10.10.0.1/24"
+func (ip *networkIP) cidr() string {
+	prefix := ip.Prefix
+	if ip.Netmask != "" {
+		if mask := net.ParseIP(ip.Netmask).To4(); mask != nil {
+			prefix, _ = net.IPv4Mask(mask[0], mask[1], mask[2], mask[3]).Size()
+		}
+	}
+	return fmt.Sprintf("%s/%d", ip.Address, prefix)
+}
@@ -0,0 +1,472 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+// networkForwardModes maps the modes of the API to the libvirt forward mode, isolated networks have none
+var networkForwardModes = map[string]string{
+	"isolated": "",
+	"nat":      "nat",
+	"routed":   "route",
+}
+
+// bridgeNamePattern accepts Linux interface names, which are at most 15 characters long
+var bridgeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,15}$`)
+
+// lookupNetwork finds the network by name, translating a missing network into a NotFoundError
+func lookupNetwork(name string, lq LibvirtQemu) (*libvirt.Network, error) {
+	network, err := lq.LookupNetworkByName(name)
+	if err != nil {
+		if er, ok := err.(libvirt.Error); ok {
+			if er.Code == libvirt.ERR_NO_NETWORK {
+				return nil, NewResourceNotFoundError("Network", name)
+			}
+		}
+		return nil, fmt.Errorf("failed to look up the network: %v", err)
+	}
+	return network, nil
+}
+
+// listNetworks returns every network defined in libvirt, including the ones not created through the API
+func listNetworks(lq LibvirtQemu) (*ListNetworksResponse, error) {
+	networks, err := lq.ListAllNetworks()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListNetworksResponse{Networks: []NetworkResponse{}}
+	for _, network := range networks {
+		n, err := networkResponse(network, lq)
+		if err != nil {
+			return nil, err
+		}
+		response.Networks = append(response.Networks, *n)
+	}
+
+	sort.Slice(response.Networks, func(i, j int) bool {
+		return response.Networks[i].Name < response.Networks[j].Name
+	})
+	return response, nil
+}
+
+// getNetwork returns the network with the given name
+func getNetwork(name string, lq LibvirtQemu) (*NetworkResponse, error) {
+	network, err := lookupNetwork(name, lq)
+	if err != nil {
+		return nil, err
+	}
+	return networkResponse(network, lq)
+}
+
+// createNetwork defines the network and starts it. A network that fails to start is undefined again.
+func createNetwork(request *NetworkCreationRequest, lq LibvirtQemu) (*NetworkResponse, error) {
+	def, err := request.networkDef()
+	if err != nil {
+		return nil, err
+	}
+
+	// Defining a network under an existing name would silently replace it
+	if _, err := lookupNetwork(request.Name, lq); err == nil {
+		return nil, NewResourceConflictError("Network", request.Name, fmt.Sprintf("Network %s already exists", request.Name))
+	} else if _, ok := err.(*NotFoundError); !ok {
+		return nil, err
+	}
+
+	// Two networks routing the same subnet can't both run, libvirt would only notice at start
+	if ip := def.ipv4(); ip != nil {
+		if err := checkSubnetOverlap(request.Name, ip.cidr(), lq); err != nil {
+			return nil, err
+		}
+	}
+
+	xmlConfig, err := def.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	var undo rollback
+	network, err := lq.NetworkDefineXML(xmlConfig)
+	if err != nil {
+		return nil, undo.fail("define_network", err)
+	}
+	undo.add("undefine the network", func() error { return lq.NetworkUndefine(network) })
+
+	if err := lq.NetworkCreate(network); err != nil {
+		return nil, undo.fail("start_network", err)
+	}
+	undo.add("stop the network", func() error { return lq.NetworkDestroy(network) })
+
+	if request.Autostart {
+		if err := lq.NetworkSetAutostart(network, true); err != nil {
+			return nil, undo.fail("set_autostart", err)
+		}
+	}
+
+	return networkResponse(network, lq)
+}
+
+// deleteNetwork stops and undefines the network. A network a VM is attached to is kept, and so is
+// the default network VMs created without networks are attached to.
+func deleteNetwork(name string, lq LibvirtQemu) (*NetworkDeletionResponse, error) {
+	network, err := lookupNetwork(name, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	if name == defaultNetwork {
+		return nil, NewResourceConflictError("Network", name, "The default network is used by VMs created without networks")
+	}
+	users, err := networkUsers(name, false, lq)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) > 0 {
+		return nil, NewResourceConflictError("Network", name, fmt.Sprintf("Network is used by VM %s", strings.Join(users, ", ")))
+	}
+
+	active, err := lq.NetworkIsActive(network)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		if err := lq.NetworkDestroy(network); err != nil {
+			return nil, err
+		}
+	}
+	if err := lq.NetworkUndefine(network); err != nil {
+		return nil, err
+	}
+
+	return &NetworkDeletionResponse{
+		Name:    name,
+		Message: "Network successfully deleted",
+	}, nil
+}
+
+// startNetwork starts the network, doing nothing if it's already running
+func startNetwork(name string, lq LibvirtQemu) (*NetworkResponse, error) {
+	network, err := lookupNetwork(name, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := lq.NetworkIsActive(network)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		if err := lq.NetworkCreate(network); err != nil {
+			return nil, err
+		}
+	}
+
+	return networkResponse(network, lq)
+}
+
+// stopNetwork stops the network unless a running VM would lose its connectivity, doing nothing
+// if it's already stopped
+func stopNetwork(name string, lq LibvirtQemu) (*NetworkResponse, error) {
+	network, err := lookupNetwork(name, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := lq.NetworkIsActive(network)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		users, err := networkUsers(name, true, lq)
+		if err != nil {
+			return nil, err
+		}
+		if len(users) > 0 {
+			return nil, NewResourceConflictError("Network", name, fmt.Sprintf("Network is used by running VM %s", strings.Join(users, ", ")))
+		}
+		if err := lq.NetworkDestroy(network); err != nil {
+			return nil, err
+		}
+	}
+
+	return networkResponse(network, lq)
+}
+
+// updateNetworkDHCP replaces the DHCP ranges of the network with the given one, live when the
+// network runs so new leases come from the new range right away
+func updateNetworkDHCP(name string, dhcp *DHCPRange, lq LibvirtQemu) (*NetworkResponse, error) {
+	network, err := lookupNetwork(name, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlDesc, err := lq.NetworkGetXMLDesc(network)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseNetworkXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+	ip := def.ipv4()
+	if ip == nil {
+		return nil, NewBadRequestError("Invalid parameter: network %s has no IPv4 address to serve DHCP on", name)
+	}
+	hostIP, subnet, err := net.ParseCIDR(ip.cidr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the network address %s: %v", ip.cidr(), err)
+	}
+	if err := validateDHCPRange(dhcp, hostIP, subnet); err != nil {
+		return nil, err
+	}
+
+	var existing []networkDHCPRange
+	if ip.DHCP != nil {
+		existing = ip.DHCP.Ranges
+	}
+	if len(existing) == 1 && existing[0].Start == dhcp.Start && existing[0].End == dhcp.End {
+		return networkResponse(network, lq)
+	}
+
+	active, err := lq.NetworkIsActive(network)
+	if err != nil {
+		return nil, err
+	}
+	flags := libvirt.NETWORK_UPDATE_AFFECT_CONFIG
+	if active {
+		flags |= libvirt.NETWORK_UPDATE_AFFECT_LIVE
+	}
+
+	// The old ranges are put back if the new one can't be added, so DHCP isn't left disabled
+	var undo rollback
+	for _, r := range existing {
+		if err := lq.NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_DELETE, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, dhcpRangeXML(r.Start, r.End), flags); err != nil {
+			return nil, undo.fail("delete_dhcp_range", err)
+		}
+		undo.add("restore the DHCP range "+r.Start+"-"+r.End, func() error {
+			return lq.NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, dhcpRangeXML(r.Start, r.End), flags)
+		})
+	}
+	if err := lq.NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, dhcpRangeXML(dhcp.Start, dhcp.End), flags); err != nil {
+		return nil, undo.fail("add_dhcp_range", err)
+	}
+
+	return networkResponse(network, lq)
+}
+
+// networkResponse describes the network from its XML definition and state
+func networkResponse(network *libvirt.Network, lq LibvirtQemu) (*NetworkResponse, error) {
+	xmlDesc, err := lq.NetworkGetXMLDesc(network)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseNetworkXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &NetworkResponse{
+		Name: def.Name,
+		UUID: def.UUID,
+		Mode: "isolated",
+	}
+	if def.Forward != nil {
+		response.Mode = def.Forward.Mode
+		if response.Mode == "" {
+			// libvirt treats a <forward> without a mode as NAT
+			response.Mode = "nat"
+		}
+		if response.Mode == "route" {
+			response.Mode = "routed"
+		}
+		response.ForwardDevice = def.Forward.Dev
+	}
+	if def.Bridge != nil {
+		response.Bridge = def.Bridge.Name
+	}
+	if ip := def.ipv4(); ip != nil {
+		response.Address = ip.cidr()
+		if ip.DHCP != nil && len(ip.DHCP.Ranges) > 0 {
+			response.DHCP = &DHCPRange{Start: ip.DHCP.Ranges[0].Start, End: ip.DHCP.Ranges[0].End}
+		}
+	}
+
+	if response.Active, err = lq.NetworkIsActive(network); err != nil {
+		return nil, err
+	}
+	if response.Autostart, err = lq.NetworkGetAutostart(network); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// networkDef validates the request and composes the network XML
+func (r *NetworkCreationRequest) networkDef() (*networkDef, error) {
+	if !networkNamePattern.MatchString(r.Name) {
+		return nil, NewBadRequestError("Invalid parameter: name must be 1 to 64 letters, digits, '.', '_' or '-'")
+	}
+	forwardMode, ok := networkForwardModes[r.Mode]
+	if !ok {
+		return nil, NewBadRequestError("Invalid parameter: mode must be isolated, nat or routed")
+	}
+	if r.Bridge != "" && !bridgeNamePattern.MatchString(r.Bridge) {
+		return nil, NewBadRequestError("Invalid parameter: bridge must be an interface name of at most 15 characters")
+	}
+	if r.ForwardDevice != "" && forwardMode == "" {
+		return nil, NewBadRequestError("Invalid parameter: forward_device requires a nat or routed network")
+	}
+	if r.Address == "" && forwardMode != "" {
+		return nil, NewBadRequestError("Invalid parameter: address is required for %s networks", r.Mode)
+	}
+	if r.DHCP != nil && r.Address == "" {
+		return nil, NewBadRequestError("Invalid parameter: dhcp requires address")
+	}
+
+	def := &networkDef{
+		Name: r.Name,
+		// Like the libvirt default network, spanning tree without a forwarding delay holding up new NICs
+		Bridge: &networkBridge{Name: r.Bridge, STP: "on", Delay: "0"},
+	}
+	if forwardMode != "" {
+		def.Forward = &networkForward{Mode: forwardMode, Dev: r.ForwardDevice}
+	}
+
+	if r.Address != "" {
+		hostIP, subnet, err := net.ParseCIDR(r.Address)
+		if err != nil || hostIP.To4() == nil {
+			return nil, NewBadRequestError("Invalid parameter: address must be an IPv4 address in CIDR form, e.g. 10.10.0.1/24")
+		}
+		prefix, _ := subnet.Mask.Size()
+		if prefix > 30 || hostIP.Equal(subnet.IP) || hostIP.Equal(broadcastAddress(subnet)) {
+			return nil, NewBadRequestError("Invalid parameter: address must be a host address of a subnet of at most /30")
+		}
+
+		ip := networkIP{Family: "ipv4", Address: hostIP.String(), Prefix: prefix}
+		if r.DHCP != nil {
+			if err := validateDHCPRange(r.DHCP, hostIP, subnet); err != nil {
+				return nil, err
+			}
+			ip.DHCP = &networkDHCP{Ranges: []networkDHCPRange{{Start: r.DHCP.Start, End: r.DHCP.End}}}
+		}
+		def.IPs = []networkIP{ip}
+	}
+
+	return def, nil
+}
+
+// validateDHCPRange checks that the range lies in the subnet and doesn't hand out the host address
+func validateDHCPRange(dhcp *DHCPRange, hostIP net.IP, subnet *net.IPNet) error {
+	start, end := net.ParseIP(dhcp.Start).To4(), net.ParseIP(dhcp.End).To4()
+	if start == nil || end == nil {
+		return NewBadRequestError("Invalid parameter: dhcp start and end must be IPv4 addresses")
+	}
+	if !subnet.Contains(start) || !subnet.Contains(end) {
+		return NewBadRequestError("Invalid parameter: dhcp range must lie in %s", subnet)
+	}
+	if bytes.Compare(start, end) > 0 {
+		return NewBadRequestError("Invalid parameter: dhcp start must not be after end")
+	}
+	if start.Equal(subnet.IP) || end.Equal(broadcastAddress(subnet)) {
+		return NewBadRequestError("Invalid parameter: dhcp range must not include the network or broadcast address")
+	}
+	if host := hostIP.To4(); bytes.Compare(start, host) <= 0 && bytes.Compare(host, end) <= 0 {
+		return NewBadRequestError("Invalid parameter: dhcp range must not include the host address %s", hostIP)
+	}
+	return nil
+}
+
+// broadcastAddress returns the last address of the IPv4 subnet
+func broadcastAddress(subnet *net.IPNet) net.IP {
+	ip := subnet.IP.To4()
+	broadcast := make(net.IP, len(ip))
+	for i := range ip {
+		broadcast[i] = ip[i] | ^subnet.Mask[i]
+	}
+	return broadcast
+}
+
+// dhcpRangeXML renders the <range> element NetworkUpdate takes for the DHCP range section
+func dhcpRangeXML(start, end string) string {
+	return fmt.Sprintf(`<range start="%s" end="%s"/>`, start, end)
+}
+
+// checkSubnetOverlap fails with a ConflictError when another network uses a subnet overlapping address
+func checkSubnetOverlap(name, address string, lq LibvirtQemu) error {
+	_, subnet, err := net.ParseCIDR(address)
+	if err != nil {
+		return fmt.Errorf("failed to parse the network address %s: %v", address, err)
+	}
+
+	networks, err := lq.ListAllNetworks()
+	if err != nil {
+		return err
+	}
+	for _, network := range networks {
+		xmlDesc, err := lq.NetworkGetXMLDesc(network)
+		if err != nil {
+			return err
+		}
+		def, err := parseNetworkXML(xmlDesc)
+		if err != nil {
+			return err
+		}
+		ip := def.ipv4()
+		if ip == nil {
+			continue
+		}
+		_, other, err := net.ParseCIDR(ip.cidr())
+		if err != nil {
+			continue
+		}
+		if subnet.Contains(other.IP) || other.Contains(subnet.IP) {
+			return NewResourceConflictError("Network", name, fmt.Sprintf("Subnet %s overlaps network %s (%s)", subnet, def.Name, other))
+		}
+	}
+	return nil
+}
+
+// networkUsers returns the UUIDs of the VMs with a NIC on the network, only the running ones if running is set
+func networkUsers(name string, running bool, lq LibvirtQemu) ([]string, error) {
+	domains, err := lq.ListAllDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []string
+	for _, domain := range domains {
+		if running {
+			state, err := lq.GetState(domain)
+			if err != nil {
+				return nil, err
+			}
+			if state != libvirt.DOMAIN_RUNNING && state != libvirt.DOMAIN_PAUSED {
+				continue
+			}
+		}
+
+		xmlDesc, err := lq.GetXMLDesc(domain, 0)
+		if err != nil {
+			return nil, err
+		}
+		def, err := parseDomainXML(xmlDesc)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range def.Devices.Interfaces {
+			if iface.Type == "network" && iface.Source != nil && iface.Source.Network == name {
+				users = append(users, def.UUID)
+				break
+			}
+		}
+	}
+
+	sort.Strings(users)
+	return users, nil
+}
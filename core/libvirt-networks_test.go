@@ -0,0 +1,327 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const defaultNetworkXML = `<network>
+  <name>default</name>
+  <uuid>9a05da11-e96b-47f3-8253-a3a482e445f5</uuid>
+  <forward mode="nat"/>
+  <bridge name="virbr0" stp="on" delay="0"/>
+  <ip address="192.168.122.1" netmask="255.255.255.0">
+    <dhcp>
+      <range start="192.168.122.2" end="192.168.122.254"/>
+    </dhcp>
+  </ip>
+</network>`
+
+// expectNetworks makes the mock serve the networks described by the given XML documents
+func expectNetworks(mockLibvirt *mocks.MockLibvirtQemu, xmlDescs ...string) []*libvirt.Network {
+	networks := make([]*libvirt.Network, 0, len(xmlDescs))
+	byNetwork := map[*libvirt.Network]string{}
+	for _, xmlDesc := range xmlDescs {
+		network := &libvirt.Network{}
+		networks = append(networks, network)
+		byNetwork[network] = xmlDesc
+	}
+
+	mockLibvirt.EXPECT().ListAllNetworks().Return(networks, nil).AnyTimes()
+	mockLibvirt.EXPECT().NetworkGetXMLDesc(gomock.Any()).DoAndReturn(func(network *libvirt.Network) (string, error) {
+		return byNetwork[network], nil
+	}).AnyTimes()
+	return networks
+}
+
+// TestCreateNetwork tests that a NAT network is defined, started and reported
+func TestCreateNetwork(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupNetworkByName("team-a").Return(nil, libvirt.Error{Code: libvirt.ERR_NO_NETWORK}).Times(1)
+
+	var defined string
+	network := &libvirt.Network{}
+	mockLibvirt.EXPECT().
+		NetworkDefineXML(gomock.Any()).
+		DoAndReturn(func(xmlConfig string) (*libvirt.Network, error) {
+			defined = xmlConfig
+			return network, nil
+		}).Times(1)
+	mockLibvirt.EXPECT().NetworkCreate(network).Return(nil).Times(1)
+	mockLibvirt.EXPECT().NetworkSetAutostart(network, true).Return(nil).Times(1)
+
+	// The response is read back from the network libvirt defined. Zero networks are deeply
+	// equal, the defined one is told apart from the existing ones by its address.
+	isDefined := gomock.Cond(func(n *libvirt.Network) bool { return n == network })
+	mockLibvirt.EXPECT().NetworkGetXMLDesc(isDefined).DoAndReturn(func(*libvirt.Network) (string, error) {
+		return defined, nil
+	}).Times(1)
+	mockLibvirt.EXPECT().NetworkIsActive(network).Return(true, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkGetAutostart(network).Return(true, nil).Times(1)
+
+	// The new subnet is checked against the existing networks
+	expectNetworks(mockLibvirt, defaultNetworkXML)
+
+	request := &NetworkCreationRequest{
+		Name:      "team-a",
+		Mode:      "nat",
+		Address:   "10.10.0.1/24",
+		Bridge:    "virbr-team-a",
+		DHCP:      &DHCPRange{Start: "10.10.0.100", End: "10.10.0.200"},
+		Autostart: true,
+	}
+	response, err := createNetwork(request, mockLibvirt)
+	assert.Nil(t, err)
+
+	def, err := parseNetworkXML(defined)
+	assert.Nil(t, err)
+	assert.Equal(t, &networkForward{Mode: "nat"}, def.Forward)
+	assert.Equal(t, []networkIP{{Family: "ipv4", Address: "10.10.0.1", Prefix: 24, DHCP: &networkDHCP{Ranges: []networkDHCPRange{{Start: "10.10.0.100", End: "10.10.0.200"}}}}}, def.IPs)
+
+	assert.Equal(t, &NetworkResponse{
+		Name:      "team-a",
+		Mode:      "nat",
+		Address:   "10.10.0.1/24",
+		Bridge:    "virbr-team-a",
+		DHCP:      &DHCPRange{Start: "10.10.0.100", End: "10.10.0.200"},
+		Active:    true,
+		Autostart: true,
+	}, response)
+}
+
+// TestCreateNetworkIsolated tests that an isolated network has no forward element and may have no address
+func TestCreateNetworkIsolated(t *testing.T) {
+	def, err := (&NetworkCreationRequest{Name: "lab", Mode: "isolated"}).networkDef()
+	assert.Nil(t, err)
+	assert.Nil(t, def.Forward)
+	assert.Empty(t, def.IPs)
+
+	def, err = (&NetworkCreationRequest{Name: "lab", Mode: "routed", Address: "10.20.0.1/16", ForwardDevice: "eth1"}).networkDef()
+	assert.Nil(t, err)
+	assert.Equal(t, &networkForward{Mode: "route", Dev: "eth1"}, def.Forward)
+}
+
+// TestCreateNetworkConflicts tests that an existing name or an overlapping subnet is rejected before defining anything
+func TestCreateNetworkConflicts(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectNetworks(mockLibvirt, defaultNetworkXML)
+	mockLibvirt.EXPECT().LookupNetworkByName("default").Return(&libvirt.Network{}, nil).Times(1)
+	mockLibvirt.EXPECT().LookupNetworkByName("team-b").Return(nil, libvirt.Error{Code: libvirt.ERR_NO_NETWORK}).Times(1)
+
+	_, err := createNetwork(&NetworkCreationRequest{Name: "default", Mode: "isolated"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Network default already exists")
+
+	_, err = createNetwork(&NetworkCreationRequest{Name: "team-b", Mode: "nat", Address: "192.168.0.1/16"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "overlaps network default (192.168.122.0/24)")
+}
+
+// TestCreateNetworkStartFailure tests that a network that fails to start is undefined again
+func TestCreateNetworkStartFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectNetworks(mockLibvirt)
+	mockLibvirt.EXPECT().LookupNetworkByName("team-a").Return(nil, libvirt.Error{Code: libvirt.ERR_NO_NETWORK}).Times(1)
+
+	network := &libvirt.Network{}
+	mockLibvirt.EXPECT().NetworkDefineXML(gomock.Any()).Return(network, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkCreate(network).Return(errors.New("bridge virbr0 is in use")).Times(1)
+	mockLibvirt.EXPECT().NetworkUndefine(network).Return(nil).Times(1)
+
+	_, err := createNetwork(&NetworkCreationRequest{Name: "team-a", Mode: "isolated", Bridge: "virbr0"}, mockLibvirt)
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "start_network", stepErr.Step)
+	assert.Empty(t, stepErr.RollbackErrors)
+}
+
+// TestNetworkCreationRequestInvalid tests the validation of the network creation request
+func TestNetworkCreationRequestInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		request NetworkCreationRequest
+		message string
+	}{
+		{"name", NetworkCreationRequest{Name: "team/a", Mode: "isolated"}, "Invalid parameter: name"},
+		{"mode", NetworkCreationRequest{Name: "team-a", Mode: "bridge"}, "Invalid parameter: mode"},
+		{"bridge", NetworkCreationRequest{Name: "team-a", Mode: "isolated", Bridge: "a-much-too-long-bridge"}, "Invalid parameter: bridge"},
+		{"nat without address", NetworkCreationRequest{Name: "team-a", Mode: "nat"}, "address is required"},
+		{"isolated forward device", NetworkCreationRequest{Name: "team-a", Mode: "isolated", ForwardDevice: "eth0"}, "forward_device requires"},
+		{"ipv6", NetworkCreationRequest{Name: "team-a", Mode: "nat", Address: "fd00::1/64"}, "IPv4 address in CIDR form"},
+		{"network address", NetworkCreationRequest{Name: "team-a", Mode: "nat", Address: "10.10.0.0/24"}, "host address"},
+		{"dhcp without address", NetworkCreationRequest{Name: "team-a", Mode: "isolated", DHCP: &DHCPRange{Start: "10.0.0.2", End: "10.0.0.9"}}, "dhcp requires address"},
+		{"dhcp outside subnet", NetworkCreationRequest{Name: "team-a", Mode: "nat", Address: "10.10.0.1/24", DHCP: &DHCPRange{Start: "10.10.1.2", End: "10.10.1.9"}}, "must lie in 10.10.0.0/24"},
+		{"dhcp reversed", NetworkCreationRequest{Name: "team-a", Mode: "nat", Address: "10.10.0.1/24", DHCP: &DHCPRange{Start: "10.10.0.9", End: "10.10.0.2"}}, "start must not be after end"},
+		{"dhcp host address", NetworkCreationRequest{Name: "team-a", Mode: "nat", Address: "10.10.0.5/24", DHCP: &DHCPRange{Start: "10.10.0.2", End: "10.10.0.9"}}, "host address 10.10.0.5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.request.networkDef()
+			assert.IsType(t, &BadRequestError{}, err)
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
+
+// TestDeleteNetwork tests that a running network no VM uses is stopped and undefined
+func TestDeleteNetwork(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomainMACs(t, mockLibvirt, "00:16:3e:00:00:01")
+
+	network := &libvirt.Network{}
+	mockLibvirt.EXPECT().LookupNetworkByName("team-a").Return(network, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkIsActive(network).Return(true, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkDestroy(network).Return(nil).Times(1)
+	mockLibvirt.EXPECT().NetworkUndefine(network).Return(nil).Times(1)
+
+	response, err := deleteNetwork("team-a", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "team-a", response.Name)
+}
+
+// TestDeleteNetworkInUse tests that a network VMs are attached to, or the default network, is kept
+func TestDeleteNetworkInUse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupNetworkByName("default").Return(&libvirt.Network{}, nil).Times(1)
+
+	_, err := deleteNetwork("default", mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "VMs created without networks")
+
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.addNetworkInterface("team-a", "00:16:3e:00:00:02", "virtio")
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+
+	mockLibvirt.EXPECT().LookupNetworkByName("team-a").Return(&libvirt.Network{}, nil).Times(1)
+	mockLibvirt.EXPECT().ListAllDomains().Return([]*libvirt.Domain{{}}, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), gomock.Any()).Return(xmlDesc, nil).Times(1)
+
+	_, err = deleteNetwork("team-a", mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Network is used by VM "+lifecycleVMID)
+}
+
+// TestStopNetworkInUse tests that a network a running VM is attached to keeps running
+func TestStopNetworkInUse(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomainMACs(t, mockLibvirt, "00:16:3e:00:00:01")
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+
+	network := &libvirt.Network{}
+	mockLibvirt.EXPECT().LookupNetworkByName("default").Return(network, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkIsActive(network).Return(true, nil).Times(1)
+
+	_, err := stopNetwork("default", mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Network is used by running VM "+lifecycleVMID)
+}
+
+// TestUpdateNetworkDHCP tests that the DHCP range of a running network is replaced live and in its config
+func TestUpdateNetworkDHCP(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	networks := expectNetworks(mockLibvirt, defaultNetworkXML)
+	network := networks[0]
+	mockLibvirt.EXPECT().LookupNetworkByName("default").Return(network, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkIsActive(network).Return(true, nil).Times(2)
+	mockLibvirt.EXPECT().NetworkGetAutostart(network).Return(true, nil).Times(1)
+
+	flags := libvirt.NETWORK_UPDATE_AFFECT_CONFIG | libvirt.NETWORK_UPDATE_AFFECT_LIVE
+	gomock.InOrder(
+		mockLibvirt.EXPECT().NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_DELETE, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, `<range start="192.168.122.2" end="192.168.122.254"/>`, flags).Return(nil),
+		mockLibvirt.EXPECT().NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, `<range start="192.168.122.100" end="192.168.122.150"/>`, flags).Return(nil),
+	)
+
+	_, err := updateNetworkDHCP("default", &DHCPRange{Start: "192.168.122.100", End: "192.168.122.150"}, mockLibvirt)
+	assert.Nil(t, err)
+}
+
+// TestUpdateNetworkDHCPFailure tests that the old range is put back when the new one can't be added
+func TestUpdateNetworkDHCPFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	network := expectNetworks(mockLibvirt, defaultNetworkXML)[0]
+	mockLibvirt.EXPECT().LookupNetworkByName("default").Return(network, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkIsActive(network).Return(false, nil).Times(1)
+
+	oldRange := `<range start="192.168.122.2" end="192.168.122.254"/>`
+	gomock.InOrder(
+		mockLibvirt.EXPECT().NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_DELETE, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, oldRange, libvirt.NETWORK_UPDATE_AFFECT_CONFIG).Return(nil),
+		mockLibvirt.EXPECT().NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, gomock.Any(), libvirt.NETWORK_UPDATE_AFFECT_CONFIG).Return(errors.New("update failed")),
+		mockLibvirt.EXPECT().NetworkUpdate(network, libvirt.NETWORK_UPDATE_COMMAND_ADD_LAST, libvirt.NETWORK_SECTION_IP_DHCP_RANGE, oldRange, libvirt.NETWORK_UPDATE_AFFECT_CONFIG).Return(nil),
+	)
+
+	_, err := updateNetworkDHCP("default", &DHCPRange{Start: "192.168.122.100", End: "192.168.122.150"}, mockLibvirt)
+	var stepErr *StepError
+	assert.ErrorAs(t, err, &stepErr)
+	assert.Equal(t, "add_dhcp_range", stepErr.Step)
+	assert.Empty(t, stepErr.RollbackErrors)
+}
+
+// TestGetNetworkNotFound tests that a missing network is reported as such rather than as a missing VM
+func TestGetNetworkNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupNetworkByName("team-z").Return(nil, libvirt.Error{Code: libvirt.ERR_NO_NETWORK}).Times(1)
+
+	_, err := getNetwork("team-z", mockLibvirt)
+	code, details := errorDetails(err)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "Network not found", details.Message)
+}
+
+// TestNetworkResponseDefinedOutside tests that a network defined outside the API is described from its netmask
+func TestNetworkResponseDefinedOutside(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	network := expectNetworks(mockLibvirt, defaultNetworkXML)[0]
+	mockLibvirt.EXPECT().NetworkIsActive(network).Return(false, nil).Times(1)
+	mockLibvirt.EXPECT().NetworkGetAutostart(network).Return(true, nil).Times(1)
+
+	response, err := listNetworks(mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []NetworkResponse{{
+		Name:      "default",
+		UUID:      "9a05da11-e96b-47f3-8253-a3a482e445f5",
+		Mode:      "nat",
+		Address:   "192.168.122.1/24",
+		Bridge:    "virbr0",
+		DHCP:      &DHCPRange{Start: "192.168.122.2", End: "192.168.122.254"},
+		Active:    false,
+		Autostart: true,
+	}}, response.Networks)
+}
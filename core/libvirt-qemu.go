@@ -2,6 +2,8 @@ package core
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 
 	"libvirt.org/go/libvirt"
@@ -11,9 +13,14 @@ import (
 
 // LibvirtQemu defines the interface for libvirt/qemu managment
 type LibvirtQemu interface {
-	NewConnect(uri string) error
 	LookupDomainByUUIDString(uuid string) (*libvirt.Domain, error)
 	CloneAndResizeDisk(baseImage string, newDiskPath string, diskSizeGB int, shrink bool) error
+	ResizeDisk(diskPath string, diskSizeGB int, shrink bool) error
+	CreateOverlay(backingFile string, diskPath string) error
+	CopyDisk(sourcePath string, diskPath string) error
+	ImageInfo(path string) (string, error)
+	RemoveDisk(diskPath string) error
+	CreateSeedImage(path string, files map[string][]byte) error
 	DomainDefineXML(xmlConfig string) (*libvirt.Domain, error)
 	Create(domain *libvirt.Domain) error
 	GetName(domain *libvirt.Domain) (string, error)
@@ -21,49 +28,160 @@ type LibvirtQemu interface {
 	Shutdown(domain *libvirt.Domain) error
 	Destroy(domain *libvirt.Domain) error
 	Undefine(domain *libvirt.Domain) error
+	Reboot(domain *libvirt.Domain) error
+	Suspend(domain *libvirt.Domain) error
+	Resume(domain *libvirt.Domain) error
+	ManagedSave(domain *libvirt.Domain) error
+	HasManagedSaveImage(domain *libvirt.Domain) (bool, error)
+	ManagedSaveRemove(domain *libvirt.Domain) error
+	ListAllDomains() ([]*libvirt.Domain, error)
+	GetUUIDString(domain *libvirt.Domain) (string, error)
+	GetMetadata(domain *libvirt.Domain, namespace string) (string, error)
+	GetXMLDesc(domain *libvirt.Domain, flags libvirt.DomainXMLFlags) (string, error)
+	GetBlockInfo(domain *libvirt.Domain, disk string) (*libvirt.DomainBlockInfo, error)
+	GetHostCPUMap() ([]bool, error)
+	SetVcpusFlags(domain *libvirt.Domain, vcpus uint, flags libvirt.DomainVcpuFlags) error
+	PinVcpuFlags(domain *libvirt.Domain, vcpu uint, cpuMap []bool, flags libvirt.DomainModificationImpact) error
+	GetFreeMemory() (uint64, error)
+	SetMemoryFlags(domain *libvirt.Domain, memoryKiB uint64, flags libvirt.DomainMemoryModFlags) error
+	SetMaxMemory(domain *libvirt.Domain, memoryKiB uint64) error
+	BlockResize(domain *libvirt.Domain, disk string, sizeBytes uint64) error
+	BlockCommit(domain *libvirt.Domain, disk string, flags libvirt.DomainBlockCommitFlags) error
+	GetBlockJobInfo(domain *libvirt.Domain, disk string) (*libvirt.DomainBlockJobInfo, error)
+	BlockJobAbort(domain *libvirt.Domain, disk string, flags libvirt.DomainBlockJobAbortFlags) error
+	GetDomainStats(domain *libvirt.Domain, statsTypes libvirt.DomainStatsTypes) (*libvirt.DomainStats, error)
+	ListAllInterfaceAddresses(domain *libvirt.Domain, source libvirt.DomainInterfaceAddressesSource) ([]libvirt.DomainInterface, error)
+	OpenConsole(domain *libvirt.Domain, flags libvirt.DomainConsoleFlags) (io.ReadWriteCloser, error)
+	QemuAgentCommand(domain *libvirt.Domain, command string, timeout libvirt.DomainQemuAgentCommandTimeout) (string, error)
+	SnapshotCreateXML(domain *libvirt.Domain, xmlConfig string, flags libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error)
+	ListAllSnapshots(domain *libvirt.Domain) ([]*libvirt.DomainSnapshot, error)
+	LookupSnapshotByName(domain *libvirt.Domain, name string) (*libvirt.DomainSnapshot, error)
+	SnapshotGetXMLDesc(snapshot *libvirt.DomainSnapshot) (string, error)
+	SnapshotIsCurrent(snapshot *libvirt.DomainSnapshot) (bool, error)
+	RevertToSnapshot(snapshot *libvirt.DomainSnapshot, flags libvirt.DomainSnapshotRevertFlags) error
+	SnapshotDelete(snapshot *libvirt.DomainSnapshot) error
+	SetBlockIoTune(domain *libvirt.Domain, disk string, params *libvirt.DomainBlockIoTuneParameters, flags libvirt.DomainModificationImpact) error
+	ListAllNetworks() ([]*libvirt.Network, error)
+	LookupNetworkByName(name string) (*libvirt.Network, error)
+	NetworkDefineXML(xmlConfig string) (*libvirt.Network, error)
+	NetworkCreate(network *libvirt.Network) error
+	NetworkDestroy(network *libvirt.Network) error
+	NetworkUndefine(network *libvirt.Network) error
+	NetworkIsActive(network *libvirt.Network) (bool, error)
+	NetworkGetAutostart(network *libvirt.Network) (bool, error)
+	NetworkSetAutostart(network *libvirt.Network, autostart bool) error
+	NetworkGetXMLDesc(network *libvirt.Network) (string, error)
+	NetworkUpdate(network *libvirt.Network, cmd libvirt.NetworkUpdateCommand, section libvirt.NetworkUpdateSection, xmlConfig string, flags libvirt.NetworkUpdateFlags) error
 }
 
+// LibvirtQemuImpl implements LibvirtQemu over the connection owned by the ConnectionManager,
+// each call takes the current connection so a reconnect or shutdown never closes it mid-call
 type LibvirtQemuImpl struct {
-	conn *libvirt.Connect
+	connections *ConnectionManager
 }
 
-// NewConnect creates a connection to the libvirt daemon at the given URI
-func (l *LibvirtQemuImpl) NewConnect(uri string) (err error) {
-	l.conn, err = libvirt.NewConnect(uri)
+func (l *LibvirtQemuImpl) DomainDefineXML(xmlConfig string) (*libvirt.Domain, error) {
+	conn, done, err := l.connections.acquire()
 	if err != nil {
-		return fmt.Errorf("failed to connect to libvirt: %v", err)
+		return nil, err
 	}
-	return nil
-}
-
-func (l *LibvirtQemuImpl) DomainDefineXML(xmlConfig string) (*libvirt.Domain, error) {
-	return l.conn.DomainDefineXML(xmlConfig)
+	defer done()
+	return conn.DomainDefineXML(xmlConfig)
 }
 
 func (l *LibvirtQemuImpl) LookupDomainByUUIDString(id string) (*libvirt.Domain, error) {
-	return l.conn.LookupDomainByUUIDString(id)
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return conn.LookupDomainByUUIDString(id)
 }
 
 // CloneAndResizeDisk clones a base image and resizes the cloned disk
 func (l *LibvirtQemuImpl) CloneAndResizeDisk(baseImage string, newDiskPath string, diskSizeGB int, shrink bool) error {
 	// Clone the base image using qemu-img
-	cmd := exec.Command("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", baseImage, newDiskPath)
-	if err := cmd.Run(); err != nil {
+	if err := l.CreateOverlay(baseImage, newDiskPath); err != nil {
 		return fmt.Errorf("failed to clone the base image: %v", err)
 	}
 
 	// Resize the cloned disk
+	return l.ResizeDisk(newDiskPath, diskSizeGB, shrink)
+}
+
+// CreateOverlay creates a qcow2 image recording its changes on top of the backing file, which
+// must not change afterwards
+func (l *LibvirtQemuImpl) CreateOverlay(backingFile string, diskPath string) error {
+	cmd := exec.Command("qemu-img", "create", "-f", "qcow2", "-F", "qcow2", "-b", backingFile, diskPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to create the overlay: %v: %s", err, output)
+	}
+	return nil
+}
+
+// CopyDisk copies a disk image and its whole backing chain into a new standalone qcow2 image
+func (l *LibvirtQemuImpl) CopyDisk(sourcePath string, diskPath string) error {
+	cmd := exec.Command("qemu-img", "convert", "-O", "qcow2", sourcePath, diskPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to copy the disk: %v: %s", err, output)
+	}
+	return nil
+}
+
+// ImageInfo returns the qemu-img description of an image and its backing chain as JSON. It also
+// works on images a running domain holds open.
+func (l *LibvirtQemuImpl) ImageInfo(path string) (string, error) {
+	cmd := exec.Command("qemu-img", "info", "--output=json", "--backing-chain", "-U", path)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("failed to inspect the image: %v: %s", err, exitErr.Stderr)
+		}
+		return "", fmt.Errorf("failed to inspect the image: %v", err)
+	}
+	return string(output), nil
+}
+
+// ResizeDisk resizes the disk image of a stopped VM with qemu-img. Unless shrink is set
+// qemu-img refuses to make the image smaller than it is.
+func (l *LibvirtQemuImpl) ResizeDisk(diskPath string, diskSizeGB int, shrink bool) error {
 	var resizeCmd *exec.Cmd
 	if shrink {
-		resizeCmd = exec.Command("qemu-img", "resize", "--shrink", newDiskPath, fmt.Sprintf("%dG", diskSizeGB))
+		resizeCmd = exec.Command("qemu-img", "resize", "--shrink", diskPath, fmt.Sprintf("%dG", diskSizeGB))
 	} else {
-		resizeCmd = exec.Command("qemu-img", "resize", newDiskPath, fmt.Sprintf("%dG", diskSizeGB))
+		resizeCmd = exec.Command("qemu-img", "resize", diskPath, fmt.Sprintf("%dG", diskSizeGB))
+	}
+
+	if output, err := resizeCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to resize the disk: %v: %s", err, output)
 	}
 
-	if err := resizeCmd.Run(); err != nil {
-		return fmt.Errorf("failed to resize the disk: %v", err)
+	return nil
+}
+
+// RemoveDisk deletes a disk image, a disk that is already gone is not an error
+func (l *LibvirtQemuImpl) RemoveDisk(diskPath string) error {
+	if err := os.Remove(diskPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the disk: %v", err)
+	}
+	return nil
+}
+
+// CreateSeedImage writes a cloud-init NoCloud seed ISO holding the given files
+func (l *LibvirtQemuImpl) CreateSeedImage(path string, files map[string][]byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create the seed image: %v", err)
 	}
 
+	err = writeSeedISO(f, files)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
 	return nil
 }
 
@@ -111,11 +229,467 @@ func (l *LibvirtQemuImpl) Destroy(domain *libvirt.Domain) error {
 	return nil
 }
 
-// Undefine removes the domain (VM) definition from libvirt
+// Undefine removes the domain (VM) definition from libvirt, with the metadata of its snapshots
 func (l *LibvirtQemuImpl) Undefine(domain *libvirt.Domain) error {
-	err := domain.Undefine()
+	err := domain.UndefineFlags(libvirt.DOMAIN_UNDEFINE_SNAPSHOTS_METADATA)
 	if err != nil {
 		return fmt.Errorf("failed to undefine the domain: %v", err)
 	}
 	return nil
 }
+
+// Reboot asks the guest OS to reboot the domain (VM)
+func (l *LibvirtQemuImpl) Reboot(domain *libvirt.Domain) error {
+	err := domain.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+	if err != nil {
+		return fmt.Errorf("failed to reboot the domain: %v", err)
+	}
+	return nil
+}
+
+// Suspend pauses the domain (VM), keeping its memory resident on the host
+func (l *LibvirtQemuImpl) Suspend(domain *libvirt.Domain) error {
+	err := domain.Suspend()
+	if err != nil {
+		return fmt.Errorf("failed to suspend the domain: %v", err)
+	}
+	return nil
+}
+
+// Resume unpauses a suspended domain (VM)
+func (l *LibvirtQemuImpl) Resume(domain *libvirt.Domain) error {
+	err := domain.Resume()
+	if err != nil {
+		return fmt.Errorf("failed to resume the domain: %v", err)
+	}
+	return nil
+}
+
+// ManagedSave saves the domain (VM) memory to disk and stops it, the next start restores it
+func (l *LibvirtQemuImpl) ManagedSave(domain *libvirt.Domain) error {
+	err := domain.ManagedSave(0)
+	if err != nil {
+		return fmt.Errorf("failed to save the domain: %v", err)
+	}
+	return nil
+}
+
+// HasManagedSaveImage reports whether the domain (VM) has a managed save image to restore from
+func (l *LibvirtQemuImpl) HasManagedSaveImage(domain *libvirt.Domain) (bool, error) {
+	saved, err := domain.HasManagedSaveImage(0)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the domain managed save image: %v", err)
+	}
+	return saved, nil
+}
+
+// ManagedSaveRemove discards the managed save image of the domain (VM), it then boots from its disks
+func (l *LibvirtQemuImpl) ManagedSaveRemove(domain *libvirt.Domain) error {
+	err := domain.ManagedSaveRemove(0)
+	if err != nil {
+		return fmt.Errorf("failed to remove the domain managed save image: %v", err)
+	}
+	return nil
+}
+
+// ListAllDomains returns every domain (VM) defined on the hypervisor
+func (l *LibvirtQemuImpl) ListAllDomains() ([]*libvirt.Domain, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	domains, err := conn.ListAllDomains(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %v", err)
+	}
+
+	result := make([]*libvirt.Domain, len(domains))
+	for i := range domains {
+		result[i] = &domains[i]
+	}
+	return result, nil
+}
+
+// GetUUIDString retrieves the UUID of the domain (VM)
+func (l *LibvirtQemuImpl) GetUUIDString(domain *libvirt.Domain) (string, error) {
+	id, err := domain.GetUUIDString()
+	if err != nil {
+		return "", fmt.Errorf("failed to get the domain uuid: %v", err)
+	}
+	return id, nil
+}
+
+// GetMetadata retrieves the custom metadata element with the given namespace from the
+// persistent domain (VM) definition. The libvirt error is returned as is so callers can
+// tell a missing element (ERR_NO_DOMAIN_METADATA) from a failure.
+func (l *LibvirtQemuImpl) GetMetadata(domain *libvirt.Domain, namespace string) (string, error) {
+	return domain.GetMetadata(libvirt.DOMAIN_METADATA_ELEMENT, namespace, libvirt.DOMAIN_AFFECT_CONFIG)
+}
+
+// GetXMLDesc retrieves the XML definition of the domain (VM), the live one unless
+// DOMAIN_XML_INACTIVE is passed
+func (l *LibvirtQemuImpl) GetXMLDesc(domain *libvirt.Domain, flags libvirt.DomainXMLFlags) (string, error) {
+	xmlDesc, err := domain.GetXMLDesc(flags)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the domain XML: %v", err)
+	}
+	return xmlDesc, nil
+}
+
+// GetBlockInfo retrieves the capacity and allocation of the domain (VM) disk with the given target
+func (l *LibvirtQemuImpl) GetBlockInfo(domain *libvirt.Domain, disk string) (*libvirt.DomainBlockInfo, error) {
+	info, err := domain.GetBlockInfo(disk, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the block info of disk %s: %v", disk, err)
+	}
+	return info, nil
+}
+
+// GetHostCPUMap reports which host CPUs are online, indexed by CPU number
+func (l *LibvirtQemuImpl) GetHostCPUMap() ([]bool, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	online, total, err := conn.GetCPUMap(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the host CPU map: %v", err)
+	}
+
+	cpuMap := make([]bool, total)
+	for cpu, up := range online {
+		if cpu < len(cpuMap) {
+			cpuMap[cpu] = up
+		}
+	}
+	return cpuMap, nil
+}
+
+// SetVcpusFlags changes the number of vCPUs of the domain (VM), live and/or in its persistent config
+func (l *LibvirtQemuImpl) SetVcpusFlags(domain *libvirt.Domain, vcpus uint, flags libvirt.DomainVcpuFlags) error {
+	err := domain.SetVcpusFlags(vcpus, flags)
+	if err != nil {
+		return fmt.Errorf("failed to set the domain vCPUs: %v", err)
+	}
+	return nil
+}
+
+// PinVcpuFlags pins a vCPU of the domain (VM) to the host CPUs set in cpuMap
+func (l *LibvirtQemuImpl) PinVcpuFlags(domain *libvirt.Domain, vcpu uint, cpuMap []bool, flags libvirt.DomainModificationImpact) error {
+	err := domain.PinVcpuFlags(vcpu, cpuMap, flags)
+	if err != nil {
+		return fmt.Errorf("failed to pin vCPU %d: %v", vcpu, err)
+	}
+	return nil
+}
+
+// GetFreeMemory returns the free memory of the host in bytes
+func (l *LibvirtQemuImpl) GetFreeMemory() (uint64, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	free, err := conn.GetFreeMemory()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the host free memory: %v", err)
+	}
+	return free, nil
+}
+
+// SetMemoryFlags changes the memory (in KiB) of the domain (VM). Live changes go through
+// the balloon driver, DOMAIN_MEM_MAXIMUM changes the maximum instead of the current amount.
+func (l *LibvirtQemuImpl) SetMemoryFlags(domain *libvirt.Domain, memoryKiB uint64, flags libvirt.DomainMemoryModFlags) error {
+	err := domain.SetMemoryFlags(memoryKiB, flags)
+	if err != nil {
+		return fmt.Errorf("failed to set the domain memory: %v", err)
+	}
+	return nil
+}
+
+// SetMaxMemory changes the maximum memory (in KiB) of a stopped domain (VM)
+func (l *LibvirtQemuImpl) SetMaxMemory(domain *libvirt.Domain, memoryKiB uint64) error {
+	err := domain.SetMaxMemory(memoryKiB)
+	if err != nil {
+		return fmt.Errorf("failed to set the domain maximum memory: %v", err)
+	}
+	return nil
+}
+
+// BlockResize grows the disk with the given target of a running domain (VM)
+func (l *LibvirtQemuImpl) BlockResize(domain *libvirt.Domain, disk string, sizeBytes uint64) error {
+	err := domain.BlockResize(disk, sizeBytes, libvirt.DOMAIN_BLOCK_RESIZE_BYTES)
+	if err != nil {
+		return fmt.Errorf("failed to resize disk %s: %v", disk, err)
+	}
+	return nil
+}
+
+// BlockCommit starts merging the top image of the disk with the given target of a running domain (VM)
+// into its backing chain, only into the image right below it with DOMAIN_BLOCK_COMMIT_SHALLOW
+func (l *LibvirtQemuImpl) BlockCommit(domain *libvirt.Domain, disk string, flags libvirt.DomainBlockCommitFlags) error {
+	err := domain.BlockCommit(disk, "", "", 0, flags)
+	if err != nil {
+		return fmt.Errorf("failed to commit disk %s: %v", disk, err)
+	}
+	return nil
+}
+
+// GetBlockJobInfo returns the progress of the block job of the disk, its type is unknown when there is none
+func (l *LibvirtQemuImpl) GetBlockJobInfo(domain *libvirt.Domain, disk string) (*libvirt.DomainBlockJobInfo, error) {
+	info, err := domain.GetBlockJobInfo(disk, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the block job of disk %s: %v", disk, err)
+	}
+	return info, nil
+}
+
+// BlockJobAbort ends the block job of the disk, a ready active commit pivots with DOMAIN_BLOCK_JOB_ABORT_PIVOT
+func (l *LibvirtQemuImpl) BlockJobAbort(domain *libvirt.Domain, disk string, flags libvirt.DomainBlockJobAbortFlags) error {
+	err := domain.BlockJobAbort(disk, flags)
+	if err != nil {
+		return fmt.Errorf("failed to end the block job of disk %s: %v", disk, err)
+	}
+	return nil
+}
+
+// GetDomainStats samples the requested groups of statistics of the domain (VM)
+func (l *LibvirtQemuImpl) GetDomainStats(domain *libvirt.Domain, statsTypes libvirt.DomainStatsTypes) (*libvirt.DomainStats, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	stats, err := conn.GetAllDomainStats([]*libvirt.Domain{domain}, statsTypes, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get domain stats: %v", err)
+	}
+	if len(stats) == 0 {
+		return nil, fmt.Errorf("failed to get domain stats: no stats returned")
+	}
+	return &stats[0], nil
+}
+
+// SetBlockIoTune changes the I/O throttling of the disk with the given target of the domain (VM)
+func (l *LibvirtQemuImpl) SetBlockIoTune(domain *libvirt.Domain, disk string, params *libvirt.DomainBlockIoTuneParameters, flags libvirt.DomainModificationImpact) error {
+	err := domain.SetBlockIoTune(disk, params, flags)
+	if err != nil {
+		return fmt.Errorf("failed to set the I/O limits of disk %s: %v", disk, err)
+	}
+	return nil
+}
+
+// ListAllInterfaceAddresses returns the NICs of the domain with the IP addresses the source knows of.
+// The libvirt error is returned as is so an unresponsive guest agent can be told apart.
+func (l *LibvirtQemuImpl) ListAllInterfaceAddresses(domain *libvirt.Domain, source libvirt.DomainInterfaceAddressesSource) ([]libvirt.DomainInterface, error) {
+	return domain.ListAllInterfaceAddresses(source)
+}
+
+// OpenConsole connects a stream to the serial console of the running domain (VM), closing it
+// ends the console session
+func (l *LibvirtQemuImpl) OpenConsole(domain *libvirt.Domain, flags libvirt.DomainConsoleFlags) (io.ReadWriteCloser, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	stream, err := conn.NewStream(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the console stream: %v", err)
+	}
+	if err := domain.OpenConsole("", stream, flags); err != nil {
+		stream.Free()
+		return nil, fmt.Errorf("failed to open the console: %v", err)
+	}
+	return &consoleStream{stream: stream}, nil
+}
+
+// QemuAgentCommand sends a JSON command to the guest agent of the running domain (VM) and returns
+// its JSON reply. The libvirt error is returned as is so an unresponsive guest agent can be told apart.
+func (l *LibvirtQemuImpl) QemuAgentCommand(domain *libvirt.Domain, command string, timeout libvirt.DomainQemuAgentCommandTimeout) (string, error) {
+	return domain.QemuAgentCommand(command, timeout, 0)
+}
+
+// SnapshotCreateXML takes a snapshot of the domain (VM) as described by the snapshot XML
+func (l *LibvirtQemuImpl) SnapshotCreateXML(domain *libvirt.Domain, xmlConfig string, flags libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+	snapshot, err := domain.CreateSnapshotXML(xmlConfig, flags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the snapshot: %v", err)
+	}
+	return snapshot, nil
+}
+
+// ListAllSnapshots returns every snapshot of the domain (VM)
+func (l *LibvirtQemuImpl) ListAllSnapshots(domain *libvirt.Domain) ([]*libvirt.DomainSnapshot, error) {
+	snapshots, err := domain.ListAllSnapshots(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+
+	result := make([]*libvirt.DomainSnapshot, len(snapshots))
+	for i := range snapshots {
+		result[i] = &snapshots[i]
+	}
+	return result, nil
+}
+
+// LookupSnapshotByName finds a snapshot of the domain (VM). The libvirt error is returned as is
+// so a missing snapshot can be told apart.
+func (l *LibvirtQemuImpl) LookupSnapshotByName(domain *libvirt.Domain, name string) (*libvirt.DomainSnapshot, error) {
+	return domain.SnapshotLookupByName(name, 0)
+}
+
+// SnapshotGetXMLDesc returns the XML description of the snapshot
+func (l *LibvirtQemuImpl) SnapshotGetXMLDesc(snapshot *libvirt.DomainSnapshot) (string, error) {
+	xmlDesc, err := snapshot.GetXMLDesc(0)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the snapshot XML: %v", err)
+	}
+	return xmlDesc, nil
+}
+
+// SnapshotIsCurrent tells whether the snapshot is the one the domain (VM) was last reverted to or created
+func (l *LibvirtQemuImpl) SnapshotIsCurrent(snapshot *libvirt.DomainSnapshot) (bool, error) {
+	current, err := snapshot.IsCurrent(0)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether the snapshot is current: %v", err)
+	}
+	return current, nil
+}
+
+// RevertToSnapshot brings the domain (VM) back to the state of the snapshot
+func (l *LibvirtQemuImpl) RevertToSnapshot(snapshot *libvirt.DomainSnapshot, flags libvirt.DomainSnapshotRevertFlags) error {
+	err := snapshot.RevertToSnapshot(flags)
+	if err != nil {
+		return fmt.Errorf("failed to revert to the snapshot: %v", err)
+	}
+	return nil
+}
+
+// SnapshotDelete deletes the snapshot, its children are kept and rebased onto its parent
+func (l *LibvirtQemuImpl) SnapshotDelete(snapshot *libvirt.DomainSnapshot) error {
+	err := snapshot.Delete(0)
+	if err != nil {
+		return fmt.Errorf("failed to delete the snapshot: %v", err)
+	}
+	return nil
+}
+
+// ListAllNetworks returns every virtual network defined on the hypervisor, active or not
+func (l *LibvirtQemuImpl) ListAllNetworks() ([]*libvirt.Network, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	networks, err := conn.ListAllNetworks(0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %v", err)
+	}
+
+	result := make([]*libvirt.Network, len(networks))
+	for i := range networks {
+		result[i] = &networks[i]
+	}
+	return result, nil
+}
+
+// LookupNetworkByName finds a virtual network. The libvirt error is returned as is so
+// callers can tell a missing network (ERR_NO_NETWORK) from a failure.
+func (l *LibvirtQemuImpl) LookupNetworkByName(name string) (*libvirt.Network, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	return conn.LookupNetworkByName(name)
+}
+
+// NetworkDefineXML defines a persistent virtual network without starting it
+func (l *LibvirtQemuImpl) NetworkDefineXML(xmlConfig string) (*libvirt.Network, error) {
+	conn, done, err := l.connections.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	network, err := conn.NetworkDefineXML(xmlConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to define the network: %v", err)
+	}
+	return network, nil
+}
+
+// NetworkCreate starts a defined virtual network, creating its bridge and DHCP server
+func (l *LibvirtQemuImpl) NetworkCreate(network *libvirt.Network) error {
+	err := network.Create()
+	if err != nil {
+		return fmt.Errorf("failed to start the network: %v", err)
+	}
+	return nil
+}
+
+// NetworkDestroy stops a virtual network, the NICs attached to it lose connectivity
+func (l *LibvirtQemuImpl) NetworkDestroy(network *libvirt.Network) error {
+	err := network.Destroy()
+	if err != nil {
+		return fmt.Errorf("failed to stop the network: %v", err)
+	}
+	return nil
+}
+
+// NetworkUndefine removes the virtual network definition from libvirt
+func (l *LibvirtQemuImpl) NetworkUndefine(network *libvirt.Network) error {
+	err := network.Undefine()
+	if err != nil {
+		return fmt.Errorf("failed to undefine the network: %v", err)
+	}
+	return nil
+}
+
+// NetworkIsActive reports whether the virtual network is running
+func (l *LibvirtQemuImpl) NetworkIsActive(network *libvirt.Network) (bool, error) {
+	active, err := network.IsActive()
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether the network is active: %v", err)
+	}
+	return active, nil
+}
+
+// NetworkGetAutostart reports whether the virtual network is started when libvirt starts
+func (l *LibvirtQemuImpl) NetworkGetAutostart(network *libvirt.Network) (bool, error) {
+	autostart, err := network.GetAutostart()
+	if err != nil {
+		return false, fmt.Errorf("failed to get the network autostart: %v", err)
+	}
+	return autostart, nil
+}
+
+// NetworkSetAutostart sets whether the virtual network is started when libvirt starts
+func (l *LibvirtQemuImpl) NetworkSetAutostart(network *libvirt.Network, autostart bool) error {
+	err := network.SetAutostart(autostart)
+	if err != nil {
+		return fmt.Errorf("failed to set the network autostart: %v", err)
+	}
+	return nil
+}
+
+// NetworkGetXMLDesc retrieves the XML definition of the virtual network
+func (l *LibvirtQemuImpl) NetworkGetXMLDesc(network *libvirt.Network) (string, error) {
+	xmlDesc, err := network.GetXMLDesc(0)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the network XML: %v", err)
+	}
+	return xmlDesc, nil
+}
+
+// NetworkUpdate changes a section of the virtual network, e.g. a DHCP range, live and/or in
+// its persistent config. The section belongs to the first <ip> element it applies to.
+func (l *LibvirtQemuImpl) NetworkUpdate(network *libvirt.Network, cmd libvirt.NetworkUpdateCommand, section libvirt.NetworkUpdateSection, xmlConfig string, flags libvirt.NetworkUpdateFlags) error {
+	err := network.Update(cmd, section, -1, xmlConfig, flags)
+	if err != nil {
+		return fmt.Errorf("failed to update the network: %v", err)
+	}
+	return nil
+}
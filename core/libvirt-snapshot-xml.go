@@ -0,0 +1,83 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+)
+
+// snapshotDef is the libvirt domain snapshot XML, the API marshals it to take snapshots and reads
+// back the parts it reports from SnapshotGetXMLDesc. Unknown elements are ignored when parsing.
+type snapshotDef struct {
+	XMLName      xml.Name        `xml:"domainsnapshot"`
+	Name         string          `xml:"name"`
+	Description  string          `xml:"description,omitempty"`
+	State        string          `xml:"state,omitempty"`
+	CreationTime int64           `xml:"creationTime,omitempty"`
+	Parent       *snapshotParent `xml:"parent"`
+	Memory       *snapshotMemory `xml:"memory"`
+	Disks        *snapshotDisks  `xml:"disks"`
+}
+
+type snapshotParent struct {
+	Name string `xml:"name"`
+}
+
+// snapshotMemory says whether and where the memory state is saved: "no", "internal" or "external"
+type snapshotMemory struct {
+	Snapshot string `xml:"snapshot,attr"`
+	File     string `xml:"file,attr,omitempty"`
+}
+
+type snapshotDisks struct {
+	Disks []snapshotDisk `xml:"disk"`
+}
+
+// snapshotDisk says how a disk of the domain, by target, is snapshotted: "no", "internal" or "external"
+type snapshotDisk struct {
+	Name     string              `xml:"name,attr"`
+	Snapshot string              `xml:"snapshot,attr,omitempty"`
+	Driver   *snapshotDiskDriver `xml:"driver"`
+	Source   *domainDiskSource   `xml:"source"`
+}
+
+type snapshotDiskDriver struct {
+	Type string `xml:"type,attr"`
+}
+
+// parseSnapshotXML decodes the XML returned by libvirt's SnapshotGetXMLDesc
+func parseSnapshotXML(data string) (*snapshotDef, error) {
+	var def snapshotDef
+	if err := xml.Unmarshal([]byte(data), &def); err != nil {
+		return nil, fmt.Errorf("failed to parse the snapshot XML: %v", err)
+	}
+	return &def, nil
+}
+
+// marshal renders the snapshot XML passed to SnapshotCreateXML
+func (s *snapshotDef) marshal() (string, error) {
+	data, err := xml.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render the snapshot XML: %v", err)
+	}
+	return string(data), nil
+}
+
+// external tells whether the snapshot keeps its state in files outside the disk images
+func (s *snapshotDef) external() bool {
+	if s.Memory != nil && s.Memory.Snapshot == "external" {
+		return true
+	}
+	if s.Disks != nil {
+		for _, disk := range s.Disks.Disks {
+			if disk.Snapshot == "external" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasMemory tells whether the snapshot saved the memory state of the running domain
+func (s *snapshotDef) hasMemory() bool {
+	return s.Memory != nil && s.Memory.Snapshot != "" && s.Memory.Snapshot != "no"
+}
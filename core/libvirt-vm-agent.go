@@ -0,0 +1,392 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// guestAgentChannel is the virtio-serial port name qemu-guest-agent opens in the guest
+const guestAgentChannel = "org.qemu.guest_agent.0"
+
+// agentCommandTimeout bounds a single guest agent command, in seconds
+const agentCommandTimeout = libvirt.DomainQemuAgentCommandTimeout(10)
+
+// agentExecPollInterval is how often guest-exec-status is asked whether the command finished
+var agentExecPollInterval = 200 * time.Millisecond
+
+// agentFileChunkSize is the most read or written per guest agent command, the replies are
+// base64 encoded and libvirt caps their size
+const agentFileChunkSize = 1 << 20
+
+// maxAgentFileSize is the largest file read through the guest agent
+const maxAgentFileSize = 16 << 20
+
+// guestAgent sends commands to the guest agent of a running VM
+type guestAgent struct {
+	vmID   string
+	domain *libvirt.Domain
+	lq     LibvirtQemu
+}
+
+// agentError is the guest agent reply to a failed command, libvirt normally turns it into an error
+type agentError struct {
+	Class string `json:"class"`
+	Desc  string `json:"desc"`
+}
+
+// newGuestAgent looks up the VM and checks that it runs, the guest agent lives in the guest
+func newGuestAgent(vmID string, lq LibvirtQemu) (*guestAgent, error) {
+	domain, err := runningDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+	return &guestAgent{vmID: vmID, domain: domain, lq: lq}, nil
+}
+
+// agentUnresponsive tells whether the libvirt error means the guest agent isn't there to answer
+func agentUnresponsive(err error) bool {
+	er, ok := err.(libvirt.Error)
+	return ok && (er.Code == libvirt.ERR_AGENT_UNRESPONSIVE || er.Code == libvirt.ERR_AGENT_UNSYNCED)
+}
+
+// call runs the guest agent command with the arguments and decodes its return value into result
+func (a *guestAgent) call(command string, arguments any, result any) error {
+	request := map[string]any{"execute": command}
+	if arguments != nil {
+		request["arguments"] = arguments
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode the %s command: %v", command, err)
+	}
+
+	reply, err := a.lq.QemuAgentCommand(a.domain, string(data), agentCommandTimeout)
+	if err != nil {
+		if agentUnresponsive(err) {
+			return NewConflictError(a.vmID, "Guest agent is not responding")
+		}
+		if er, ok := err.(libvirt.Error); ok {
+			switch {
+			case er.Code == libvirt.ERR_ARGUMENT_UNSUPPORTED:
+				// VMs created before the channel was added have no agent to talk to
+				return NewConflictError(a.vmID, "VM has no guest agent channel")
+			case strings.Contains(er.Message, "unable to execute QEMU agent command"):
+				// The agent ran the command and refused it, e.g. a missing file or program
+				_, reason, found := strings.Cut(er.Message, "': ")
+				if !found {
+					reason = er.Message
+				}
+				return NewBadRequestError("Guest agent %s failed: %s", command, reason)
+			}
+		}
+		return fmt.Errorf("failed to run the guest agent %s command: %v", command, err)
+	}
+
+	var response struct {
+		Return json.RawMessage `json:"return"`
+		Error  *agentError     `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(reply), &response); err != nil {
+		return fmt.Errorf("failed to parse the guest agent %s reply: %v", command, err)
+	}
+	if response.Error != nil {
+		return NewBadRequestError("Guest agent %s failed: %s", command, response.Error.Desc)
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Return, result); err != nil {
+		return fmt.Errorf("failed to parse the guest agent %s reply: %v", command, err)
+	}
+	return nil
+}
+
+// decodeAgentData decodes the base64 data of a guest agent reply, absent data is empty
+func decodeAgentData(data string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the guest agent data: %v", err)
+	}
+	return decoded, nil
+}
+
+// execGuestCommand runs the program in the guest and waits for it to exit, returning its output.
+// The guest agent can't stop a program, one still running after the timeout or a cancellation is
+// left running.
+func execGuestCommand(c *gin.Context, vmID string, request *AgentExecRequest, lq LibvirtQemu) (*AgentExecResponse, error) {
+	progress := progressFromContext(c)
+	agent, err := newGuestAgent(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	arguments := map[string]any{
+		"path":           request.Path,
+		"capture-output": true,
+	}
+	// The agent refuses a null list, the arguments are only sent when there are some
+	if len(request.Args) > 0 {
+		arguments["arg"] = request.Args
+	}
+	if len(request.Env) > 0 {
+		arguments["env"] = request.Env
+	}
+	if request.Input != "" {
+		arguments["input-data"] = base64.StdEncoding.EncodeToString([]byte(request.Input))
+	}
+
+	var started struct {
+		PID int `json:"pid"`
+	}
+	if err := agent.call("guest-exec", arguments, &started); err != nil {
+		return nil, err
+	}
+	progress.Report(10, fmt.Sprintf("Waiting for pid %d to exit", started.PID))
+
+	timeout := request.Timeout
+	if timeout == 0 {
+		timeout = defaultAgentExecTimeout
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	for {
+		var status struct {
+			Exited       bool   `json:"exited"`
+			ExitCode     *int   `json:"exitcode"`
+			Signal       *int   `json:"signal"`
+			OutData      string `json:"out-data"`
+			ErrData      string `json:"err-data"`
+			OutTruncated bool   `json:"out-truncated"`
+			ErrTruncated bool   `json:"err-truncated"`
+		}
+		if err := agent.call("guest-exec-status", map[string]any{"pid": started.PID}, &status); err != nil {
+			return nil, err
+		}
+
+		if status.Exited {
+			stdout, err := decodeAgentData(status.OutData)
+			if err != nil {
+				return nil, err
+			}
+			stderr, err := decodeAgentData(status.ErrData)
+			if err != nil {
+				return nil, err
+			}
+
+			response := &AgentExecResponse{
+				VMID:            vmID,
+				PID:             started.PID,
+				Stdout:          string(stdout),
+				Stderr:          string(stderr),
+				StdoutTruncated: status.OutTruncated,
+				StderrTruncated: status.ErrTruncated,
+				Signal:          status.Signal,
+			}
+			// A program killed by a signal has no exit code
+			if status.ExitCode != nil {
+				response.ExitCode = *status.ExitCode
+			} else if status.Signal != nil {
+				response.ExitCode = -1
+			}
+			return response, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, NewConflictError(vmID, fmt.Sprintf("Command is still running after %d seconds (pid %d)", timeout, started.PID))
+		}
+		if progress.Cancelled() {
+			return nil, errOperationCancelled
+		}
+		time.Sleep(agentExecPollInterval)
+	}
+}
+
+// readGuestFile reads a file of the guest through the guest agent
+func readGuestFile(vmID, path, encoding string, lq LibvirtQemu) (*AgentFileResponse, error) {
+	if path == "" {
+		return nil, NewBadRequestError("Invalid parameter: path is required")
+	}
+	if encoding != "base64" && encoding != "text" {
+		return nil, NewBadRequestError("Invalid parameter: encoding must be base64 or text")
+	}
+
+	agent, err := newGuestAgent(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	var handle int
+	if err := agent.call("guest-file-open", map[string]any{"path": path, "mode": "r"}, &handle); err != nil {
+		return nil, err
+	}
+	defer agent.call("guest-file-close", map[string]any{"handle": handle}, nil)
+
+	var content []byte
+	for {
+		var chunk struct {
+			Count int    `json:"count"`
+			Data  string `json:"buf-b64"`
+			EOF   bool   `json:"eof"`
+		}
+		if err := agent.call("guest-file-read", map[string]any{"handle": handle, "count": agentFileChunkSize}, &chunk); err != nil {
+			return nil, err
+		}
+		data, err := decodeAgentData(chunk.Data)
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, data...)
+
+		if len(content) > maxAgentFileSize {
+			return nil, NewBadRequestError("File %s is larger than %d MiB", path, maxAgentFileSize>>20)
+		}
+		if chunk.EOF || chunk.Count == 0 {
+			break
+		}
+	}
+
+	response := &AgentFileResponse{
+		VMID:     vmID,
+		Path:     path,
+		Size:     len(content),
+		Encoding: encoding,
+	}
+	if encoding == "text" {
+		if !utf8.Valid(content) {
+			return nil, NewBadRequestError("File %s is not valid UTF-8 text, read it as base64", path)
+		}
+		response.Content = string(content)
+	} else {
+		response.Content = base64.StdEncoding.EncodeToString(content)
+	}
+	return response, nil
+}
+
+// writeGuestFile creates or replaces a file of the guest through the guest agent
+func writeGuestFile(vmID string, request *AgentFileWriteRequest, lq LibvirtQemu) (*AgentFileWriteResponse, error) {
+	if request.Path == "" {
+		return nil, NewBadRequestError("Invalid parameter: path is required")
+	}
+
+	var content []byte
+	switch request.Encoding {
+	case "", "base64":
+		decoded, err := base64.StdEncoding.DecodeString(request.Content)
+		if err != nil {
+			return nil, NewBadRequestError("Invalid parameter: content is not valid base64")
+		}
+		content = decoded
+	case "text":
+		content = []byte(request.Content)
+	default:
+		return nil, NewBadRequestError("Invalid parameter: encoding must be base64 or text")
+	}
+
+	agent, err := newGuestAgent(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	var handle int
+	if err := agent.call("guest-file-open", map[string]any{"path": request.Path, "mode": "w"}, &handle); err != nil {
+		return nil, err
+	}
+
+	written, err := writeGuestFileChunks(agent, handle, content)
+	if err != nil {
+		agent.call("guest-file-close", map[string]any{"handle": handle}, nil)
+		return nil, err
+	}
+
+	// Closing flushes the file, a failure here means the content may not have reached the disk
+	if err := agent.call("guest-file-close", map[string]any{"handle": handle}, nil); err != nil {
+		return nil, err
+	}
+
+	return &AgentFileWriteResponse{
+		VMID:    vmID,
+		Path:    request.Path,
+		Size:    written,
+		Status:  "written",
+		Message: fmt.Sprintf("Wrote %d bytes to %s", written, request.Path),
+	}, nil
+}
+
+// writeGuestFileChunks writes the content to the open file, the agent may write less than asked
+func writeGuestFileChunks(agent *guestAgent, handle int, content []byte) (int, error) {
+	written := 0
+	for written < len(content) {
+		end := min(written+agentFileChunkSize, len(content))
+
+		var result struct {
+			Count int `json:"count"`
+		}
+		arguments := map[string]any{
+			"handle":  handle,
+			"buf-b64": base64.StdEncoding.EncodeToString(content[written:end]),
+		}
+		if err := agent.call("guest-file-write", arguments, &result); err != nil {
+			return written, err
+		}
+		if result.Count <= 0 {
+			return written, fmt.Errorf("guest agent wrote nothing to the file")
+		}
+		written += result.Count
+	}
+	return written, nil
+}
+
+// getGuestFSInfo lists the mounted filesystems of the guest with their usage
+func getGuestFSInfo(vmID string, lq LibvirtQemu) (*AgentFSInfoResponse, error) {
+	agent, err := newGuestAgent(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	var filesystems []struct {
+		Name       string  `json:"name"`
+		Mountpoint string  `json:"mountpoint"`
+		Type       string  `json:"type"`
+		UsedBytes  *uint64 `json:"used-bytes"`
+		TotalBytes *uint64 `json:"total-bytes"`
+		Disks      []struct {
+			Dev    string `json:"dev"`
+			Serial string `json:"serial"`
+			Bus    string `json:"bus-type"`
+		} `json:"disk"`
+	}
+	if err := agent.call("guest-get-fsinfo", nil, &filesystems); err != nil {
+		return nil, err
+	}
+
+	response := &AgentFSInfoResponse{
+		VMID:        vmID,
+		Filesystems: make([]AgentFilesystem, 0, len(filesystems)),
+	}
+	for _, fs := range filesystems {
+		filesystem := AgentFilesystem{
+			Name:       fs.Name,
+			Mountpoint: fs.Mountpoint,
+			Type:       fs.Type,
+			UsedBytes:  fs.UsedBytes,
+			TotalBytes: fs.TotalBytes,
+			Disks:      []AgentFilesystemDisk{},
+		}
+		for _, disk := range fs.Disks {
+			filesystem.Disks = append(filesystem.Disks, AgentFilesystemDisk{
+				Device: disk.Dev,
+				Serial: disk.Serial,
+				Bus:    disk.Bus,
+			})
+		}
+		response.Filesystems = append(response.Filesystems, filesystem)
+	}
+	return response, nil
+}
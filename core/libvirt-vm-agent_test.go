@@ -0,0 +1,232 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// expectRunningAgent makes the VM found and running for the given number of lookups
+func expectRunningAgent(mockLibvirt *mocks.MockLibvirtQemu, times int) *libvirt.Domain {
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(times)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil).Times(times)
+	return domain
+}
+
+// agentCommand matches the guest agent command sent, capturing its arguments
+func agentCommand(t *testing.T, command string, arguments *map[string]any) gomock.Matcher {
+	return gomock.Cond(func(data string) bool {
+		var request struct {
+			Execute   string         `json:"execute"`
+			Arguments map[string]any `json:"arguments"`
+		}
+		assert.Nil(t, json.Unmarshal([]byte(data), &request))
+		if request.Execute != command {
+			return false
+		}
+		if arguments != nil {
+			*arguments = request.Arguments
+		}
+		return true
+	})
+}
+
+// TestExecGuestCommand tests that the command is started and polled until it exits
+func TestExecGuestCommand(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	pollInterval := agentExecPollInterval
+	agentExecPollInterval = 0
+	defer func() { agentExecPollInterval = pollInterval }()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := expectRunningAgent(mockLibvirt, 1)
+
+	var arguments map[string]any
+	stdout := base64.StdEncoding.EncodeToString([]byte("hello\n"))
+	gomock.InOrder(
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-exec", &arguments), agentCommandTimeout).
+			Return(`{"return":{"pid":42}}`, nil),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-exec-status", nil), agentCommandTimeout).
+			Return(`{"return":{"exited":false}}`, nil),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-exec-status", nil), agentCommandTimeout).
+			Return(`{"return":{"exited":true,"exitcode":3,"out-data":"`+stdout+`"}}`, nil),
+	)
+
+	response, err := execGuestCommand(nil, lifecycleVMID, &AgentExecRequest{Path: "/bin/sh", Args: []string{"-c", "cat; exit 3"}, Input: "hello\n"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, 42, response.PID)
+	assert.Equal(t, 3, response.ExitCode)
+	assert.Equal(t, "hello\n", response.Stdout)
+	assert.Equal(t, "", response.Stderr)
+
+	assert.Equal(t, "/bin/sh", arguments["path"])
+	assert.Equal(t, []any{"-c", "cat; exit 3"}, arguments["arg"])
+	assert.Equal(t, true, arguments["capture-output"])
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("hello\n")), arguments["input-data"])
+}
+
+// TestExecGuestCommandNoArgs tests that a program run without arguments gets no arg list, the
+// guest agent refuses a null one
+func TestExecGuestCommandNoArgs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := expectRunningAgent(mockLibvirt, 1)
+
+	var arguments map[string]any
+	gomock.InOrder(
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-exec", &arguments), agentCommandTimeout).
+			Return(`{"return":{"pid":7}}`, nil),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-exec-status", nil), agentCommandTimeout).
+			Return(`{"return":{"exited":true,"exitcode":0}}`, nil),
+	)
+
+	response, err := execGuestCommand(nil, lifecycleVMID, &AgentExecRequest{Path: "/bin/true"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, response.ExitCode)
+	assert.Equal(t, "/bin/true", arguments["path"])
+	assert.NotContains(t, arguments, "arg")
+}
+
+// TestGuestAgentErrors tests that the libvirt errors of the guest agent are mapped to the documented responses
+func TestGuestAgentErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := expectRunningAgent(mockLibvirt, 4)
+
+	gomock.InOrder(
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, gomock.Any(), agentCommandTimeout).
+			Return("", libvirt.Error{Code: libvirt.ERR_AGENT_UNRESPONSIVE, Message: "Guest agent is not responding"}),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, gomock.Any(), agentCommandTimeout).
+			Return("", libvirt.Error{Code: libvirt.ERR_ARGUMENT_UNSUPPORTED, Message: "QEMU guest agent is not configured"}),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, gomock.Any(), agentCommandTimeout).
+			Return("", libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "internal error: unable to execute QEMU agent command 'guest-exec': Failed to execute child process \"/nope\""}),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, gomock.Any(), agentCommandTimeout).
+			Return("", libvirt.Error{Code: libvirt.ERR_INTERNAL_ERROR, Message: "internal error: cannot send command"}),
+	)
+
+	_, err := execGuestCommand(nil, lifecycleVMID, &AgentExecRequest{Path: "/bin/true"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Guest agent is not responding")
+
+	// VMs created before the channel was added
+	_, err = getGuestFSInfo(lifecycleVMID, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "VM has no guest agent channel")
+
+	// The agent refusing the command is the client's doing
+	_, err = execGuestCommand(nil, lifecycleVMID, &AgentExecRequest{Path: "/nope"}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+	assert.ErrorContains(t, err, `Guest agent guest-exec failed: Failed to execute child process "/nope"`)
+
+	// Any other failure is the hypervisor's
+	_, err = execGuestCommand(nil, lifecycleVMID, &AgentExecRequest{Path: "/bin/true"}, mockLibvirt)
+	code, _ := errorDetails(err)
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+// TestReadGuestFile tests that the file is read in chunks until the end and closed
+func TestReadGuestFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := expectRunningAgent(mockLibvirt, 2)
+
+	first := base64.StdEncoding.EncodeToString([]byte("vm-"))
+	second := base64.StdEncoding.EncodeToString([]byte("01\n"))
+	var closed map[string]any
+	for range 2 {
+		gomock.InOrder(
+			mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-open", nil), agentCommandTimeout).
+				Return(`{"return":7}`, nil),
+			mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-read", nil), agentCommandTimeout).
+				Return(`{"return":{"count":3,"buf-b64":"`+first+`","eof":false}}`, nil),
+			mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-read", nil), agentCommandTimeout).
+				Return(`{"return":{"count":3,"buf-b64":"`+second+`","eof":true}}`, nil),
+			mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-close", &closed), agentCommandTimeout).
+				Return(`{"return":{}}`, nil),
+		)
+	}
+
+	response, err := readGuestFile(lifecycleVMID, "/etc/hostname", "text", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "vm-01\n", response.Content)
+	assert.Equal(t, 6, response.Size)
+	assert.Equal(t, float64(7), closed["handle"])
+
+	response, err = readGuestFile(lifecycleVMID, "/etc/hostname", "base64", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("vm-01\n")), response.Content)
+
+	// Bad parameters are refused before the guest is reached
+	_, err = readGuestFile(lifecycleVMID, "", "text", mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+	_, err = readGuestFile(lifecycleVMID, "/etc/hostname", "hex", mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestWriteGuestFile tests that the content is written and the file closed
+func TestWriteGuestFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := expectRunningAgent(mockLibvirt, 1)
+
+	var opened, written map[string]any
+	gomock.InOrder(
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-open", &opened), agentCommandTimeout).
+			Return(`{"return":3}`, nil),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-write", &written), agentCommandTimeout).
+			Return(`{"return":{"count":10,"eof":false}}`, nil),
+		mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-file-close", nil), agentCommandTimeout).
+			Return(`{"return":{}}`, nil),
+	)
+
+	response, err := writeGuestFile(lifecycleVMID, &AgentFileWriteRequest{Path: "/etc/app.conf", Content: "port=8080\n", Encoding: "text"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, response.Size)
+	assert.Equal(t, "written", response.Status)
+	assert.Equal(t, "w", opened["mode"])
+	assert.Equal(t, "/etc/app.conf", opened["path"])
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("port=8080\n")), written["buf-b64"])
+
+	_, err = writeGuestFile(lifecycleVMID, &AgentFileWriteRequest{Path: "/etc/app.conf", Content: "not base64!"}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestGetGuestFSInfo tests that the filesystems reported by the guest agent are returned
+func TestGetGuestFSInfo(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := expectRunningAgent(mockLibvirt, 1)
+
+	mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-get-fsinfo", nil), agentCommandTimeout).
+		Return(`{"return":[{"name":"vda1","mountpoint":"/","type":"ext4","used-bytes":1024,"total-bytes":4096,
+			"disk":[{"dev":"/dev/vda1","serial":"abc","bus-type":"virtio","bus":0,"target":0,"unit":0}]}]}`, nil)
+
+	response, err := getGuestFSInfo(lifecycleVMID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Len(t, response.Filesystems, 1)
+	fs := response.Filesystems[0]
+	assert.Equal(t, "/", fs.Mountpoint)
+	assert.Equal(t, "ext4", fs.Type)
+	assert.Equal(t, uint64(1024), *fs.UsedBytes)
+	assert.Equal(t, uint64(4096), *fs.TotalBytes)
+	assert.Equal(t, []AgentFilesystemDisk{{Device: "/dev/vda1", Serial: "abc", Bus: "virtio"}}, fs.Disks)
+}
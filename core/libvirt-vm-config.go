@@ -0,0 +1,162 @@
+package core
+
+import (
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getVMConfig reads the live domain XML of the VM and turns it into the config response
+func getVMConfig(c *gin.Context, vmID string, lq LibvirtQemu) (*VMConfigResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlDesc, err := lq.GetXMLDesc(domain, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &VMConfigResponse{
+		ID:    vmID,
+		Name:  def.Name,
+		VCPUs: def.VCPU.Value,
+		Disks: []VMDiskConfig{},
+		NICs:  []VMNICConfig{},
+	}
+	// The vcpu value is the maximum a hot-plug can go up to, current is what the VM has
+	if def.VCPU.Current > 0 {
+		response.VCPUs = def.VCPU.Current
+	}
+
+	if response.Memory, err = def.Memory.inMiB(); err != nil {
+		return nil, err
+	}
+	response.CurrentMemory = response.Memory
+	if def.CurrentMemory != nil {
+		if response.CurrentMemory, err = def.CurrentMemory.inMiB(); err != nil {
+			return nil, err
+		}
+	}
+
+	if def.CPUTune != nil && (len(def.CPUTune.VCPUPins) > 0 || def.CPUTune.EmulatorPin != nil || len(def.CPUTune.IOThreadPins) > 0) {
+		if response.CPUPinning, response.VCPUPins, err = cpuPinningFromTune(def.CPUTune, response.VCPUs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, disk := range def.Devices.Disks {
+		diskConfig := VMDiskConfig{
+			Target:   disk.Target.Dev,
+			Bus:      disk.Target.Bus,
+			Device:   disk.Device,
+			IOLimits: ioLimitsFromTune(disk.IOTune),
+		}
+		if disk.Source != nil {
+			diskConfig.Path = disk.Source.File
+			if diskConfig.Path == "" {
+				diskConfig.Path = disk.Source.Dev
+			}
+		}
+		if disk.Driver != nil {
+			diskConfig.Format = disk.Driver.Type
+		}
+
+		// Only disks backed by an image have a capacity, an empty CD-ROM drive has none
+		if disk.Device == "disk" && disk.Source != nil {
+			info, err := lq.GetBlockInfo(domain, disk.Target.Dev)
+			if err != nil {
+				return nil, err
+			}
+			diskConfig.SizeBytes = info.Capacity
+		}
+
+		response.Disks = append(response.Disks, diskConfig)
+	}
+
+	// The first disk is the root disk created from the base image
+	for _, disk := range response.Disks {
+		if disk.Device == "disk" {
+			response.DiskSize = int(disk.SizeBytes >> 30)
+			response.IOLimits = disk.IOLimits
+			break
+		}
+	}
+
+	for _, iface := range def.Devices.Interfaces {
+		nic := VMNICConfig{
+			Type: iface.Type,
+		}
+		if iface.MAC != nil {
+			nic.MacAddress = iface.MAC.Address
+		}
+		if iface.Source != nil {
+			nic.Source = iface.Source.Network
+			if nic.Source == "" {
+				nic.Source = iface.Source.Bridge
+			}
+		}
+		if iface.Model != nil {
+			nic.Model = iface.Model.Type
+		}
+		if iface.VLAN != nil && len(iface.VLAN.Tags) > 0 {
+			nic.VLAN = iface.VLAN.Tags[0].ID
+		}
+		nic.Bandwidth = networkBandwidthFromDomain(iface.Bandwidth)
+		response.NICs = append(response.NICs, nic)
+	}
+
+	return response, nil
+}
+
+// cpuPinningFromTune lists the host core of each vCPU, in vCPU order. When the vCPUs aren't each
+// pinned to a single core the pins can't be expressed as cores, they are returned as libvirt holds them.
+func cpuPinningFromTune(tune *domainCPUTune, vcpus int) (*CPUPinning, []VCPUPinConfig, error) {
+	pinning := &CPUPinning{}
+
+	pins := make([]domainVCPUPin, len(tune.VCPUPins))
+	copy(pins, tune.VCPUPins)
+	sort.Slice(pins, func(i, j int) bool { return pins[i].VCPU < pins[j].VCPU })
+
+	collapsible := len(pins) == vcpus
+	for i, pin := range pins {
+		cpus, err := parseCPUSet(pin.CPUSet)
+		if err != nil {
+			return nil, nil, err
+		}
+		if pin.VCPU != i || len(cpus) != 1 {
+			collapsible = false
+			continue
+		}
+		pinning.Cores = append(pinning.Cores, cpus[0])
+	}
+
+	var rawPins []VCPUPinConfig
+	if !collapsible {
+		pinning.Cores = nil
+		for _, pin := range pins {
+			rawPins = append(rawPins, VCPUPinConfig{VCPU: pin.VCPU, CPUSet: pin.CPUSet})
+		}
+	}
+
+	var err error
+	if tune.EmulatorPin != nil {
+		if pinning.EmulatorCores, err = parseCPUSet(tune.EmulatorPin.CPUSet); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, pin := range tune.IOThreadPins {
+		cpus, err := parseCPUSet(pin.CPUSet)
+		if err != nil {
+			return nil, nil, err
+		}
+		pinning.IOThreadCores = append(pinning.IOThreadCores, cpus...)
+	}
+	return pinning, rawPins, nil
+}
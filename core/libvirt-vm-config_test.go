@@ -0,0 +1,135 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const testConfigXML = `
+<domain type='kvm' id='3'>
+  <name>123e4567-e89b-12d3-a456-426614174000</name>
+  <uuid>123e4567-e89b-12d3-a456-426614174000</uuid>
+  <memory unit='KiB'>4194304</memory>
+  <currentMemory unit='KiB'>2097152</currentMemory>
+  <vcpu placement='static'>2</vcpu>
+  <cputune>
+    <vcpupin vcpu='0' cpuset='2'/>
+    <vcpupin vcpu='1' cpuset='3'/>
+  </cputune>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/var/lib/libvirt/images/123e4567-e89b-12d3-a456-426614174000.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+      <iotune>
+        <total_iops_sec>1000</total_iops_sec>
+      </iotune>
+    </disk>
+    <disk type='file' device='cdrom'>
+      <target dev='sda' bus='sata'/>
+    </disk>
+    <interface type='network'>
+      <mac address='00:16:3e:2b:8a:9d'/>
+      <source network='default'/>
+      <model type='virtio'/>
+    </interface>
+  </devices>
+</domain>`
+
+// TestGetVMConfig tests that the domain XML is turned into the config response
+func TestGetVMConfig(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	vmID := "123e4567-e89b-12d3-a456-426614174000"
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(vmID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DomainXMLFlags(0)).Return(testConfigXML, nil).Times(1)
+	mockLibvirt.EXPECT().
+		GetBlockInfo(gomock.Any(), "vda").
+		Return(&libvirt.DomainBlockInfo{Capacity: 20 << 30}, nil). // Only the image backed disk is queried
+		Times(1)
+
+	response, err := getVMConfig(nil, vmID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, vmID, response.ID)
+	assert.Equal(t, 2, response.VCPUs)
+	assert.Equal(t, 4096, response.Memory)
+	assert.Equal(t, 2048, response.CurrentMemory)
+	assert.Equal(t, 20, response.DiskSize)
+	assert.Equal(t, []int{2, 3}, response.CPUPinning.Cores)
+	assert.Equal(t, 1000, response.IOLimits.IOPS)
+
+	assert.Len(t, response.Disks, 2)
+	assert.Equal(t, "/var/lib/libvirt/images/123e4567-e89b-12d3-a456-426614174000.qcow2", response.Disks[0].Path)
+	assert.Equal(t, "qcow2", response.Disks[0].Format)
+	assert.Equal(t, uint64(20<<30), response.Disks[0].SizeBytes)
+	assert.Equal(t, "cdrom", response.Disks[1].Device)
+
+	assert.Len(t, response.NICs, 1)
+	assert.Equal(t, "00:16:3e:2b:8a:9d", response.NICs[0].MacAddress)
+	assert.Equal(t, "default", response.NICs[0].Source)
+}
+
+// TestGetVMConfigCurrentVCPUs tests that the vCPUs in use are reported rather than the hot-plug maximum
+func TestGetVMConfigCurrentVCPUs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	vmID := "123e4567-e89b-12d3-a456-426614174000"
+	xmlDesc := strings.Replace(testConfigXML, "<vcpu placement='static'>2</vcpu>", "<vcpu placement='static' current='2'>8</vcpu>", 1)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(vmID).Return(&libvirt.Domain{}, nil)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DomainXMLFlags(0)).Return(xmlDesc, nil)
+	mockLibvirt.EXPECT().GetBlockInfo(gomock.Any(), "vda").Return(&libvirt.DomainBlockInfo{Capacity: 20 << 30}, nil)
+
+	response, err := getVMConfig(nil, vmID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, response.VCPUs)
+	assert.Equal(t, []int{2, 3}, response.CPUPinning.Cores)
+}
+
+// TestParseCPUSet tests the expansion of libvirt cpuset strings
+func TestParseCPUSet(t *testing.T) {
+	cpus, err := parseCPUSet("0-3,^2,6")
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 1, 3, 6}, cpus)
+
+	_, err = parseCPUSet("3-1")
+	assert.NotNil(t, err)
+}
+
+// TestCPUPinningFromTune tests that the pins are reported as one core per vCPU in vCPU order, and
+// kept as libvirt holds them when a vCPU may run on several cores
+func TestCPUPinningFromTune(t *testing.T) {
+	tune := &domainCPUTune{VCPUPins: []domainVCPUPin{
+		{VCPU: 1, CPUSet: "3"},
+		{VCPU: 0, CPUSet: "5"},
+		{VCPU: 2, CPUSet: "3"},
+	}}
+	pinning, pins, err := cpuPinningFromTune(tune, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{5, 3, 3}, pinning.Cores)
+	assert.Nil(t, pins)
+
+	tune.VCPUPins[2].CPUSet = "0-3,^2"
+	pinning, pins, err = cpuPinningFromTune(tune, 3)
+	assert.Nil(t, err)
+	assert.Nil(t, pinning.Cores)
+	assert.Equal(t, []VCPUPinConfig{{VCPU: 0, CPUSet: "5"}, {VCPU: 1, CPUSet: "3"}, {VCPU: 2, CPUSet: "0-3,^2"}}, pins)
+
+	// Pins missing for some vCPUs can't be sent back as cores either
+	pinning, pins, err = cpuPinningFromTune(&domainCPUTune{VCPUPins: []domainVCPUPin{{VCPU: 0, CPUSet: "1"}}}, 2)
+	assert.Nil(t, err)
+	assert.Nil(t, pinning.Cores)
+	assert.Len(t, pins, 1)
+}
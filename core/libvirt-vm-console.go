@@ -0,0 +1,204 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"libvirt.org/go/libvirt"
+)
+
+// vncListenAddress is where the VNC display of a new VM listens. Only the API connects to it,
+// clients reach it through the WebSocket proxy.
+const vncListenAddress = "127.0.0.1"
+
+// vncDialTimeout bounds the connection to the VNC display of the VM
+var vncDialTimeout = 5 * time.Second
+
+// consoleBufferSize is the largest chunk read from the console before it's sent as one message
+const consoleBufferSize = 32 * 1024
+
+// consoleStream reads and writes the serial console of a domain through a libvirt stream
+type consoleStream struct {
+	stream *libvirt.Stream
+	once   sync.Once
+}
+
+func (s *consoleStream) Read(p []byte) (int, error) {
+	n, err := s.stream.Recv(p)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from the console: %v", err)
+	}
+	// libvirt reports the end of the stream as an empty read
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (s *consoleStream) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := s.stream.Send(p[written:])
+		if err != nil {
+			return written, fmt.Errorf("failed to write to the console: %v", err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close aborts the stream, unblocking a pending Read, and frees it
+func (s *consoleStream) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.stream.Abort()
+		err = s.stream.Free()
+	})
+	return err
+}
+
+// runningDomain looks up the VM and checks that it runs, a console needs a live domain
+func runningDomain(vmID string, lq LibvirtQemu) (*libvirt.Domain, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	if state != libvirt.DOMAIN_RUNNING && state != libvirt.DOMAIN_PAUSED {
+		return nil, NewConflictError(vmID, "VM is not running")
+	}
+	return domain, nil
+}
+
+// openSerialConsole connects to the serial console of the running VM. Unless force is set a
+// console another client holds is left alone and libvirt refuses to open it.
+func openSerialConsole(vmID string, force bool, lq LibvirtQemu) (io.ReadWriteCloser, error) {
+	domain, err := runningDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlDesc, err := lq.GetXMLDesc(domain, 0)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+	if len(def.Devices.Consoles) == 0 && len(def.Devices.Serials) == 0 {
+		return nil, NewConflictError(vmID, "VM has no serial console")
+	}
+
+	flags := libvirt.DOMAIN_CONSOLE_SAFE
+	if force {
+		flags |= libvirt.DOMAIN_CONSOLE_FORCE
+	}
+	console, err := lq.OpenConsole(domain, flags)
+	if err != nil {
+		return nil, NewConflictError(vmID, fmt.Sprintf("Serial console is not available: %v", err))
+	}
+	return console, nil
+}
+
+// vncAddress returns the host address of the VNC display of the running VM, read from its live definition
+func vncAddress(vmID string, lq LibvirtQemu) (string, error) {
+	domain, err := runningDomain(vmID, lq)
+	if err != nil {
+		return "", err
+	}
+
+	xmlDesc, err := lq.GetXMLDesc(domain, 0)
+	if err != nil {
+		return "", err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return "", err
+	}
+
+	for _, graphics := range def.Devices.Graphics {
+		if graphics.Type != "vnc" {
+			continue
+		}
+		// The port is only known once QEMU allocated it
+		if graphics.Port <= 0 {
+			return "", NewConflictError(vmID, "VNC display has no port allocated")
+		}
+		host := graphics.Listen
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = vncListenAddress
+		}
+		return net.JoinHostPort(host, strconv.Itoa(graphics.Port)), nil
+	}
+	return "", NewConflictError(vmID, "VM has no VNC display")
+}
+
+// dialVNC connects to the VNC display of the VM
+func dialVNC(address string) (io.ReadWriteCloser, error) {
+	conn, err := net.DialTimeout("tcp", address, vncDialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the VNC display: %v", err)
+	}
+	return conn, nil
+}
+
+// proxyWebSocket copies the console output to the WebSocket as binary messages and the messages
+// of the client to the console, until either side closes. Both are closed when it returns.
+func proxyWebSocket(ws *websocket.Conn, console io.ReadWriteCloser) error {
+	defer ws.Close()
+	defer console.Close()
+
+	errs := make(chan error, 2)
+
+	go func() {
+		buf := make([]byte, consoleBufferSize)
+		for {
+			n, err := console.Read(buf)
+			if n > 0 {
+				if err := ws.WriteMessage(websocket.BinaryMessage, buf[:n]); err != nil {
+					errs <- err
+					return
+				}
+			}
+			if err != nil {
+				// Tell the client the console went away rather than dropping the connection
+				ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "console closed"), time.Now().Add(time.Second))
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			// Terminals send keystrokes as text, noVNC as binary, both go to the console as is
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if _, err := console.Write(data); err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	// The first side to stop ends the session, closing both unblocks the other copy
+	err := <-errs
+	if errors.Is(err, io.EOF) || websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,144 @@
+package core
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// consoleDomainXML renders a domain with the console devices createVM adds, the VNC port set as in the live XML
+func consoleDomainXML(t *testing.T, vncPort int, listen string) string {
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.addSerialConsole()
+	def.addVNCGraphics(listen)
+	def.Devices.Graphics[0].Port = vncPort
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+	return xmlDesc
+}
+
+// TestOpenSerialConsole tests that the console is opened safely and only taken over when forced
+func TestOpenSerialConsole(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(2)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil).Times(2)
+	mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(consoleDomainXML(t, 5900, vncListenAddress), nil).Times(2)
+
+	console, _ := net.Pipe()
+	gomock.InOrder(
+		mockLibvirt.EXPECT().OpenConsole(domain, libvirt.DOMAIN_CONSOLE_SAFE).Return(console, nil),
+		mockLibvirt.EXPECT().OpenConsole(domain, libvirt.DOMAIN_CONSOLE_SAFE|libvirt.DOMAIN_CONSOLE_FORCE).Return(nil, io.ErrClosedPipe),
+	)
+
+	opened, err := openSerialConsole(lifecycleVMID, false, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, console, opened)
+
+	// A console libvirt refuses to open, e.g. held by another client, is a conflict
+	_, err = openSerialConsole(lifecycleVMID, true, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Serial console is not available")
+}
+
+// TestOpenSerialConsoleNotRunning tests that a stopped VM has no console to open
+func TestOpenSerialConsoleNotRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(2)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(2)
+
+	_, err := openSerialConsole(lifecycleVMID, false, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "VM is not running")
+
+	_, err = vncAddress(lifecycleVMID, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+}
+
+// TestVNCAddress tests that the VNC display is found in the live definition
+func TestVNCAddress(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(3)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil).Times(3)
+
+	withoutGraphics, err := newDomainDef("vm", lifecycleVMID, 1024, 1).marshal()
+	assert.Nil(t, err)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(consoleDomainXML(t, 5901, vncListenAddress), nil),
+		mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(consoleDomainXML(t, 5902, "0.0.0.0"), nil),
+		mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(withoutGraphics, nil),
+	)
+
+	address, err := vncAddress(lifecycleVMID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:5901", address)
+
+	// A display listening on every address is reached over the loopback
+	address, err = vncAddress(lifecycleVMID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "127.0.0.1:5902", address)
+
+	// VMs created before the display was added have none
+	_, err = vncAddress(lifecycleVMID, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "VM has no VNC display")
+}
+
+// TestProxyWebSocket tests that the console and the WebSocket client see each other's data
+func TestProxyWebSocket(t *testing.T) {
+	console, guest := net.Pipe()
+	done := make(chan error, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := consoleUpgrader.Upgrade(w, r, nil)
+		if !assert.Nil(t, err) {
+			return
+		}
+		done <- proxyWebSocket(ws, console)
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	// Keystrokes sent as text reach the console as is
+	assert.Nil(t, client.WriteMessage(websocket.TextMessage, []byte("root\n")))
+	buf := make([]byte, 16)
+	n, err := guest.Read(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "root\n", string(buf[:n]))
+
+	// Console output comes back as a binary message
+	go guest.Write([]byte("Password: "))
+	messageType, data, err := client.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, websocket.BinaryMessage, messageType)
+	assert.Equal(t, "Password: ", string(data))
+
+	// The end of the console closes the WebSocket normally
+	guest.Close()
+	_, _, err = client.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	assert.Nil(t, <-done)
+}
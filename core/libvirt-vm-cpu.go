@@ -0,0 +1,135 @@
+package core
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// updateVMCPU changes the vCPU count and pinning of the VM. The persistent config is always
+// updated, a running VM is changed live where libvirt allows it and the response tells
+// whether a reboot is needed for the rest.
+func updateVMCPU(c *gin.Context, vmID string, request *UpdateVMCPURequest, lq LibvirtQemu) (*UpdateVMCPUResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	var hostCPUs []bool
+	if request.CPUPinning != nil {
+		if hostCPUs, err = lq.GetHostCPUMap(); err != nil {
+			return nil, err
+		}
+		if err := validateHostCores(request.CPUPinning.Cores, hostCPUs); err != nil {
+			return nil, err
+		}
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	running := state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED
+
+	// The maximum vCPU count lives in the persistent definition
+	xmlDesc, err := lq.GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	vcpus := uint(request.VCPUs)
+	rebootRequired := false
+
+	if request.VCPUs > def.VCPU.Value {
+		// Raising the maximum is a cold change, QEMU can't hotplug past the boot time limit
+		if err := lq.SetVcpusFlags(domain, vcpus, libvirt.DOMAIN_VCPU_MAXIMUM|libvirt.DOMAIN_VCPU_CONFIG); err != nil {
+			return nil, err
+		}
+		rebootRequired = running
+	}
+
+	if err := lq.SetVcpusFlags(domain, vcpus, libvirt.DOMAIN_VCPU_CONFIG); err != nil {
+		return nil, err
+	}
+
+	if running && !rebootRequired {
+		if err := lq.SetVcpusFlags(domain, vcpus, libvirt.DOMAIN_VCPU_LIVE); err != nil {
+			// Hot unplug depends on the guest cooperating, the config change still applies on reboot
+			log.Printf("Live vCPU change failed for VM %s, reboot required: %v", vmID, err)
+			rebootRequired = true
+		}
+	}
+
+	if request.CPUPinning != nil {
+		flags := libvirt.DOMAIN_AFFECT_CONFIG
+		if running && !rebootRequired {
+			flags |= libvirt.DOMAIN_AFFECT_LIVE
+		}
+
+		for vcpu, core := range request.CPUPinning.Cores {
+			cpuMap := make([]bool, len(hostCPUs))
+			cpuMap[core] = true
+			if err := lq.PinVcpuFlags(domain, uint(vcpu), cpuMap, flags); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	message := "CPU configuration successfully updated"
+	if rebootRequired {
+		message = "CPU configuration saved, reboot the VM to apply it"
+	}
+
+	return &UpdateVMCPUResponse{
+		ID:             vmID,
+		VCPUs:          request.VCPUs,
+		CPUPinning:     request.CPUPinning,
+		Status:         "updated",
+		RebootRequired: rebootRequired,
+		Message:        message,
+	}, nil
+}
+
+// validateHostCores checks that every core exists and is online in the host CPU map
+func validateHostCores(cores []int, hostCPUs []bool) error {
+	for _, core := range cores {
+		if core < 0 || core >= len(hostCPUs) {
+			return NewBadRequestError("Invalid parameter: host core %d does not exist", core)
+		}
+		if !hostCPUs[core] {
+			return NewBadRequestError("Invalid parameter: host core %d is offline", core)
+		}
+	}
+	return nil
+}
+
+// validateHost checks every core of the pinning against the host CPU map
+func (p *CPUPinning) validateHost(hostCPUs []bool) error {
+	for _, cores := range [][]int{p.Cores, p.EmulatorCores, p.IOThreadCores} {
+		if err := validateHostCores(cores, hostCPUs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cpuTune converts the pinning into the domain <cputune> element, vCPU N is pinned to the Nth core.
+// The emulator threads and the I/O thread may float over their cores.
+func (p *CPUPinning) cpuTune() *domainCPUTune {
+	tune := &domainCPUTune{}
+	for vcpu, core := range p.Cores {
+		tune.VCPUPins = append(tune.VCPUPins, domainVCPUPin{VCPU: vcpu, CPUSet: formatCPUSet([]int{core})})
+	}
+	if len(p.EmulatorCores) > 0 {
+		tune.EmulatorPin = &domainEmulatorPin{CPUSet: formatCPUSet(p.EmulatorCores)}
+	}
+	if len(p.IOThreadCores) > 0 {
+		tune.IOThreadPins = []domainIOThreadPin{{IOThread: 1, CPUSet: formatCPUSet(p.IOThreadCores)}}
+	}
+	return tune
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const testCPUXML = `<domain type='kvm'><name>vm</name><vcpu placement='static'>4</vcpu></domain>`
+
+// TestUpdateVMCPULive tests that a vCPU change within the maximum is applied live and pinned
+func TestUpdateVMCPULive(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetHostCPUMap().Return([]bool{true, true, true, true}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testCPUXML, nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), uint(2), libvirt.DOMAIN_VCPU_CONFIG).Return(nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), uint(2), libvirt.DOMAIN_VCPU_LIVE).Return(nil).Times(1)
+	mockLibvirt.EXPECT().
+		PinVcpuFlags(gomock.Any(), uint(0), []bool{false, false, true, false}, libvirt.DOMAIN_AFFECT_CONFIG|libvirt.DOMAIN_AFFECT_LIVE).
+		Return(nil).Times(1)
+	mockLibvirt.EXPECT().
+		PinVcpuFlags(gomock.Any(), uint(1), []bool{false, false, false, true}, libvirt.DOMAIN_AFFECT_CONFIG|libvirt.DOMAIN_AFFECT_LIVE).
+		Return(nil).Times(1)
+
+	request := &UpdateVMCPURequest{VCPUs: 2, CPUPinning: &CPUPinning{Cores: []int{2, 3}}}
+	response, err := updateVMCPU(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "updated", response.Status)
+	assert.False(t, response.RebootRequired)
+}
+
+// TestUpdateVMCPUAboveMaximum tests that raising the vCPU count past the maximum of a running VM needs a reboot
+func TestUpdateVMCPUAboveMaximum(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testCPUXML, nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), uint(8), libvirt.DOMAIN_VCPU_MAXIMUM|libvirt.DOMAIN_VCPU_CONFIG).Return(nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), uint(8), libvirt.DOMAIN_VCPU_CONFIG).Return(nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), gomock.Any(), libvirt.DOMAIN_VCPU_LIVE).Times(0)
+
+	response, err := updateVMCPU(nil, lifecycleVMID, &UpdateVMCPURequest{VCPUs: 8}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.True(t, response.RebootRequired)
+}
+
+// TestUpdateVMCPULiveFailure tests that a refused live change still saves the config and asks for a reboot
+func TestUpdateVMCPULiveFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testCPUXML, nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), uint(1), libvirt.DOMAIN_VCPU_CONFIG).Return(nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), uint(1), libvirt.DOMAIN_VCPU_LIVE).Return(errors.New("vcpu unplug failed")).Times(1)
+
+	response, err := updateVMCPU(nil, lifecycleVMID, &UpdateVMCPURequest{VCPUs: 1}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.True(t, response.RebootRequired)
+}
+
+// TestUpdateVMCPUUnknownCore tests that pinning to a core the host doesn't have is rejected
+func TestUpdateVMCPUUnknownCore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetHostCPUMap().Return([]bool{true, true}, nil).Times(1)
+	mockLibvirt.EXPECT().SetVcpusFlags(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	request := &UpdateVMCPURequest{VCPUs: 2, CPUPinning: &CPUPinning{Cores: []int{1, 7}}}
+	response, err := updateVMCPU(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &BadRequestError{}, err)
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// resizeVMDisk resizes the root disk of the VM. A running VM grows online through libvirt,
+// a stopped one is resized with qemu-img. Shrinking is refused unless the caller opts in,
+// and is only possible while the VM is stopped. VMs with snapshots are refused, reverting
+// would bring back the old size.
+func resizeVMDisk(c *gin.Context, vmID string, request *ResizeVMDiskRequest, lq LibvirtQemu) (*ResizeVMDiskResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	running := state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED
+
+	xmlDesc, err := lq.GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+	disk := def.rootDisk()
+	if disk == nil {
+		return nil, fmt.Errorf("VM %s has no root disk", vmID)
+	}
+
+	snapshots, err := lq.ListAllSnapshots(domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) > 0 {
+		return nil, NewConflictError(vmID, "VM has snapshots, delete them before resizing its disk")
+	}
+
+	// qemu-img works on the image file itself, which has to be the one the VM was created on
+	if !running {
+		if disk.Source == nil || disk.Source.File == "" {
+			return nil, NewConflictError(vmID, "VM must be running to resize a disk that isn't an image file")
+		}
+		if isSnapshotOverlay(vmID, disk.Source.File) {
+			return nil, NewConflictError(vmID, "VM runs on a snapshot overlay, start it to resize its disk")
+		}
+		saved, err := lq.HasManagedSaveImage(domain)
+		if err != nil {
+			return nil, err
+		}
+		if saved {
+			return nil, NewConflictError(vmID, "VM has a saved state, resume it to resize its disk")
+		}
+	}
+
+	info, err := lq.GetBlockInfo(domain, disk.Target.Dev)
+	if err != nil {
+		return nil, err
+	}
+
+	newSize := uint64(request.DiskSize) << 30
+	switch {
+	case newSize == info.Capacity:
+		return &ResizeVMDiskResponse{
+			ID:       vmID,
+			DiskSize: request.DiskSize,
+			Status:   "updated",
+			Message:  "Disk already has the requested size",
+		}, nil
+	case newSize < info.Capacity && !request.AllowShrink:
+		return nil, NewBadRequestError("Invalid parameter: disk_size %d GB is smaller than the current %d GB, set allow_shrink to shrink the disk", request.DiskSize, info.Capacity>>30)
+	case newSize < info.Capacity && running:
+		return nil, NewConflictError(vmID, "VM must be stopped to shrink its disk")
+	}
+
+	if running {
+		if err := lq.BlockResize(domain, disk.Target.Dev, newSize); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := lq.ResizeDisk(disk.Source.File, request.DiskSize, request.AllowShrink); err != nil {
+			return nil, err
+		}
+	}
+
+	return &ResizeVMDiskResponse{
+		ID:       vmID,
+		DiskSize: request.DiskSize,
+		Status:   "updated",
+		Message:  "Disk resized successfully",
+	}, nil
+}
+
+// isSnapshotOverlay tells whether the disk image is an overlay left by an external snapshot or a
+// linked clone of the VM rather than the image it was created on
+func isSnapshotOverlay(vmID, file string) bool {
+	return strings.HasPrefix(filepath.Base(file), vmID+"-snap-")
+}
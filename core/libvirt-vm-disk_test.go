@@ -0,0 +1,155 @@
+package core
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const testDiskXML = `
+<domain type='kvm'>
+  <name>vm</name>
+  <devices>
+    <disk type='file' device='disk'>
+      <driver name='qemu' type='qcow2'/>
+      <source file='/var/lib/libvirt/images/vm.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+  </devices>
+</domain>`
+
+// expectRootDisk sets up the lookups shared by every disk resize test
+func expectRootDisk(mockLibvirt *mocks.MockLibvirtQemu, state libvirt.DomainState, capacityGB uint64) {
+	expectResizeChecks(mockLibvirt, state, testDiskXML)
+	mockLibvirt.EXPECT().ListAllSnapshots(gomock.Any()).Return(nil, nil).Times(1)
+	if state == libvirt.DOMAIN_SHUTOFF {
+		mockLibvirt.EXPECT().HasManagedSaveImage(gomock.Any()).Return(false, nil).Times(1)
+	}
+	mockLibvirt.EXPECT().GetBlockInfo(gomock.Any(), "vda").Return(&libvirt.DomainBlockInfo{Capacity: capacityGB << 30}, nil).Times(1)
+}
+
+// expectResizeChecks sets up the lookups made before a disk resize is refused or goes ahead
+func expectResizeChecks(mockLibvirt *mocks.MockLibvirtQemu, state libvirt.DomainState, xmlDesc string) {
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(state, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(xmlDesc, nil).Times(1)
+}
+
+// TestResizeVMDiskOnline tests that a running VM grows its disk through BlockResize
+func TestResizeVMDiskOnline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectRootDisk(mockLibvirt, libvirt.DOMAIN_RUNNING, 20)
+	mockLibvirt.EXPECT().BlockResize(gomock.Any(), "vda", uint64(50)<<30).Return(nil).Times(1)
+	mockLibvirt.EXPECT().ResizeDisk(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := resizeVMDisk(nil, lifecycleVMID, &ResizeVMDiskRequest{DiskSize: 50}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50, response.DiskSize)
+	assert.Equal(t, "updated", response.Status)
+}
+
+// TestResizeVMDiskOffline tests that a stopped VM grows its disk with qemu-img
+func TestResizeVMDiskOffline(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectRootDisk(mockLibvirt, libvirt.DOMAIN_SHUTOFF, 20)
+	mockLibvirt.EXPECT().ResizeDisk("/var/lib/libvirt/images/vm.qcow2", 50, false).Return(nil).Times(1)
+	mockLibvirt.EXPECT().BlockResize(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := resizeVMDisk(nil, lifecycleVMID, &ResizeVMDiskRequest{DiskSize: 50}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 50, response.DiskSize)
+}
+
+// TestResizeVMDiskShrinkRefused tests that shrinking without opting in is rejected
+func TestResizeVMDiskShrinkRefused(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectRootDisk(mockLibvirt, libvirt.DOMAIN_SHUTOFF, 20)
+	mockLibvirt.EXPECT().ResizeDisk(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := resizeVMDisk(nil, lifecycleVMID, &ResizeVMDiskRequest{DiskSize: 10}, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestResizeVMDiskShrink tests that an explicit shrink of a stopped VM passes --shrink to qemu-img
+func TestResizeVMDiskShrink(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectRootDisk(mockLibvirt, libvirt.DOMAIN_SHUTOFF, 20)
+	mockLibvirt.EXPECT().ResizeDisk("/var/lib/libvirt/images/vm.qcow2", 10, true).Return(nil).Times(1)
+
+	response, err := resizeVMDisk(nil, lifecycleVMID, &ResizeVMDiskRequest{DiskSize: 10, AllowShrink: true}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 10, response.DiskSize)
+}
+
+// TestResizeVMDiskShrinkRunning tests that a running VM can't shrink its disk even when allowed
+func TestResizeVMDiskShrinkRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectRootDisk(mockLibvirt, libvirt.DOMAIN_RUNNING, 20)
+	mockLibvirt.EXPECT().BlockResize(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := resizeVMDisk(nil, lifecycleVMID, &ResizeVMDiskRequest{DiskSize: 10, AllowShrink: true}, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &ConflictError{}, err)
+}
+
+// TestResizeVMDiskRefused tests that disks qemu-img or a later revert would get wrong are not resized
+func TestResizeVMDiskRefused(t *testing.T) {
+	overlayXML := strings.Replace(testDiskXML, "/var/lib/libvirt/images/vm.qcow2", snapshotDiskPath(lifecycleVMID, "nightly", "vda"), 1)
+	blockXML := strings.Replace(testDiskXML, "<source file='/var/lib/libvirt/images/vm.qcow2'/>", "<source dev='/dev/vg0/vm'/>", 1)
+
+	tests := []struct {
+		name      string
+		xmlDesc   string
+		snapshots []*libvirt.DomainSnapshot
+		saved     bool
+		message   string
+	}{
+		{name: "snapshots", xmlDesc: testDiskXML, snapshots: []*libvirt.DomainSnapshot{{}}, message: "VM has snapshots"},
+		{name: "block device", xmlDesc: blockXML, message: "isn't an image file"},
+		{name: "snapshot overlay", xmlDesc: overlayXML, message: "snapshot overlay"},
+		{name: "managed save", xmlDesc: testDiskXML, saved: true, message: "saved state"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+
+			mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+			expectResizeChecks(mockLibvirt, libvirt.DOMAIN_SHUTOFF, tt.xmlDesc)
+			mockLibvirt.EXPECT().ListAllSnapshots(gomock.Any()).Return(tt.snapshots, nil)
+			mockLibvirt.EXPECT().HasManagedSaveImage(gomock.Any()).Return(tt.saved, nil).MaxTimes(1)
+			mockLibvirt.EXPECT().ResizeDisk(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+			response, err := resizeVMDisk(nil, lifecycleVMID, &ResizeVMDiskRequest{DiskSize: 50}, mockLibvirt)
+
+			assert.Nil(t, response)
+			assert.IsType(t, &ConflictError{}, err)
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"libvirt.org/go/libvirt"
+)
+
+// interfaceAddressSources maps the source query parameter to the libvirt lookup
+var interfaceAddressSources = map[string]libvirt.DomainInterfaceAddressesSource{
+	"lease": libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE, // DHCP leases of the libvirt networks
+	"agent": libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT, // The QEMU guest agent, also sees static addresses
+	"arp":   libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_ARP,   // The ARP table of the host, IPv4 only
+}
+
+// getVMInterfaces lists the NICs of the domain definition and fills in the addresses the source knows of.
+// NICs the source has no address for yet are listed with empty address lists.
+func getVMInterfaces(vmID, source string, lq LibvirtQemu) (*VMInterfacesResponse, error) {
+	src, ok := interfaceAddressSources[source]
+	if !ok {
+		return nil, NewBadRequestError("Invalid parameter: source must be one of lease, agent or arp")
+	}
+
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	if state != libvirt.DOMAIN_RUNNING && state != libvirt.DOMAIN_PAUSED {
+		return nil, NewConflictError(vmID, "VM is not running")
+	}
+
+	xmlDesc, err := lq.GetXMLDesc(domain, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the domain XML: %v", err)
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := lq.ListAllInterfaceAddresses(domain, src)
+	if err != nil {
+		if source == "agent" && agentUnresponsive(err) {
+			return nil, NewConflictError(vmID, "Guest agent is not responding")
+		}
+		return nil, fmt.Errorf("failed to get the interface addresses: %v", err)
+	}
+
+	// The guest agent reports the guest side names, matching is done on the MAC address
+	byMAC := make(map[string]libvirt.DomainInterface, len(addresses))
+	for _, iface := range addresses {
+		byMAC[strings.ToLower(iface.Hwaddr)] = iface
+	}
+
+	response := &VMInterfacesResponse{
+		VMID:       vmID,
+		Source:     source,
+		Interfaces: []VMInterface{},
+	}
+	for _, nic := range def.Devices.Interfaces {
+		if nic.MAC == nil {
+			continue
+		}
+
+		iface := VMInterface{
+			MacAddress: strings.ToLower(nic.MAC.Address),
+			IPv4:       []string{},
+			IPv6:       []string{},
+		}
+		if nic.Source != nil {
+			iface.Network = nic.Source.Network
+			iface.Bridge = nic.Source.Bridge
+		}
+
+		if found, ok := byMAC[iface.MacAddress]; ok {
+			iface.Name = found.Name
+			for _, addr := range found.Addrs {
+				cidr := fmt.Sprintf("%s/%d", addr.Addr, addr.Prefix)
+				if addr.Type == libvirt.IP_ADDR_TYPE_IPV6 {
+					iface.IPv6 = append(iface.IPv6, cidr)
+				} else {
+					iface.IPv4 = append(iface.IPv4, cidr)
+				}
+			}
+		}
+
+		response.Interfaces = append(response.Interfaces, iface)
+	}
+
+	return response, nil
+}
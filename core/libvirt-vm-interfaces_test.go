@@ -0,0 +1,102 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// expectInterfacesDomain makes the mock serve a running domain with a NAT and a bridged NIC
+func expectInterfacesDomain(t *testing.T, mockLibvirt *mocks.MockLibvirtQemu) {
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.addNetworkInterface(defaultNetwork, "00:16:3e:00:00:01", "virtio")
+	def.addBridgeInterface("br0", "00:16:3e:00:00:02", "virtio")
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), gomock.Any()).Return(xmlDesc, nil).Times(1)
+}
+
+// TestGetVMInterfaces tests that the lease addresses are matched to the NICs by MAC address
+func TestGetVMInterfaces(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectInterfacesDomain(t, mockLibvirt)
+	mockLibvirt.EXPECT().ListAllInterfaceAddresses(gomock.Any(), libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE).Return([]libvirt.DomainInterface{
+		{Name: "vnet0", Hwaddr: "00:16:3E:00:00:01", Addrs: []libvirt.DomainIPAddress{
+			{Type: libvirt.IP_ADDR_TYPE_IPV4, Addr: "192.168.122.10", Prefix: 24},
+			{Type: libvirt.IP_ADDR_TYPE_IPV6, Addr: "fd00::10", Prefix: 64},
+		}},
+	}, nil).Times(1)
+
+	response, err := getVMInterfaces(lifecycleVMID, "lease", mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "lease", response.Source)
+	assert.Equal(t, []VMInterface{
+		{Name: "vnet0", MacAddress: "00:16:3e:00:00:01", Network: "default", IPv4: []string{"192.168.122.10/24"}, IPv6: []string{"fd00::10/64"}},
+		{MacAddress: "00:16:3e:00:00:02", Bridge: "br0", IPv4: []string{}, IPv6: []string{}},
+	}, response.Interfaces)
+}
+
+// TestGetVMInterfacesAgent tests that the guest side interfaces without a NIC, such as loopback, are left out
+func TestGetVMInterfacesAgent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectInterfacesDomain(t, mockLibvirt)
+	mockLibvirt.EXPECT().ListAllInterfaceAddresses(gomock.Any(), libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT).Return([]libvirt.DomainInterface{
+		{Name: "lo", Hwaddr: "00:00:00:00:00:00", Addrs: []libvirt.DomainIPAddress{{Type: libvirt.IP_ADDR_TYPE_IPV4, Addr: "127.0.0.1", Prefix: 8}}},
+		{Name: "eth1", Hwaddr: "00:16:3e:00:00:02", Addrs: []libvirt.DomainIPAddress{{Type: libvirt.IP_ADDR_TYPE_IPV4, Addr: "10.0.0.5", Prefix: 16}}},
+	}, nil).Times(1)
+
+	response, err := getVMInterfaces(lifecycleVMID, "agent", mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Len(t, response.Interfaces, 2)
+	assert.Empty(t, response.Interfaces[0].IPv4)
+	assert.Equal(t, "eth1", response.Interfaces[1].Name)
+	assert.Equal(t, []string{"10.0.0.5/16"}, response.Interfaces[1].IPv4)
+}
+
+// TestGetVMInterfacesAgentUnresponsive tests that a missing guest agent is reported as a conflict
+func TestGetVMInterfacesAgentUnresponsive(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectInterfacesDomain(t, mockLibvirt)
+	mockLibvirt.EXPECT().ListAllInterfaceAddresses(gomock.Any(), libvirt.DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT).
+		Return(nil, libvirt.Error{Code: libvirt.ERR_AGENT_UNRESPONSIVE}).Times(1)
+
+	response, err := getVMInterfaces(lifecycleVMID, "agent", mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &ConflictError{}, err)
+}
+
+// TestGetVMInterfacesInvalid tests the rejection of an unknown source and of a stopped VM
+func TestGetVMInterfacesInvalid(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	_, err := getVMInterfaces(lifecycleVMID, "dns", mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().ListAllInterfaceAddresses(gomock.Any(), gomock.Any()).Times(0)
+
+	_, err = getVMInterfaces(lifecycleVMID, "arp", mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+}
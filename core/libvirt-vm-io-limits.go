@@ -0,0 +1,236 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// updateVMIOLimits replaces the I/O throttling of a disk of the VM. The persistent config is
+// always updated and a running VM is throttled live, limits left at zero are cleared.
+func updateVMIOLimits(c *gin.Context, vmID string, request *UpdateVMIOLimitsRequest, lq LibvirtQemu) (*UpdateVMIOLimitsResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlDesc, err := lq.GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	target := request.Disk
+	if target == "" {
+		disk := def.rootDisk()
+		if disk == nil {
+			return nil, fmt.Errorf("VM %s has no root disk", vmID)
+		}
+		target = disk.Target.Dev
+	} else if !def.hasDisk(target) {
+		return nil, NewResourceNotFoundError("Disk", target)
+	}
+
+	flags := libvirt.DOMAIN_AFFECT_CONFIG
+	if state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED {
+		flags |= libvirt.DOMAIN_AFFECT_LIVE
+	}
+
+	if err := lq.SetBlockIoTune(domain, target, request.IOLimits.blockIoTuneParameters(), flags); err != nil {
+		return nil, err
+	}
+
+	return &UpdateVMIOLimitsResponse{
+		ID:       vmID,
+		Disk:     target,
+		IOLimits: request.IOLimits,
+		Status:   "updated",
+		Message:  "I/O limits successfully updated",
+	}, nil
+}
+
+// validateIOLimits checks the combinations of limits QEMU accepts
+func validateIOLimits(limits *IOLimits) error {
+	// Checked in a fixed order so the same request always reports the same field
+	values := []struct {
+		name  string
+		value int
+	}{
+		{"iops", limits.IOPS},
+		{"read_iops", limits.ReadIOPS},
+		{"write_iops", limits.WriteIOPS},
+		{"total_bytes_sec", limits.TotalBytesSec},
+		{"read_bytes_sec", limits.ReadBytesSec},
+		{"write_bytes_sec", limits.WriteBytesSec},
+		{"iops_max", limits.IOPSMax},
+		{"read_iops_max", limits.ReadIOPSMax},
+		{"write_iops_max", limits.WriteIOPSMax},
+		{"total_bytes_sec_max", limits.TotalBytesSecMax},
+		{"read_bytes_sec_max", limits.ReadBytesSecMax},
+		{"write_bytes_sec_max", limits.WriteBytesSecMax},
+		{"burst_length", limits.BurstLength},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return fmt.Errorf("Invalid parameter: %s must not be negative", v.name)
+		}
+	}
+
+	if limits.IOPS > 0 && (limits.ReadIOPS > 0 || limits.WriteIOPS > 0) {
+		return fmt.Errorf("Invalid parameter: iops can't be combined with read_iops or write_iops")
+	}
+	if limits.TotalBytesSec > 0 && (limits.ReadBytesSec > 0 || limits.WriteBytesSec > 0) {
+		return fmt.Errorf("Invalid parameter: total_bytes_sec can't be combined with read_bytes_sec or write_bytes_sec")
+	}
+
+	// A burst limit only makes sense on top of the matching base limit
+	bursts := []struct {
+		name      string
+		base, max int
+	}{
+		{"iops", limits.IOPS, limits.IOPSMax},
+		{"read_iops", limits.ReadIOPS, limits.ReadIOPSMax},
+		{"write_iops", limits.WriteIOPS, limits.WriteIOPSMax},
+		{"total_bytes_sec", limits.TotalBytesSec, limits.TotalBytesSecMax},
+		{"read_bytes_sec", limits.ReadBytesSec, limits.ReadBytesSecMax},
+		{"write_bytes_sec", limits.WriteBytesSec, limits.WriteBytesSecMax},
+	}
+	hasBurst := false
+	for _, burst := range bursts {
+		if burst.max == 0 {
+			continue
+		}
+		hasBurst = true
+		if burst.base == 0 {
+			return fmt.Errorf("Invalid parameter: %s_max requires %s", burst.name, burst.name)
+		}
+		if burst.max < burst.base {
+			return fmt.Errorf("Invalid parameter: %s_max must not be lower than %s", burst.name, burst.name)
+		}
+	}
+	if limits.BurstLength > 0 && !hasBurst {
+		return fmt.Errorf("Invalid parameter: burst_length requires a burst limit")
+	}
+	return nil
+}
+
+// ioTune converts the API I/O limits into the disk <iotune> element
+func (l *IOLimits) ioTune() *domainIOTune {
+	tune := &domainIOTune{
+		TotalIOPSSec:     uint64(l.IOPS),
+		ReadIOPSSec:      uint64(l.ReadIOPS),
+		WriteIOPSSec:     uint64(l.WriteIOPS),
+		TotalBytesSec:    uint64(l.TotalBytesSec),
+		ReadBytesSec:     uint64(l.ReadBytesSec),
+		WriteBytesSec:    uint64(l.WriteBytesSec),
+		TotalIOPSSecMax:  uint64(l.IOPSMax),
+		ReadIOPSSecMax:   uint64(l.ReadIOPSMax),
+		WriteIOPSSecMax:  uint64(l.WriteIOPSMax),
+		TotalBytesSecMax: uint64(l.TotalBytesSecMax),
+		ReadBytesSecMax:  uint64(l.ReadBytesSecMax),
+		WriteBytesSecMax: uint64(l.WriteBytesSecMax),
+	}
+
+	// The burst length applies to every burst limit that is set
+	burstLength := func(max uint64) uint64 {
+		if max == 0 {
+			return 0
+		}
+		return uint64(l.BurstLength)
+	}
+	tune.TotalIOPSSecMaxLength = burstLength(tune.TotalIOPSSecMax)
+	tune.ReadIOPSSecMaxLength = burstLength(tune.ReadIOPSSecMax)
+	tune.WriteIOPSSecMaxLength = burstLength(tune.WriteIOPSSecMax)
+	tune.TotalBytesSecMaxLength = burstLength(tune.TotalBytesSecMax)
+	tune.ReadBytesSecMaxLength = burstLength(tune.ReadBytesSecMax)
+	tune.WriteBytesSecMaxLength = burstLength(tune.WriteBytesSecMax)
+	return tune
+}
+
+// blockIoTuneParameters converts the API I/O limits into the parameters of SetBlockIoTune. Every
+// limit is passed so the ones left at zero are cleared, the burst lengths only along with their limit.
+func (l *IOLimits) blockIoTuneParameters() *libvirt.DomainBlockIoTuneParameters {
+	tune := l.ioTune()
+	return &libvirt.DomainBlockIoTuneParameters{
+		TotalBytesSecSet:          true,
+		TotalBytesSec:             tune.TotalBytesSec,
+		ReadBytesSecSet:           true,
+		ReadBytesSec:              tune.ReadBytesSec,
+		WriteBytesSecSet:          true,
+		WriteBytesSec:             tune.WriteBytesSec,
+		TotalIopsSecSet:           true,
+		TotalIopsSec:              tune.TotalIOPSSec,
+		ReadIopsSecSet:            true,
+		ReadIopsSec:               tune.ReadIOPSSec,
+		WriteIopsSecSet:           true,
+		WriteIopsSec:              tune.WriteIOPSSec,
+		TotalBytesSecMaxSet:       true,
+		TotalBytesSecMax:          tune.TotalBytesSecMax,
+		ReadBytesSecMaxSet:        true,
+		ReadBytesSecMax:           tune.ReadBytesSecMax,
+		WriteBytesSecMaxSet:       true,
+		WriteBytesSecMax:          tune.WriteBytesSecMax,
+		TotalIopsSecMaxSet:        true,
+		TotalIopsSecMax:           tune.TotalIOPSSecMax,
+		ReadIopsSecMaxSet:         true,
+		ReadIopsSecMax:            tune.ReadIOPSSecMax,
+		WriteIopsSecMaxSet:        true,
+		WriteIopsSecMax:           tune.WriteIOPSSecMax,
+		TotalBytesSecMaxLengthSet: tune.TotalBytesSecMaxLength > 0,
+		TotalBytesSecMaxLength:    tune.TotalBytesSecMaxLength,
+		ReadBytesSecMaxLengthSet:  tune.ReadBytesSecMaxLength > 0,
+		ReadBytesSecMaxLength:     tune.ReadBytesSecMaxLength,
+		WriteBytesSecMaxLengthSet: tune.WriteBytesSecMaxLength > 0,
+		WriteBytesSecMaxLength:    tune.WriteBytesSecMaxLength,
+		TotalIopsSecMaxLengthSet:  tune.TotalIOPSSecMaxLength > 0,
+		TotalIopsSecMaxLength:     tune.TotalIOPSSecMaxLength,
+		ReadIopsSecMaxLengthSet:   tune.ReadIOPSSecMaxLength > 0,
+		ReadIopsSecMaxLength:      tune.ReadIOPSSecMaxLength,
+		WriteIopsSecMaxLengthSet:  tune.WriteIOPSSecMaxLength > 0,
+		WriteIopsSecMaxLength:     tune.WriteIOPSSecMaxLength,
+	}
+}
+
+// ioLimitsFromTune converts the disk <iotune> element into the API I/O limits
+func ioLimitsFromTune(tune *domainIOTune) *IOLimits {
+	if tune == nil {
+		return nil
+	}
+
+	// The API has a single burst length, report the first one libvirt has
+	burstLength := uint64(0)
+	for _, length := range []uint64{
+		tune.TotalIOPSSecMaxLength, tune.ReadIOPSSecMaxLength, tune.WriteIOPSSecMaxLength,
+		tune.TotalBytesSecMaxLength, tune.ReadBytesSecMaxLength, tune.WriteBytesSecMaxLength,
+	} {
+		if length > 0 {
+			burstLength = length
+			break
+		}
+	}
+
+	return &IOLimits{
+		IOPS:             int(tune.TotalIOPSSec),
+		ReadIOPS:         int(tune.ReadIOPSSec),
+		WriteIOPS:        int(tune.WriteIOPSSec),
+		TotalBytesSec:    int(tune.TotalBytesSec),
+		ReadBytesSec:     int(tune.ReadBytesSec),
+		WriteBytesSec:    int(tune.WriteBytesSec),
+		IOPSMax:          int(tune.TotalIOPSSecMax),
+		ReadIOPSMax:      int(tune.ReadIOPSSecMax),
+		WriteIOPSMax:     int(tune.WriteIOPSSecMax),
+		TotalBytesSecMax: int(tune.TotalBytesSecMax),
+		ReadBytesSecMax:  int(tune.ReadBytesSecMax),
+		WriteBytesSecMax: int(tune.WriteBytesSecMax),
+		BurstLength:      int(burstLength),
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const testTwoDisksXML = `
+<domain type='kvm'>
+  <name>vm</name>
+  <devices>
+    <disk type='file' device='disk'>
+      <source file='/var/lib/libvirt/images/vm.qcow2'/>
+      <target dev='vda' bus='virtio'/>
+    </disk>
+    <disk type='file' device='disk'>
+      <source file='/var/lib/libvirt/images/data.qcow2'/>
+      <target dev='vdb' bus='virtio'/>
+    </disk>
+  </devices>
+</domain>`
+
+// TestValidateIOLimits tests the combinations of limits that are rejected
+func TestValidateIOLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits IOLimits
+		valid  bool
+	}{
+		{"iops only", IOLimits{IOPS: 500}, true},
+		{"read and write", IOLimits{ReadIOPS: 100, WriteIOPS: 50, ReadBytesSec: 1 << 20}, true},
+		{"burst", IOLimits{TotalBytesSec: 1 << 20, TotalBytesSecMax: 4 << 20, BurstLength: 10}, true},
+		{"negative", IOLimits{IOPS: -1}, false},
+		{"negative burst", IOLimits{IOPS: 500, IOPSMax: -1, BurstLength: -1}, false},
+		{"total with read", IOLimits{IOPS: 500, ReadIOPS: 100}, false},
+		{"total bytes with write bytes", IOLimits{TotalBytesSec: 1 << 20, WriteBytesSec: 1 << 20}, false},
+		{"burst without base", IOLimits{ReadIOPSMax: 100}, false},
+		{"burst below base", IOLimits{IOPS: 500, IOPSMax: 100}, false},
+		{"burst length without burst", IOLimits{IOPS: 500, BurstLength: 10}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateIOLimits(&tt.limits)
+			if tt.valid {
+				assert.Nil(t, err)
+			} else {
+				assert.NotNil(t, err)
+			}
+		})
+	}
+}
+
+// TestIOLimitsRoundTrip tests that the limits read back from <iotune> match the ones rendered
+func TestIOLimitsRoundTrip(t *testing.T) {
+	limits := &IOLimits{
+		ReadIOPS:         100,
+		ReadIOPSMax:      200,
+		WriteBytesSec:    1 << 20,
+		WriteBytesSecMax: 2 << 20,
+		BurstLength:      15,
+	}
+
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.addDisk("/var/lib/libvirt/images/vm.qcow2", "vda", limits.ioTune())
+	data, err := def.marshal()
+	assert.Nil(t, err)
+
+	def, err = parseDomainXML(data)
+	assert.Nil(t, err)
+	assert.Equal(t, limits, ioLimitsFromTune(def.Devices.Disks[0].IOTune))
+}
+
+// TestUpdateVMIOLimitsLive tests that a running VM is throttled live and in its config
+func TestUpdateVMIOLimitsLive(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testDiskXML, nil).Times(1)
+	mockLibvirt.EXPECT().
+		SetBlockIoTune(gomock.Any(), "vda", gomock.Any(), libvirt.DOMAIN_AFFECT_CONFIG|libvirt.DOMAIN_AFFECT_LIVE).
+		Do(func(_ *libvirt.Domain, _ string, params *libvirt.DomainBlockIoTuneParameters, _ libvirt.DomainModificationImpact) {
+			assert.True(t, params.TotalIopsSecSet)
+			assert.Equal(t, uint64(500), params.TotalIopsSec)
+			// Limits left out are cleared
+			assert.True(t, params.ReadBytesSecSet)
+			assert.Zero(t, params.ReadBytesSec)
+			assert.False(t, params.TotalIopsSecMaxLengthSet)
+		}).
+		Return(nil).Times(1)
+
+	request := &UpdateVMIOLimitsRequest{IOLimits: &IOLimits{IOPS: 500}}
+	response, err := updateVMIOLimits(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "vda", response.Disk)
+	assert.Equal(t, "updated", response.Status)
+}
+
+// TestUpdateVMIOLimitsStopped tests that a stopped VM only gets its config updated
+func TestUpdateVMIOLimitsStopped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testTwoDisksXML, nil).Times(1)
+	mockLibvirt.EXPECT().SetBlockIoTune(gomock.Any(), "vdb", gomock.Any(), libvirt.DOMAIN_AFFECT_CONFIG).Return(nil).Times(1)
+
+	request := &UpdateVMIOLimitsRequest{Disk: "vdb", IOLimits: &IOLimits{TotalBytesSec: 1 << 20}}
+	response, err := updateVMIOLimits(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "vdb", response.Disk)
+}
+
+// TestValidateIOLimitsOrder tests that the first negative field in the documented order is always reported
+func TestValidateIOLimitsOrder(t *testing.T) {
+	limits := &IOLimits{ReadIOPS: -1, WriteBytesSec: -1, IOPSMax: -1, BurstLength: -1}
+	for i := 0; i < 20; i++ {
+		assert.EqualError(t, validateIOLimits(limits), "Invalid parameter: read_iops must not be negative")
+	}
+}
+
+// TestUpdateVMIOLimitsUnknownDisk tests that a disk the VM doesn't have is reported as not found
+func TestUpdateVMIOLimitsUnknownDisk(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testDiskXML, nil)
+	mockLibvirt.EXPECT().SetBlockIoTune(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	request := &UpdateVMIOLimitsRequest{Disk: "vdz", IOLimits: &IOLimits{IOPS: 500}}
+	_, err := updateVMIOLimits(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.IsType(t, &NotFoundError{}, err)
+	assert.Equal(t, "Disk", err.(*NotFoundError).Resource)
+}
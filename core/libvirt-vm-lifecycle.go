@@ -0,0 +1,178 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// stopPollInterval is how often the VM state is checked while waiting for a graceful shutdown
+var stopPollInterval = time.Second
+
+// lookupDomain finds the domain (VM) by UUID, translating a missing domain into a NotFoundError
+func lookupDomain(vmID string, lq LibvirtQemu) (*libvirt.Domain, error) {
+	domain, err := lq.LookupDomainByUUIDString(vmID)
+	if err != nil {
+		if er, ok := err.(libvirt.Error); ok {
+			if er.Code == libvirt.ERR_NO_DOMAIN {
+				return nil, NewNotFoundError(vmID)
+			}
+		}
+		return nil, err
+	}
+	return domain, nil
+}
+
+// startVM starts the VM, doing nothing if it's already running
+func startVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case libvirt.DOMAIN_RUNNING:
+		return &VMLifecycleResponse{
+			ID:      vmID,
+			Status:  "running",
+			Message: "VM is already running",
+		}, nil
+	case libvirt.DOMAIN_PAUSED:
+		return nil, NewConflictError(vmID, "VM is paused, resume it instead")
+	}
+
+	if err := lq.Create(domain); err != nil {
+		return nil, fmt.Errorf("failed to start the domain: %v", err)
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "running",
+		Message: "VM successfully started",
+	}, nil
+}
+
+// stopVM stops the VM. Unless force is set, the guest is asked to shut down first and
+// is destroyed only if it's still running once the timeout expires.
+func stopVM(c *gin.Context, vmID string, force bool, timeout time.Duration, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if state == libvirt.DOMAIN_SHUTOFF {
+		return &VMLifecycleResponse{
+			ID:      vmID,
+			Status:  "stopped",
+			Message: "VM is already stopped",
+		}, nil
+	}
+
+	progress := progressFromContext(c)
+
+	if !force {
+		if err := progress.Step(10, "Waiting for the guest to shut down"); err != nil {
+			return nil, err
+		}
+		stopped, err := shutdownAndWait(domain, timeout, progress, lq)
+		if err != nil {
+			return nil, err
+		}
+		if stopped {
+			return &VMLifecycleResponse{
+				ID:      vmID,
+				Status:  "stopped",
+				Message: "VM successfully stopped",
+			}, nil
+		}
+		log.Printf("Graceful shutdown timed out after %s, forcing stop for VM %s", timeout, vmID)
+	}
+
+	if err := progress.Step(90, "Forcing the VM off"); err != nil {
+		return nil, err
+	}
+	if err := lq.Destroy(domain); err != nil {
+		return nil, err
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "stopped",
+		Message: "VM forcefully stopped",
+	}, nil
+}
+
+// shutdownAndWait asks the guest to shut down and polls its state until it's off or the timeout
+// expires, it stops waiting when the operation running it is cancelled
+func shutdownAndWait(domain *libvirt.Domain, timeout time.Duration, progress *Progress, lq LibvirtQemu) (bool, error) {
+	if err := lq.Shutdown(domain); err != nil {
+		// The guest may not react to ACPI at all, let the caller force it off
+		log.Printf("Graceful shutdown failed: %v", err)
+		return false, nil
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		state, err := lq.GetState(domain)
+		if err != nil {
+			return false, err
+		}
+		if state == libvirt.DOMAIN_SHUTOFF {
+			return true, nil
+		}
+		if !time.Now().Before(deadline) {
+			return false, nil
+		}
+		if progress.Cancelled() {
+			return false, errOperationCancelled
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+// rebootVM reboots a running VM, falling back to a hard reset if the guest doesn't accept the request
+func rebootVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	if state != libvirt.DOMAIN_RUNNING {
+		return nil, NewConflictError(vmID, "VM is not running, cannot reboot")
+	}
+
+	if err := lq.Reboot(domain); err != nil {
+		log.Printf("Graceful reboot failed, forcing reboot for VM %s: %v", vmID, err)
+
+		if err := lq.Destroy(domain); err != nil {
+			return nil, err
+		}
+		if err := lq.Create(domain); err != nil {
+			return nil, fmt.Errorf("failed to start the domain after forced reboot: %v", err)
+		}
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "rebooting",
+		Message: "VM successfully rebooted",
+	}, nil
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const lifecycleVMID = "123e4567-e89b-12d3-a456-426614174000"
+
+// TestStartVM tests that a stopped VM is started
+func TestStartVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil).Times(1)
+
+	response, err := startVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, lifecycleVMID, response.ID)
+	assert.Equal(t, "running", response.Status)
+}
+
+// TestStartVMAlreadyRunning tests that starting a running VM is a no-op
+func TestStartVMAlreadyRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Times(0)
+
+	response, err := startVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "running", response.Status)
+	assert.Contains(t, response.Message, "already running")
+}
+
+// TestStartVMNotFound tests that a missing domain is reported as NotFoundError
+func TestStartVMNotFound(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().
+		LookupDomainByUUIDString(lifecycleVMID).
+		Return(nil, libvirt.Error{Code: libvirt.ERR_NO_DOMAIN}).Times(1)
+
+	response, err := startVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &NotFoundError{}, err)
+}
+
+// TestStopVMGraceful tests that a graceful stop doesn't destroy a VM that shuts down in time
+func TestStopVMGraceful(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	gomock.InOrder(
+		mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil),
+		mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil),
+		mockLibvirt.EXPECT().Shutdown(gomock.Any()).Return(nil),
+		mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil),
+	)
+	mockLibvirt.EXPECT().Destroy(gomock.Any()).Times(0)
+
+	response, err := stopVM(nil, lifecycleVMID, false, time.Second, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", response.Status)
+	assert.Equal(t, "VM successfully stopped", response.Message)
+}
+
+// TestStopVMGracefulTimeout tests that a VM still running after the timeout is destroyed
+func TestStopVMGracefulTimeout(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	defer func(interval time.Duration) { stopPollInterval = interval }(stopPollInterval)
+	stopPollInterval = time.Millisecond
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).MinTimes(2)
+	mockLibvirt.EXPECT().Shutdown(gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().Destroy(gomock.Any()).Return(nil).Times(1)
+
+	response, err := stopVM(nil, lifecycleVMID, false, 10*time.Millisecond, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", response.Status)
+	assert.Equal(t, "VM forcefully stopped", response.Message)
+}
+
+// TestStopVMForce tests that a forced stop skips the graceful shutdown
+func TestStopVMForce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().Shutdown(gomock.Any()).Times(0)
+	mockLibvirt.EXPECT().Destroy(gomock.Any()).Return(nil).Times(1)
+
+	response, err := stopVM(nil, lifecycleVMID, true, time.Second, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", response.Status)
+}
+
+// TestStopVMAlreadyStopped tests that stopping a stopped VM is a no-op
+func TestStopVMAlreadyStopped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+
+	response, err := stopVM(nil, lifecycleVMID, false, time.Second, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Contains(t, response.Message, "already stopped")
+}
+
+// TestRebootVM tests a graceful reboot of a running VM
+func TestRebootVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().Reboot(gomock.Any()).Return(nil).Times(1)
+
+	response, err := rebootVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "rebooting", response.Status)
+}
+
+// TestRebootVMForced tests that a failed graceful reboot falls back to destroy and start
+func TestRebootVMForced(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	gomock.InOrder(
+		mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil),
+		mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil),
+		mockLibvirt.EXPECT().Reboot(gomock.Any()).Return(errors.New("guest agent not responding")),
+		mockLibvirt.EXPECT().Destroy(gomock.Any()).Return(nil),
+		mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil),
+	)
+
+	response, err := rebootVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "rebooting", response.Status)
+}
+
+// TestRebootVMNotRunning tests that rebooting a stopped VM is a conflict
+func TestRebootVMNotRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().Reboot(gomock.Any()).Times(0)
+
+	response, err := rebootVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &ConflictError{}, err)
+}
+
+// TestStopVMCancelled tests that cancelling the stop operation ends the wait without forcing the VM off
+func TestStopVMCancelled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	defer func(interval time.Duration) { stopPollInterval = interval }(stopPollInterval)
+	stopPollInterval = time.Millisecond
+
+	waiting := make(chan struct{})
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().Shutdown(gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).DoAndReturn(func(*libvirt.Domain) (libvirt.DomainState, error) {
+		select {
+		case <-waiting:
+		default:
+			close(waiting)
+		}
+		return libvirt.DOMAIN_RUNNING, nil
+	}).MinTimes(1)
+	mockLibvirt.EXPECT().Destroy(gomock.Any()).Times(0)
+
+	m := NewOperationManager()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	op, err := m.Start("stop_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		c.Set("progress", progress)
+		return stopVM(c, lifecycleVMID, false, time.Minute, mockLibvirt)
+	})
+	assert.Nil(t, err)
+
+	<-waiting
+	_, err = m.Cancel(op.ID)
+	assert.Nil(t, err)
+
+	op = waitOperation(t, m, op.ID)
+	assert.Equal(t, OperationCancelled, op.Status)
+}
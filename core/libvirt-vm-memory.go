@@ -0,0 +1,140 @@
+package core
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// updateVMMemory changes the current and, optionally, the maximum memory of the VM.
+// The current memory of a running VM is changed live through the balloon driver, the
+// maximum is a cold setting and only takes effect once the VM is restarted.
+func updateVMMemory(c *gin.Context, vmID string, request *UpdateVMMemoryRequest, lq LibvirtQemu) (*UpdateVMMemoryResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	running := state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED
+
+	// The maximum memory lives in the persistent definition
+	xmlDesc, err := lq.GetXMLDesc(domain, libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+	oldMax, err := def.Memory.inMiB()
+	if err != nil {
+		return nil, err
+	}
+
+	newMax := oldMax
+	if request.MaxMemory > 0 {
+		newMax = request.MaxMemory
+	}
+	if request.Memory > newMax {
+		return nil, NewBadRequestError("Invalid parameter: memory must not exceed the maximum memory of %d MB", newMax)
+	}
+
+	// The balloon can only grow up to the maximum the VM was started with,
+	// a new maximum always waits for a restart
+	balloon := running && request.Memory <= oldMax
+	rebootRequired := running && (newMax != oldMax || !balloon)
+
+	// Only the balloon takes memory from the host now. A new maximum and the config of a stopped
+	// VM reserve nothing until the VM boots, and the free memory today says nothing about then,
+	// so they aren't checked: a VM the host can't back fails to start instead.
+	if balloon {
+		if err := checkHostFreeMemory(domain, request.Memory, lq); err != nil {
+			return nil, err
+		}
+	}
+
+	// Raise the maximum before the current memory so the config never holds current > maximum
+	if newMax > oldMax {
+		if err := setMaxMemory(domain, newMax, running, lq); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := lq.SetMemoryFlags(domain, uint64(request.Memory)<<10, libvirt.DOMAIN_MEM_CONFIG); err != nil {
+		return nil, err
+	}
+
+	if newMax < oldMax {
+		if err := setMaxMemory(domain, newMax, running, lq); err != nil {
+			return nil, err
+		}
+	}
+
+	if balloon {
+		if err := lq.SetMemoryFlags(domain, uint64(request.Memory)<<10, libvirt.DOMAIN_MEM_LIVE); err != nil {
+			// Without a balloon driver in the guest the config change only applies on reboot
+			log.Printf("Live memory change failed for VM %s, reboot required: %v", vmID, err)
+			rebootRequired = true
+		}
+	}
+
+	message := "Memory configuration successfully updated"
+	if rebootRequired {
+		message = "Memory configuration saved, reboot the VM to apply it"
+	}
+
+	return &UpdateVMMemoryResponse{
+		ID:             vmID,
+		Memory:         request.Memory,
+		MaxMemory:      newMax,
+		Status:         "updated",
+		RebootRequired: rebootRequired,
+		Message:        message,
+	}, nil
+}
+
+// setMaxMemory changes the maximum memory (in MB) in the persistent config of the VM
+func setMaxMemory(domain *libvirt.Domain, memory int, running bool, lq LibvirtQemu) error {
+	if running {
+		return lq.SetMemoryFlags(domain, uint64(memory)<<10, libvirt.DOMAIN_MEM_MAXIMUM|libvirt.DOMAIN_MEM_CONFIG)
+	}
+	return lq.SetMaxMemory(domain, uint64(memory)<<10)
+}
+
+// checkHostFreeMemory makes sure the host can back the memory a running VM is about to grow by
+func checkHostFreeMemory(domain *libvirt.Domain, memory int, lq LibvirtQemu) error {
+	xmlDesc, err := lq.GetXMLDesc(domain, 0)
+	if err != nil {
+		return err
+	}
+	def, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return err
+	}
+
+	current := def.Memory
+	if def.CurrentMemory != nil {
+		current = *def.CurrentMemory
+	}
+	currentMiB, err := current.inMiB()
+	if err != nil {
+		return err
+	}
+	if memory <= currentMiB {
+		return nil
+	}
+
+	free, err := lq.GetFreeMemory()
+	if err != nil {
+		return err
+	}
+	if growth := uint64(memory-currentMiB) << 20; growth > free {
+		return NewBadRequestError("Invalid parameter: the host has only %d MB of free memory, %d MB requested", free>>20, growth>>20)
+	}
+	return nil
+}
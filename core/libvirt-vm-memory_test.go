@@ -0,0 +1,106 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+const (
+	testMemoryInactiveXML = `<domain type='kvm'><name>vm</name><memory unit='KiB'>8388608</memory><currentMemory unit='KiB'>4194304</currentMemory></domain>`
+	testMemoryLiveXML     = `<domain type='kvm'><name>vm</name><memory unit='KiB'>8388608</memory><currentMemory unit='KiB'>4194304</currentMemory></domain>`
+)
+
+// TestUpdateVMMemoryBalloon tests that the current memory of a running VM is changed live
+func TestUpdateVMMemoryBalloon(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testMemoryInactiveXML, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DomainXMLFlags(0)).Return(testMemoryLiveXML, nil).Times(1)
+	mockLibvirt.EXPECT().GetFreeMemory().Return(uint64(16)<<30, nil).Times(1)
+	mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), uint64(6144)<<10, libvirt.DOMAIN_MEM_CONFIG).Return(nil).Times(1)
+	mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), uint64(6144)<<10, libvirt.DOMAIN_MEM_LIVE).Return(nil).Times(1)
+	mockLibvirt.EXPECT().SetMaxMemory(gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := updateVMMemory(nil, lifecycleVMID, &UpdateVMMemoryRequest{Memory: 6144}, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 6144, response.Memory)
+	assert.Equal(t, 8192, response.MaxMemory)
+	assert.False(t, response.RebootRequired)
+}
+
+// TestUpdateVMMemoryNotEnoughHostMemory tests that growing past the host free memory is rejected
+func TestUpdateVMMemoryNotEnoughHostMemory(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testMemoryInactiveXML, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DomainXMLFlags(0)).Return(testMemoryLiveXML, nil).Times(1)
+	mockLibvirt.EXPECT().GetFreeMemory().Return(uint64(1)<<30, nil).Times(1)
+	mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := updateVMMemory(nil, lifecycleVMID, &UpdateVMMemoryRequest{Memory: 8192}, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestUpdateVMMemoryMaximumRunning tests that a new maximum on a running VM is saved in the config only
+func TestUpdateVMMemoryMaximumRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testMemoryInactiveXML, nil).Times(1)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), uint64(16384)<<10, libvirt.DOMAIN_MEM_MAXIMUM|libvirt.DOMAIN_MEM_CONFIG).Return(nil),
+		mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), uint64(12288)<<10, libvirt.DOMAIN_MEM_CONFIG).Return(nil),
+	)
+	mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), gomock.Any(), libvirt.DOMAIN_MEM_LIVE).Times(0)
+
+	request := &UpdateVMMemoryRequest{Memory: 12288, MaxMemory: 16384}
+	response, err := updateVMMemory(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 16384, response.MaxMemory)
+	assert.True(t, response.RebootRequired)
+}
+
+// TestUpdateVMMemoryStopped tests that a stopped VM gets its maximum through SetMaxMemory
+func TestUpdateVMMemoryStopped(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), libvirt.DOMAIN_XML_INACTIVE).Return(testMemoryInactiveXML, nil).Times(1)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SetMemoryFlags(gomock.Any(), uint64(2048)<<10, libvirt.DOMAIN_MEM_CONFIG).Return(nil),
+		mockLibvirt.EXPECT().SetMaxMemory(gomock.Any(), uint64(4096)<<10).Return(nil),
+	)
+	mockLibvirt.EXPECT().GetFreeMemory().Times(0)
+
+	request := &UpdateVMMemoryRequest{Memory: 2048, MaxMemory: 4096}
+	response, err := updateVMMemory(nil, lifecycleVMID, request, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.False(t, response.RebootRequired)
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"net"
+)
+
+// nicModels are the NIC models a VM can be created with
+var nicModels = map[string]bool{
+	"virtio": true,
+	"e1000":  true,
+}
+
+// defaultNetworks is what a VM created without networks gets: one virtio NIC on the default NAT network
+func defaultNetworks() []NetworkAttachment {
+	return []NetworkAttachment{{Network: defaultNetwork}}
+}
+
+// validateNetworks checks the NICs of a creation request
+func validateNetworks(networks []NetworkAttachment) error {
+	macs := map[string]bool{}
+	for i, nic := range networks {
+		if (nic.Network == "") == (nic.Bridge == "") {
+			return fmt.Errorf("Invalid parameter: networks[%d] must set exactly one of network or bridge", i)
+		}
+		if nic.Model != "" && !nicModels[nic.Model] {
+			return fmt.Errorf("Invalid parameter: networks[%d].model must be virtio or e1000", i)
+		}
+		if nic.VLAN < 0 || nic.VLAN > 4094 {
+			return fmt.Errorf("Invalid parameter: networks[%d].vlan must be between 1 and 4094", i)
+		}
+
+		if nic.MacAddress != "" {
+			hw, err := net.ParseMAC(nic.MacAddress)
+			if err != nil || len(hw) != 6 {
+				return fmt.Errorf("Invalid parameter: networks[%d].mac_address is not a MAC address", i)
+			}
+			if hw[0]&1 != 0 {
+				return fmt.Errorf("Invalid parameter: networks[%d].mac_address must not be a multicast address", i)
+			}
+			mac := hw.String()
+			if macs[mac] {
+				return fmt.Errorf("Invalid parameter: networks[%d].mac_address %s is used by another NIC", i, mac)
+			}
+			macs[mac] = true
+		}
+
+		if b := nic.Bandwidth; b != nil {
+			for _, value := range []int{b.InboundAverage, b.InboundPeak, b.InboundBurst, b.OutboundAverage, b.OutboundPeak, b.OutboundBurst} {
+				if value < 0 {
+					return fmt.Errorf("Invalid parameter: networks[%d].bandwidth limits must not be negative", i)
+				}
+			}
+			if b.InboundAverage == 0 && (b.InboundPeak > 0 || b.InboundBurst > 0) {
+				return fmt.Errorf("Invalid parameter: networks[%d].bandwidth inbound_peak and inbound_burst require inbound_average", i)
+			}
+			if b.OutboundAverage == 0 && (b.OutboundPeak > 0 || b.OutboundBurst > 0) {
+				return fmt.Errorf("Invalid parameter: networks[%d].bandwidth outbound_peak and outbound_burst require outbound_average", i)
+			}
+		}
+	}
+	return nil
+}
+
+// addNIC attaches the NIC to the domain with the allocated MAC address
+func (d *domainDef) addNIC(nic NetworkAttachment, mac string) {
+	model := nic.Model
+	if model == "" {
+		model = "virtio"
+	}
+
+	var iface *domainInterface
+	if nic.Bridge != "" {
+		iface = d.addBridgeInterface(nic.Bridge, mac, model)
+	} else {
+		iface = d.addNetworkInterface(nic.Network, mac, model)
+	}
+
+	if nic.VLAN > 0 {
+		iface.VLAN = &domainVLAN{Tags: []domainVLANTag{{ID: nic.VLAN}}}
+	}
+	if nic.Bandwidth != nil {
+		iface.Bandwidth = nic.Bandwidth.domainBandwidth()
+	}
+}
+
+// domainBandwidth converts the limits to libvirt <bandwidth>, a direction without an average is left out
+func (b *NetworkBandwidth) domainBandwidth() *domainBandwidth {
+	bandwidth := &domainBandwidth{}
+	if b.InboundAverage > 0 {
+		bandwidth.Inbound = &domainBandwidthLimit{Average: b.InboundAverage, Peak: b.InboundPeak, Burst: b.InboundBurst}
+	}
+	if b.OutboundAverage > 0 {
+		bandwidth.Outbound = &domainBandwidthLimit{Average: b.OutboundAverage, Peak: b.OutboundPeak, Burst: b.OutboundBurst}
+	}
+	if bandwidth.Inbound == nil && bandwidth.Outbound == nil {
+		return nil
+	}
+	return bandwidth
+}
+
+// networkBandwidthFromDomain reads the limits back from libvirt <bandwidth>
+func networkBandwidthFromDomain(bandwidth *domainBandwidth) *NetworkBandwidth {
+	if bandwidth == nil || (bandwidth.Inbound == nil && bandwidth.Outbound == nil) {
+		return nil
+	}
+	b := &NetworkBandwidth{}
+	if in := bandwidth.Inbound; in != nil {
+		b.InboundAverage, b.InboundPeak, b.InboundBurst = in.Average, in.Peak, in.Burst
+	}
+	if out := bandwidth.Outbound; out != nil {
+		b.OutboundAverage, b.OutboundPeak, b.OutboundBurst = out.Average, out.Peak, out.Burst
+	}
+	return b
+}
+
+// requestedMACs lists the fixed MAC address of every NIC, empty for the ones to allocate
+func requestedMACs(networks []NetworkAttachment) []string {
+	macs := make([]string, len(networks))
+	for i, nic := range networks {
+		if nic.MacAddress != "" {
+			hw, _ := net.ParseMAC(nic.MacAddress)
+			macs[i] = hw.String()
+		}
+	}
+	return macs
+}
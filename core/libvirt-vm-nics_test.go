@@ -0,0 +1,111 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// TestCreateVMWithNetworks tests that every NIC of the request is rendered with its options
+func TestCreateVMWithNetworks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().ListAllDomains().Return(nil, nil).Times(1)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), false).Return(nil).Times(1)
+	mockLibvirt.EXPECT().
+		DomainDefineXML(gomock.Any()).
+		Do(func(xmlConfig string) {
+			def, err := parseDomainXML(xmlConfig)
+			assert.Nil(t, err)
+			assert.Len(t, def.Devices.Interfaces, 2)
+
+			nat := def.Devices.Interfaces[0]
+			assert.Equal(t, "network", nat.Type)
+			assert.Equal(t, "default", nat.Source.Network)
+			assert.Equal(t, "virtio", nat.Model.Type)
+			assert.Nil(t, nat.VLAN)
+			assert.Nil(t, nat.Bandwidth)
+
+			bridged := def.Devices.Interfaces[1]
+			assert.Equal(t, "bridge", bridged.Type)
+			assert.Equal(t, "br0", bridged.Source.Bridge)
+			assert.Equal(t, "e1000", bridged.Model.Type)
+			assert.Equal(t, "52:54:00:12:34:56", bridged.MAC.Address)
+			assert.Equal(t, &domainVLAN{Tags: []domainVLANTag{{ID: 100}}}, bridged.VLAN)
+			assert.Equal(t, &domainBandwidth{Inbound: &domainBandwidthLimit{Average: 1000, Peak: 2000, Burst: 512}}, bridged.Bandwidth)
+			assert.Contains(t, xmlConfig, `<inbound average="1000" peak="2000" burst="512">`)
+		}).
+		Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil).Times(1)
+
+	request := &VMCreationRequest{
+		VCPUs:     1,
+		Memory:    1024,
+		DiskSize:  10,
+		BaseImage: "/var/lib/libvirt/images/ubuntu-base.qcow2",
+		Networks: []NetworkAttachment{
+			{Network: "default"},
+			{Bridge: "br0", Model: "e1000", MacAddress: "52:54:00:12:34:56", VLAN: 100, Bandwidth: &NetworkBandwidth{InboundAverage: 1000, InboundPeak: 2000, InboundBurst: 512}},
+		},
+	}
+	assert.Nil(t, validateVMCreationRequest(request))
+
+	response, err := createVM(nil, request, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Len(t, response.MacAddresses, 2)
+	assert.Equal(t, response.MacAddresses[0], response.MacAddress)
+	assert.Equal(t, "52:54:00:12:34:56", response.MacAddresses[1])
+}
+
+// TestCreateVMMACConflict tests that a fixed MAC address used by another domain is rejected before anything is created
+func TestCreateVMMACConflict(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomainMACs(t, mockLibvirt, "52:54:00:12:34:56")
+
+	request := &VMCreationRequest{
+		VCPUs:     1,
+		Memory:    1024,
+		DiskSize:  10,
+		BaseImage: "/var/lib/libvirt/images/ubuntu-base.qcow2",
+		Networks:  []NetworkAttachment{{Network: "default", MacAddress: "52:54:00:12:34:56"}},
+	}
+
+	_, err := createVM(nil, request, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "MAC address 52:54:00:12:34:56 is already in use")
+}
+
+// TestValidateNetworks tests the validation of the NICs of a creation request
+func TestValidateNetworks(t *testing.T) {
+	tests := []struct {
+		name     string
+		networks []NetworkAttachment
+		message  string
+	}{
+		{"no source", []NetworkAttachment{{}}, "exactly one of network or bridge"},
+		{"both sources", []NetworkAttachment{{Network: "default", Bridge: "br0"}}, "exactly one of network or bridge"},
+		{"model", []NetworkAttachment{{Network: "default", Model: "rtl8139"}}, "networks[0].model"},
+		{"vlan", []NetworkAttachment{{Bridge: "br0", VLAN: 4095}}, "networks[0].vlan"},
+		{"mac", []NetworkAttachment{{Network: "default", MacAddress: "52:54:00:12:34"}}, "is not a MAC address"},
+		{"multicast mac", []NetworkAttachment{{Network: "default", MacAddress: "01:00:5e:00:00:01"}}, "multicast"},
+		{"duplicate mac", []NetworkAttachment{{Network: "default", MacAddress: "52:54:00:12:34:56"}, {Bridge: "br0", MacAddress: "52:54:00:12:34:56"}}, "networks[1].mac_address"},
+		{"negative bandwidth", []NetworkAttachment{{Network: "default", Bandwidth: &NetworkBandwidth{OutboundAverage: -1}}}, "must not be negative"},
+		{"peak without average", []NetworkAttachment{{Network: "default", Bandwidth: &NetworkBandwidth{OutboundPeak: 100}}}, "require outbound_average"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateVMCreationRequest(&VMCreationRequest{Networks: tt.networks})
+			assert.ErrorContains(t, err, "Invalid parameter: ")
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+}
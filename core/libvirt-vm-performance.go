@@ -0,0 +1,140 @@
+package core
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// performanceSampleInterval is the time between the two stats samples the rates are computed from
+var performanceSampleInterval = time.Second
+
+// performanceStatsTypes are the groups of domain statistics the performance endpoint reads
+const performanceStatsTypes = libvirt.DOMAIN_STATS_CPU_TOTAL | libvirt.DOMAIN_STATS_BALLOON |
+	libvirt.DOMAIN_STATS_VCPU | libvirt.DOMAIN_STATS_INTERFACE | libvirt.DOMAIN_STATS_BLOCK
+
+// getVMPerformance samples the stats of a running VM twice and reports the utilisation in between
+func getVMPerformance(c *gin.Context, vmID string, lq LibvirtQemu) (*VMPerformanceResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	if state != libvirt.DOMAIN_RUNNING && state != libvirt.DOMAIN_PAUSED {
+		return nil, NewConflictError(vmID, "VM is not running")
+	}
+
+	first, err := lq.GetDomainStats(domain, performanceStatsTypes)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+
+	time.Sleep(performanceSampleInterval)
+
+	second, err := lq.GetDomainStats(domain, performanceStatsTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	response := computeVMPerformance(first, second, time.Since(start))
+	response.ID = vmID
+	return response, nil
+}
+
+// computeVMPerformance turns two stats samples taken elapsed apart into the performance response
+func computeVMPerformance(first, second *libvirt.DomainStats, elapsed time.Duration) *VMPerformanceResponse {
+	seconds := elapsed.Seconds()
+	rate := func(before, after uint64) float64 {
+		// Counters restart from zero when a device is hot plugged again
+		if seconds <= 0 || after < before {
+			return 0
+		}
+		return float64(after-before) / seconds
+	}
+
+	response := &VMPerformanceResponse{
+		SampleInterval: seconds,
+		Disks:          []VMDiskPerformance{},
+		NICs:           []VMNICPerformance{},
+	}
+
+	// The CPU time is the sum over all vCPUs, so a fully busy VM uses vcpus * elapsed nanoseconds
+	if first.Cpu != nil && second.Cpu != nil && first.Cpu.TimeSet && second.Cpu.TimeSet {
+		vcpus := len(second.Vcpu)
+		if vcpus == 0 {
+			vcpus = 1
+		}
+		response.CPUUsage = rate(first.Cpu.Time, second.Cpu.Time) / float64(time.Second) / float64(vcpus) * 100
+	}
+
+	if balloon := second.Balloon; balloon != nil && balloon.CurrentSet {
+		response.MemoryTotal = int(balloon.Current >> 10)
+		switch {
+		case balloon.AvailableSet && balloon.UnusedSet:
+			// The guest reports its own usage when the balloon driver has stats enabled
+			response.MemoryUsage = int((balloon.Available - balloon.Unused) >> 10)
+		case balloon.RssSet:
+			response.MemoryUsage = int(balloon.Rss >> 10)
+		default:
+			response.MemoryUsage = response.MemoryTotal
+		}
+	}
+
+	previousBlocks := map[string]libvirt.DomainStatsBlock{}
+	for _, block := range first.Block {
+		previousBlocks[block.Name] = block
+	}
+	for _, block := range second.Block {
+		previous := previousBlocks[block.Name]
+		disk := VMDiskPerformance{
+			Target:           block.Name,
+			ReadBytes:        block.RdBytes,
+			WriteBytes:       block.WrBytes,
+			ReadOps:          block.RdReqs,
+			WriteOps:         block.WrReqs,
+			ReadBytesPerSec:  rate(previous.RdBytes, block.RdBytes),
+			WriteBytesPerSec: rate(previous.WrBytes, block.WrBytes),
+			ReadIOPS:         rate(previous.RdReqs, block.RdReqs),
+			WriteIOPS:        rate(previous.WrReqs, block.WrReqs),
+		}
+		response.Disks = append(response.Disks, disk)
+
+		response.DiskReadBytes += disk.ReadBytes
+		response.DiskWriteBytes += disk.WriteBytes
+		response.DiskReadIOPS += disk.ReadIOPS
+		response.DiskWriteIOPS += disk.WriteIOPS
+	}
+
+	previousNets := map[string]libvirt.DomainStatsNet{}
+	for _, net := range first.Net {
+		previousNets[net.Name] = net
+	}
+	for _, net := range second.Net {
+		previous := previousNets[net.Name]
+		nic := VMNICPerformance{
+			Name:            net.Name,
+			RxBytes:         net.RxBytes,
+			TxBytes:         net.TxBytes,
+			RxPackets:       net.RxPkts,
+			TxPackets:       net.TxPkts,
+			RxBytesPerSec:   rate(previous.RxBytes, net.RxBytes),
+			TxBytesPerSec:   rate(previous.TxBytes, net.TxBytes),
+			RxPacketsPerSec: rate(previous.RxPkts, net.RxPkts),
+			TxPacketsPerSec: rate(previous.TxPkts, net.TxPkts),
+		}
+		response.NICs = append(response.NICs, nic)
+
+		response.NetworkInBytes += nic.RxBytes
+		response.NetworkOutBytes += nic.TxBytes
+		response.NetworkInPackets += nic.RxPacketsPerSec
+		response.NetworkOutPackets += nic.TxPacketsPerSec
+	}
+
+	return response
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// testStatsSamples returns two stats samples of a 2 vCPU VM taken one second apart
+func testStatsSamples() (*libvirt.DomainStats, *libvirt.DomainStats) {
+	first := &libvirt.DomainStats{
+		Cpu:   &libvirt.DomainStatsCPU{TimeSet: true, Time: 10_000_000_000},
+		Vcpu:  make([]libvirt.DomainStatsVcpu, 2),
+		Block: []libvirt.DomainStatsBlock{{Name: "vda", RdBytes: 1000, WrBytes: 2000, RdReqs: 10, WrReqs: 20}},
+		Net:   []libvirt.DomainStatsNet{{Name: "vnet0", RxBytes: 500, TxBytes: 300, RxPkts: 5, TxPkts: 3}},
+	}
+	second := &libvirt.DomainStats{
+		Cpu:  &libvirt.DomainStatsCPU{TimeSet: true, Time: 11_000_000_000},
+		Vcpu: make([]libvirt.DomainStatsVcpu, 2),
+		Balloon: &libvirt.DomainStatsBalloon{
+			CurrentSet: true, Current: 4096 << 10,
+			AvailableSet: true, Available: 4000 << 10,
+			UnusedSet: true, Unused: 1000 << 10,
+		},
+		Block: []libvirt.DomainStatsBlock{{Name: "vda", RdBytes: 5000, WrBytes: 2000, RdReqs: 50, WrReqs: 25}},
+		Net:   []libvirt.DomainStatsNet{{Name: "vnet0", RxBytes: 1500, TxBytes: 800, RxPkts: 15, TxPkts: 8}},
+	}
+	return first, second
+}
+
+// TestComputeVMPerformance tests the utilisation computed from two stats samples
+func TestComputeVMPerformance(t *testing.T) {
+	first, second := testStatsSamples()
+
+	response := computeVMPerformance(first, second, time.Second)
+
+	// One second of CPU time over one second on 2 vCPUs
+	assert.InDelta(t, 50.0, response.CPUUsage, 0.001)
+	assert.Equal(t, 4096, response.MemoryTotal)
+	assert.Equal(t, 3000, response.MemoryUsage)
+
+	assert.Len(t, response.Disks, 1)
+	assert.Equal(t, "vda", response.Disks[0].Target)
+	assert.Equal(t, uint64(5000), response.Disks[0].ReadBytes)
+	assert.InDelta(t, 4000.0, response.Disks[0].ReadBytesPerSec, 0.001)
+	assert.InDelta(t, 40.0, response.Disks[0].ReadIOPS, 0.001)
+	assert.InDelta(t, 5.0, response.Disks[0].WriteIOPS, 0.001)
+	assert.Equal(t, uint64(5000), response.DiskReadBytes)
+	assert.InDelta(t, 40.0, response.DiskReadIOPS, 0.001)
+
+	assert.Len(t, response.NICs, 1)
+	assert.Equal(t, "vnet0", response.NICs[0].Name)
+	assert.InDelta(t, 1000.0, response.NICs[0].RxBytesPerSec, 0.001)
+	assert.InDelta(t, 500.0, response.NICs[0].TxBytesPerSec, 0.001)
+	assert.Equal(t, uint64(1500), response.NetworkInBytes)
+	assert.InDelta(t, 10.0, response.NetworkInPackets, 0.001)
+	assert.InDelta(t, 5.0, response.NetworkOutPackets, 0.001)
+}
+
+// TestComputeVMPerformanceCounterReset tests that a counter going backwards doesn't report a huge rate
+func TestComputeVMPerformanceCounterReset(t *testing.T) {
+	first, second := testStatsSamples()
+	first.Block[0].RdBytes = 10000
+
+	response := computeVMPerformance(first, second, time.Second)
+
+	assert.Equal(t, 0.0, response.Disks[0].ReadBytesPerSec)
+}
+
+// TestGetVMPerformance tests that two samples are taken from a running VM
+func TestGetVMPerformance(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	previousInterval := performanceSampleInterval
+	performanceSampleInterval = 0
+	defer func() { performanceSampleInterval = previousInterval }()
+
+	first, second := testStatsSamples()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().GetDomainStats(gomock.Any(), performanceStatsTypes).Return(first, nil).Times(1),
+		mockLibvirt.EXPECT().GetDomainStats(gomock.Any(), performanceStatsTypes).Return(second, nil).Times(1),
+	)
+
+	response, err := getVMPerformance(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, lifecycleVMID, response.ID)
+	assert.Equal(t, uint64(5000), response.DiskReadBytes)
+	assert.Equal(t, uint64(800), response.NetworkOutBytes)
+}
+
+// TestGetVMPerformanceNotRunning tests that a stopped VM has no performance metrics
+func TestGetVMPerformanceNotRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().GetDomainStats(gomock.Any(), gomock.Any()).Times(0)
+
+	response, err := getVMPerformance(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &ConflictError{}, err)
+}
@@ -0,0 +1,382 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// snapshotDiskPath is the overlay an external snapshot writes the changes of a disk to
+func snapshotDiskPath(vmID, name, dev string) string {
+	return fmt.Sprintf("/var/lib/libvirt/images/%s-snap-%s-%s.qcow2", vmID, name, dev)
+}
+
+// snapshotMemoryPath is where an external snapshot saves the memory state
+func snapshotMemoryPath(vmID, name string) string {
+	return fmt.Sprintf("/var/lib/libvirt/images/%s-snap-%s.mem", vmID, name)
+}
+
+// snapshotFiles lists the files external snapshots of the VM left, for the VM deletion to remove
+func snapshotFiles(vmID string) []string {
+	files, err := filepath.Glob(fmt.Sprintf("/var/lib/libvirt/images/%s-snap-*", vmID))
+	if err != nil {
+		return nil
+	}
+	return files
+}
+
+// lookupSnapshot finds the snapshot of the domain, mapping a missing one to a NotFoundError
+func lookupSnapshot(domain *libvirt.Domain, name string, lq LibvirtQemu) (*libvirt.DomainSnapshot, error) {
+	snapshot, err := lq.LookupSnapshotByName(domain, name)
+	if err != nil {
+		if er, ok := err.(libvirt.Error); ok {
+			if er.Code == libvirt.ERR_NO_DOMAIN_SNAPSHOT {
+				return nil, NewResourceNotFoundError("Snapshot", name)
+			}
+		}
+		return nil, fmt.Errorf("failed to look up the snapshot: %v", err)
+	}
+	return snapshot, nil
+}
+
+// snapshotResponse reads the snapshot definition back from libvirt
+func snapshotResponse(vmID string, snapshot *libvirt.DomainSnapshot, lq LibvirtQemu) (*SnapshotResponse, error) {
+	xmlDesc, err := lq.SnapshotGetXMLDesc(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseSnapshotXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := lq.SnapshotIsCurrent(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &SnapshotResponse{
+		VMID:        vmID,
+		Name:        def.Name,
+		Description: def.Description,
+		Type:        "internal",
+		State:       def.State,
+		Memory:      def.hasMemory(),
+		Current:     current,
+		CreatedAt:   time.Unix(def.CreationTime, 0).UTC(),
+	}
+	if def.external() {
+		response.Type = "external"
+	}
+	if def.Parent != nil {
+		response.Parent = def.Parent.Name
+	}
+	return response, nil
+}
+
+// listVMSnapshots lists the snapshots of the VM, oldest first
+func listVMSnapshots(vmID string, lq LibvirtQemu) (*ListSnapshotsResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots, err := lq.ListAllSnapshots(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListSnapshotsResponse{
+		VMID:      vmID,
+		Snapshots: make([]SnapshotResponse, 0, len(snapshots)),
+	}
+	for _, snapshot := range snapshots {
+		s, err := snapshotResponse(vmID, snapshot, lq)
+		if err != nil {
+			return nil, err
+		}
+		response.Snapshots = append(response.Snapshots, *s)
+	}
+
+	sort.SliceStable(response.Snapshots, func(i, j int) bool {
+		return response.Snapshots[i].CreatedAt.Before(response.Snapshots[j].CreatedAt)
+	})
+	return response, nil
+}
+
+// getVMSnapshot returns a snapshot of the VM
+func getVMSnapshot(vmID, name string, lq LibvirtQemu) (*SnapshotResponse, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, NewBadRequestError("Invalid snapshot name")
+	}
+
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := lookupSnapshot(domain, name, lq)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotResponse(vmID, snapshot, lq)
+}
+
+// createVMSnapshot takes a snapshot of the disks of the VM and, if asked, of its memory. Disk-only
+// snapshots of a running VM are quiesced through the guest agent when one answers.
+func createVMSnapshot(c *gin.Context, vmID string, request *SnapshotRequest, lq LibvirtQemu) (*SnapshotResponse, error) {
+	progress := progressFromContext(c)
+	if err := progress.Step(0, "Taking the snapshot"); err != nil {
+		return nil, err
+	}
+
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+	running := state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED
+
+	if _, err := lookupSnapshot(domain, request.Name, lq); err == nil {
+		return nil, snapshotExistsError(request.Name)
+	} else if _, ok := err.(*NotFoundError); !ok {
+		return nil, err
+	}
+
+	external := request.Type == "external"
+
+	// A running VM can only be snapshotted internally together with its memory, so that's the default
+	memory := running && !external
+	if request.Memory != nil {
+		memory = *request.Memory
+	}
+	if memory && !running {
+		return nil, NewConflictError(vmID, "VM is not running, there is no memory state to save")
+	}
+	if !memory && running && !external {
+		return nil, NewBadRequestError("Invalid parameter: internal snapshots of a running VM include the memory state, take an external snapshot for the disks only")
+	}
+
+	xmlDesc, err := lq.GetXMLDesc(domain, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the domain XML: %v", err)
+	}
+	domainDef, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+
+	def := newSnapshotDef(vmID, request.Name, request.Description, external, memory, domainDef)
+	snapshotXML, err := def.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	flags := libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC
+	if external && !memory {
+		flags |= libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY
+	}
+
+	if memory {
+		progress.Report(20, "Saving the disks and the memory")
+	} else {
+		progress.Report(20, "Saving the disks")
+	}
+
+	snapshot, quiesced, err := takeSnapshot(vmID, domain, state, snapshotXML, flags, lq)
+	if err != nil {
+		// A concurrent request may have taken the name since it was checked, libvirt then refuses
+		// the duplicate with an error of its own
+		if _, lookupErr := lookupSnapshot(domain, request.Name, lq); lookupErr == nil {
+			return nil, snapshotExistsError(request.Name)
+		}
+		return nil, err
+	}
+
+	progress.Report(90, "Reading the snapshot back")
+	response, err := snapshotResponse(vmID, snapshot, lq)
+	if err != nil {
+		return nil, err
+	}
+	response.Quiesced = quiesced
+	return response, nil
+}
+
+// snapshotExistsError reports a snapshot name already taken on the VM
+func snapshotExistsError(name string) error {
+	return NewResourceConflictError("Snapshot", name, fmt.Sprintf("Snapshot %s already exists", name))
+}
+
+// externalSnapshotError refuses to revert to or delete an external snapshot. The VM runs on the
+// overlays it created and libvirt can't drop them safely, they are removed with the VM.
+func externalSnapshotError(name, action string) error {
+	return NewResourceConflictError("Snapshot", name,
+		fmt.Sprintf("Snapshot %s is external and can't be %s, the VM runs on its overlay files until the VM is deleted", name, action))
+}
+
+// newSnapshotDef describes a snapshot of the writable disks of the domain and, if asked, of its memory.
+// External snapshots write to overlays next to the disk images.
+func newSnapshotDef(vmID, name, description string, external, memory bool, domainDef *domainDef) *snapshotDef {
+	def := &snapshotDef{
+		Name:        name,
+		Description: description,
+		Memory:      &snapshotMemory{Snapshot: "no"},
+		Disks:       &snapshotDisks{},
+	}
+	for _, disk := range domainDef.Devices.Disks {
+		// Read-only media such as the cloud-init seed have no changes to keep
+		if disk.Device != "disk" || disk.ReadOnly != nil || disk.Source == nil || disk.Source.File == "" {
+			def.Disks.Disks = append(def.Disks.Disks, snapshotDisk{Name: disk.Target.Dev, Snapshot: "no"})
+			continue
+		}
+		if external {
+			def.Disks.Disks = append(def.Disks.Disks, snapshotDisk{
+				Name:     disk.Target.Dev,
+				Snapshot: "external",
+				Driver:   &snapshotDiskDriver{Type: "qcow2"},
+				Source:   &domainDiskSource{File: snapshotDiskPath(vmID, name, disk.Target.Dev)},
+			})
+		} else {
+			def.Disks.Disks = append(def.Disks.Disks, snapshotDisk{Name: disk.Target.Dev, Snapshot: "internal"})
+		}
+	}
+	if memory {
+		if external {
+			def.Memory = &snapshotMemory{Snapshot: "external", File: snapshotMemoryPath(vmID, name)}
+		} else {
+			def.Memory = &snapshotMemory{Snapshot: "internal"}
+		}
+	}
+	return def
+}
+
+// takeSnapshot creates the snapshot, quiescing disk-only snapshots of a running domain when the
+// guest agent answers. It returns whether the guest filesystems were frozen.
+func takeSnapshot(vmID string, domain *libvirt.Domain, state libvirt.DomainState, snapshotXML string, flags libvirt.DomainSnapshotCreateFlags, lq LibvirtQemu) (*libvirt.DomainSnapshot, bool, error) {
+	// Freezing the guest filesystems only makes sense when the memory isn't saved with the disks
+	if state == libvirt.DOMAIN_RUNNING && flags&libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY != 0 {
+		agent := &guestAgent{vmID: vmID, domain: domain, lq: lq}
+		if agent.call("guest-ping", nil, nil) == nil {
+			snapshot, err := lq.SnapshotCreateXML(domain, snapshotXML, flags|libvirt.DOMAIN_SNAPSHOT_CREATE_QUIESCE)
+			if err == nil {
+				return snapshot, true, nil
+			}
+			// The agent may answer pings without supporting freezing, a crash consistent snapshot still helps
+			log.Printf("Quiesced snapshot of VM %s failed, retrying without quiescing: %v", vmID, err)
+		}
+	}
+
+	snapshot, err := lq.SnapshotCreateXML(domain, snapshotXML, flags)
+	if err != nil {
+		return nil, false, err
+	}
+	return snapshot, false, nil
+}
+
+// revertVMSnapshot brings the VM back to the snapshot. A running VM is reverted in place and keeps
+// running, a snapshot with memory state restores the VM as it was when taken.
+func revertVMSnapshot(vmID, name string, lq LibvirtQemu) (*SnapshotRevertResponse, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, NewBadRequestError("Invalid snapshot name")
+	}
+
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := lookupSnapshot(domain, name, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlDesc, err := lq.SnapshotGetXMLDesc(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseSnapshotXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+	if def.external() {
+		return nil, externalSnapshotError(name, "reverted to")
+	}
+
+	var flags libvirt.DomainSnapshotRevertFlags
+	if state == libvirt.DOMAIN_RUNNING || state == libvirt.DOMAIN_PAUSED {
+		// libvirt refuses to replace a running guest it can't restore in place unless forced
+		flags |= libvirt.DOMAIN_SNAPSHOT_REVERT_FORCE
+		// A snapshot without memory state would leave the VM off, boot it from the reverted disks instead
+		if !def.hasMemory() {
+			flags |= libvirt.DOMAIN_SNAPSHOT_REVERT_RUNNING
+		}
+	}
+
+	if err := lq.RevertToSnapshot(snapshot, flags); err != nil {
+		return nil, err
+	}
+
+	state, err = lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotRevertResponse{
+		VMID:    vmID,
+		Name:    name,
+		Status:  domainStatus(state, false),
+		Message: fmt.Sprintf("VM reverted to snapshot %s", name),
+	}, nil
+}
+
+// deleteVMSnapshot deletes an internal snapshot, the VM keeps its current state. The children of
+// the snapshot are kept.
+func deleteVMSnapshot(vmID, name string, lq LibvirtQemu) (*SnapshotDeletionResponse, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, NewBadRequestError("Invalid snapshot name")
+	}
+
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := lookupSnapshot(domain, name, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	xmlDesc, err := lq.SnapshotGetXMLDesc(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	def, err := parseSnapshotXML(xmlDesc)
+	if err != nil {
+		return nil, err
+	}
+	if def.external() {
+		return nil, externalSnapshotError(name, "deleted")
+	}
+
+	if err := lq.SnapshotDelete(snapshot); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotDeletionResponse{
+		VMID:    vmID,
+		Name:    name,
+		Status:  "deleted",
+		Message: fmt.Sprintf("Snapshot %s successfully deleted", name),
+	}, nil
+}
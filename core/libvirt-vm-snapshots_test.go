@@ -0,0 +1,307 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// snapshotDomainXML renders a domain with a root disk and a cloud-init seed, as createVM defines it
+func snapshotDomainXML(t *testing.T) string {
+	def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+	def.addDisk("/var/lib/libvirt/images/"+lifecycleVMID+".qcow2", "vda", nil)
+	def.addCDROM(seedImagePath(lifecycleVMID), "sda")
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+	return xmlDesc
+}
+
+// snapshotXML renders the definition libvirt returns for a snapshot
+func snapshotXML(t *testing.T, def *snapshotDef) string {
+	xmlDesc, err := def.marshal()
+	assert.Nil(t, err)
+	return xmlDesc
+}
+
+// expectNoSnapshot makes the snapshot name free
+func expectNoSnapshot(mockLibvirt *mocks.MockLibvirtQemu, domain *libvirt.Domain, name string) *gomock.Call {
+	return mockLibvirt.EXPECT().LookupSnapshotByName(domain, name).Return(nil, libvirt.Error{Code: libvirt.ERR_NO_DOMAIN_SNAPSHOT})
+}
+
+// TestCreateVMSnapshotInternal tests that a running VM is snapshotted with its memory inside the image
+func TestCreateVMSnapshotInternal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	snapshot := &libvirt.DomainSnapshot{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil)
+	expectNoSnapshot(mockLibvirt, domain, "before-upgrade")
+	mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(snapshotDomainXML(t), nil)
+
+	var created *snapshotDef
+	mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC).
+		DoAndReturn(func(_ *libvirt.Domain, xmlConfig string, _ libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+			def, err := parseSnapshotXML(xmlConfig)
+			assert.Nil(t, err)
+			created = def
+			return snapshot, nil
+		})
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(snapshot).Return(snapshotXML(t, &snapshotDef{
+		Name:         "before-upgrade",
+		State:        "running",
+		CreationTime: 1700000000,
+		Memory:       &snapshotMemory{Snapshot: "internal"},
+		Disks:        &snapshotDisks{Disks: []snapshotDisk{{Name: "vda", Snapshot: "internal"}, {Name: "sda", Snapshot: "no"}}},
+	}), nil)
+	mockLibvirt.EXPECT().SnapshotIsCurrent(snapshot).Return(true, nil)
+
+	response, err := createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "before-upgrade", Type: "internal"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "internal", response.Type)
+	assert.True(t, response.Memory)
+	assert.True(t, response.Current)
+	assert.False(t, response.Quiesced)
+	assert.Equal(t, int64(1700000000), response.CreatedAt.Unix())
+
+	assert.Equal(t, "internal", created.Memory.Snapshot)
+	assert.Equal(t, []snapshotDisk{{Name: "vda", Snapshot: "internal"}, {Name: "sda", Snapshot: "no"}}, created.Disks.Disks)
+}
+
+// TestCreateVMSnapshotExternal tests that a disk-only snapshot of a running VM writes overlays and
+// is quiesced when the guest agent answers, falling back to a plain snapshot if freezing fails
+func TestCreateVMSnapshotExternal(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	snapshot := &libvirt.DomainSnapshot{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(2)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil).Times(2)
+	expectNoSnapshot(mockLibvirt, domain, "disks")
+	expectNoSnapshot(mockLibvirt, domain, "fallback")
+	mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(snapshotDomainXML(t), nil).Times(2)
+	mockLibvirt.EXPECT().QemuAgentCommand(domain, agentCommand(t, "guest-ping", nil), agentCommandTimeout).Return(`{"return":{}}`, nil).Times(2)
+
+	diskOnly := libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC | libvirt.DOMAIN_SNAPSHOT_CREATE_DISK_ONLY
+	var created *snapshotDef
+	gomock.InOrder(
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), diskOnly|libvirt.DOMAIN_SNAPSHOT_CREATE_QUIESCE).
+			DoAndReturn(func(_ *libvirt.Domain, xmlConfig string, _ libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+				def, err := parseSnapshotXML(xmlConfig)
+				assert.Nil(t, err)
+				created = def
+				return snapshot, nil
+			}),
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), diskOnly|libvirt.DOMAIN_SNAPSHOT_CREATE_QUIESCE).
+			Return(nil, errors.New("guest agent does not support fsfreeze")),
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), diskOnly).Return(snapshot, nil),
+	)
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(snapshot).Return(snapshotXML(t, &snapshotDef{
+		Name:   "disks",
+		State:  "disk-snapshot",
+		Memory: &snapshotMemory{Snapshot: "no"},
+		Disks:  &snapshotDisks{Disks: []snapshotDisk{{Name: "vda", Snapshot: "external"}}},
+	}), nil).Times(2)
+	mockLibvirt.EXPECT().SnapshotIsCurrent(snapshot).Return(true, nil).Times(2)
+
+	response, err := createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "disks", Type: "external"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "external", response.Type)
+	assert.False(t, response.Memory)
+	assert.True(t, response.Quiesced)
+
+	assert.Equal(t, "no", created.Memory.Snapshot)
+	assert.Equal(t, snapshotDisk{
+		Name:     "vda",
+		Snapshot: "external",
+		Driver:   &snapshotDiskDriver{Type: "qcow2"},
+		Source:   &domainDiskSource{File: snapshotDiskPath(lifecycleVMID, "disks", "vda")},
+	}, created.Disks.Disks[0])
+	assert.Equal(t, "no", created.Disks.Disks[1].Snapshot)
+
+	response, err = createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "fallback", Type: "external"}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.False(t, response.Quiesced)
+}
+
+// TestCreateVMSnapshotRefused tests the snapshots that can't be taken
+func TestCreateVMSnapshotRefused(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(3)
+	gomock.InOrder(
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil),
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil),
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil),
+	)
+	mockLibvirt.EXPECT().LookupSnapshotByName(domain, "taken").Return(&libvirt.DomainSnapshot{}, nil)
+	expectNoSnapshot(mockLibvirt, domain, "memory")
+	expectNoSnapshot(mockLibvirt, domain, "disks")
+
+	_, err := createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "taken", Type: "internal"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Snapshot taken already exists")
+
+	// A stopped VM has no memory to save
+	memory := true
+	_, err = createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "memory", Type: "internal", Memory: &memory}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+
+	// libvirt can't take an internal snapshot of a running VM without its memory
+	memory = false
+	_, err = createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "disks", Type: "internal", Memory: &memory}, mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestCreateVMSnapshotConcurrent tests that a name taken by a concurrent request after the check is
+// still reported as a conflict when libvirt refuses the duplicate
+func TestCreateVMSnapshotConcurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil)
+	mockLibvirt.EXPECT().GetXMLDesc(domain, gomock.Any()).Return(snapshotDomainXML(t), nil)
+	gomock.InOrder(
+		expectNoSnapshot(mockLibvirt, domain, "nightly"),
+		mockLibvirt.EXPECT().SnapshotCreateXML(domain, gomock.Any(), libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC).
+			Return(nil, errors.New("failed to create the snapshot: domain snapshot 'nightly' already exists")),
+		mockLibvirt.EXPECT().LookupSnapshotByName(domain, "nightly").Return(&libvirt.DomainSnapshot{}, nil),
+	)
+
+	_, err := createVMSnapshot(nil, lifecycleVMID, &SnapshotRequest{Name: "nightly", Type: "internal"}, mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Snapshot nightly already exists")
+}
+
+// TestRevertVMSnapshot tests that running VMs are reverted in place and kept running
+func TestRevertVMSnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	withMemory := &libvirt.DomainSnapshot{}
+	diskOnly := &libvirt.DomainSnapshot{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(3)
+	mockLibvirt.EXPECT().LookupSnapshotByName(domain, "checkpoint").Return(withMemory, nil)
+	mockLibvirt.EXPECT().LookupSnapshotByName(domain, "disks").Return(diskOnly, nil).Times(2)
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == withMemory })).
+		Return(snapshotXML(t, &snapshotDef{Name: "checkpoint", State: "running", Memory: &snapshotMemory{Snapshot: "internal"}}), nil)
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == diskOnly })).
+		Return(snapshotXML(t, &snapshotDef{Name: "disks", State: "disk-snapshot", Memory: &snapshotMemory{Snapshot: "no"}}), nil).Times(2)
+
+	gomock.InOrder(
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil),
+		mockLibvirt.EXPECT().RevertToSnapshot(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == withMemory }), libvirt.DOMAIN_SNAPSHOT_REVERT_FORCE).Return(nil),
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil),
+
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil),
+		mockLibvirt.EXPECT().RevertToSnapshot(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == diskOnly }),
+			libvirt.DOMAIN_SNAPSHOT_REVERT_FORCE|libvirt.DOMAIN_SNAPSHOT_REVERT_RUNNING).Return(nil),
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil),
+
+		// A stopped VM stays as the snapshot left it
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil),
+		mockLibvirt.EXPECT().RevertToSnapshot(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == diskOnly }), libvirt.DomainSnapshotRevertFlags(0)).Return(nil),
+		mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_SHUTOFF, nil),
+	)
+
+	response, err := revertVMSnapshot(lifecycleVMID, "checkpoint", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "running", response.Status)
+
+	response, err = revertVMSnapshot(lifecycleVMID, "disks", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "running", response.Status)
+
+	response, err = revertVMSnapshot(lifecycleVMID, "disks", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "stopped", response.Status)
+}
+
+// TestListAndDeleteVMSnapshots tests listing the snapshots oldest first and deleting one
+func TestListAndDeleteVMSnapshots(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	older := &libvirt.DomainSnapshot{}
+	newer := &libvirt.DomainSnapshot{}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(3)
+	mockLibvirt.EXPECT().ListAllSnapshots(domain).Return([]*libvirt.DomainSnapshot{newer, older}, nil)
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == older })).
+		Return(snapshotXML(t, &snapshotDef{Name: "base", State: "shutoff", CreationTime: 100}), nil).Times(2)
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(gomock.Cond(func(s *libvirt.DomainSnapshot) bool { return s == newer })).
+		Return(snapshotXML(t, &snapshotDef{Name: "next", State: "shutoff", CreationTime: 200, Parent: &snapshotParent{Name: "base"}}), nil)
+	mockLibvirt.EXPECT().SnapshotIsCurrent(gomock.Any()).Return(false, nil).Times(2)
+
+	response, err := listVMSnapshots(lifecycleVMID, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Len(t, response.Snapshots, 2)
+	assert.Equal(t, "base", response.Snapshots[0].Name)
+	assert.Equal(t, "next", response.Snapshots[1].Name)
+	assert.Equal(t, "base", response.Snapshots[1].Parent)
+
+	mockLibvirt.EXPECT().LookupSnapshotByName(domain, "base").Return(older, nil)
+	mockLibvirt.EXPECT().SnapshotDelete(older).Return(nil)
+	deleted, err := deleteVMSnapshot(lifecycleVMID, "base", mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "deleted", deleted.Status)
+
+	expectNoSnapshot(mockLibvirt, domain, "gone")
+	_, err = deleteVMSnapshot(lifecycleVMID, "gone", mockLibvirt)
+	assert.IsType(t, &NotFoundError{}, err)
+	assert.Equal(t, "Snapshot", err.(*NotFoundError).Resource)
+
+	_, err = deleteVMSnapshot(lifecycleVMID, "../etc", mockLibvirt)
+	assert.IsType(t, &BadRequestError{}, err)
+}
+
+// TestExternalVMSnapshotRefused tests that reverting to or deleting an external snapshot is refused
+// with a conflict instead of failing in libvirt and leaving the overlays behind
+func TestExternalVMSnapshotRefused(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	domain := &libvirt.Domain{}
+	snapshot := &libvirt.DomainSnapshot{}
+	external := &snapshotDef{
+		Name:   "disks",
+		State:  "disk-snapshot",
+		Memory: &snapshotMemory{Snapshot: "no"},
+		Disks: &snapshotDisks{Disks: []snapshotDisk{{
+			Name:     "vda",
+			Snapshot: "external",
+			Source:   &domainDiskSource{File: snapshotDiskPath(lifecycleVMID, "disks", "vda")},
+		}}},
+	}
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(domain, nil).Times(2)
+	mockLibvirt.EXPECT().LookupSnapshotByName(domain, "disks").Return(snapshot, nil).Times(2)
+	mockLibvirt.EXPECT().GetState(domain).Return(libvirt.DOMAIN_RUNNING, nil)
+	mockLibvirt.EXPECT().SnapshotGetXMLDesc(snapshot).Return(snapshotXML(t, external), nil).Times(2)
+	// Neither RevertToSnapshot nor SnapshotDelete may be called
+
+	_, err := revertVMSnapshot(lifecycleVMID, "disks", mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Snapshot disks is external and can't be reverted to")
+
+	_, err = deleteVMSnapshot(lifecycleVMID, "disks", mockLibvirt)
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, "Snapshot disks is external and can't be deleted")
+}
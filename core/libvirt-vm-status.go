@@ -31,19 +31,17 @@ func getVMstatus(c *gin.Context, vmID string, lq LibvirtQemu) (*GetVMStatusRespo
 		return nil, err
 	}
 
-	// Convert the state to a string (e.g., "running", "stopped")
-	var status string
-	switch state {
-	case libvirt.DOMAIN_RUNNING:
-		status = "running"
-	case libvirt.DOMAIN_SHUTOFF:
-		status = "stopped"
-	case libvirt.DOMAIN_PAUSED:
-		status = "paused"
-	default:
-		status = "unknown"
+	// A stopped VM with a managed save image resumes from it on the next start
+	saved := false
+	if state == libvirt.DOMAIN_SHUTOFF {
+		if saved, err = lq.HasManagedSaveImage(domain); err != nil {
+			return nil, err
+		}
 	}
 
+	// Convert the state to a string (e.g., "running", "stopped")
+	status := domainStatus(state, saved)
+
 	// Prepare the response
 	return &GetVMStatusResponse{
 		VMID:    vmID,
@@ -52,3 +50,20 @@ func getVMstatus(c *gin.Context, vmID string, lq LibvirtQemu) (*GetVMStatusRespo
 		Message: fmt.Sprintf("VM %s is %s", name, status),
 	}, nil
 }
+
+// domainStatus converts a libvirt domain state into the status reported by the API
+func domainStatus(state libvirt.DomainState, managedSaved bool) string {
+	switch state {
+	case libvirt.DOMAIN_RUNNING:
+		return "running"
+	case libvirt.DOMAIN_SHUTOFF:
+		if managedSaved {
+			return "saved"
+		}
+		return "stopped"
+	case libvirt.DOMAIN_PAUSED:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}
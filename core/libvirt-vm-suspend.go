@@ -0,0 +1,164 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"libvirt.org/go/libvirt"
+)
+
+// pauseVM freezes a running VM, its memory stays resident on the host
+func pauseVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case libvirt.DOMAIN_PAUSED:
+		return &VMLifecycleResponse{
+			ID:      vmID,
+			Status:  "paused",
+			Message: "VM is already paused",
+		}, nil
+	case libvirt.DOMAIN_RUNNING:
+	default:
+		return nil, NewConflictError(vmID, "VM is not running, cannot pause")
+	}
+
+	if err := lq.Suspend(domain); err != nil {
+		return nil, err
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "paused",
+		Message: "VM successfully paused",
+	}, nil
+}
+
+// resumeVM unfreezes a paused VM
+func resumeVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case libvirt.DOMAIN_RUNNING:
+		return &VMLifecycleResponse{
+			ID:      vmID,
+			Status:  "running",
+			Message: "VM is already running",
+		}, nil
+	case libvirt.DOMAIN_PAUSED:
+	default:
+		return nil, NewConflictError(vmID, "VM is not paused, cannot resume")
+	}
+
+	if err := lq.Resume(domain); err != nil {
+		return nil, err
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "running",
+		Message: "VM successfully resumed",
+	}, nil
+}
+
+// saveVM writes the memory of a running or paused VM to a managed save image and stops it
+func saveVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case libvirt.DOMAIN_RUNNING, libvirt.DOMAIN_PAUSED:
+	case libvirt.DOMAIN_SHUTOFF:
+		saved, err := lq.HasManagedSaveImage(domain)
+		if err != nil {
+			return nil, err
+		}
+		if saved {
+			return &VMLifecycleResponse{
+				ID:      vmID,
+				Status:  "saved",
+				Message: "VM is already saved",
+			}, nil
+		}
+		return nil, NewConflictError(vmID, "VM is not running, cannot save")
+	default:
+		return nil, NewConflictError(vmID, "VM is not running, cannot save")
+	}
+
+	if err := lq.ManagedSave(domain); err != nil {
+		return nil, err
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "saved",
+		Message: "VM successfully saved to disk",
+	}, nil
+}
+
+// restoreVM starts a VM from its managed save image
+func restoreVM(c *gin.Context, vmID string, lq LibvirtQemu) (*VMLifecycleResponse, error) {
+	domain, err := lookupDomain(vmID, lq)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := lq.GetState(domain)
+	if err != nil {
+		return nil, err
+	}
+
+	switch state {
+	case libvirt.DOMAIN_RUNNING:
+		return &VMLifecycleResponse{
+			ID:      vmID,
+			Status:  "running",
+			Message: "VM is already running",
+		}, nil
+	case libvirt.DOMAIN_SHUTOFF:
+	default:
+		return nil, NewConflictError(vmID, "VM is not saved, cannot restore")
+	}
+
+	saved, err := lq.HasManagedSaveImage(domain)
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		return nil, NewConflictError(vmID, "VM has no saved state to restore")
+	}
+
+	// Starting a domain with a managed save image resumes it from that image
+	if err := lq.Create(domain); err != nil {
+		return nil, fmt.Errorf("failed to restore the domain: %v", err)
+	}
+
+	return &VMLifecycleResponse{
+		ID:      vmID,
+		Status:  "running",
+		Message: "VM successfully restored",
+	}, nil
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// TestPauseVM tests that a running VM is suspended
+func TestPauseVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().Suspend(gomock.Any()).Return(nil).Times(1)
+
+	response, err := pauseVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "paused", response.Status)
+}
+
+// TestPauseVMNotRunning tests that pausing a stopped VM is a conflict
+func TestPauseVMNotRunning(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().Suspend(gomock.Any()).Times(0)
+
+	response, err := pauseVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &ConflictError{}, err)
+}
+
+// TestResumeVM tests that a paused VM is resumed
+func TestResumeVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_PAUSED, nil).Times(1)
+	mockLibvirt.EXPECT().Resume(gomock.Any()).Return(nil).Times(1)
+
+	response, err := resumeVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "running", response.Status)
+}
+
+// TestSaveVM tests that a running VM is saved to a managed save image
+func TestSaveVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_RUNNING, nil).Times(1)
+	mockLibvirt.EXPECT().ManagedSave(gomock.Any()).Return(nil).Times(1)
+
+	response, err := saveVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "saved", response.Status)
+}
+
+// TestRestoreVM tests that a saved VM is started from its managed save image
+func TestRestoreVM(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().HasManagedSaveImage(gomock.Any()).Return(true, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Return(nil).Times(1)
+
+	response, err := restoreVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "running", response.Status)
+}
+
+// TestRestoreVMWithoutSaveImage tests that restoring a VM that was never saved is a conflict
+func TestRestoreVMWithoutSaveImage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+
+	mockLibvirt.EXPECT().LookupDomainByUUIDString(lifecycleVMID).Return(&libvirt.Domain{}, nil).Times(1)
+	mockLibvirt.EXPECT().GetState(gomock.Any()).Return(libvirt.DOMAIN_SHUTOFF, nil).Times(1)
+	mockLibvirt.EXPECT().HasManagedSaveImage(gomock.Any()).Return(false, nil).Times(1)
+	mockLibvirt.EXPECT().Create(gomock.Any()).Times(0)
+
+	response, err := restoreVM(nil, lifecycleVMID, mockLibvirt)
+
+	assert.Nil(t, response)
+	assert.IsType(t, &ConflictError{}, err)
+}
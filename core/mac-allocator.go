@@ -0,0 +1,209 @@
+package core
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultMACPrefix     = "00:16:3e"
+	defaultMACRangeStart = "00:00:00"
+	defaultMACRangeEnd   = "ff:ff:ff"
+)
+
+// MACAllocator hands out the MAC addresses of new NICs. Addresses are drawn from a
+// configured prefix and range, skipping the ones used by any libvirt domain, and are
+// reserved in the store so they are never handed out twice, even across restarts.
+type MACAllocator struct {
+	prefix string // The first three octets, e.g. "00:16:3e"
+	start  uint32 // The first allocatable value of the last three octets
+	end    uint32 // The last allocatable value of the last three octets
+	store  Store  // Where the reservations are kept, nil only checks libvirt
+
+	// Serializes the libvirt check and the reservation of concurrent creations
+	mu sync.Mutex
+}
+
+// NewMACAllocator validates the configured prefix and range and returns an allocator reserving in store
+func NewMACAllocator(config MACConfig, store Store) (*MACAllocator, error) {
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = defaultMACPrefix
+	}
+	prefixBytes, err := parseMACOctets(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC prefix: %v", err)
+	}
+	// A NIC can't have a multicast address, the least significant bit of the first octet must be clear
+	if prefixBytes&(1<<16) != 0 {
+		return nil, fmt.Errorf("invalid MAC prefix %s: multicast addresses can't be assigned to a NIC", prefix)
+	}
+
+	rangeStart, rangeEnd := config.RangeStart, config.RangeEnd
+	if rangeStart == "" {
+		rangeStart = defaultMACRangeStart
+	}
+	if rangeEnd == "" {
+		rangeEnd = defaultMACRangeEnd
+	}
+	start, err := parseMACOctets(rangeStart)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC range start: %v", err)
+	}
+	end, err := parseMACOctets(rangeEnd)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MAC range end: %v", err)
+	}
+	if start > end {
+		return nil, fmt.Errorf("invalid MAC range: %s is after %s", rangeStart, rangeEnd)
+	}
+
+	return &MACAllocator{
+		prefix: strings.ToLower(prefix),
+		start:  start,
+		end:    end,
+		store:  store,
+	}, nil
+}
+
+// Allocate reserves a MAC address for each NIC of the VM. A fixed address in requested is
+// reserved as is and fails with a ConflictError when taken, an empty entry gets a free one.
+// The search starts at a random point of the range so allocations don't all contend for the
+// lowest free address. Addresses reserved before a failure are left for Release.
+func (a *MACAllocator) Allocate(vmID string, requested []string, lq LibvirtQemu) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	used, err := domainMACs(lq)
+	if err != nil {
+		return nil, err
+	}
+
+	macs := make([]string, len(requested))
+	for i, mac := range requested {
+		if mac == "" {
+			continue
+		}
+		reserved, err := a.reserve(mac, vmID, used)
+		if err != nil {
+			return nil, err
+		}
+		if !reserved {
+			return nil, NewConflictError(vmID, fmt.Sprintf("MAC address %s is already in use", mac))
+		}
+		macs[i] = mac
+	}
+
+	size := uint64(a.end-a.start) + 1
+	offset := rand.Uint64N(size)
+	next := uint64(0)
+	for i := range macs {
+		if macs[i] != "" {
+			continue
+		}
+		for ; next < size && macs[i] == ""; next++ {
+			mac := a.format(a.start + uint32((offset+next)%size))
+			reserved, err := a.reserve(mac, vmID, used)
+			if err != nil {
+				return nil, err
+			}
+			if reserved {
+				macs[i] = mac
+			}
+		}
+		if macs[i] == "" {
+			return nil, fmt.Errorf("no free MAC address left in %s", a.format(a.start)+"-"+a.format(a.end))
+		}
+	}
+	return macs, nil
+}
+
+// reserve claims the address for the VM unless a domain or another VM uses it
+func (a *MACAllocator) reserve(mac, vmID string, used map[string]bool) (bool, error) {
+	if used[mac] {
+		return false, nil
+	}
+	if a.store != nil {
+		reserved, err := a.store.ReserveMAC(mac, vmID)
+		if err != nil || !reserved {
+			return false, err
+		}
+	}
+	used[mac] = true
+	return true, nil
+}
+
+// Release frees the MAC addresses reserved for the VM
+func (a *MACAllocator) Release(vmID string) error {
+	if a.store == nil {
+		return nil
+	}
+	return a.store.ReleaseMACs(vmID)
+}
+
+func (a *MACAllocator) format(suffix uint32) string {
+	return fmt.Sprintf("%s:%02x:%02x:%02x", a.prefix, byte(suffix>>16), byte(suffix>>8), byte(suffix))
+}
+
+// parseMACOctets parses three colon separated octets such as "00:16:3e" into a number
+func parseMACOctets(octets string) (uint32, error) {
+	// Padding to a full address reuses the standard parser
+	hw, err := net.ParseMAC(octets + ":00:00:00")
+	if err != nil {
+		return 0, fmt.Errorf("%q is not three octets such as 00:16:3e", octets)
+	}
+	return uint32(hw[0])<<16 | uint32(hw[1])<<8 | uint32(hw[2]), nil
+}
+
+// domainMACs returns the MAC addresses of the NICs of every libvirt domain, including
+// domains not created through the API
+func domainMACs(lq LibvirtQemu) (map[string]bool, error) {
+	domains, err := lq.ListAllDomains()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the domains: %v", err)
+	}
+
+	used := map[string]bool{}
+	for _, domain := range domains {
+		xmlDesc, err := lq.GetXMLDesc(domain, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the domain XML: %v", err)
+		}
+		def, err := parseDomainXML(xmlDesc)
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range def.Devices.Interfaces {
+			if iface.MAC != nil {
+				used[strings.ToLower(iface.MAC.Address)] = true
+			}
+		}
+	}
+	return used, nil
+}
+
+// MACAllocatorMiddleware makes the MAC allocator available to the handlers
+func MACAllocatorMiddleware(macs *MACAllocator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("macs", macs)
+		c.Next()
+	}
+}
+
+// macAllocatorFromContext returns the allocator of the request, or nil when the server runs without one
+func macAllocatorFromContext(c *gin.Context) *MACAllocator {
+	if c == nil {
+		return nil
+	}
+	m, ok := c.Get("macs")
+	if !ok {
+		return nil
+	}
+	macs, _ := m.(*MACAllocator)
+	return macs
+}
@@ -0,0 +1,138 @@
+package core
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+	"libvirt.org/go/libvirt"
+)
+
+// expectDomainMACs makes the mock serve one domain per MAC address
+func expectDomainMACs(t *testing.T, mockLibvirt *mocks.MockLibvirtQemu, macs ...string) {
+	domains := make([]*libvirt.Domain, 0, len(macs))
+	byDomain := map[*libvirt.Domain]string{}
+	for _, mac := range macs {
+		def := newDomainDef("vm", lifecycleVMID, 1024, 1)
+		def.addNetworkInterface(defaultNetwork, mac, "virtio")
+		xmlDesc, err := def.marshal()
+		assert.Nil(t, err)
+
+		domain := &libvirt.Domain{}
+		domains = append(domains, domain)
+		byDomain[domain] = xmlDesc
+	}
+
+	mockLibvirt.EXPECT().ListAllDomains().Return(domains, nil).AnyTimes()
+	mockLibvirt.EXPECT().GetXMLDesc(gomock.Any(), gomock.Any()).DoAndReturn(func(domain *libvirt.Domain, _ libvirt.DomainXMLFlags) (string, error) {
+		return byDomain[domain], nil
+	}).AnyTimes()
+}
+
+// TestNewMACAllocator tests the validation of the configured prefix and range
+func TestNewMACAllocator(t *testing.T) {
+	macs, err := NewMACAllocator(MACConfig{}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "00:16:3e:00:00:00", macs.format(macs.start))
+	assert.Equal(t, "00:16:3e:ff:ff:ff", macs.format(macs.end))
+
+	macs, err = NewMACAllocator(MACConfig{Prefix: "52:54:00", RangeStart: "10:00:00", RangeEnd: "10:00:ff"}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "52:54:00:10:00:00", macs.format(macs.start))
+
+	_, err = NewMACAllocator(MACConfig{Prefix: "52:54"}, nil)
+	assert.ErrorContains(t, err, "invalid MAC prefix")
+
+	_, err = NewMACAllocator(MACConfig{Prefix: "01:00:5e"}, nil)
+	assert.ErrorContains(t, err, "multicast")
+
+	_, err = NewMACAllocator(MACConfig{RangeStart: "00:01:00", RangeEnd: "00:00:ff"}, nil)
+	assert.ErrorContains(t, err, "is after")
+}
+
+// TestMACAllocatorAllocate tests that addresses used by libvirt or reserved in the store are skipped
+func TestMACAllocatorAllocate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomainMACs(t, mockLibvirt, "00:16:3E:00:00:01")
+
+	store := newTestBoltStore(t)
+	reserved, err := store.ReserveMAC("00:16:3e:00:00:02", "other-vm")
+	assert.Nil(t, err)
+	assert.True(t, reserved)
+
+	macs, err := NewMACAllocator(MACConfig{RangeStart: "00:00:01", RangeEnd: "00:00:03"}, store)
+	assert.Nil(t, err)
+
+	allocated, err := macs.Allocate(lifecycleVMID, []string{""}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00:16:3e:00:00:03"}, allocated)
+
+	// The range is exhausted until the VM releases its address
+	_, err = macs.Allocate("another-vm", []string{""}, mockLibvirt)
+	assert.ErrorContains(t, err, "no free MAC address left in 00:16:3e:00:00:01-00:16:3e:00:00:03")
+
+	assert.Nil(t, macs.Release(lifecycleVMID))
+	allocated, err = macs.Allocate("another-vm", []string{""}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00:16:3e:00:00:03"}, allocated)
+}
+
+// TestMACAllocatorAllocateFixed tests that fixed addresses are reserved as is, outside the range too
+func TestMACAllocatorAllocateFixed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomainMACs(t, mockLibvirt, "52:54:00:00:00:01")
+
+	store := newTestBoltStore(t)
+	macs, err := NewMACAllocator(MACConfig{RangeStart: "00:00:01", RangeEnd: "00:00:02"}, store)
+	assert.Nil(t, err)
+
+	// The automatic addresses of one VM never repeat
+	allocated, err := macs.Allocate(lifecycleVMID, []string{"", "52:54:00:00:00:02", ""}, mockLibvirt)
+	assert.Nil(t, err)
+	assert.Equal(t, "52:54:00:00:00:02", allocated[1])
+	assert.ElementsMatch(t, []string{"00:16:3e:00:00:01", "00:16:3e:00:00:02"}, []string{allocated[0], allocated[2]})
+
+	// An address used by a domain or reserved by another VM is a conflict
+	for _, mac := range []string{"52:54:00:00:00:01", "52:54:00:00:00:02"} {
+		_, err = macs.Allocate("another-vm", []string{mac}, mockLibvirt)
+		assert.IsType(t, &ConflictError{}, err)
+		assert.ErrorContains(t, err, mac)
+	}
+}
+
+// TestCreateVMReleasesMAC tests that a failed creation gives its MAC address back
+func TestCreateVMReleasesMAC(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomainMACs(t, mockLibvirt)
+	mockLibvirt.EXPECT().CloneAndResizeDisk(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+	mockLibvirt.EXPECT().DomainDefineXML(gomock.Any()).Return(nil, errors.New("invalid XML")).Times(1)
+	mockLibvirt.EXPECT().RemoveDisk(gomock.Any()).Return(nil).Times(1)
+
+	// A single address range makes a leaked reservation visible
+	store := newTestBoltStore(t)
+	macs, err := NewMACAllocator(MACConfig{RangeStart: "00:00:01", RangeEnd: "00:00:01"}, store)
+	assert.Nil(t, err)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set("macs", macs)
+
+	_, err = createVM(c, rollbackRequest, mockLibvirt)
+	assert.ErrorContains(t, err, "invalid XML")
+
+	reserved, err := store.ReserveMAC("00:16:3e:00:00:01", "another-vm")
+	assert.Nil(t, err)
+	assert.True(t, reserved)
+}
@@ -10,6 +10,7 @@
 package mocks
 
 import (
+	io "io"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
@@ -40,6 +41,48 @@ func (m *MockLibvirtQemu) EXPECT() *MockLibvirtQemuMockRecorder {
 	return m.recorder
 }
 
+// BlockCommit mocks base method.
+func (m *MockLibvirtQemu) BlockCommit(domain *libvirt.Domain, disk string, flags libvirt.DomainBlockCommitFlags) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BlockCommit", domain, disk, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BlockCommit indicates an expected call of BlockCommit.
+func (mr *MockLibvirtQemuMockRecorder) BlockCommit(domain, disk, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockCommit", reflect.TypeOf((*MockLibvirtQemu)(nil).BlockCommit), domain, disk, flags)
+}
+
+// BlockJobAbort mocks base method.
+func (m *MockLibvirtQemu) BlockJobAbort(domain *libvirt.Domain, disk string, flags libvirt.DomainBlockJobAbortFlags) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BlockJobAbort", domain, disk, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BlockJobAbort indicates an expected call of BlockJobAbort.
+func (mr *MockLibvirtQemuMockRecorder) BlockJobAbort(domain, disk, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockJobAbort", reflect.TypeOf((*MockLibvirtQemu)(nil).BlockJobAbort), domain, disk, flags)
+}
+
+// BlockResize mocks base method.
+func (m *MockLibvirtQemu) BlockResize(domain *libvirt.Domain, disk string, sizeBytes uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BlockResize", domain, disk, sizeBytes)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// BlockResize indicates an expected call of BlockResize.
+func (mr *MockLibvirtQemuMockRecorder) BlockResize(domain, disk, sizeBytes any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BlockResize", reflect.TypeOf((*MockLibvirtQemu)(nil).BlockResize), domain, disk, sizeBytes)
+}
+
 // CloneAndResizeDisk mocks base method.
 func (m *MockLibvirtQemu) CloneAndResizeDisk(baseImage, newDiskPath string, diskSizeGB int, shrink bool) error {
 	m.ctrl.T.Helper()
@@ -54,6 +97,20 @@ func (mr *MockLibvirtQemuMockRecorder) CloneAndResizeDisk(baseImage, newDiskPath
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CloneAndResizeDisk", reflect.TypeOf((*MockLibvirtQemu)(nil).CloneAndResizeDisk), baseImage, newDiskPath, diskSizeGB, shrink)
 }
 
+// CopyDisk mocks base method.
+func (m *MockLibvirtQemu) CopyDisk(sourcePath, diskPath string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CopyDisk", sourcePath, diskPath)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CopyDisk indicates an expected call of CopyDisk.
+func (mr *MockLibvirtQemuMockRecorder) CopyDisk(sourcePath, diskPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CopyDisk", reflect.TypeOf((*MockLibvirtQemu)(nil).CopyDisk), sourcePath, diskPath)
+}
+
 // Create mocks base method.
 func (m *MockLibvirtQemu) Create(domain *libvirt.Domain) error {
 	m.ctrl.T.Helper()
@@ -68,6 +125,34 @@ func (mr *MockLibvirtQemuMockRecorder) Create(domain any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockLibvirtQemu)(nil).Create), domain)
 }
 
+// CreateOverlay mocks base method.
+func (m *MockLibvirtQemu) CreateOverlay(backingFile, diskPath string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateOverlay", backingFile, diskPath)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateOverlay indicates an expected call of CreateOverlay.
+func (mr *MockLibvirtQemuMockRecorder) CreateOverlay(backingFile, diskPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateOverlay", reflect.TypeOf((*MockLibvirtQemu)(nil).CreateOverlay), backingFile, diskPath)
+}
+
+// CreateSeedImage mocks base method.
+func (m *MockLibvirtQemu) CreateSeedImage(path string, files map[string][]byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSeedImage", path, files)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSeedImage indicates an expected call of CreateSeedImage.
+func (mr *MockLibvirtQemuMockRecorder) CreateSeedImage(path, files any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSeedImage", reflect.TypeOf((*MockLibvirtQemu)(nil).CreateSeedImage), path, files)
+}
+
 // Destroy mocks base method.
 func (m *MockLibvirtQemu) Destroy(domain *libvirt.Domain) error {
 	m.ctrl.T.Helper()
@@ -97,6 +182,96 @@ func (mr *MockLibvirtQemuMockRecorder) DomainDefineXML(xmlConfig any) *gomock.Ca
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DomainDefineXML", reflect.TypeOf((*MockLibvirtQemu)(nil).DomainDefineXML), xmlConfig)
 }
 
+// GetBlockInfo mocks base method.
+func (m *MockLibvirtQemu) GetBlockInfo(domain *libvirt.Domain, disk string) (*libvirt.DomainBlockInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBlockInfo", domain, disk)
+	ret0, _ := ret[0].(*libvirt.DomainBlockInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBlockInfo indicates an expected call of GetBlockInfo.
+func (mr *MockLibvirtQemuMockRecorder) GetBlockInfo(domain, disk any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBlockInfo", reflect.TypeOf((*MockLibvirtQemu)(nil).GetBlockInfo), domain, disk)
+}
+
+// GetBlockJobInfo mocks base method.
+func (m *MockLibvirtQemu) GetBlockJobInfo(domain *libvirt.Domain, disk string) (*libvirt.DomainBlockJobInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetBlockJobInfo", domain, disk)
+	ret0, _ := ret[0].(*libvirt.DomainBlockJobInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetBlockJobInfo indicates an expected call of GetBlockJobInfo.
+func (mr *MockLibvirtQemuMockRecorder) GetBlockJobInfo(domain, disk any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBlockJobInfo", reflect.TypeOf((*MockLibvirtQemu)(nil).GetBlockJobInfo), domain, disk)
+}
+
+// GetDomainStats mocks base method.
+func (m *MockLibvirtQemu) GetDomainStats(domain *libvirt.Domain, statsTypes libvirt.DomainStatsTypes) (*libvirt.DomainStats, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetDomainStats", domain, statsTypes)
+	ret0, _ := ret[0].(*libvirt.DomainStats)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDomainStats indicates an expected call of GetDomainStats.
+func (mr *MockLibvirtQemuMockRecorder) GetDomainStats(domain, statsTypes any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDomainStats", reflect.TypeOf((*MockLibvirtQemu)(nil).GetDomainStats), domain, statsTypes)
+}
+
+// GetFreeMemory mocks base method.
+func (m *MockLibvirtQemu) GetFreeMemory() (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetFreeMemory")
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetFreeMemory indicates an expected call of GetFreeMemory.
+func (mr *MockLibvirtQemuMockRecorder) GetFreeMemory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetFreeMemory", reflect.TypeOf((*MockLibvirtQemu)(nil).GetFreeMemory))
+}
+
+// GetHostCPUMap mocks base method.
+func (m *MockLibvirtQemu) GetHostCPUMap() ([]bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetHostCPUMap")
+	ret0, _ := ret[0].([]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHostCPUMap indicates an expected call of GetHostCPUMap.
+func (mr *MockLibvirtQemuMockRecorder) GetHostCPUMap() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHostCPUMap", reflect.TypeOf((*MockLibvirtQemu)(nil).GetHostCPUMap))
+}
+
+// GetMetadata mocks base method.
+func (m *MockLibvirtQemu) GetMetadata(domain *libvirt.Domain, namespace string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetMetadata", domain, namespace)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMetadata indicates an expected call of GetMetadata.
+func (mr *MockLibvirtQemuMockRecorder) GetMetadata(domain, namespace any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMetadata", reflect.TypeOf((*MockLibvirtQemu)(nil).GetMetadata), domain, namespace)
+}
+
 // GetName mocks base method.
 func (m *MockLibvirtQemu) GetName(domain *libvirt.Domain) (string, error) {
 	m.ctrl.T.Helper()
@@ -127,6 +302,126 @@ func (mr *MockLibvirtQemuMockRecorder) GetState(domain any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetState", reflect.TypeOf((*MockLibvirtQemu)(nil).GetState), domain)
 }
 
+// GetUUIDString mocks base method.
+func (m *MockLibvirtQemu) GetUUIDString(domain *libvirt.Domain) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUUIDString", domain)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUUIDString indicates an expected call of GetUUIDString.
+func (mr *MockLibvirtQemuMockRecorder) GetUUIDString(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUUIDString", reflect.TypeOf((*MockLibvirtQemu)(nil).GetUUIDString), domain)
+}
+
+// GetXMLDesc mocks base method.
+func (m *MockLibvirtQemu) GetXMLDesc(domain *libvirt.Domain, flags libvirt.DomainXMLFlags) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetXMLDesc", domain, flags)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetXMLDesc indicates an expected call of GetXMLDesc.
+func (mr *MockLibvirtQemuMockRecorder) GetXMLDesc(domain, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetXMLDesc", reflect.TypeOf((*MockLibvirtQemu)(nil).GetXMLDesc), domain, flags)
+}
+
+// HasManagedSaveImage mocks base method.
+func (m *MockLibvirtQemu) HasManagedSaveImage(domain *libvirt.Domain) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "HasManagedSaveImage", domain)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// HasManagedSaveImage indicates an expected call of HasManagedSaveImage.
+func (mr *MockLibvirtQemuMockRecorder) HasManagedSaveImage(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "HasManagedSaveImage", reflect.TypeOf((*MockLibvirtQemu)(nil).HasManagedSaveImage), domain)
+}
+
+// ImageInfo mocks base method.
+func (m *MockLibvirtQemu) ImageInfo(path string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageInfo", path)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageInfo indicates an expected call of ImageInfo.
+func (mr *MockLibvirtQemuMockRecorder) ImageInfo(path any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageInfo", reflect.TypeOf((*MockLibvirtQemu)(nil).ImageInfo), path)
+}
+
+// ListAllDomains mocks base method.
+func (m *MockLibvirtQemu) ListAllDomains() ([]*libvirt.Domain, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAllDomains")
+	ret0, _ := ret[0].([]*libvirt.Domain)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAllDomains indicates an expected call of ListAllDomains.
+func (mr *MockLibvirtQemuMockRecorder) ListAllDomains() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllDomains", reflect.TypeOf((*MockLibvirtQemu)(nil).ListAllDomains))
+}
+
+// ListAllInterfaceAddresses mocks base method.
+func (m *MockLibvirtQemu) ListAllInterfaceAddresses(domain *libvirt.Domain, source libvirt.DomainInterfaceAddressesSource) ([]libvirt.DomainInterface, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAllInterfaceAddresses", domain, source)
+	ret0, _ := ret[0].([]libvirt.DomainInterface)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAllInterfaceAddresses indicates an expected call of ListAllInterfaceAddresses.
+func (mr *MockLibvirtQemuMockRecorder) ListAllInterfaceAddresses(domain, source any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllInterfaceAddresses", reflect.TypeOf((*MockLibvirtQemu)(nil).ListAllInterfaceAddresses), domain, source)
+}
+
+// ListAllNetworks mocks base method.
+func (m *MockLibvirtQemu) ListAllNetworks() ([]*libvirt.Network, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAllNetworks")
+	ret0, _ := ret[0].([]*libvirt.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAllNetworks indicates an expected call of ListAllNetworks.
+func (mr *MockLibvirtQemuMockRecorder) ListAllNetworks() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllNetworks", reflect.TypeOf((*MockLibvirtQemu)(nil).ListAllNetworks))
+}
+
+// ListAllSnapshots mocks base method.
+func (m *MockLibvirtQemu) ListAllSnapshots(domain *libvirt.Domain) ([]*libvirt.DomainSnapshot, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAllSnapshots", domain)
+	ret0, _ := ret[0].([]*libvirt.DomainSnapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAllSnapshots indicates an expected call of ListAllSnapshots.
+func (mr *MockLibvirtQemuMockRecorder) ListAllSnapshots(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAllSnapshots", reflect.TypeOf((*MockLibvirtQemu)(nil).ListAllSnapshots), domain)
+}
+
 // LookupDomainByUUIDString mocks base method.
 func (m *MockLibvirtQemu) LookupDomainByUUIDString(uuid string) (*libvirt.Domain, error) {
 	m.ctrl.T.Helper()
@@ -142,18 +437,362 @@ func (mr *MockLibvirtQemuMockRecorder) LookupDomainByUUIDString(uuid any) *gomoc
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupDomainByUUIDString", reflect.TypeOf((*MockLibvirtQemu)(nil).LookupDomainByUUIDString), uuid)
 }
 
-// NewConnect mocks base method.
-func (m *MockLibvirtQemu) NewConnect(uri string) error {
+// LookupNetworkByName mocks base method.
+func (m *MockLibvirtQemu) LookupNetworkByName(name string) (*libvirt.Network, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupNetworkByName", name)
+	ret0, _ := ret[0].(*libvirt.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupNetworkByName indicates an expected call of LookupNetworkByName.
+func (mr *MockLibvirtQemuMockRecorder) LookupNetworkByName(name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupNetworkByName", reflect.TypeOf((*MockLibvirtQemu)(nil).LookupNetworkByName), name)
+}
+
+// LookupSnapshotByName mocks base method.
+func (m *MockLibvirtQemu) LookupSnapshotByName(domain *libvirt.Domain, name string) (*libvirt.DomainSnapshot, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LookupSnapshotByName", domain, name)
+	ret0, _ := ret[0].(*libvirt.DomainSnapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LookupSnapshotByName indicates an expected call of LookupSnapshotByName.
+func (mr *MockLibvirtQemuMockRecorder) LookupSnapshotByName(domain, name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LookupSnapshotByName", reflect.TypeOf((*MockLibvirtQemu)(nil).LookupSnapshotByName), domain, name)
+}
+
+// ManagedSave mocks base method.
+func (m *MockLibvirtQemu) ManagedSave(domain *libvirt.Domain) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ManagedSave", domain)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ManagedSave indicates an expected call of ManagedSave.
+func (mr *MockLibvirtQemuMockRecorder) ManagedSave(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ManagedSave", reflect.TypeOf((*MockLibvirtQemu)(nil).ManagedSave), domain)
+}
+
+// ManagedSaveRemove mocks base method.
+func (m *MockLibvirtQemu) ManagedSaveRemove(domain *libvirt.Domain) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "NewConnect", uri)
+	ret := m.ctrl.Call(m, "ManagedSaveRemove", domain)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// NewConnect indicates an expected call of NewConnect.
-func (mr *MockLibvirtQemuMockRecorder) NewConnect(uri any) *gomock.Call {
+// ManagedSaveRemove indicates an expected call of ManagedSaveRemove.
+func (mr *MockLibvirtQemuMockRecorder) ManagedSaveRemove(domain any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NewConnect", reflect.TypeOf((*MockLibvirtQemu)(nil).NewConnect), uri)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ManagedSaveRemove", reflect.TypeOf((*MockLibvirtQemu)(nil).ManagedSaveRemove), domain)
+}
+
+// NetworkCreate mocks base method.
+func (m *MockLibvirtQemu) NetworkCreate(network *libvirt.Network) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkCreate", network)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NetworkCreate indicates an expected call of NetworkCreate.
+func (mr *MockLibvirtQemuMockRecorder) NetworkCreate(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkCreate", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkCreate), network)
+}
+
+// NetworkDefineXML mocks base method.
+func (m *MockLibvirtQemu) NetworkDefineXML(xmlConfig string) (*libvirt.Network, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkDefineXML", xmlConfig)
+	ret0, _ := ret[0].(*libvirt.Network)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NetworkDefineXML indicates an expected call of NetworkDefineXML.
+func (mr *MockLibvirtQemuMockRecorder) NetworkDefineXML(xmlConfig any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkDefineXML", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkDefineXML), xmlConfig)
+}
+
+// NetworkDestroy mocks base method.
+func (m *MockLibvirtQemu) NetworkDestroy(network *libvirt.Network) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkDestroy", network)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NetworkDestroy indicates an expected call of NetworkDestroy.
+func (mr *MockLibvirtQemuMockRecorder) NetworkDestroy(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkDestroy", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkDestroy), network)
+}
+
+// NetworkGetAutostart mocks base method.
+func (m *MockLibvirtQemu) NetworkGetAutostart(network *libvirt.Network) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkGetAutostart", network)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NetworkGetAutostart indicates an expected call of NetworkGetAutostart.
+func (mr *MockLibvirtQemuMockRecorder) NetworkGetAutostart(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkGetAutostart", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkGetAutostart), network)
+}
+
+// NetworkGetXMLDesc mocks base method.
+func (m *MockLibvirtQemu) NetworkGetXMLDesc(network *libvirt.Network) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkGetXMLDesc", network)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NetworkGetXMLDesc indicates an expected call of NetworkGetXMLDesc.
+func (mr *MockLibvirtQemuMockRecorder) NetworkGetXMLDesc(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkGetXMLDesc", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkGetXMLDesc), network)
+}
+
+// NetworkIsActive mocks base method.
+func (m *MockLibvirtQemu) NetworkIsActive(network *libvirt.Network) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkIsActive", network)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// NetworkIsActive indicates an expected call of NetworkIsActive.
+func (mr *MockLibvirtQemuMockRecorder) NetworkIsActive(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkIsActive", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkIsActive), network)
+}
+
+// NetworkSetAutostart mocks base method.
+func (m *MockLibvirtQemu) NetworkSetAutostart(network *libvirt.Network, autostart bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkSetAutostart", network, autostart)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NetworkSetAutostart indicates an expected call of NetworkSetAutostart.
+func (mr *MockLibvirtQemuMockRecorder) NetworkSetAutostart(network, autostart any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkSetAutostart", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkSetAutostart), network, autostart)
+}
+
+// NetworkUndefine mocks base method.
+func (m *MockLibvirtQemu) NetworkUndefine(network *libvirt.Network) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkUndefine", network)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NetworkUndefine indicates an expected call of NetworkUndefine.
+func (mr *MockLibvirtQemuMockRecorder) NetworkUndefine(network any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkUndefine", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkUndefine), network)
+}
+
+// NetworkUpdate mocks base method.
+func (m *MockLibvirtQemu) NetworkUpdate(network *libvirt.Network, cmd libvirt.NetworkUpdateCommand, section libvirt.NetworkUpdateSection, xmlConfig string, flags libvirt.NetworkUpdateFlags) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "NetworkUpdate", network, cmd, section, xmlConfig, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// NetworkUpdate indicates an expected call of NetworkUpdate.
+func (mr *MockLibvirtQemuMockRecorder) NetworkUpdate(network, cmd, section, xmlConfig, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "NetworkUpdate", reflect.TypeOf((*MockLibvirtQemu)(nil).NetworkUpdate), network, cmd, section, xmlConfig, flags)
+}
+
+// OpenConsole mocks base method.
+func (m *MockLibvirtQemu) OpenConsole(domain *libvirt.Domain, flags libvirt.DomainConsoleFlags) (io.ReadWriteCloser, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "OpenConsole", domain, flags)
+	ret0, _ := ret[0].(io.ReadWriteCloser)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// OpenConsole indicates an expected call of OpenConsole.
+func (mr *MockLibvirtQemuMockRecorder) OpenConsole(domain, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OpenConsole", reflect.TypeOf((*MockLibvirtQemu)(nil).OpenConsole), domain, flags)
+}
+
+// PinVcpuFlags mocks base method.
+func (m *MockLibvirtQemu) PinVcpuFlags(domain *libvirt.Domain, vcpu uint, cpuMap []bool, flags libvirt.DomainModificationImpact) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PinVcpuFlags", domain, vcpu, cpuMap, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PinVcpuFlags indicates an expected call of PinVcpuFlags.
+func (mr *MockLibvirtQemuMockRecorder) PinVcpuFlags(domain, vcpu, cpuMap, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PinVcpuFlags", reflect.TypeOf((*MockLibvirtQemu)(nil).PinVcpuFlags), domain, vcpu, cpuMap, flags)
+}
+
+// QemuAgentCommand mocks base method.
+func (m *MockLibvirtQemu) QemuAgentCommand(domain *libvirt.Domain, command string, timeout libvirt.DomainQemuAgentCommandTimeout) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QemuAgentCommand", domain, command, timeout)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QemuAgentCommand indicates an expected call of QemuAgentCommand.
+func (mr *MockLibvirtQemuMockRecorder) QemuAgentCommand(domain, command, timeout any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QemuAgentCommand", reflect.TypeOf((*MockLibvirtQemu)(nil).QemuAgentCommand), domain, command, timeout)
+}
+
+// Reboot mocks base method.
+func (m *MockLibvirtQemu) Reboot(domain *libvirt.Domain) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reboot", domain)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reboot indicates an expected call of Reboot.
+func (mr *MockLibvirtQemuMockRecorder) Reboot(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reboot", reflect.TypeOf((*MockLibvirtQemu)(nil).Reboot), domain)
+}
+
+// RemoveDisk mocks base method.
+func (m *MockLibvirtQemu) RemoveDisk(diskPath string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveDisk", diskPath)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveDisk indicates an expected call of RemoveDisk.
+func (mr *MockLibvirtQemuMockRecorder) RemoveDisk(diskPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveDisk", reflect.TypeOf((*MockLibvirtQemu)(nil).RemoveDisk), diskPath)
+}
+
+// ResizeDisk mocks base method.
+func (m *MockLibvirtQemu) ResizeDisk(diskPath string, diskSizeGB int, shrink bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResizeDisk", diskPath, diskSizeGB, shrink)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResizeDisk indicates an expected call of ResizeDisk.
+func (mr *MockLibvirtQemuMockRecorder) ResizeDisk(diskPath, diskSizeGB, shrink any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResizeDisk", reflect.TypeOf((*MockLibvirtQemu)(nil).ResizeDisk), diskPath, diskSizeGB, shrink)
+}
+
+// Resume mocks base method.
+func (m *MockLibvirtQemu) Resume(domain *libvirt.Domain) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Resume", domain)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Resume indicates an expected call of Resume.
+func (mr *MockLibvirtQemuMockRecorder) Resume(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Resume", reflect.TypeOf((*MockLibvirtQemu)(nil).Resume), domain)
+}
+
+// RevertToSnapshot mocks base method.
+func (m *MockLibvirtQemu) RevertToSnapshot(snapshot *libvirt.DomainSnapshot, flags libvirt.DomainSnapshotRevertFlags) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevertToSnapshot", snapshot, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RevertToSnapshot indicates an expected call of RevertToSnapshot.
+func (mr *MockLibvirtQemuMockRecorder) RevertToSnapshot(snapshot, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevertToSnapshot", reflect.TypeOf((*MockLibvirtQemu)(nil).RevertToSnapshot), snapshot, flags)
+}
+
+// SetBlockIoTune mocks base method.
+func (m *MockLibvirtQemu) SetBlockIoTune(domain *libvirt.Domain, disk string, params *libvirt.DomainBlockIoTuneParameters, flags libvirt.DomainModificationImpact) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetBlockIoTune", domain, disk, params, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetBlockIoTune indicates an expected call of SetBlockIoTune.
+func (mr *MockLibvirtQemuMockRecorder) SetBlockIoTune(domain, disk, params, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetBlockIoTune", reflect.TypeOf((*MockLibvirtQemu)(nil).SetBlockIoTune), domain, disk, params, flags)
+}
+
+// SetMaxMemory mocks base method.
+func (m *MockLibvirtQemu) SetMaxMemory(domain *libvirt.Domain, memoryKiB uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMaxMemory", domain, memoryKiB)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMaxMemory indicates an expected call of SetMaxMemory.
+func (mr *MockLibvirtQemuMockRecorder) SetMaxMemory(domain, memoryKiB any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMaxMemory", reflect.TypeOf((*MockLibvirtQemu)(nil).SetMaxMemory), domain, memoryKiB)
+}
+
+// SetMemoryFlags mocks base method.
+func (m *MockLibvirtQemu) SetMemoryFlags(domain *libvirt.Domain, memoryKiB uint64, flags libvirt.DomainMemoryModFlags) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetMemoryFlags", domain, memoryKiB, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetMemoryFlags indicates an expected call of SetMemoryFlags.
+func (mr *MockLibvirtQemuMockRecorder) SetMemoryFlags(domain, memoryKiB, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetMemoryFlags", reflect.TypeOf((*MockLibvirtQemu)(nil).SetMemoryFlags), domain, memoryKiB, flags)
+}
+
+// SetVcpusFlags mocks base method.
+func (m *MockLibvirtQemu) SetVcpusFlags(domain *libvirt.Domain, vcpus uint, flags libvirt.DomainVcpuFlags) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetVcpusFlags", domain, vcpus, flags)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetVcpusFlags indicates an expected call of SetVcpusFlags.
+func (mr *MockLibvirtQemuMockRecorder) SetVcpusFlags(domain, vcpus, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetVcpusFlags", reflect.TypeOf((*MockLibvirtQemu)(nil).SetVcpusFlags), domain, vcpus, flags)
 }
 
 // Shutdown mocks base method.
@@ -170,6 +809,79 @@ func (mr *MockLibvirtQemuMockRecorder) Shutdown(domain any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Shutdown", reflect.TypeOf((*MockLibvirtQemu)(nil).Shutdown), domain)
 }
 
+// SnapshotCreateXML mocks base method.
+func (m *MockLibvirtQemu) SnapshotCreateXML(domain *libvirt.Domain, xmlConfig string, flags libvirt.DomainSnapshotCreateFlags) (*libvirt.DomainSnapshot, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SnapshotCreateXML", domain, xmlConfig, flags)
+	ret0, _ := ret[0].(*libvirt.DomainSnapshot)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SnapshotCreateXML indicates an expected call of SnapshotCreateXML.
+func (mr *MockLibvirtQemuMockRecorder) SnapshotCreateXML(domain, xmlConfig, flags any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SnapshotCreateXML", reflect.TypeOf((*MockLibvirtQemu)(nil).SnapshotCreateXML), domain, xmlConfig, flags)
+}
+
+// SnapshotDelete mocks base method.
+func (m *MockLibvirtQemu) SnapshotDelete(snapshot *libvirt.DomainSnapshot) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SnapshotDelete", snapshot)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SnapshotDelete indicates an expected call of SnapshotDelete.
+func (mr *MockLibvirtQemuMockRecorder) SnapshotDelete(snapshot any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SnapshotDelete", reflect.TypeOf((*MockLibvirtQemu)(nil).SnapshotDelete), snapshot)
+}
+
+// SnapshotGetXMLDesc mocks base method.
+func (m *MockLibvirtQemu) SnapshotGetXMLDesc(snapshot *libvirt.DomainSnapshot) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SnapshotGetXMLDesc", snapshot)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SnapshotGetXMLDesc indicates an expected call of SnapshotGetXMLDesc.
+func (mr *MockLibvirtQemuMockRecorder) SnapshotGetXMLDesc(snapshot any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SnapshotGetXMLDesc", reflect.TypeOf((*MockLibvirtQemu)(nil).SnapshotGetXMLDesc), snapshot)
+}
+
+// SnapshotIsCurrent mocks base method.
+func (m *MockLibvirtQemu) SnapshotIsCurrent(snapshot *libvirt.DomainSnapshot) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SnapshotIsCurrent", snapshot)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SnapshotIsCurrent indicates an expected call of SnapshotIsCurrent.
+func (mr *MockLibvirtQemuMockRecorder) SnapshotIsCurrent(snapshot any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SnapshotIsCurrent", reflect.TypeOf((*MockLibvirtQemu)(nil).SnapshotIsCurrent), snapshot)
+}
+
+// Suspend mocks base method.
+func (m *MockLibvirtQemu) Suspend(domain *libvirt.Domain) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Suspend", domain)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Suspend indicates an expected call of Suspend.
+func (mr *MockLibvirtQemuMockRecorder) Suspend(domain any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Suspend", reflect.TypeOf((*MockLibvirtQemu)(nil).Suspend), domain)
+}
+
 // Undefine mocks base method.
 func (m *MockLibvirtQemu) Undefine(domain *libvirt.Domain) error {
 	m.ctrl.T.Helper()
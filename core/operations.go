@@ -0,0 +1,277 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Operation states
+const (
+	OperationRunning   = "running"
+	OperationSucceeded = "succeeded"
+	OperationFailed    = "failed"
+	OperationCancelled = "cancelled"
+)
+
+// operationRetention is how long finished operations can still be polled
+var operationRetention = time.Hour
+
+var (
+	// errOperationCancelled is returned by Progress.Step once the operation was cancelled
+	errOperationCancelled = errors.New("operation cancelled")
+
+	errOperationNotFound = errors.New("operation not found")
+	errOperationFinished = errors.New("operation already finished")
+	errOperationsClosed  = errors.New("the operation manager is shutting down")
+)
+
+// Operation represents a long running action executed in the background
+type Operation struct {
+	ID        string        `json:"id"`               // The UUID of the operation
+	Type      string        `json:"type"`             // The action being run (e.g., "create_vm")
+	VMID      string        `json:"vm_id,omitempty"`  // The UUID of the VM the action applies to, if known
+	Status    string        `json:"status"`           // running, succeeded, failed or cancelled
+	Progress  int           `json:"progress"`         // Completion percentage from 0 to 100
+	Message   string        `json:"message"`          // The step the action is at, or its outcome
+	Result    any           `json:"result,omitempty"` // The response of the action once it succeeded
+	Error     *ErrorDetails `json:"error,omitempty"`  // The error of the action once it failed
+	CreatedAt time.Time     `json:"created_at"`       // When the operation was started
+	UpdatedAt time.Time     `json:"updated_at"`       // When the operation last changed
+
+	cancel context.CancelFunc
+}
+
+// OperationManager keeps track of the operations of the API in memory. A VM runs one operation
+// at a time, so a clone and a deletion of the same VM can't interleave.
+type OperationManager struct {
+	mu         sync.Mutex
+	operations map[string]*Operation
+	busy       map[string]string // The running operation of each VM
+	closed     bool
+	running    sync.WaitGroup
+}
+
+// NewOperationManager returns an empty operation manager
+func NewOperationManager() *OperationManager {
+	return &OperationManager{operations: map[string]*Operation{}, busy: map[string]string{}}
+}
+
+// Start runs the action in the background and returns the operation tracking it. It returns a
+// ConflictError when the VM already has an operation running.
+func (m *OperationManager) Start(opType, vmID string, action func(progress *Progress) (any, error)) (Operation, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	op := &Operation{
+		ID:        uuid.New().String(),
+		Type:      opType,
+		VMID:      vmID,
+		Status:    OperationRunning,
+		Message:   "Operation started",
+		CreatedAt: now,
+		UpdatedAt: now,
+		cancel:    cancel,
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		cancel()
+		return Operation{}, errOperationsClosed
+	}
+	if vmID != "" {
+		if id, ok := m.busy[vmID]; ok {
+			running := m.operations[id]
+			m.mu.Unlock()
+			cancel()
+			return Operation{}, NewConflictError(vmID, fmt.Sprintf("Operation %s (%s) is already running on the VM", running.ID, running.Type))
+		}
+		m.busy[vmID] = op.ID
+	}
+	m.prune(now)
+	m.operations[op.ID] = op
+	snapshot := *op
+	m.running.Add(1)
+	m.mu.Unlock()
+
+	go func() {
+		defer m.running.Done()
+		defer cancel()
+		result, err := action(&Progress{ctx: ctx, manager: m, id: op.ID})
+		m.finish(op.ID, result, err, ctx.Err() != nil)
+	}()
+
+	return snapshot, nil
+}
+
+// Shutdown refuses new operations and waits for the running ones to finish. Those still running
+// when the context ends are cancelled, and the context error is returned.
+func (m *OperationManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		for _, op := range m.operations {
+			if op.Status == OperationRunning {
+				op.cancel()
+			}
+		}
+		m.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Get returns a copy of the operation
+func (m *OperationManager) Get(id string) (Operation, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op, ok := m.operations[id]
+	if !ok {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// Cancel asks a running operation to stop, the action notices it at its next step
+func (m *OperationManager) Cancel(id string) (Operation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op, ok := m.operations[id]
+	if !ok {
+		return Operation{}, errOperationNotFound
+	}
+	if op.Status != OperationRunning {
+		return Operation{}, errOperationFinished
+	}
+	op.cancel()
+	op.Message = "Cancellation requested"
+	op.UpdatedAt = time.Now()
+	return *op, nil
+}
+
+// finish records the outcome of the action
+func (m *OperationManager) finish(id string, result any, err error, cancelled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	op := m.operations[id]
+	op.UpdatedAt = time.Now()
+	if op.VMID != "" && m.busy[op.VMID] == id {
+		delete(m.busy, op.VMID)
+	}
+	switch {
+	case err == nil:
+		op.Status = OperationSucceeded
+		op.Progress = 100
+		op.Message = "Operation completed"
+		op.Result = result
+	case cancelled && errors.Is(err, errOperationCancelled):
+		op.Status = OperationCancelled
+		op.Message = "Operation cancelled"
+	default:
+		_, details := errorDetails(err)
+		op.Status = OperationFailed
+		op.Message = "Operation failed"
+		op.Error = &details
+	}
+}
+
+// prune forgets the operations that finished longer than the retention ago
+func (m *OperationManager) prune(now time.Time) {
+	for id, op := range m.operations {
+		if op.Status != OperationRunning && now.Sub(op.UpdatedAt) > operationRetention {
+			delete(m.operations, id)
+		}
+	}
+}
+
+// Progress lets a long running action report how far it got and notice cancellation.
+// A nil Progress, as used by synchronous callers, ignores reports and is never cancelled.
+type Progress struct {
+	ctx     context.Context
+	manager *OperationManager
+	id      string
+}
+
+// Step records the progress of the action and is a cancellation point: it returns
+// errOperationCancelled once the operation was cancelled so the action stops there
+func (p *Progress) Step(percent int, message string) error {
+	p.Report(percent, message)
+	if p.Cancelled() {
+		return errOperationCancelled
+	}
+	return nil
+}
+
+// Report records the progress of the action past the point where it can be cancelled
+func (p *Progress) Report(percent int, message string) {
+	if p == nil {
+		return
+	}
+
+	p.manager.mu.Lock()
+	defer p.manager.mu.Unlock()
+	if op, ok := p.manager.operations[p.id]; ok && op.Status == OperationRunning {
+		op.Progress = percent
+		op.Message = message
+		op.UpdatedAt = time.Now()
+	}
+}
+
+// Cancelled reports whether the operation was cancelled, for actions that wait in a loop
+func (p *Progress) Cancelled() bool {
+	return p != nil && p.ctx.Err() != nil
+}
+
+// OperationsMiddleware makes the operation manager available to the handlers through the Gin context
+func OperationsMiddleware(operations *OperationManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("operations", operations)
+		c.Next()
+	}
+}
+
+// operationsFromContext returns the operation manager of the request, or nil when there is none
+func operationsFromContext(c *gin.Context) *OperationManager {
+	if c == nil {
+		return nil
+	}
+	o, ok := c.Get("operations")
+	if !ok {
+		return nil
+	}
+	operations, _ := o.(*OperationManager)
+	return operations
+}
+
+// progressFromContext returns the progress of the operation running the action, or nil
+// when the action runs synchronously
+func progressFromContext(c *gin.Context) *Progress {
+	if c == nil {
+		return nil
+	}
+	p, ok := c.Get("progress")
+	if !ok {
+		return nil
+	}
+	progress, _ := p.(*Progress)
+	return progress
+}
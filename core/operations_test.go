@@ -0,0 +1,205 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitOperation polls the operation until it's no longer running
+func waitOperation(t *testing.T, m *OperationManager, id string) Operation {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		op, ok := m.Get(id)
+		assert.True(t, ok)
+		if op.Status != OperationRunning {
+			return op
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("operation %s still running", id)
+	return Operation{}
+}
+
+// TestOperationSucceeded tests that the result of the action is kept on the operation
+func TestOperationSucceeded(t *testing.T) {
+	m := NewOperationManager()
+
+	op, err := m.Start("create_vm", "", func(progress *Progress) (any, error) {
+		assert.Nil(t, progress.Step(50, "Halfway"))
+		return &VMLifecycleResponse{ID: lifecycleVMID, Status: "running"}, nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, OperationRunning, op.Status)
+	assert.Equal(t, "create_vm", op.Type)
+
+	op = waitOperation(t, m, op.ID)
+	assert.Equal(t, OperationSucceeded, op.Status)
+	assert.Equal(t, 100, op.Progress)
+	assert.Equal(t, lifecycleVMID, op.Result.(*VMLifecycleResponse).ID)
+	assert.Nil(t, op.Error)
+}
+
+// TestOperationFailed tests that the error of the action is mapped like a synchronous response
+func TestOperationFailed(t *testing.T) {
+	m := NewOperationManager()
+
+	op, err := m.Start("delete_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		return nil, NewNotFoundError(lifecycleVMID)
+	})
+	assert.Nil(t, err)
+
+	op = waitOperation(t, m, op.ID)
+	assert.Equal(t, OperationFailed, op.Status)
+	assert.Equal(t, http.StatusNotFound, op.Error.Code)
+	assert.Equal(t, "VM not found", op.Error.Message)
+}
+
+// TestOperationCancel tests that the action stops at its next step once cancelled
+func TestOperationCancel(t *testing.T) {
+	m := NewOperationManager()
+	started := make(chan struct{})
+	resume := make(chan struct{})
+
+	op, err := m.Start("stop_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		close(started)
+		<-resume
+		if err := progress.Step(50, "Next step"); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("the step after the cancellation ran")
+	})
+	assert.Nil(t, err)
+
+	<-started
+	cancelled, err := m.Cancel(op.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, OperationRunning, cancelled.Status)
+	close(resume)
+
+	op = waitOperation(t, m, op.ID)
+	assert.Equal(t, OperationCancelled, op.Status)
+
+	// A finished operation can't be cancelled again
+	_, err = m.Cancel(op.ID)
+	assert.Equal(t, errOperationFinished, err)
+}
+
+// TestOperationNotFound tests the lookups of an unknown operation
+func TestOperationNotFound(t *testing.T) {
+	m := NewOperationManager()
+
+	_, ok := m.Get(lifecycleVMID)
+	assert.False(t, ok)
+
+	_, err := m.Cancel(lifecycleVMID)
+	assert.Equal(t, errOperationNotFound, err)
+}
+
+// TestOperationPrune tests that finished operations are forgotten after the retention
+func TestOperationPrune(t *testing.T) {
+	m := NewOperationManager()
+
+	op, err := m.Start("stop_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	waitOperation(t, m, op.ID)
+
+	m.mu.Lock()
+	m.prune(time.Now().Add(2 * operationRetention))
+	m.mu.Unlock()
+
+	_, ok := m.Get(op.ID)
+	assert.False(t, ok)
+}
+
+// TestOperationOnePerVM tests that a VM runs one operation at a time, other VMs aren't held up
+func TestOperationOnePerVM(t *testing.T) {
+	m := NewOperationManager()
+	resume := make(chan struct{})
+
+	clone, err := m.Start("clone_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		<-resume
+		return nil, nil
+	})
+	assert.Nil(t, err)
+
+	_, err = m.Start("delete_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		t.Error("the deletion ran during the clone")
+		return nil, nil
+	})
+	assert.IsType(t, &ConflictError{}, err)
+	assert.ErrorContains(t, err, clone.ID)
+
+	other, err := m.Start("stop_vm", "223e4567-e89b-12d3-a456-426614174000", func(progress *Progress) (any, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	waitOperation(t, m, other.ID)
+
+	// The VM takes operations again once its operation finished
+	close(resume)
+	waitOperation(t, m, clone.ID)
+	deletion, err := m.Start("delete_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		return nil, nil
+	})
+	assert.Nil(t, err)
+	waitOperation(t, m, deletion.ID)
+}
+
+// TestOperationShutdown tests that shutdown waits for the running operations and refuses new ones
+func TestOperationShutdown(t *testing.T) {
+	m := NewOperationManager()
+	resume := make(chan struct{})
+
+	op, err := m.Start("clone_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		<-resume
+		return nil, nil
+	})
+	assert.Nil(t, err)
+
+	done := make(chan error)
+	go func() { done <- m.Shutdown(context.Background()) }()
+
+	select {
+	case <-done:
+		t.Fatal("shutdown returned before the operation finished")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(resume)
+	assert.Nil(t, <-done)
+
+	finished, _ := m.Get(op.ID)
+	assert.Equal(t, OperationSucceeded, finished.Status)
+
+	_, err = m.Start("create_vm", "", func(progress *Progress) (any, error) { return nil, nil })
+	assert.Equal(t, errOperationsClosed, err)
+}
+
+// TestOperationShutdownTimeout tests that operations still running at the shutdown deadline are cancelled
+func TestOperationShutdownTimeout(t *testing.T) {
+	m := NewOperationManager()
+	started := make(chan struct{})
+
+	op, err := m.Start("stop_vm", lifecycleVMID, func(progress *Progress) (any, error) {
+		close(started)
+		for !progress.Cancelled() {
+			time.Sleep(time.Millisecond)
+		}
+		return nil, errOperationCancelled
+	})
+	assert.Nil(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, m.Shutdown(ctx))
+
+	op = waitOperation(t, m, op.ID)
+	assert.Equal(t, OperationCancelled, op.Status)
+}
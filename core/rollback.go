@@ -0,0 +1,41 @@
+package core
+
+import "fmt"
+
+// rollback collects the compensating actions of the steps completed so far, so a
+// multi-step action that fails halfway can undo what it already changed
+type rollback struct {
+	actions []compensation
+}
+
+type compensation struct {
+	description string // What the action undoes, e.g. "remove the cloned disk"
+	undo        func() error
+}
+
+// add registers the action undoing the step that just completed
+func (r *rollback) add(description string, undo func() error) {
+	r.actions = append(r.actions, compensation{description: description, undo: undo})
+}
+
+// run undoes the completed steps, most recent first. Every action is attempted even
+// if an earlier one fails, the failures are returned.
+func (r *rollback) run() []error {
+	var errs []error
+	for i := len(r.actions) - 1; i >= 0; i-- {
+		if err := r.actions[i].undo(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to %s: %v", r.actions[i].description, err))
+		}
+	}
+	r.actions = nil
+	return errs
+}
+
+// fail rolls back and reports the step that failed
+func (r *rollback) fail(step string, err error) *StepError {
+	return &StepError{
+		Step:           step,
+		Err:            err,
+		RollbackErrors: r.run(),
+	}
+}
@@ -0,0 +1,254 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// vmsBucket holds the VM records keyed by UUID, values are JSON encoded
+	vmsBucket = []byte("vms")
+
+	// macsBucket holds the reserved MAC addresses, the value is the UUID of the VM owning it
+	macsBucket = []byte("macs")
+
+	// imagesBucket holds the registered base images keyed by UUID, values are JSON encoded
+	imagesBucket = []byte("images")
+)
+
+// BoltStore is the embedded Store backed by a bbolt file
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the bbolt store at path
+func NewBoltStore(path string) (*BoltStore, error) {
+	// The timeout stops a second API instance from hanging on the file lock
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the store %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{vmsBucket, macsBucket, imagesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize the store: %v", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// PutVM creates or replaces the record of a VM
+func (s *BoltStore) PutVM(record *VMRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode the record of VM %s: %v", record.ID, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmsBucket).Put([]byte(record.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store the record of VM %s: %v", record.ID, err)
+	}
+	return nil
+}
+
+// GetVM returns the record of a VM
+func (s *BoltStore) GetVM(id string) (*VMRecord, error) {
+	var record *VMRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(vmsBucket).Get([]byte(id))
+		if data == nil {
+			return NewNotFoundError(id)
+		}
+		record = &VMRecord{}
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ListVMs returns every record, ordered by UUID
+func (s *BoltStore) ListVMs() ([]*VMRecord, error) {
+	records := []*VMRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmsBucket).ForEach(func(k, v []byte) error {
+			record := &VMRecord{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return fmt.Errorf("failed to decode the record of VM %s: %v", k, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// UpdateDrift sets the drift state of a VM record in one transaction, so a record deleted since it
+// was read isn't written back. Updating an unknown VM is not an error.
+func (s *BoltStore) UpdateDrift(id, drift string, since time.Time) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(vmsBucket)
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		record := &VMRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return err
+		}
+		if record.Drift == drift {
+			return nil
+		}
+		record.Drift = drift
+		record.DriftSince = since
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the drift of VM %s: %v", id, err)
+	}
+	return nil
+}
+
+// DeleteVM removes the record of a VM, deleting an unknown VM is not an error
+func (s *BoltStore) DeleteVM(id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(vmsBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the record of VM %s: %v", id, err)
+	}
+	return nil
+}
+
+// PutImage creates or replaces the record of a base image
+func (s *BoltStore) PutImage(record *ImageRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode the record of image %s: %v", record.ID, err)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Put([]byte(record.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store the record of image %s: %v", record.ID, err)
+	}
+	return nil
+}
+
+// GetImage returns the record of a base image
+func (s *BoltStore) GetImage(id string) (*ImageRecord, error) {
+	var record *ImageRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(imagesBucket).Get([]byte(id))
+		if data == nil {
+			return NewResourceNotFoundError("Image", id)
+		}
+		record = &ImageRecord{}
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ListImages returns every image record, ordered by UUID
+func (s *BoltStore) ListImages() ([]*ImageRecord, error) {
+	records := []*ImageRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(k, v []byte) error {
+			record := &ImageRecord{}
+			if err := json.Unmarshal(v, record); err != nil {
+				return fmt.Errorf("failed to decode the record of image %s: %v", k, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DeleteImage removes the record of a base image, deleting an unknown image is not an error
+func (s *BoltStore) DeleteImage(id string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the record of image %s: %v", id, err)
+	}
+	return nil
+}
+
+// ReserveMAC reserves a MAC address for a VM unless it's already taken, checking and
+// reserving happen in one transaction so concurrent creations can't get the same address
+func (s *BoltStore) ReserveMAC(mac, vmID string) (bool, error) {
+	reserved := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(macsBucket)
+		if bucket.Get([]byte(mac)) != nil {
+			return nil
+		}
+		reserved = true
+		return bucket.Put([]byte(mac), []byte(vmID))
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve the MAC address %s: %v", mac, err)
+	}
+	return reserved, nil
+}
+
+// ReleaseMACs releases every MAC address reserved for a VM
+func (s *BoltStore) ReleaseMACs(vmID string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		// Deleting while iterating makes the cursor skip keys, the MACs are collected first
+		bucket := tx.Bucket(macsBucket)
+		var macs [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			if string(v) == vmID {
+				macs = append(macs, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, mac := range macs {
+			if err := bucket.Delete(mac); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release the MAC addresses of VM %s: %v", vmID, err)
+	}
+	return nil
+}
+
+// Close releases the store file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
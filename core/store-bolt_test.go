@@ -0,0 +1,92 @@
+package core
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBoltStore(t *testing.T) *BoltStore {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "vm-api.db"))
+	assert.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// TestBoltStoreRoundTrip tests that a record can be stored, listed and removed
+func TestBoltStoreRoundTrip(t *testing.T) {
+	store := newTestBoltStore(t)
+
+	request := &VMCreationRequest{VCPUs: 2, Memory: 4096, DiskSize: 20, Labels: map[string]string{"team": "ci"}, Owner: "alice"}
+	response := &VMCreationResponse{VMID: lifecycleVMID, MacAddress: "00:16:3e:00:00:01", DiskFile: "/var/lib/libvirt/images/vm.qcow2"}
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	assert.Nil(t, store.PutVM(newVMRecord(request, response, createdAt)))
+
+	record, err := store.GetVM(lifecycleVMID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, record.Spec.VCPUs)
+	assert.Equal(t, "alice", record.Owner)
+	assert.Equal(t, map[string]string{"team": "ci"}, record.Labels)
+	assert.Equal(t, "00:16:3e:00:00:01", record.MacAddress)
+	assert.True(t, createdAt.Equal(record.CreatedAt))
+
+	records, err := store.ListVMs()
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	assert.Nil(t, store.DeleteVM(lifecycleVMID))
+	_, err = store.GetVM(lifecycleVMID)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	// Deleting twice is not an error
+	assert.Nil(t, store.DeleteVM(lifecycleVMID))
+}
+
+// TestBoltStoreSurvivesReopen tests that records are still there after the store is reopened
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vm-api.db")
+
+	store, err := NewBoltStore(path)
+	assert.Nil(t, err)
+	assert.Nil(t, store.PutVM(&VMRecord{ID: lifecycleVMID}))
+	assert.Nil(t, store.Close())
+
+	store, err = NewBoltStore(path)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	record, err := store.GetVM(lifecycleVMID)
+	assert.Nil(t, err)
+	assert.Equal(t, lifecycleVMID, record.ID)
+}
+
+// TestBoltStoreMACReservations tests that a MAC can only be reserved once and is released with its VM
+func TestBoltStoreMACReservations(t *testing.T) {
+	store := newTestBoltStore(t)
+
+	for _, mac := range []string{"00:16:3e:00:00:01", "00:16:3e:00:00:02"} {
+		reserved, err := store.ReserveMAC(mac, lifecycleVMID)
+		assert.Nil(t, err)
+		assert.True(t, reserved)
+	}
+	reserved, err := store.ReserveMAC("00:16:3e:00:00:03", "other-vm")
+	assert.Nil(t, err)
+	assert.True(t, reserved)
+
+	reserved, err = store.ReserveMAC("00:16:3e:00:00:01", "other-vm")
+	assert.Nil(t, err)
+	assert.False(t, reserved)
+
+	// Only the addresses of the VM are released
+	assert.Nil(t, store.ReleaseMACs(lifecycleVMID))
+	for _, mac := range []string{"00:16:3e:00:00:01", "00:16:3e:00:00:02"} {
+		reserved, err = store.ReserveMAC(mac, "other-vm")
+		assert.Nil(t, err)
+		assert.True(t, reserved)
+	}
+	reserved, err = store.ReserveMAC("00:16:3e:00:00:03", lifecycleVMID)
+	assert.Nil(t, err)
+	assert.False(t, reserved)
+}
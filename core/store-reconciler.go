@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// ReconcileReport is the outcome of one comparison of the store with libvirt
+type ReconcileReport struct {
+	InSync    int      // Records whose domain exists in libvirt
+	Missing   []string // Records whose domain is gone from libvirt
+	Untracked []string // Domains libvirt knows that the store has no record of
+}
+
+// Reconciler periodically compares the store with the libvirt domain list and flags drift on the records
+type Reconciler struct {
+	store    Store
+	interval time.Duration
+	logger   *slog.Logger
+	connect  func() (LibvirtQemu, error)
+}
+
+// NewReconciler returns a reconciler that checks the store every interval over the shared connection
+func NewReconciler(store Store, connections *ConnectionManager, interval time.Duration, logger *slog.Logger) *Reconciler {
+	return &Reconciler{
+		store:    store,
+		interval: interval,
+		logger:   logger.With("component", "reconciler"),
+		connect:  connections.Get,
+	}
+}
+
+// Run reconciles right away and then every interval until the context is cancelled
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.runOnce()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runOnce reconciles and logs the drift found
+func (r *Reconciler) runOnce() {
+	lq, err := r.connect()
+	if err != nil {
+		r.logger.Error("Fail to connect to libvirt", "error", err)
+		return
+	}
+
+	report, err := r.reconcile(lq, time.Now())
+	if err != nil {
+		r.logger.Error("Failed to reconcile the store", "error", err)
+		return
+	}
+
+	if len(report.Missing) > 0 || len(report.Untracked) > 0 {
+		r.logger.Warn("Store drifted from libvirt", "in_sync", report.InSync, "missing", report.Missing, "untracked", report.Untracked)
+	} else {
+		r.logger.Debug("Store in sync with libvirt", "in_sync", report.InSync)
+	}
+}
+
+// reconcile flags the records whose domain is gone and lists the domains the store doesn't know.
+// A record is only rewritten when its drift state changes.
+func (r *Reconciler) reconcile(lq LibvirtQemu, now time.Time) (*ReconcileReport, error) {
+	domains, err := lq.ListAllDomains()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(domains))
+	existing := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		id, err := lq.GetUUIDString(domain)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		existing[id] = true
+	}
+
+	records, err := r.store.ListVMs()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Missing: []string{}, Untracked: []string{}}
+	tracked := make(map[string]bool, len(records))
+	for _, record := range records {
+		tracked[record.ID] = true
+
+		drift := DriftNone
+		if existing[record.ID] {
+			report.InSync++
+		} else {
+			drift = DriftMissingDomain
+			report.Missing = append(report.Missing, record.ID)
+		}
+
+		// The record may have been deleted since it was listed, the store only updates it when it's still there
+		if record.Drift != drift {
+			if err := r.store.UpdateDrift(record.ID, drift, now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if !tracked[id] {
+			report.Untracked = append(report.Untracked, id)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,81 @@
+package core
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vzahanych/vm-api/core/mocks"
+	"go.uber.org/mock/gomock"
+)
+
+// TestReconcile tests that missing domains are flagged, recovered ones cleared and untracked ones reported
+func TestReconcile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := newTestBoltStore(t)
+	assert.Nil(t, store.PutVM(&VMRecord{ID: "in-sync"}))
+	assert.Nil(t, store.PutVM(&VMRecord{ID: "deleted"}))
+	assert.Nil(t, store.PutVM(&VMRecord{ID: "recovered", Drift: DriftMissingDomain}))
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomains(mockLibvirt, []testDomain{
+		{id: "in-sync", name: "a"},
+		{id: "recovered", name: "b"},
+		{id: "manual", name: "c"},
+	})
+
+	reconciler := NewReconciler(store, nil, time.Minute, slog.Default())
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	report, err := reconciler.reconcile(mockLibvirt, now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.InSync)
+	assert.Equal(t, []string{"deleted"}, report.Missing)
+	assert.Equal(t, []string{"manual"}, report.Untracked)
+
+	deleted, err := store.GetVM("deleted")
+	assert.Nil(t, err)
+	assert.Equal(t, DriftMissingDomain, deleted.Drift)
+	assert.True(t, now.Equal(deleted.DriftSince))
+
+	recovered, err := store.GetVM("recovered")
+	assert.Nil(t, err)
+	assert.Equal(t, DriftNone, recovered.Drift)
+}
+
+// deletingStore deletes a record right after listing, like a VM deletion racing the reconciler
+type deletingStore struct {
+	*BoltStore
+	deleted string
+}
+
+func (s *deletingStore) ListVMs() ([]*VMRecord, error) {
+	records, err := s.BoltStore.ListVMs()
+	if err == nil {
+		err = s.BoltStore.DeleteVM(s.deleted)
+	}
+	return records, err
+}
+
+// TestReconcileDeletedRecord tests that a record deleted during a reconciliation isn't written back
+func TestReconcileDeletedRecord(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	store := &deletingStore{BoltStore: newTestBoltStore(t), deleted: "deleted"}
+	assert.Nil(t, store.PutVM(&VMRecord{ID: "deleted"}))
+
+	mockLibvirt := mocks.NewMockLibvirtQemu(ctrl)
+	expectDomains(mockLibvirt, []testDomain{})
+
+	reconciler := NewReconciler(store, nil, time.Minute, slog.Default())
+	report, err := reconciler.reconcile(mockLibvirt, time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"deleted"}, report.Missing)
+
+	_, err = store.GetVM("deleted")
+	assert.IsType(t, &NotFoundError{}, err)
+}
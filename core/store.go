@@ -0,0 +1,141 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Drift states of a VM record, compared against the libvirt domain list by the reconciler
+const (
+	DriftNone          = ""               // The domain exists in libvirt
+	DriftMissingDomain = "missing_domain" // The VM is in the store but its domain is gone from libvirt
+)
+
+// VMRecord is the inventory entry of a VM created through the API
+type VMRecord struct {
+	ID           string            `json:"id"`                      // The UUID of the VM
+	Spec         VMCreationRequest `json:"spec"`                    // The request the VM was created from
+	Labels       map[string]string `json:"labels,omitempty"`        // The labels of the VM
+	Owner        string            `json:"owner,omitempty"`         // The owner given at creation
+	MacAddress   string            `json:"mac_address"`             // The MAC address of the first VM NIC
+	MacAddresses []string          `json:"mac_addresses,omitempty"` // The MAC addresses of all VM NICs
+	DiskFile     string            `json:"disk_file"`               // Path to the root disk image file
+	ClonedFrom   string            `json:"cloned_from,omitempty"`   // The UUID of the VM this one was cloned from
+	LinkedClone  bool              `json:"linked_clone,omitempty"`  // Whether the disk is an overlay on the disk of the source VM
+	CreatedAt    time.Time         `json:"created_at"`              // When the VM was created
+	Drift        string            `json:"drift,omitempty"`         // How the record differs from libvirt, empty when in sync
+	DriftSince   time.Time         `json:"drift_since"`             // When the reconciler detected the current drift state
+}
+
+// ImageRecord is what the store keeps about a registered base image
+type ImageRecord struct {
+	ID           string    `json:"id"`                      // The UUID of the image
+	Name         string    `json:"name"`                    // The unique name of the image
+	Description  string    `json:"description,omitempty"`   // Free text description
+	Path         string    `json:"path"`                    // The resolved path of the image file
+	Format       string    `json:"format"`                  // The image format reported by qemu-img, always "qcow2"
+	VirtualSize  uint64    `json:"virtual_size"`            // The size of the disk the guest sees, in bytes
+	FileSize     int64     `json:"file_size"`               // The size of the image file when registered, in bytes
+	Checksum     string    `json:"checksum"`                // The SHA-256 of the image file, "sha256:<hex>"
+	BackingChain []string  `json:"backing_chain,omitempty"` // The backing files of the image, nearest first
+	CreatedAt    time.Time `json:"created_at"`              // When the image was registered
+}
+
+// Store keeps the VM inventory and the image catalog across API restarts
+type Store interface {
+	PutVM(record *VMRecord) error
+	GetVM(id string) (*VMRecord, error) // Returns a *NotFoundError for an unknown VM
+	ListVMs() ([]*VMRecord, error)
+	DeleteVM(id string) error
+	UpdateDrift(id, drift string, since time.Time) error // Leaves a deleted VM deleted
+	PutImage(record *ImageRecord) error
+	GetImage(id string) (*ImageRecord, error) // Returns a *NotFoundError for an unknown image
+	ListImages() ([]*ImageRecord, error)
+	DeleteImage(id string) error
+	ReserveMAC(mac, vmID string) (bool, error) // Returns false when the MAC is already reserved
+	ReleaseMACs(vmID string) error             // Releases every MAC reserved for the VM
+	Close() error
+}
+
+// NewStore opens the store backend selected in the config
+func NewStore(config StoreConfig) (Store, error) {
+	switch config.Driver {
+	case "", "bolt":
+		path := config.Path
+		if path == "" {
+			path = "vm-api.db"
+		}
+		return NewBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store driver %q", config.Driver)
+	}
+}
+
+// StoreMiddleware makes the store available to the handlers through the Gin context
+func StoreMiddleware(store Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("store", store)
+		c.Next()
+	}
+}
+
+// storeFromContext returns the store of the request, or nil when the server runs without one
+func storeFromContext(c *gin.Context) Store {
+	if c == nil {
+		return nil
+	}
+	s, ok := c.Get("store")
+	if !ok {
+		return nil
+	}
+	store, _ := s.(Store)
+	return store
+}
+
+// newVMRecord builds the inventory entry of a freshly created VM
+func newVMRecord(request *VMCreationRequest, response *VMCreationResponse, createdAt time.Time) *VMRecord {
+	return &VMRecord{
+		ID:           response.VMID,
+		Spec:         *request,
+		Labels:       request.Labels,
+		Owner:        request.Owner,
+		MacAddress:   response.MacAddress,
+		MacAddresses: response.MacAddresses,
+		DiskFile:     response.DiskFile,
+		CreatedAt:    createdAt,
+		DriftSince:   createdAt,
+	}
+}
+
+// newCloneRecord builds the store record of a cloned VM. The clone keeps the spec of the source,
+// or only its size when the source isn't in the store.
+func newCloneRecord(source *VMRecord, request *VMCloneRequest, response *VMCloneResponse, createdAt time.Time) *VMRecord {
+	record := &VMRecord{
+		ID:           response.VMID,
+		Spec:         VMCreationRequest{VCPUs: response.VCPUs, Memory: response.Memory},
+		Labels:       response.Labels,
+		Owner:        request.Owner,
+		MacAddress:   response.MacAddress,
+		MacAddresses: response.MacAddresses,
+		DiskFile:     response.DiskFile,
+		ClonedFrom:   response.SourceVMID,
+		LinkedClone:  response.Mode == "linked",
+		CreatedAt:    createdAt,
+		DriftSince:   createdAt,
+	}
+	if source != nil {
+		record.Spec = source.Spec
+		if record.Owner == "" {
+			record.Owner = source.Owner
+		}
+	}
+	record.Spec.Labels = response.Labels
+	record.Spec.Owner = record.Owner
+	// A full copy doesn't depend on the base image of the source, a linked clone still does
+	if !record.LinkedClone {
+		record.Spec.Image = ""
+	}
+	return record
+}
@@ -1,6 +1,9 @@
 package core
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type (
 	// ErrorResponse represents the structure of the error response
@@ -10,18 +13,39 @@ type (
 
 	// ErrorDetails holds the error code and message
 	ErrorDetails struct {
-		Code    int    `json:"code"`    // Error code (e.g., 404)
-		Message string `json:"message"` // Error message (e.g., "Resource not found")
+		Code    int    `json:"code"`           // Error code (e.g., 404)
+		Message string `json:"message"`        // Error message (e.g., "Resource not found")
+		Step    string `json:"step,omitempty"` // The step a multi-step action failed at (e.g., "define_domain")
 	}
 
 	NotFoundError struct {
 		ID       string
+		Resource string // The kind of resource that is missing, "VM" when empty
+	}
+
+	// BadRequestError reports a request that is well formed but can't be applied to this host or VM
+	BadRequestError struct {
+		Message string
+	}
+
+	// ConflictError reports that the VM is in a state that doesn't allow the requested action
+	ConflictError struct {
+		ID       string
+		Message  string
+		Resource string // The kind of resource in conflict, "VM" when empty
+	}
+
+	// StepError reports the step a multi-step action failed at, after the steps before it were rolled back
+	StepError struct {
+		Step           string  // The step that failed (e.g., "define_domain")
+		Err            error   // Why the step failed
+		RollbackErrors []error // The compensating actions that failed, the changes they cover were left behind
 	}
 )
 
 // Error implements the error interface for NotFoundError
 func (e NotFoundError) Error() string {
-	return fmt.Sprintf("VM with ID %s not found", e.ID)
+	return fmt.Sprintf("%s with ID %s not found", resourceKind(e.Resource), e.ID)
 }
 
 // NewNotFoundError creates a new NotFoundError
@@ -29,4 +53,72 @@ func NewNotFoundError(id string) *NotFoundError {
 	return &NotFoundError{
 		ID:       id,
 	}
-}
\ No newline at end of file
+}
+
+// NewResourceNotFoundError creates a NotFoundError for a resource other than a VM
+func NewResourceNotFoundError(resource, id string) *NotFoundError {
+	return &NotFoundError{
+		ID:       id,
+		Resource: resource,
+	}
+}
+
+// Error implements the error interface for ConflictError
+func (e ConflictError) Error() string {
+	return fmt.Sprintf("%s with ID %s: %s", resourceKind(e.Resource), e.ID, e.Message)
+}
+
+// NewConflictError creates a new ConflictError
+func NewConflictError(id string, message string) *ConflictError {
+	return &ConflictError{
+		ID:      id,
+		Message: message,
+	}
+}
+
+// NewResourceConflictError creates a ConflictError for a resource other than a VM
+func NewResourceConflictError(resource, id, message string) *ConflictError {
+	return &ConflictError{
+		ID:       id,
+		Message:  message,
+		Resource: resource,
+	}
+}
+
+// resourceKind names the resource of an error, errors without one are about a VM
+func resourceKind(resource string) string {
+	if resource == "" {
+		return "VM"
+	}
+	return resource
+}
+
+// Error implements the error interface for BadRequestError
+func (e BadRequestError) Error() string {
+	return e.Message
+}
+
+// NewBadRequestError creates a new BadRequestError
+func NewBadRequestError(format string, args ...any) *BadRequestError {
+	return &BadRequestError{
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error implements the error interface for StepError
+func (e StepError) Error() string {
+	message := fmt.Sprintf("step %s failed: %v", e.Step, e.Err)
+	if len(e.RollbackErrors) > 0 {
+		failures := make([]string, len(e.RollbackErrors))
+		for i, err := range e.RollbackErrors {
+			failures[i] = err.Error()
+		}
+		message += "; rollback incomplete: " + strings.Join(failures, "; ")
+	}
+	return message
+}
+
+// Unwrap returns the error of the failed step
+func (e StepError) Unwrap() error {
+	return e.Err
+}
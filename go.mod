@@ -3,7 +3,10 @@ module github.com/vzahanych/vm-api
 go 1.24.0
 
 require (
+	github.com/gorilla/websocket v1.5.3
+	github.com/kdomanski/iso9660 v0.4.0
 	github.com/spf13/cobra v1.9.1
+	go.etcd.io/bbolt v1.4.3
 	gopkg.in/yaml.v3 v3.0.1
 )
 